@@ -4,8 +4,8 @@ import (
 	"context"
 	"github.com/ziliscite/video-to-mp3/auth/internal/repository"
 	"github.com/ziliscite/video-to-mp3/auth/internal/service"
-	"github.com/ziliscite/video-to-mp3/auth/pkg/db"
 	"github.com/ziliscite/video-to-mp3/auth/pkg/validator"
+	"github.com/ziliscite/video-to-mp3/platform/db"
 	"log/slog"
 	"os"
 	"time"
@@ -2,9 +2,14 @@ package main
 
 import (
 	"flag"
-	"fmt"
 	"os"
+	"strconv"
 	"sync"
+	"time"
+
+	"github.com/ziliscite/video-to-mp3/converter/internal/domain"
+	"github.com/ziliscite/video-to-mp3/platform/mq"
+	"github.com/ziliscite/video-to-mp3/platform/storage"
 )
 
 type DB struct {
@@ -30,9 +35,54 @@ type AWS struct {
 		mp3 string
 	}
 	s3Region                 string
+	s3Endpoint               string
+	s3PathStyle              bool
+	s3CABundle               string
+	profile                  string
 	s3CloudFrontDistribution string
-	accessKeyId              string
-	secretAccessKey          string
+	cloudFront               struct {
+		domain     string
+		keyPairId  string
+		privateKey string
+	}
+	audioUrlExpiry time.Duration
+	sse            struct {
+		algorithm string
+		kmsKeyId  string
+	}
+	accessKeyId     string
+	secretAccessKey string
+}
+
+type Storage struct {
+	backend  string
+	dir      string
+	url      string
+	secret   string
+	envelope struct {
+		keys         string
+		active       string
+		encryptAudio bool
+	}
+}
+
+func (a AWS) s3SSE() storage.SSE {
+	return storage.SSE{
+		Algorithm: a.sse.algorithm,
+		KMSKeyId:  a.sse.kmsKeyId,
+	}
+}
+
+func (a AWS) s3Config() storage.S3Config {
+	return storage.S3Config{
+		Region:          a.s3Region,
+		Endpoint:        a.s3Endpoint,
+		PathStyle:       a.s3PathStyle,
+		CABundle:        a.s3CABundle,
+		Profile:         a.profile,
+		AccessKeyId:     a.accessKeyId,
+		SecretAccessKey: a.secretAccessKey,
+	}
 }
 
 type RabbitMQ struct {
@@ -44,18 +94,142 @@ type RabbitMQ struct {
 		video        string
 		notification string
 	}
+	progressExchange string
 }
 
 func (r RabbitMQ) dsn() string {
-	return fmt.Sprintf("amqps://%s:%s@%s:%s", r.username, r.password, r.host, r.port)
+	return mq.DSN(r.username, r.password, r.host, r.port)
+}
+
+type Reconcile struct {
+	grace time.Duration
+}
+
+type Retention struct {
+	deleteVideoOnSuccess bool
+	videoDays            int
+	audioDays            int
+}
+
+type FFmpeg struct {
+	timeout    time.Duration
+	cpu        time.Duration
+	memoryMB   int64
+	fileSizeMB int64
+}
+
+func (f FFmpeg) limits() domain.Limits {
+	return domain.Limits{
+		Timeout:  f.timeout,
+		CPU:      f.cpu,
+		Memory:   f.memoryMB << 20,
+		FileSize: f.fileSizeMB << 20,
+	}
+}
+
+type Waveform struct {
+	pixelsPerSecond int
+	width           int
+	height          int
+}
+
+func (w Waveform) config() domain.WaveformConfig {
+	return domain.WaveformConfig{
+		PixelsPerSecond: w.pixelsPerSecond,
+		Width:           w.width,
+		Height:          w.height,
+	}
+}
+
+type Thumbnail struct {
+	width           int
+	previewFormat   string
+	previewDuration time.Duration
+}
+
+func (t Thumbnail) config() domain.ThumbnailConfig {
+	return domain.ThumbnailConfig{
+		Width:           t.width,
+		PreviewFormat:   t.previewFormat,
+		PreviewDuration: t.previewDuration,
+	}
+}
+
+type Transcriber struct {
+	engine  string
+	whisper struct {
+		path  string
+		model string
+	}
+	url     string
+	token   string
+	model   string
+	timeout time.Duration
 }
 
 type Config struct {
-	port       int
-	encryptKey string
-	db         DB
-	aws        AWS
-	rabbit     RabbitMQ
+	port         int
+	serviceToken string
+	encryptKey   string
+	encryptKeys  struct {
+		keys   string
+		active string
+	}
+	maxDuration time.Duration
+	ffmpeg      FFmpeg
+	waveform    Waveform
+	thumbnail   Thumbnail
+	transcriber Transcriber
+	db          DB
+	aws         AWS
+	storage     Storage
+	rabbit      RabbitMQ
+	dryRun      bool
+	reconcile   Reconcile
+	retention   Retention
+}
+
+// jobRuns is how many ffmpeg or transcriber runs a job makes at most, a conversion probes, analyses,
+// encodes and draws its waveform, a stream encodes every rendition.
+const jobRuns = 8
+
+// maxJobTimeout bounds the deliveries whose runs have no timeouts, so a hung run can't hold its message forever.
+const maxJobTimeout = 12 * time.Hour
+
+// jobTimeout is how long a delivery may take, its runs are bounded by their own timeouts and the rest
+// is quick next to them. maxJobTimeout when the runs have no timeouts either.
+func (c Config) jobTimeout() time.Duration {
+	if timeout := jobRuns * max(c.ffmpeg.timeout, c.transcriber.timeout); timeout > 0 {
+		return timeout
+	}
+
+	return maxJobTimeout
+}
+
+// envInt reads an integer environment variable, defaulting to 0 when it is unset or invalid.
+func envInt(key string) int {
+	v, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return 0
+	}
+	return v
+}
+
+// envIntOr reads an integer environment variable, defaulting to def when it is unset or invalid.
+func envIntOr(key string, def int) int {
+	v, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+// envOr reads an environment variable, defaulting to def when it is unset.
+func envOr(key, def string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return def
 }
 
 var (
@@ -68,8 +242,32 @@ func getConfig() Config {
 		instance = Config{}
 
 		flag.IntVar(&instance.port, "port", 8080, "Server Port")
+		flag.StringVar(&instance.serviceToken, "service-token", os.Getenv("CONVERTER_SERVICE_TOKEN"), "Token the gateway calls the API with, empty to disable the API")
+
+		flag.StringVar(&instance.encryptKey, "key", os.Getenv("ENCRYPT_KEY"), "Legacy 64-byte encryption key, decrypts keys made before the keyring")
+		flag.StringVar(&instance.encryptKeys.keys, "encrypt-keys", os.Getenv("ENCRYPT_KEYS"), "Keyring of file key secrets as id:hex pairs, empty to use the legacy key only")
+		flag.StringVar(&instance.encryptKeys.active, "encrypt-active-key", os.Getenv("ENCRYPT_ACTIVE_KEY"), "Keyring secret id that encrypts new file keys")
+		flag.DurationVar(&instance.maxDuration, "max-duration", 3*time.Hour, "Maximum video duration, 0 to disable")
+		flag.DurationVar(&instance.ffmpeg.timeout, "ffmpeg-timeout", 30*time.Minute, "Wall-clock limit of an ffmpeg run, 0 to disable")
+		flag.DurationVar(&instance.ffmpeg.cpu, "ffmpeg-cpu", 20*time.Minute, "CPU time limit of an ffmpeg run, 0 to disable")
+		flag.Int64Var(&instance.ffmpeg.memoryMB, "ffmpeg-memory-mb", 4096, "Address space limit of an ffmpeg run in MB, 0 to disable")
+		flag.Int64Var(&instance.ffmpeg.fileSizeMB, "ffmpeg-file-size-mb", 2048, "Largest file an ffmpeg run may write in MB, 0 to disable")
+
+		flag.IntVar(&instance.waveform.pixelsPerSecond, "waveform-pixels-per-second", envIntOr("WAVEFORM_PIXELS_PER_SECOND", 20), "Peaks drawn per second of converted audio")
+		flag.IntVar(&instance.waveform.width, "waveform-width", envInt("WAVEFORM_WIDTH"), "Width of the waveform image in pixels, 0 to store the peaks only")
+		flag.IntVar(&instance.waveform.height, "waveform-height", envIntOr("WAVEFORM_HEIGHT", 200), "Height of the waveform image in pixels")
+
+		flag.IntVar(&instance.thumbnail.width, "thumbnail-width", 480, "Width of the poster frame of uploaded videos in pixels, 0 to draw none")
+		flag.StringVar(&instance.thumbnail.previewFormat, "preview-format", os.Getenv("PREVIEW_FORMAT"), "Format of the animated preview of videos uploaded asking for one (webp|gif), empty to draw the poster frame only")
+		flag.DurationVar(&instance.thumbnail.previewDuration, "preview-duration", 3*time.Second, "How much of the video the animated preview shows")
 
-		flag.StringVar(&instance.encryptKey, "key", os.Getenv("ENCRYPT_KEY"), "Encryption key")
+		flag.StringVar(&instance.transcriber.engine, "transcriber", os.Getenv("TRANSCRIBER"), "Speech-to-text engine (whisper|http), empty to disable transcription")
+		flag.StringVar(&instance.transcriber.whisper.path, "whisper-path", os.Getenv("WHISPER_PATH"), "Path of the whisper.cpp binary")
+		flag.StringVar(&instance.transcriber.whisper.model, "whisper-model", os.Getenv("WHISPER_MODEL"), "Path of the whisper model file")
+		flag.StringVar(&instance.transcriber.url, "transcriber-url", os.Getenv("TRANSCRIBER_URL"), "URL of the http transcription engine")
+		flag.StringVar(&instance.transcriber.token, "transcriber-token", os.Getenv("TRANSCRIBER_TOKEN"), "Bearer token of the http transcription engine")
+		flag.StringVar(&instance.transcriber.model, "transcriber-model", os.Getenv("TRANSCRIBER_MODEL"), "Model the http transcription engine runs")
+		flag.DurationVar(&instance.transcriber.timeout, "transcriber-timeout", 30*time.Minute, "Time limit of a transcription")
 
 		flag.StringVar(&instance.db.host, "db-host", os.Getenv("POSTGRES_HOST"), "Database host")
 		flag.StringVar(&instance.db.port, "db-port", os.Getenv("POSTGRES_PORT"), "Database port")
@@ -82,9 +280,27 @@ func getConfig() Config {
 		flag.StringVar(&instance.aws.s3bucket.mp4, "s3-mp4-bucket", os.Getenv("S3_MP4_BUCKET"), "S3 mp4 bucket name")
 		flag.StringVar(&instance.aws.s3bucket.mp3, "s3-mp3-bucket", os.Getenv("S3_MP3_BUCKET"), "S3 mp3 bucket name")
 		flag.StringVar(&instance.aws.s3Region, "s3-region", os.Getenv("S3_REGION"), "S3 region")
+		flag.StringVar(&instance.aws.s3Endpoint, "s3-endpoint", os.Getenv("S3_ENDPOINT"), "S3-compatible endpoint URL, e.g. MinIO, empty for AWS")
+		flag.BoolVar(&instance.aws.s3PathStyle, "s3-path-style", os.Getenv("S3_PATH_STYLE") == "true", "Use path-style bucket addressing")
+		flag.StringVar(&instance.aws.s3CABundle, "s3-ca-bundle", os.Getenv("S3_CA_BUNDLE"), "PEM file of extra certificate authorities for the S3 endpoint")
+		flag.StringVar(&instance.aws.sse.algorithm, "s3-sse", os.Getenv("S3_SSE"), "Server-side encryption of uploads (AES256|aws:kms), empty for the bucket default")
+		flag.StringVar(&instance.aws.sse.kmsKeyId, "s3-sse-kms-key", os.Getenv("S3_SSE_KMS_KEY_ID"), "KMS key for aws:kms server-side encryption")
+		flag.StringVar(&instance.aws.profile, "aws-profile", os.Getenv("AWS_PROFILE"), "Shared config profile")
 		flag.StringVar(&instance.aws.s3CloudFrontDistribution, "s3-cf", os.Getenv("S3_CLOUDFRONT_DISTRIBUTION"), "S3 CloudFront distribution ID")
+		flag.StringVar(&instance.aws.cloudFront.domain, "cf-domain", os.Getenv("CLOUDFRONT_DOMAIN"), "CloudFront domain serving the mp3 bucket, empty to use presigned storage URLs")
+		flag.StringVar(&instance.aws.cloudFront.keyPairId, "cf-key-pair-id", os.Getenv("CLOUDFRONT_KEY_PAIR_ID"), "CloudFront public key id used to sign URLs")
+		flag.StringVar(&instance.aws.cloudFront.privateKey, "cf-private-key", os.Getenv("CLOUDFRONT_PRIVATE_KEY_FILE"), "PEM file of the CloudFront signing key")
+		flag.DurationVar(&instance.aws.audioUrlExpiry, "audio-url-expiry", 7*24*time.Hour, "How long emailed audio links stay valid, at most 7 days for presigned S3 URLs")
 		flag.StringVar(&instance.aws.accessKeyId, "aws-access-key-id", os.Getenv("AWS_ACCESS_KEY_ID"), "AWS access key ID")
-		flag.StringVar(&instance.aws.secretAccessKey, "aws-secret-access-key", os.Getenv("AWS_SECRET_ACCESS_KEY"), "AWS secret access key")
+		flag.StringVar(&instance.aws.secretAccessKey, "aws-secret-access-key", os.Getenv("AWS_SECRET_ACCESS_KEY"), "AWS secret access key, leave both keys empty to use the default credential chain")
+
+		flag.StringVar(&instance.storage.backend, "storage", envOr("STORAGE_BACKEND", "s3"), "Storage backend (s3|disk|memory)")
+		flag.StringVar(&instance.storage.dir, "storage-dir", os.Getenv("STORAGE_DIR"), "Root directory of the disk storage backend")
+		flag.StringVar(&instance.storage.url, "storage-url", os.Getenv("STORAGE_URL"), "Base URL the gateway serves disk storage files from")
+		flag.StringVar(&instance.storage.secret, "storage-secret", os.Getenv("STORAGE_SECRET"), "Secret used to sign disk storage URLs")
+		flag.StringVar(&instance.storage.envelope.keys, "envelope-keys", os.Getenv("ENVELOPE_KEYS"), "Master keys for envelope encryption as id:hex pairs, empty to disable it")
+		flag.StringVar(&instance.storage.envelope.active, "envelope-active-key", os.Getenv("ENVELOPE_ACTIVE_KEY"), "Master key id that wraps new data keys")
+		flag.BoolVar(&instance.storage.envelope.encryptAudio, "envelope-encrypt-audio", os.Getenv("ENVELOPE_ENCRYPT_AUDIO") == "true", "Envelope encrypt stored audio, which disables download links")
 
 		flag.StringVar(&instance.rabbit.host, "rabbit-host", os.Getenv("AMQP_HOST"), "RabbitMQ host")
 		flag.StringVar(&instance.rabbit.username, "rabbit-username", os.Getenv("AMQP_USERNAME"), "RabbitMQ username")
@@ -92,6 +308,14 @@ func getConfig() Config {
 		flag.StringVar(&instance.rabbit.port, "rabbit-port", os.Getenv("AMQP_PORT"), "RabbitMQ password")
 		flag.StringVar(&instance.rabbit.queue.video, "rabbit-vid-queue", os.Getenv("AMQP_VIDEO_QUEUE_NAME"), "RabbitMQ video queue")
 		flag.StringVar(&instance.rabbit.queue.notification, "rabbit-notif-queue", os.Getenv("AMQP_NOTIFICATION_QUEUE_NAME"), "RabbitMQ notification queue")
+		flag.StringVar(&instance.rabbit.progressExchange, "rabbit-progress-exchange", envOr("AMQP_PROGRESS_EXCHANGE", "job_progress"), "RabbitMQ fanout exchange of job progress events")
+
+		flag.BoolVar(&instance.dryRun, "dry-run", false, "Report what the reconcile, retention and rekey commands would change without changing it")
+		flag.DurationVar(&instance.reconcile.grace, "grace", 24*time.Hour, "Minimum age of an orphaned object before it is deleted")
+
+		flag.BoolVar(&instance.retention.deleteVideoOnSuccess, "delete-video-on-success", os.Getenv("RETENTION_DELETE_VIDEO_ON_SUCCESS") == "true", "Delete the source video once its conversion succeeds")
+		flag.IntVar(&instance.retention.videoDays, "video-retention-days", envInt("RETENTION_VIDEO_DAYS"), "Days to keep source videos after conversion, 0 keeps them forever")
+		flag.IntVar(&instance.retention.audioDays, "audio-retention-days", envInt("RETENTION_AUDIO_DAYS"), "Days to keep unpinned audio after conversion, 0 keeps it forever")
 
 		flag.Parse()
 	})
@@ -2,11 +2,15 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/google/uuid"
 	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/ziliscite/video-to-mp3/converter/internal/domain"
 	"github.com/ziliscite/video-to-mp3/converter/internal/service"
+	"github.com/ziliscite/video-to-mp3/events"
+	"github.com/ziliscite/video-to-mp3/platform/mq"
+	"log/slog"
 	"net"
 	"time"
 )
@@ -15,19 +19,14 @@ type consumer struct {
 	cfg Config
 	ac  *amqp.Connection
 	cvs service.ConverterService
+	rs  service.RetentionService
+	ds  service.DeliveryService
 	np  service.NotificationService
 	vq  amqp.Queue
 }
 
-func newConsumer(cfg Config, ac *amqp.Connection, cvs service.ConverterService, np service.NotificationService) (*consumer, error) {
-	ch, err := ac.Channel()
-	if err != nil {
-		return nil, err
-	}
-	defer ch.Close()
-
-	// make queue durable
-	vq, err := ch.QueueDeclare(cfg.rabbit.queue.video, true, false, false, false, nil)
+func newConsumer(cfg Config, ac *amqp.Connection, cvs service.ConverterService, rs service.RetentionService, ds service.DeliveryService, np service.NotificationService) (*consumer, error) {
+	vq, err := mq.DeclareQueue(ac, cfg.rabbit.queue.video)
 	if err != nil {
 		return nil, err
 	}
@@ -36,6 +35,8 @@ func newConsumer(cfg Config, ac *amqp.Connection, cvs service.ConverterService,
 		cfg: cfg,
 		ac:  ac,
 		cvs: cvs,
+		rs:  rs,
+		ds:  ds,
 		np:  np,
 		vq:  vq,
 	}, nil
@@ -62,33 +63,10 @@ func (c *consumer) consume() error {
 		return err
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Minute)
-	defer cancel()
-
 	forever := make(chan bool)
 	go func() {
 		for v := range videos {
-			if err = c.consumeVideo(ctx, v.Body); err != nil {
-				var netErr net.Error
-				var amqpErr *amqp.Error
-				switch {
-				case errors.Is(err, amqp.ErrClosed):
-					v.Nack(false, true)
-				case errors.As(err, &netErr) && netErr.Temporary():
-					v.Nack(false, true)
-				case errors.As(err, &amqpErr) && amqpErr.Code == 320:
-					v.Nack(false, true)
-				case errors.Is(err, context.DeadlineExceeded):
-					v.Nack(false, true)
-				case errors.Is(err, service.ErrInternal):
-					v.Nack(false, true)
-				default:
-					v.Nack(false, false)
-				}
-				continue
-			}
-
-			v.Ack(false)
+			deliver(v, c.cfg.jobTimeout(), c.consumeMessage)
 		}
 	}()
 
@@ -96,33 +74,363 @@ func (c *consumer) consume() error {
 	return nil
 }
 
-func (c *consumer) consumeVideo(ctx context.Context, body []byte) error {
-	var request struct {
-		UserId    int64  `json:"user_id"`
-		UserEmail string `json:"user_email"`
-		FileSize  int64  `json:"file_size"`
-		FileKey   string `json:"file_key"`
+// deliver handles a delivery under a context of its own, bounded by the timeout unless it's zero,
+// and acks it or nacks it once handled. The context is cancelled then.
+func deliver(v amqp.Delivery, timeout time.Duration, handle func(context.Context, amqp.Delivery) error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if timeout > 0 {
+		var stop context.CancelFunc
+		ctx, stop = context.WithTimeout(ctx, timeout)
+		defer stop()
 	}
 
-	if err := json.Unmarshal(body, &request); err != nil {
+	if err := handle(ctx, v); err != nil {
+		// requeue if the error is transient, otherwise drop the message
+		v.Nack(false, retryable(err))
+		return
+	}
+
+	v.Ack(false)
+}
+
+func (c *consumer) consumeMessage(ctx context.Context, v amqp.Delivery) error {
+	// messages published before envelopes carry no type, they can only be videos
+	env, err := events.Decode(v.Body, events.TypeVideoUploaded, v.Headers)
+	if err != nil {
+		// reject
+		return fmt.Errorf("error decoding event: %v", err)
+	}
+
+	switch env.Type {
+	case events.TypeVideoUploaded:
+		return c.consumeVideo(ctx, env)
+	case events.TypeAudioPinned:
+		return c.consumePin(ctx, env)
+	case events.TypeTranscriptionRequested:
+		return c.consumeTranscription(ctx, env)
+	case events.TypeReencodeRequested:
+		return c.consumeReencode(ctx, env)
+	case events.TypeThumbnailRequested:
+		return c.consumeThumbnail(ctx, env)
+	case events.TypeStreamRequested:
+		return c.consumeStream(ctx, env)
+	default:
+		return fmt.Errorf("unexpected event %s on video queue", env.Type)
+	}
+}
+
+func (c *consumer) consumeVideo(ctx context.Context, env *events.Envelope) error {
+	var video events.VideoUploaded
+	if err := env.Unmarshal(&video); err != nil {
 		// reject
 		return fmt.Errorf("error unmarshalling video: %v", err)
 	}
 
-	result, err := c.cvs.ConvertMP4(ctx, request.UserId, request.FileSize, request.FileKey)
+	// the job id makes redeliveries idempotent. Videos published before gateways sent one
+	// fall back to the event id, which is stable across redeliveries of an envelope
+	jobId := video.JobId
+	if jobId == "" {
+		jobId = env.ID
+	}
+
+	result, err := c.cvs.ConvertMP4(ctx, jobId, video.UserId, video.FileSize, video.FileKey, video.FileName, options(&video), service.Throttle(service.ProgressStep, func(percent int) {
+		c.publishProgress(ctx, env, &events.JobProgress{JobId: jobId, UserId: video.UserId, Status: events.JobProcessing, Percent: percent})
+	}))
 	if err != nil {
-		switch {
-		case errors.Is(err, service.ErrInternal):
+		if retryable(err) {
 			return fmt.Errorf("error converting video: %w", err)
-		default:
-			return fmt.Errorf("error converting video: %v", err)
+		}
+
+		c.publishProgress(ctx, env, &events.JobProgress{JobId: jobId, UserId: video.UserId, Status: events.JobFailed})
+
+		if ferr := c.cvs.RecordFailure(ctx, jobId, err); ferr != nil {
+			slog.Error("Failed to record job failure", "error", ferr, "job_id", jobId)
+		}
+
+		// the message is about to be dropped, so let the user know it won't be converted
+		failure := c.cvs.Failure(&video, err)
+		if perr := c.np.PublishFailureNotification(ctx, env.Correlation(), failure); perr != nil {
+			slog.Error("Failed to publish failure notification", "error", perr, "reason", failure.Reason)
+		}
+
+		return fmt.Errorf("error converting video: %v", err)
+	}
+
+	c.publishProgress(ctx, env, &events.JobProgress{JobId: jobId, UserId: video.UserId, Status: events.JobCompleted, Percent: 100})
+
+	// the email still names the audio key when no link can be made
+	urls := make(map[string]string)
+	for _, key := range result.AudioKeys() {
+		if urls[key], err = c.ds.AudioURL(ctx, key); err != nil {
+			slog.Error("Failed to create audio url", "error", err, "metadata_id", result.Id, "audio_key", key)
+		}
+	}
+
+	// queued before the email, a redelivery queues them again under the same job ids
+	if video.Transcript != nil {
+		for _, key := range result.AudioKeys() {
+			if err = c.np.PublishTranscription(ctx, env.Correlation(), &events.TranscriptionRequested{
+				JobId: transcriptionJob(jobId, key), UserId: video.UserId, AudioKey: key, Language: video.Transcript.Language,
+			}); err != nil {
+				return fmt.Errorf("error requesting transcription: %w", err)
+			}
+		}
+	}
+
+	// the pictures and the stream are made from the video, so the stage making them applies the retention policy
+	// once done with it. When both read it, the video is left for the retention run to expire
+	thumbnail, stream := c.cfg.thumbnail.width > 0, video.Stream != nil
+	if thumbnail {
+		if err = c.np.PublishThumbnail(ctx, env.Correlation(), &events.ThumbnailRequested{
+			UserId: video.UserId, MetadataId: result.Id, VideoKey: result.VideoKey, FileSize: video.FileSize, AudioKey: result.AudioKey,
+			KeepVideo: stream, Preview: video.Preview,
+		}); err != nil {
+			return fmt.Errorf("error requesting thumbnail: %w", err)
+		}
+	}
+
+	if stream {
+		if err = c.np.PublishStream(ctx, env.Correlation(), &events.StreamRequested{
+			JobId: streamJob(jobId), UserId: video.UserId, MetadataId: result.Id, VideoKey: result.VideoKey, FileSize: video.FileSize,
+			AudioKey: result.AudioKey, Renditions: video.Stream.Renditions, KeepVideo: thumbnail,
+		}); err != nil {
+			return fmt.Errorf("error requesting stream: %w", err)
 		}
 	}
 
 	// publish to notification queue
-	if err = c.np.PublishEmailNotification(ctx, result, request.UserEmail); err != nil {
+	if err = c.np.PublishEmailNotification(ctx, env.Correlation(), result, video.UserEmail, urls); err != nil {
 		return fmt.Errorf("error publishing notification: %v", err)
 	}
 
+	// the conversion is done either way, a video left behind is expired by the retention run
+	if !thumbnail && !stream {
+		c.afterConversion(ctx, result)
+	}
+
 	return nil
 }
+
+// afterConversion applies the retention policy to the video of a finished conversion.
+func (c *consumer) afterConversion(ctx context.Context, metadata *domain.Metadata) {
+	if err := c.rs.AfterConversion(ctx, metadata); err != nil {
+		slog.Error("Failed to apply retention policy", "error", err, "metadata_id", metadata.Id)
+	}
+}
+
+// options reads how the video asked for its audio to be processed.
+func options(video *events.VideoUploaded) domain.Options {
+	opts := domain.Options{Loudness: video.Loudness, Preset: video.PresetId}
+	if f := video.Filters; f != nil {
+		opts.TrimSilence = f.TrimSilence
+		opts.CompressSilence = time.Duration(f.CompressSilence * float64(time.Second))
+		opts.HighPass = f.HighPass
+		opts.LowPass = f.LowPass
+		opts.NoiseReduction = f.NoiseReduction
+	}
+	if ch := video.Chapters; ch != nil {
+		opts.SplitChapters = true
+		for _, cue := range ch.Cues {
+			opts.Cues = append(opts.Cues, domain.Cue{Start: time.Duration(cue.Start * float64(time.Second)), Title: cue.Title})
+		}
+	}
+	return opts
+}
+
+// publishProgress reports how far the job got. Progress is only shown to users who are watching,
+// so failing to publish it never fails the job.
+func (c *consumer) publishProgress(ctx context.Context, env *events.Envelope, progress *events.JobProgress) {
+	if err := c.np.PublishProgress(ctx, env.Correlation(), progress); err != nil {
+		slog.Warn("Failed to publish progress", "error", err, "job_id", progress.JobId)
+	}
+}
+
+func (c *consumer) consumePin(ctx context.Context, env *events.Envelope) error {
+	var pin events.AudioPinned
+	if err := env.Unmarshal(&pin); err != nil {
+		// reject
+		return fmt.Errorf("error unmarshalling pin: %v", err)
+	}
+
+	if err := c.rs.Pin(ctx, pin.UserId, pin.AudioKey, pin.Pinned); err != nil {
+		return fmt.Errorf("error pinning audio: %w", err)
+	}
+
+	return nil
+}
+
+// transcriptionJob derives the id of the transcription of an audio asked for with its conversion,
+// so requesting it again for the same conversion is recognized as a redelivery.
+func transcriptionJob(jobId, audioKey string) string {
+	return uuid.NewSHA1(uuid.NameSpaceURL, []byte("transcript:"+jobId+"/"+audioKey)).String()
+}
+
+func (c *consumer) consumeTranscription(ctx context.Context, env *events.Envelope) error {
+	var request events.TranscriptionRequested
+	if err := env.Unmarshal(&request); err != nil {
+		// reject
+		return fmt.Errorf("error unmarshalling transcription: %v", err)
+	}
+
+	progress := &events.JobProgress{JobId: request.JobId, UserId: request.UserId, Status: events.JobProcessing}
+	c.publishProgress(ctx, env, progress)
+
+	if _, err := c.cvs.Transcribe(ctx, request.JobId, request.UserId, request.AudioKey, request.Language); err != nil {
+		if retryable(err) {
+			return fmt.Errorf("error transcribing audio: %w", err)
+		}
+
+		progress.Status = events.JobFailed
+		c.publishProgress(ctx, env, progress)
+
+		if ferr := c.cvs.RecordTranscriptionFailure(ctx, request.JobId, err); ferr != nil {
+			slog.Error("Failed to record transcription failure", "error", ferr, "job_id", request.JobId)
+		}
+
+		return fmt.Errorf("error transcribing audio: %v", err)
+	}
+
+	progress.Status, progress.Percent = events.JobCompleted, 100
+	c.publishProgress(ctx, env, progress)
+
+	return nil
+}
+
+// consumeReencode encodes an existing audio again. Users learn of a failure from the job's progress,
+// there is no video whose conversion failed to tell them about by email.
+func (c *consumer) consumeReencode(ctx context.Context, env *events.Envelope) error {
+	var request events.ReencodeRequested
+	if err := env.Unmarshal(&request); err != nil {
+		// reject
+		return fmt.Errorf("error unmarshalling re-encode: %v", err)
+	}
+
+	r := domain.Reencoding{
+		Format: request.Format, Bitrate: request.Bitrate, Loudness: request.Loudness,
+		Start: time.Duration(request.Start * float64(time.Second)),
+		End:   time.Duration(request.End * float64(time.Second)),
+	}
+
+	result, err := c.cvs.Reencode(ctx, request.JobId, request.UserId, request.AudioKey, r, service.Throttle(service.ProgressStep, func(percent int) {
+		c.publishProgress(ctx, env, &events.JobProgress{JobId: request.JobId, UserId: request.UserId, Status: events.JobProcessing, Percent: percent})
+	}))
+	if err != nil {
+		if retryable(err) {
+			return fmt.Errorf("error re-encoding audio: %w", err)
+		}
+
+		c.publishProgress(ctx, env, &events.JobProgress{JobId: request.JobId, UserId: request.UserId, Status: events.JobFailed})
+
+		// audio the user doesn't have may never have got a job to record the failure in
+		if ferr := c.cvs.RecordFailure(ctx, request.JobId, err); ferr != nil && !errors.Is(err, service.ErrAudioNotFound) {
+			slog.Error("Failed to record job failure", "error", ferr, "job_id", request.JobId)
+		}
+
+		return fmt.Errorf("error re-encoding audio: %v", err)
+	}
+
+	c.publishProgress(ctx, env, &events.JobProgress{JobId: request.JobId, UserId: request.UserId, Status: events.JobCompleted, Percent: 100})
+
+	// the email still names the audio key when no link can be made
+	urls := make(map[string]string)
+	if urls[result.AudioKey], err = c.ds.AudioURL(ctx, result.AudioKey); err != nil {
+		slog.Error("Failed to create audio url", "error", err, "metadata_id", result.Id, "audio_key", result.AudioKey)
+	}
+
+	if err = c.np.PublishEmailNotification(ctx, env.Correlation(), result, request.UserEmail, urls); err != nil {
+		return fmt.Errorf("error publishing notification: %v", err)
+	}
+
+	return nil
+}
+
+// consumeThumbnail draws the pictures of a converted video. They only decorate the audio,
+// so a video they can't be drawn from is dropped without failing anything.
+func (c *consumer) consumeThumbnail(ctx context.Context, env *events.Envelope) error {
+	var request events.ThumbnailRequested
+	if err := env.Unmarshal(&request); err != nil {
+		// reject
+		return fmt.Errorf("error unmarshalling thumbnail: %v", err)
+	}
+
+	_, _, err := c.cvs.Thumbnail(ctx, request.MetadataId, request.VideoKey, request.AudioKey, request.FileSize, request.Preview)
+	if retryable(err) {
+		return fmt.Errorf("error drawing thumbnail: %w", err)
+	}
+
+	// the video is no longer needed once its pictures are drawn, or can't be
+	if !request.KeepVideo {
+		c.afterConversion(ctx, &domain.Metadata{Id: request.MetadataId, VideoKey: request.VideoKey})
+	}
+
+	if err != nil {
+		return fmt.Errorf("error drawing thumbnail: %v", err)
+	}
+
+	return nil
+}
+
+// streamJob derives the id of the transcoding of the video of a conversion,
+// so requesting it again for the same conversion is recognized as a redelivery.
+func streamJob(jobId string) string {
+	return uuid.NewSHA1(uuid.NameSpaceURL, []byte("stream:"+jobId)).String()
+}
+
+// consumeStream transcodes the video of a conversion into HLS. Like for a transcription,
+// users learn of a failure from the job's progress, their audio was converted all the same.
+func (c *consumer) consumeStream(ctx context.Context, env *events.Envelope) error {
+	var request events.StreamRequested
+	if err := env.Unmarshal(&request); err != nil {
+		// reject
+		return fmt.Errorf("error unmarshalling stream: %v", err)
+	}
+
+	s := domain.Streaming{Renditions: request.Renditions}
+	_, err := c.cvs.Stream(ctx, request.JobId, request.UserId, request.MetadataId, request.VideoKey, request.AudioKey, request.FileSize, s, service.Throttle(service.ProgressStep, func(percent int) {
+		c.publishProgress(ctx, env, &events.JobProgress{JobId: request.JobId, UserId: request.UserId, Status: events.JobProcessing, Percent: percent})
+	}))
+	if retryable(err) {
+		return fmt.Errorf("error transcoding video: %w", err)
+	}
+
+	// the video is no longer needed once it's transcoded, or can't be
+	if !request.KeepVideo {
+		c.afterConversion(ctx, &domain.Metadata{Id: request.MetadataId, VideoKey: request.VideoKey})
+	}
+
+	if err != nil {
+		c.publishProgress(ctx, env, &events.JobProgress{JobId: request.JobId, UserId: request.UserId, Status: events.JobFailed})
+
+		if ferr := c.cvs.RecordStreamFailure(ctx, request.JobId, err); ferr != nil {
+			slog.Error("Failed to record transcoding failure", "error", ferr, "job_id", request.JobId)
+		}
+
+		return fmt.Errorf("error transcoding video: %v", err)
+	}
+
+	c.publishProgress(ctx, env, &events.JobProgress{JobId: request.JobId, UserId: request.UserId, Status: events.JobCompleted, Percent: 100})
+
+	return nil
+}
+
+// retryable reports whether the message should be requeued.
+func retryable(err error) bool {
+	var netErr net.Error
+	var amqpErr *amqp.Error
+	switch {
+	case errors.Is(err, amqp.ErrClosed):
+		return true
+	case errors.As(err, &netErr) && netErr.Temporary():
+		return true
+	case errors.As(err, &amqpErr) && amqpErr.Code == 320:
+		return true
+	case errors.Is(err, context.DeadlineExceeded):
+		return true
+	case errors.Is(err, service.ErrInternal):
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/ziliscite/video-to-mp3/converter/internal/service"
+)
+
+// acknowledger records how deliveries were settled.
+type acknowledger struct {
+	acks, nacks, requeues int
+}
+
+func (a *acknowledger) Ack(uint64, bool) error {
+	a.acks++
+	return nil
+}
+
+func (a *acknowledger) Nack(_ uint64, _ bool, requeue bool) error {
+	a.nacks++
+	if requeue {
+		a.requeues++
+	}
+	return nil
+}
+
+func (a *acknowledger) Reject(_ uint64, requeue bool) error {
+	return a.Nack(0, false, requeue)
+}
+
+func TestDeliver(t *testing.T) {
+	t.Run("context of its own", func(t *testing.T) {
+		ack := &acknowledger{}
+		timeout := 50 * time.Millisecond
+
+		// a context made when the consumer started would have expired by the second delivery
+		var contexts []context.Context
+		slow := func(ctx context.Context, _ amqp.Delivery) error {
+			select {
+			case <-time.After(30 * time.Millisecond):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			contexts = append(contexts, ctx)
+			return nil
+		}
+
+		for range 3 {
+			deliver(amqp.Delivery{Acknowledger: ack}, timeout, slow)
+		}
+
+		if ack.acks != 3 || ack.nacks != 0 {
+			t.Errorf("Expected 3 acks, got %d acks and %d nacks", ack.acks, ack.nacks)
+		}
+
+		for i, ctx := range contexts {
+			if !errors.Is(ctx.Err(), context.Canceled) {
+				t.Errorf("Expected the context of delivery %d to be cancelled once acked, got %v", i, ctx.Err())
+			}
+		}
+	})
+
+	t.Run("outlives its timeout", func(t *testing.T) {
+		ack := &acknowledger{}
+
+		deliver(amqp.Delivery{Acknowledger: ack}, 10*time.Millisecond, func(ctx context.Context, _ amqp.Delivery) error {
+			<-ctx.Done()
+			return ctx.Err()
+		})
+
+		if ack.nacks != 1 || ack.requeues != 1 {
+			t.Errorf("Expected the delivery to be requeued, got %d nacks and %d requeues", ack.nacks, ack.requeues)
+		}
+	})
+
+	t.Run("permanent failure", func(t *testing.T) {
+		ack := &acknowledger{}
+
+		deliver(amqp.Delivery{Acknowledger: ack}, 0, func(ctx context.Context, _ amqp.Delivery) error {
+			if _, ok := ctx.Deadline(); ok {
+				t.Errorf("Expected no deadline without a timeout")
+			}
+			return service.ErrAudioNotFound
+		})
+
+		if ack.nacks != 1 || ack.requeues != 0 {
+			t.Errorf("Expected the delivery to be dropped, got %d nacks and %d requeues", ack.nacks, ack.requeues)
+		}
+	})
+}
+
+func TestJobTimeout(t *testing.T) {
+	var cfg Config
+	if got := cfg.jobTimeout(); got != maxJobTimeout {
+		t.Errorf("Expected deliveries of runs without timeouts to be bounded by %s, got %s", maxJobTimeout, got)
+	}
+
+	cfg.transcriber.timeout = time.Minute
+	if got := cfg.jobTimeout(); got != jobRuns*time.Minute {
+		t.Errorf("Expected %s, got %s", jobRuns*time.Minute, got)
+	}
+}
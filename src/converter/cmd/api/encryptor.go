@@ -0,0 +1,20 @@
+package main
+
+import (
+	"github.com/ziliscite/video-to-mp3/platform/encryptor"
+)
+
+// newEncryptor creates the file key encryptor. The gateway encrypts the video keys,
+// so both must be given the same keyring.
+func newEncryptor(cfg Config) (*encryptor.Encryptor, error) {
+	if cfg.encryptKeys.keys == "" {
+		return encryptor.NewEncryptor(cfg.encryptKey)
+	}
+
+	secrets, err := encryptor.ParseKeys(cfg.encryptKeys.keys)
+	if err != nil {
+		return nil, err
+	}
+
+	return encryptor.NewKeyring(cfg.encryptKeys.active, secrets, cfg.encryptKey)
+}
@@ -2,24 +2,27 @@ package main
 
 import (
 	"context"
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/credentials"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
 	amqp "github.com/rabbitmq/amqp091-go"
 	"github.com/ziliscite/video-to-mp3/converter/external/ffmpeg"
 	"github.com/ziliscite/video-to-mp3/converter/internal/domain"
 	"github.com/ziliscite/video-to-mp3/converter/internal/service"
-	"github.com/ziliscite/video-to-mp3/converter/pkg/db"
+	"github.com/ziliscite/video-to-mp3/platform/db"
+	"strings"
 	"time"
 
 	"github.com/ziliscite/video-to-mp3/converter/internal/repository"
-	"github.com/ziliscite/video-to-mp3/converter/pkg/encryptor"
 
 	"log/slog"
 	"os"
 )
 
 func main() {
+	command := subcommand()
+	if command != "" && command != "reconcile" && command != "retention" && command != "rekey" {
+		slog.Error("Unknown command", "command", command)
+		os.Exit(2)
+	}
+
 	cfg := getConfig()
 	db.AutoMigrate(cfg.db.dsn())
 
@@ -32,14 +35,59 @@ func main() {
 		os.Exit(1)
 	}
 
-	s3c := s3.NewFromConfig(aws.Config{
-		Region: cfg.aws.s3Region,
-		Credentials: credentials.NewStaticCredentialsProvider(
-			cfg.aws.accessKeyId,
-			cfg.aws.secretAccessKey,
-			"",
-		),
-	})
+	fr, err := newFileStore(ctx, cfg)
+	if err != nil {
+		slog.Error("Failed to create file store", "error", err)
+		os.Exit(1)
+	}
+
+	mr := repository.NewMetadataRepo(pool)
+
+	enc, err := newEncryptor(cfg)
+	if err != nil {
+		slog.Error("Failed to create encryptor", "error", err)
+		os.Exit(1)
+	}
+
+	cdn, err := newCDN(ctx, cfg)
+	if err != nil {
+		slog.Error("Failed to create cdn", "error", err)
+		os.Exit(1)
+	}
+
+	ds := service.NewDeliveryService(fr, cdn, cfg.aws.s3bucket.mp3, cfg.aws.audioUrlExpiry)
+
+	rs := service.NewRetentionService(fr, repository.NewRetentionRepo(pool), ds, service.RetentionPolicy{
+		DeleteVideoOnSuccess: cfg.retention.deleteVideoOnSuccess,
+		VideoDays:            cfg.retention.videoDays,
+		AudioDays:            cfg.retention.audioDays,
+	}, cfg.aws.s3bucket.mp4, cfg.aws.s3bucket.mp3)
+
+	if command == "reconcile" {
+		rec := service.NewReconciler(fr, mr, cfg.aws.s3bucket.mp4, cfg.aws.s3bucket.mp3, cfg.reconcile.grace)
+		if err = reconcile(rec, cfg.dryRun); err != nil {
+			slog.Error("Failed to reconcile buckets", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if command == "retention" {
+		if err = retention(rs, cfg.dryRun); err != nil {
+			slog.Error("Failed to apply retention policy", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if command == "rekey" {
+		rk := service.NewRekeyer(fr, repository.NewKeyRepo(pool), enc, ds, cfg.aws.s3bucket.mp4, cfg.aws.s3bucket.mp3)
+		if err = rekey(rk, cfg.dryRun); err != nil {
+			slog.Error("Failed to rekey", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
 
 	conn, err := amqp.Dial(cfg.rabbit.dsn())
 	if err != nil {
@@ -48,39 +96,71 @@ func main() {
 	}
 	defer conn.Close()
 
-	enc, err := encryptor.NewEncryptor(cfg.encryptKey)
+	ffp, closeFFmpeg, err := ffmpeg.Open()
 	if err != nil {
-		slog.Error("Failed to create encryptor", "error", err)
+		slog.Error("Failed to open ffmpeg", "error", err)
 		os.Exit(1)
 	}
+	defer closeFFmpeg()
 
-	ffp, err := ffmpeg.Open()
+	tr, err := newTranscriber(cfg)
 	if err != nil {
-		slog.Error("Failed to open ffmpeg", "error", err)
+		slog.Error("Failed to create transcriber", "error", err)
 		os.Exit(1)
 	}
 
-	cvt := domain.NewConverter(ffp)
-	fr := repository.NewStore(s3c)
+	cvt := domain.NewConverter(ffp, cfg.maxDuration, cfg.ffmpeg.limits())
+	jr := repository.NewJobRepo(pool)
+	xr := repository.NewTranscriptRepo(pool)
+	sr := repository.NewStreamRepo(pool)
+	pr := repository.NewPresetRepo(pool)
 
-	mr := repository.NewMetadataRepo(pool)
-
-	cvs := service.NewConverterService(cvt, fr, mr, enc, cfg.aws.s3bucket.mp4, cfg.aws.s3bucket.mp3)
+	cvs := service.NewConverterService(cvt, tr, fr, mr, jr, xr, sr, pr, enc, cfg.aws.s3bucket.mp4, cfg.aws.s3bucket.mp3, cfg.waveform.config(), cfg.thumbnail.config())
 
-	np, err := service.NewPublisher(conn, cfg.rabbit.queue.notification)
+	np, err := service.NewPublisher(conn, cfg.rabbit.queue.notification, cfg.rabbit.progressExchange, cfg.rabbit.queue.video)
 	if err != nil {
 		slog.Error("Failed to create publisher", "error", err)
 		os.Exit(1)
 	}
 
-	con, err := newConsumer(cfg, conn, cvs, np)
+	con, err := newConsumer(cfg, conn, cvs, rs, ds, np)
 	if err != nil {
 		slog.Error("Failed to create consumer", "error", err)
 		os.Exit(1)
 	}
 
+	// the gateway manages the presets of users and lists their conversions through the API, the consumer resolves the presets
+	if cfg.serviceToken != "" {
+		go func() {
+			if err := serve(cfg, service.NewPresetService(pr), service.NewLibraryService(mr, ds)); err != nil {
+				slog.Error("Failed to serve", "error", err)
+				os.Exit(1)
+			}
+		}()
+	} else {
+		slog.Warn("The API is disabled without a service token, presets can't be managed nor conversions listed")
+	}
+
 	if err = con.consume(); err != nil {
 		slog.Error("Failed to consume", "error", err)
 		os.Exit(1)
 	}
 }
+
+// subcommand removes the command from the arguments so that the flags after it still parse,
+// e.g. `converter reconcile --dry-run`. Running without a command consumes the video queue.
+//
+// Commands:
+//
+//	reconcile  delete objects that no metadata or job refers to
+//	retention  expire source videos and audio past their retention period
+//	rekey      encrypt filenames again with the active key and move objects off legacy keys
+func subcommand() string {
+	if len(os.Args) < 2 || strings.HasPrefix(os.Args[1], "-") {
+		return ""
+	}
+
+	command := os.Args[1]
+	os.Args = append(os.Args[:1], os.Args[2:]...)
+	return command
+}
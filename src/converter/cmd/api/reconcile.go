@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/ziliscite/video-to-mp3/converter/internal/service"
+)
+
+func reconcile(rec service.Reconciler, dryRun bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+
+	report, err := rec.Reconcile(ctx, dryRun)
+	if err != nil {
+		return err
+	}
+
+	var size int64
+	for _, o := range report.Orphans {
+		size += o.Size
+		slog.Info("Orphaned object",
+			"bucket", o.Bucket, "key", o.Key,
+			"size", o.Size, "last_modified", o.LastModified,
+		)
+	}
+
+	slog.Info("Reconciliation finished",
+		"dry_run", dryRun, "scanned", report.Scanned,
+		"orphans", len(report.Orphans), "orphaned_bytes", size,
+		"deleted", report.Deleted, "failed", report.Failed,
+	)
+
+	return nil
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/ziliscite/video-to-mp3/converter/internal/service"
+)
+
+func rekey(rk service.Rekeyer, dryRun bool) error {
+	// every object is copied, which takes far longer than the other commands
+	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Hour)
+	defer cancel()
+
+	report, err := rk.Rekey(ctx, dryRun)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Rekey finished",
+		"dry_run", dryRun, "scanned", report.Scanned,
+		"rekeyed", report.Rekeyed, "failed", report.Failed,
+	)
+
+	return nil
+}
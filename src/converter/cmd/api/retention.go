@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/ziliscite/video-to-mp3/converter/internal/service"
+)
+
+func retention(rs service.RetentionService, dryRun bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+
+	report, err := rs.Expire(ctx, dryRun)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range report.Videos {
+		slog.Info("Expired video", "metadata_id", e.MetadataId, "bucket", e.Bucket, "key", e.Key)
+	}
+
+	for _, e := range report.Audios {
+		slog.Info("Expired audio", "metadata_id", e.MetadataId, "bucket", e.Bucket, "key", e.Key)
+	}
+
+	slog.Info("Retention finished",
+		"dry_run", dryRun, "videos", len(report.Videos), "audios", len(report.Audios),
+		"deleted", report.Deleted, "failed", report.Failed,
+	)
+
+	return nil
+}
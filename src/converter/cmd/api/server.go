@@ -0,0 +1,238 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ziliscite/video-to-mp3/converter/internal/domain"
+	"github.com/ziliscite/video-to-mp3/converter/internal/service"
+)
+
+// maxBody is how large a request to the converter's API may be.
+const maxBody = 1 << 16 // 64 KB
+
+// server is the API of the converter for the other services, it's not exposed to users.
+// Callers hold the service token, the gateway then names the user it authenticated in the X-User-Id header.
+type server struct {
+	token []byte
+	ps    service.PresetService
+	ls    service.LibraryService
+}
+
+// userHandler handles a request on behalf of the user.
+type userHandler func(w http.ResponseWriter, r *http.Request, userId int64)
+
+func serve(cfg Config, ps service.PresetService, ls service.LibraryService) error {
+	s := &server{token: []byte(cfg.serviceToken), ps: ps, ls: ls}
+
+	srv := &http.Server{
+		Addr:         fmt.Sprintf("0.0.0.0:%d", cfg.port),
+		Handler:      s.routes(),
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 30 * time.Second,
+	}
+
+	return srv.ListenAndServe()
+}
+
+func (s *server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/ping", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"message": "pong"})
+	})
+
+	mux.HandleFunc("GET /v1/presets", s.user(s.listPresets))
+	mux.HandleFunc("POST /v1/presets", s.user(s.createPreset))
+	mux.HandleFunc("GET /v1/presets/{id}", s.user(s.getPreset))
+	mux.HandleFunc("PUT /v1/presets/{id}", s.user(s.updatePreset))
+	mux.HandleFunc("DELETE /v1/presets/{id}", s.user(s.deletePreset))
+
+	mux.HandleFunc("GET /v1/conversions", s.user(s.listConversions))
+
+	return mux
+}
+
+// user lets callers holding the service token act on behalf of the user they name.
+func (s *server) user(next userHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || len(s.token) == 0 || subtle.ConstantTimeCompare([]byte(token), s.token) != 1 {
+			writeError(w, http.StatusUnauthorized, "invalid service token")
+			return
+		}
+
+		userId, err := strconv.ParseInt(r.Header.Get("X-User-Id"), 10, 64)
+		if err != nil || userId < 1 {
+			writeError(w, http.StatusBadRequest, "invalid user")
+			return
+		}
+
+		next(w, r, userId)
+	}
+}
+
+func (s *server) listPresets(w http.ResponseWriter, r *http.Request, userId int64) {
+	presets, err := s.ps.List(r.Context(), userId)
+	if err != nil {
+		presetError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"presets": presets})
+}
+
+func (s *server) createPreset(w http.ResponseWriter, r *http.Request, userId int64) {
+	preset, ok := readPreset(w, r)
+	if !ok {
+		return
+	}
+
+	preset.UserId = userId
+	if err := s.ps.Create(r.Context(), preset); err != nil {
+		presetError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, preset)
+}
+
+func (s *server) getPreset(w http.ResponseWriter, r *http.Request, userId int64) {
+	id, ok := presetId(w, r)
+	if !ok {
+		return
+	}
+
+	preset, err := s.ps.Get(r.Context(), userId, id)
+	if err != nil {
+		presetError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, preset)
+}
+
+func (s *server) updatePreset(w http.ResponseWriter, r *http.Request, userId int64) {
+	id, ok := presetId(w, r)
+	if !ok {
+		return
+	}
+
+	preset, ok := readPreset(w, r)
+	if !ok {
+		return
+	}
+
+	preset.Id, preset.UserId = id, userId
+	if err := s.ps.Update(r.Context(), preset); err != nil {
+		presetError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, preset)
+}
+
+func (s *server) deletePreset(w http.ResponseWriter, r *http.Request, userId int64) {
+	id, ok := presetId(w, r)
+	if !ok {
+		return
+	}
+
+	if err := s.ps.Delete(r.Context(), userId, id); err != nil {
+		presetError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// listConversions lists the conversions of the user a page at a time, the next page is the one before the last id listed.
+func (s *server) listConversions(w http.ResponseWriter, r *http.Request, userId int64) {
+	var (
+		before int64
+		limit  int
+		err    error
+	)
+	if v := r.URL.Query().Get("before"); v != "" {
+		if before, err = strconv.ParseInt(v, 10, 64); err != nil || before < 1 {
+			writeError(w, http.StatusBadRequest, "before must be the id of a conversion")
+			return
+		}
+	}
+
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > service.MaxConversions {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", service.MaxConversions))
+			return
+		}
+	}
+
+	conversions, err := s.ls.Conversions(r.Context(), userId, before, limit)
+	if err != nil {
+		slog.Error("Failed to list conversions", "error", err, "user_id", userId)
+		writeError(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"conversions": conversions})
+}
+
+// readPreset decodes the preset of the body, it responds itself when it can't.
+// Its id, user and timestamps are the converter's to set, whatever the body says.
+func readPreset(w http.ResponseWriter, r *http.Request) (*domain.Preset, bool) {
+	var preset domain.Preset
+
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&preset); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return nil, false
+	}
+
+	preset.Id, preset.UserId, preset.CreatedAt, preset.UpdatedAt = 0, 0, time.Time{}, time.Time{}
+	return &preset, true
+}
+
+// presetId reads the id of the preset from the path, it responds itself when it can't.
+func presetId(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id < 1 {
+		writeError(w, http.StatusNotFound, service.ErrPresetNotFound.Error())
+		return 0, false
+	}
+	return id, true
+}
+
+// presetError responds with the status of an error of the preset service.
+func presetError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, domain.ErrInvalidOptions):
+		writeError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, service.ErrPresetNotFound):
+		writeError(w, http.StatusNotFound, service.ErrPresetNotFound.Error())
+	case errors.Is(err, service.ErrPresetExists):
+		writeError(w, http.StatusConflict, err.Error())
+	default:
+		slog.Error("Failed to access presets", "error", err)
+		writeError(w, http.StatusInternalServerError, "something went wrong")
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("Failed to write response", "error", err)
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ziliscite/video-to-mp3/converter/internal/domain"
+	"github.com/ziliscite/video-to-mp3/converter/internal/service"
+)
+
+// presets keeps the presets of every user in memory.
+type presets map[int64]*domain.Preset
+
+func (p presets) Create(_ context.Context, preset *domain.Preset) error {
+	if err := preset.Validate(); err != nil {
+		return err
+	}
+	preset.Id = int64(len(p) + 1)
+	p[preset.Id] = preset
+	return nil
+}
+
+func (p presets) Get(_ context.Context, userId, id int64) (*domain.Preset, error) {
+	if preset, ok := p[id]; ok && preset.UserId == userId {
+		return preset, nil
+	}
+	return nil, service.ErrPresetNotFound
+}
+
+func (p presets) List(_ context.Context, userId int64) ([]*domain.Preset, error) {
+	var list []*domain.Preset
+	for _, preset := range p {
+		if preset.UserId == userId {
+			list = append(list, preset)
+		}
+	}
+	return list, nil
+}
+
+func (p presets) Update(ctx context.Context, preset *domain.Preset) error {
+	if _, err := p.Get(ctx, preset.UserId, preset.Id); err != nil {
+		return err
+	}
+	p[preset.Id] = preset
+	return nil
+}
+
+func (p presets) Delete(ctx context.Context, userId, id int64) error {
+	if _, err := p.Get(ctx, userId, id); err != nil {
+		return err
+	}
+	delete(p, id)
+	return nil
+}
+
+// library lists the conversions it's given, recording how it was asked to.
+type library struct {
+	conversions []*service.Conversion
+	userId      int64
+	before      int64
+	limit       int
+}
+
+func (l *library) Conversions(_ context.Context, userId, before int64, limit int) ([]*service.Conversion, error) {
+	l.userId, l.before, l.limit = userId, before, limit
+	return l.conversions, nil
+}
+
+func TestServer(t *testing.T) {
+	const token = "secret"
+
+	request := func(h http.Handler, method, path, token, user, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		if user != "" {
+			r.Header.Set("X-User-Id", user)
+		}
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("service token", func(t *testing.T) {
+		h := (&server{token: []byte(token), ps: presets{}}).routes()
+
+		for _, tt := range []struct{ name, token string }{{"none", ""}, {"wrong", "guess"}} {
+			if w := request(h, http.MethodGet, "/v1/presets", tt.token, "1", ""); w.Code != http.StatusUnauthorized {
+				t.Errorf("Expected %d with %s token, got %d", http.StatusUnauthorized, tt.name, w.Code)
+			}
+		}
+
+		// without a token of its own, the API lets no one in
+		open := (&server{ps: presets{}}).routes()
+		if w := request(open, http.MethodGet, "/v1/presets", "", "1", ""); w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected %d without a token, got %d", http.StatusUnauthorized, w.Code)
+		}
+
+		if w := request(h, http.MethodGet, "/v1/presets", token, "", ""); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected %d without a user, got %d", http.StatusBadRequest, w.Code)
+		}
+	})
+
+	t.Run("presets of the user", func(t *testing.T) {
+		h := (&server{token: []byte(token), ps: presets{}}).routes()
+
+		w := request(h, http.MethodPost, "/v1/presets", token, "1", `{"name":"Lectures","loudness":"podcast","user_id":2,"id":7}`)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("Expected %d, got %d: %s", http.StatusCreated, w.Code, w.Body)
+		}
+
+		var created domain.Preset
+		if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+			t.Fatalf("Failed to decode preset: %v", err)
+		}
+
+		if created.UserId != 1 || created.Id != 1 {
+			t.Errorf("Expected the preset of user 1 with an id of the converter's, got %+v", created)
+		}
+
+		if w = request(h, http.MethodGet, "/v1/presets/1", token, "1", ""); w.Code != http.StatusOK {
+			t.Errorf("Expected %d, got %d", http.StatusOK, w.Code)
+		}
+
+		for _, method := range []string{http.MethodGet, http.MethodDelete} {
+			if w = request(h, method, "/v1/presets/1", token, "2", ""); w.Code != http.StatusNotFound {
+				t.Errorf("Expected %s of the preset of someone else to be %d, got %d", method, http.StatusNotFound, w.Code)
+			}
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		h := (&server{token: []byte(token), ps: presets{}}).routes()
+
+		for _, body := range []string{`{"name":""}`, `{"name":"Music","format":"flac"}`, `{"name":"Music","colour":"red"}`, `not json`} {
+			if w := request(h, http.MethodPost, "/v1/presets", token, "1", body); w.Code != http.StatusBadRequest {
+				t.Errorf("Expected %d for %s, got %d", http.StatusBadRequest, body, w.Code)
+			}
+		}
+	})
+
+	t.Run("conversions of the user", func(t *testing.T) {
+		ls := &library{conversions: []*service.Conversion{{
+			Id: 3, FileName: "lecture.mp4", AudioURL: "https://cdn.test/a.mp3", PeaksURL: "https://cdn.test/a.json",
+			ThumbnailURL: "https://cdn.test/thumbnails/a.jpg", PreviewURL: "https://cdn.test/thumbnails/a.webp",
+		}}}
+		h := (&server{token: []byte(token), ps: presets{}, ls: ls}).routes()
+
+		w := request(h, http.MethodGet, "/v1/conversions?before=7&limit=10", token, "1", "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected %d, got %d: %s", http.StatusOK, w.Code, w.Body)
+		}
+
+		if ls.userId != 1 || ls.before != 7 || ls.limit != 10 {
+			t.Errorf("Expected the page of user 1 before 7 of 10, got user %d before %d of %d", ls.userId, ls.before, ls.limit)
+		}
+
+		var body struct {
+			Conversions []service.Conversion `json:"conversions"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("Failed to decode conversions: %v", err)
+		}
+
+		if len(body.Conversions) != 1 || !reflect.DeepEqual(body.Conversions[0], *ls.conversions[0]) {
+			t.Errorf("Expected the conversion with its links, got %s", w.Body)
+		}
+
+		for _, query := range []string{"before=0", "before=last", "limit=0", "limit=1000"} {
+			if w = request(h, http.MethodGet, "/v1/conversions?"+query, token, "1", ""); w.Code != http.StatusBadRequest {
+				t.Errorf("Expected %d for %s, got %d", http.StatusBadRequest, query, w.Code)
+			}
+		}
+
+		if w = request(h, http.MethodGet, "/v1/conversions", "guess", "1", ""); w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected %d with a wrong token, got %d", http.StatusUnauthorized, w.Code)
+		}
+	})
+}
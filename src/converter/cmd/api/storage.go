@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudfront"
+
+	"github.com/ziliscite/video-to-mp3/converter/internal/repository"
+	"github.com/ziliscite/video-to-mp3/platform/envelope"
+	"github.com/ziliscite/video-to-mp3/platform/storage"
+)
+
+// newFileStore creates the configured storage backend, wrapped in envelope encryption when master keys are set.
+func newFileStore(ctx context.Context, cfg Config) (storage.FileStore, error) {
+	fs, err := newBackend(ctx, cfg)
+	if err != nil || cfg.storage.envelope.keys == "" {
+		return fs, err
+	}
+
+	kr, err := envelope.ParseKeyring(cfg.storage.envelope.active, cfg.storage.envelope.keys)
+	if err != nil {
+		return nil, err
+	}
+
+	// videos are encrypted by the gateway, the converter only has to read them
+	var encrypted []string
+	if cfg.storage.envelope.encryptAudio {
+		encrypted = append(encrypted, cfg.aws.s3bucket.mp3)
+		slog.Warn("Audio is envelope encrypted, uploads asking for a stream won't get one")
+	}
+
+	return storage.NewEncryptedStore(fs, kr, encrypted...), nil
+}
+
+// newBackend creates the configured storage backend. The disk backend must share its
+// directory with the gateway, the memory backend only works when nothing else reads the files.
+func newBackend(ctx context.Context, cfg Config) (storage.FileStore, error) {
+	switch cfg.storage.backend {
+	case "s3":
+		sse := cfg.aws.s3SSE()
+		if err := sse.Validate(); err != nil {
+			return nil, err
+		}
+
+		s3c, err := storage.NewS3Client(ctx, cfg.aws.s3Config())
+		if err != nil {
+			return nil, err
+		}
+
+		return storage.NewStore(s3c, sse), nil
+	case "disk":
+		if cfg.storage.dir == "" {
+			return nil, fmt.Errorf("disk storage needs a directory")
+		}
+
+		var signer *storage.URLSigner
+		if cfg.storage.url != "" {
+			signer = storage.NewURLSigner(cfg.storage.url, []byte(cfg.storage.secret))
+		}
+
+		return storage.NewDiskStore(cfg.storage.dir, signer)
+	case "memory":
+		return storage.NewMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.storage.backend)
+	}
+}
+
+// newCDN creates the CloudFront distribution in front of the mp3 bucket,
+// or returns nil when none is configured so audio is served through presigned URLs.
+func newCDN(ctx context.Context, cfg Config) (repository.CDN, error) {
+	cf := cfg.aws.cloudFront
+	if cf.domain == "" {
+		return nil, nil
+	}
+
+	if cfg.storage.envelope.encryptAudio {
+		return nil, fmt.Errorf("cloudfront can't serve envelope encrypted audio")
+	}
+
+	if cfg.aws.s3CloudFrontDistribution == "" || cf.keyPairId == "" || cf.privateKey == "" {
+		return nil, fmt.Errorf("cloudfront needs a distribution id, key pair id and private key")
+	}
+
+	key, err := repository.LoadCloudFrontKey(cf.privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// the endpoint and CA bundle belong to the storage server, CloudFront is always AWS
+	awsCfg, err := storage.LoadAWSConfig(ctx, storage.S3Config{
+		Region:          cfg.aws.s3Region,
+		Profile:         cfg.aws.profile,
+		AccessKeyId:     cfg.aws.accessKeyId,
+		SecretAccessKey: cfg.aws.secretAccessKey,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return repository.NewCloudFront(cloudfront.NewFromConfig(awsCfg), repository.CloudFrontConfig{
+		Domain:         cf.domain,
+		DistributionId: cfg.aws.s3CloudFrontDistribution,
+		KeyPairId:      cf.keyPairId,
+		PrivateKey:     key,
+	}), nil
+}
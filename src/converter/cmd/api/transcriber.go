@@ -0,0 +1,28 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ziliscite/video-to-mp3/platform/transcriber"
+)
+
+// newTranscriber creates the configured transcription engine, nil when none is configured.
+func newTranscriber(cfg Config) (transcriber.Transcriber, error) {
+	switch cfg.transcriber.engine {
+	case "":
+		return nil, nil
+	case "whisper":
+		if cfg.transcriber.whisper.path == "" || cfg.transcriber.whisper.model == "" {
+			return nil, errors.New("the whisper engine needs a binary and a model")
+		}
+		return transcriber.NewWhisper(cfg.transcriber.whisper.path, cfg.transcriber.whisper.model), nil
+	case "http":
+		if cfg.transcriber.url == "" {
+			return nil, errors.New("the http engine needs an url")
+		}
+		return transcriber.NewHTTP(cfg.transcriber.url, cfg.transcriber.token, cfg.transcriber.model, cfg.transcriber.timeout), nil
+	default:
+		return nil, fmt.Errorf("unknown transcription engine %q", cfg.transcriber.engine)
+	}
+}
@@ -9,22 +9,21 @@ import (
 //go:embed ffmpeg
 var binary []byte
 
-func Open() (string, error) {
-	binDir := "/usr/local/bin"
-	outputPath := filepath.Join(binDir, "ffmpeg")
+// Open writes the embedded binary to a directory only this process can reach, and returns
+// its path along with a function removing it. System directories are left untouched.
+func Open() (string, func(), error) {
+	dir, err := os.MkdirTemp("", "ffmpeg-*")
+	if err != nil {
+		return "", nil, err
+	}
 
-	// check if the file already exists
-	if _, err := os.Stat(outputPath); os.IsNotExist(err) {
-		// create a directory if it doesn't exist
-		if err := os.MkdirAll(binDir, 0755); err != nil {
-			return "", err
-		}
+	cleanup := func() { _ = os.RemoveAll(dir) }
 
-		// write embedded binary to the file
-		if err = os.WriteFile(outputPath, binary, 0755); err != nil {
-			return "", err
-		}
+	outputPath := filepath.Join(dir, "ffmpeg")
+	if err = os.WriteFile(outputPath, binary, 0500); err != nil {
+		cleanup()
+		return "", nil, err
 	}
 
-	return outputPath, nil
+	return outputPath, cleanup, nil
 }
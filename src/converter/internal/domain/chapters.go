@@ -0,0 +1,156 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Chapters are found at silences at least chapterSilence long, and are no shorter than minChapter.
+const (
+	chapterSilence = 2 * time.Second
+	minChapter     = 5 * time.Minute
+)
+
+// Chapter is a stretch of the video, timed from its start.
+type Chapter struct {
+	Title string        `json:"title,omitempty"`
+	Start time.Duration `json:"start"`
+	End   time.Duration `json:"end"`
+}
+
+// Cue starts a chapter where the user said one starts.
+type Cue struct {
+	Start time.Duration
+	Title string
+}
+
+// Track is the audio of one chapter of a split conversion.
+type Track struct {
+	Chapter
+	// Path is where the audio file of the chapter was written.
+	Path string
+	// Loudness is the measurement of the normalization, nil when the audio wasn't normalized.
+	Loudness *Loudness
+}
+
+// fromCues returns the chapters the cues start. Audio before the first cue belongs to the first chapter,
+// and cues past the end of the video are dropped.
+func fromCues(cues []Cue, duration time.Duration) []Chapter {
+	var chapters []Chapter
+	for _, cue := range cues {
+		if duration > 0 && cue.Start >= duration {
+			break
+		}
+		chapters = append(chapters, Chapter{Title: cue.Title, Start: cue.Start})
+	}
+	return closeChapters(chapters, duration)
+}
+
+// closeChapters ends every chapter where the next one starts, and the last one at the end of the video.
+func closeChapters(chapters []Chapter, duration time.Duration) []Chapter {
+	if len(chapters) == 0 {
+		return nil
+	}
+
+	chapters[0].Start = 0
+	for i := range chapters {
+		if i+1 < len(chapters) {
+			chapters[i].End = chapters[i+1].Start
+		} else {
+			chapters[i].End = duration
+		}
+	}
+	return chapters
+}
+
+// chapters returns where the video is split, from the cues the user gave, the chapter markers
+// of the container, or else its long silences. Fewer than two chapters leave it whole.
+func (c *Converter) chapters(ctx context.Context, dir, input string, opts Options, p probe, progress func(done float64)) ([]Chapter, error) {
+	var chapters []Chapter
+	switch {
+	case len(opts.Cues) > 0:
+		chapters = fromCues(opts.Cues, p.duration)
+	case len(p.chapters) > 1:
+		chapters = p.chapters
+	default:
+		silences, err := c.silences(ctx, dir, input, opts, p.duration, progress)
+		if err != nil {
+			return nil, err
+		}
+		chapters = fromSilences(silences, p.duration)
+	}
+
+	if len(chapters) < 2 {
+		return nil, nil
+	}
+	return chapters, nil
+}
+
+// silence is a stretch of silence, the end is zero when the audio ends in it.
+type silence struct {
+	start, end time.Duration
+}
+
+// silences runs a pass finding the long silences of the cleaned up audio. They can be too many for
+// the end of the output that's kept, so ffmpeg writes them to a file of the job directory.
+func (c *Converter) silences(ctx context.Context, dir, input string, opts Options, duration time.Duration, progress func(done float64)) ([]silence, error) {
+	const file = "silences.txt"
+
+	detect := fmt.Sprintf("silencedetect=noise=%s:d=%s,ametadata=mode=print:file=%s", silenceThreshold, decimal(chapterSilence.Seconds()), file)
+	args, stdout := withProgress([]string{"-i", input, "-vn", "-af", strings.Join(append(opts.clean(), detect), ",")}, duration, progress)
+
+	if _, err := c.run(ctx, dir, stdout, append(args, "-f", "null", "-")...); err != nil {
+		return nil, fmt.Errorf("failed to detect chapters: %w", err)
+	}
+
+	path := filepath.Join(dir, file)
+	defer os.Remove(path)
+
+	// no file is written when ffmpeg found nothing to print
+	out, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read silences: %v", err)
+	}
+
+	return parseSilences(string(out)), nil
+}
+
+// parseSilences reads the silences ametadata printed.
+func parseSilences(out string) []silence {
+	var silences []silence
+	for _, line := range strings.Split(out, "\n") {
+		if f, ok := value(line, "lavfi.silence_start="); ok {
+			silences = append(silences, silence{start: seconds(f)})
+		}
+		if f, ok := value(line, "lavfi.silence_end="); ok && len(silences) > 0 {
+			silences[len(silences)-1].end = seconds(f)
+		}
+	}
+	return silences
+}
+
+// fromSilences splits the video in the middle of its silences, keeping every chapter at least minChapter long.
+func fromSilences(silences []silence, duration time.Duration) []Chapter {
+	chapters := []Chapter{{}}
+	for _, s := range silences {
+		// a silence the audio ends in has nothing after it
+		if s.end <= s.start {
+			continue
+		}
+
+		split := s.start + (s.end-s.start)/2
+		if split-chapters[len(chapters)-1].Start >= minChapter && duration-split >= minChapter {
+			chapters = append(chapters, Chapter{Start: split})
+		}
+	}
+	return closeChapters(chapters, duration)
+}
+
+// seconds converts a time printed by ffmpeg.
+func seconds(f float64) time.Duration {
+	return time.Duration(f * float64(time.Second))
+}
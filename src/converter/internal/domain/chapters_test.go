@@ -0,0 +1,133 @@
+package domain
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFromCues(t *testing.T) {
+	cues := []Cue{{Start: 10 * time.Second, Title: "Intro"}, {Start: time.Minute, Title: "Lecture"}, {Start: time.Hour, Title: "Past the end"}}
+
+	// audio before the first cue belongs to the first chapter
+	want := []Chapter{
+		{Title: "Intro", Start: 0, End: time.Minute},
+		{Title: "Lecture", Start: time.Minute, End: 10 * time.Minute},
+	}
+
+	if got := fromCues(cues, 10*time.Minute); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+}
+
+func TestFromSilences(t *testing.T) {
+	silences := []silence{
+		// too close to the start
+		{start: time.Minute, end: time.Minute + 3*time.Second},
+		{start: 6 * time.Minute, end: 6*time.Minute + 4*time.Second},
+		// too close to the split before it
+		{start: 8 * time.Minute, end: 8*time.Minute + 2*time.Second},
+		{start: 12 * time.Minute, end: 12*time.Minute + 2*time.Second},
+		// too close to the end
+		{start: 16 * time.Minute, end: 16*time.Minute + 2*time.Second},
+		// the audio ends in it
+		{start: 19 * time.Minute},
+	}
+
+	want := []Chapter{
+		{Start: 0, End: 6*time.Minute + 2*time.Second},
+		{Start: 6*time.Minute + 2*time.Second, End: 12*time.Minute + time.Second},
+		{Start: 12*time.Minute + time.Second, End: 20 * time.Minute},
+	}
+
+	if got := fromSilences(silences, 20*time.Minute); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+}
+
+func TestParseSilences(t *testing.T) {
+	out := "frame:10   pts:480000  pts_time:10\nlavfi.silence_start=10\nframe:13   pts:624000  pts_time:13\n" +
+		"lavfi.silence_end=13.5\nlavfi.silence_duration=3.5\nframe:20   pts:960000  pts_time:20\nlavfi.silence_start=20.25\n"
+
+	want := []silence{{start: 10 * time.Second, end: 13500 * time.Millisecond}, {start: 20250 * time.Millisecond}}
+	if got := parseSilences(out); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+}
+
+func TestProbeChapters(t *testing.T) {
+	c := NewConverter(fakeFFmpeg(t, `cat >&2 <<'OUT'
+Input #0, mov,mp4,m4a,3gp,3g2,mj2, from 'input.mp4':
+  Metadata:
+    title           : Whole lecture
+  Duration: 00:20:00.00, start: 0.000000, bitrate: 128 kb/s
+  Chapters:
+    Chapter #0:0: start 0.000000, end 300.000000
+      Metadata:
+        title           : Intro
+    Chapter #0:1: start 300.000000, end 1200.000000
+      Metadata:
+        title           : Proofs: part one
+  Stream #0:0(und): Audio: aac (LC) (mp4a / 0x6134706D), 44100 Hz, stereo
+    Metadata:
+      title           : Stream title
+OUT
+exit 1`), 0, Limits{})
+
+	p, err := c.probe(context.Background(), t.TempDir(), "input.mp4")
+	if err != nil {
+		t.Fatalf("probe failed: %v", err)
+	}
+
+	want := []Chapter{
+		{Title: "Intro", Start: 0, End: 5 * time.Minute},
+		{Title: "Proofs: part one", Start: 5 * time.Minute, End: 20 * time.Minute},
+	}
+	if !reflect.DeepEqual(p.chapters, want) {
+		t.Errorf("Expected %+v, got %+v", want, p.chapters)
+	}
+
+	if p.codec != "aac" || p.duration != 20*time.Minute {
+		t.Errorf("Expected a 20 minute aac video, got %+v", p)
+	}
+}
+
+func TestConvertChapters(t *testing.T) {
+	dir := t.TempDir()
+	c := NewConverter(fakeFFmpeg(t, `for a; do last=$a; done
+case "$*" in
+*output*)
+	echo "$*" >> args
+	: > "$last" ;;
+*)
+	echo '  Duration: 00:10:00.00, start: 0.000000, bitrate: 128 kb/s' >&2
+	echo '  Stream #0:1(und): Audio: mp3 (mp3float), 44100 Hz, stereo' >&2
+	exit 1 ;;
+esac`), 0, Limits{})
+
+	opts := Options{Cues: []Cue{{Title: "Intro"}, {Start: 90 * time.Second, Title: "Lecture"}}}
+	audio, err := c.ConvertMP4ToMP3(context.Background(), dir, strings.NewReader("video"), opts, nil)
+	if err != nil {
+		t.Fatalf("ConvertMP4ToMP3 failed: %v", err)
+	}
+
+	if audio.Path != "" || len(audio.Tracks) != 2 || audio.Tracks[1].Title != "Lecture" || audio.Tracks[1].End != 10*time.Minute {
+		t.Fatalf("Expected two tracks, got %+v", audio)
+	}
+
+	args, err := os.ReadFile(filepath.Join(dir, "args"))
+	if err != nil {
+		t.Fatalf("Failed to read the arguments of the conversion: %v", err)
+	}
+
+	// each chapter is cut from the input into a file of its own
+	runs := strings.Split(strings.TrimSpace(string(args)), "\n")
+	if len(runs) != 2 || !strings.Contains(runs[0], "-ss 0 -t 90 -i") || !strings.HasSuffix(runs[0], "output-001.mp3") ||
+		!strings.Contains(runs[1], "-ss 90 -t 510 -i") || !strings.HasSuffix(runs[1], "output-002.mp3") {
+		t.Errorf("Unexpected runs: %q", runs)
+	}
+}
@@ -1,97 +1,380 @@
 package domain
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type Converter struct {
-	ffp string
+	ffp         string
+	maxDuration time.Duration
+	limits      Limits
 }
 
-func NewConverter(ffmpegPath string) *Converter {
-	return &Converter{ffp: ffmpegPath}
+// NewConverter creates a converter around the ffmpeg binary, every run of it is capped by the limits.
+// Videos longer than maxDuration are rejected, a zero maxDuration disables the check.
+func NewConverter(ffmpegPath string, maxDuration time.Duration, limits Limits) *Converter {
+	return &Converter{ffp: ffmpegPath, maxDuration: maxDuration, limits: limits}
 }
 
-func (c *Converter) ConvertMP4ToMP3(filename string, video io.Reader) (string, error) {
-	// create a temporary file to store the video (io reader) so that ffmpeg can read it
-	inputFile, err := os.CreateTemp("", filename)
+// Audio is the result of a conversion, either a single file or one per chapter.
+type Audio struct {
+	// Path is where the audio file was written, empty when the video was split into Tracks.
+	Path string
+	// Loudness is the measurement of the normalization, nil when the audio wasn't normalized.
+	Loudness *Loudness
+	// Tracks are the chapters the video was split into, in order.
+	Tracks []Track
+}
+
+// ConvertMP4ToMP3 extracts the audio of the video into dir, processing it as the options say.
+// The dir should be private to the job, ffmpeg reads and writes nothing outside of it.
+// The progress function, if any, is called with the share of the video converted so far, from 0 to 1.
+func (c *Converter) ConvertMP4ToMP3(ctx context.Context, dir string, video io.Reader, opts Options, progress func(done float64)) (*Audio, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	input, err := writeInput(dir, video)
 	if err != nil {
-		return "", fmt.Errorf("failed to create temporary file: %v", err)
+		return nil, err
 	}
-	defer inputFile.Close()
-	defer os.Remove(inputFile.Name())
+	defer os.Remove(input)
 
-	body, err := io.ReadAll(video)
+	probe, err := c.probe(ctx, dir, input)
 	if err != nil {
-		return "", fmt.Errorf("failed to read video file: %v", err)
+		return nil, err
 	}
 
-	// write the video file to the temporary file
-	if _, err = inputFile.Write(body); err != nil {
-		return "", fmt.Errorf("failed to write to temporary file: %v", err)
+	if probe.invalid {
+		return nil, ErrCorruptFile
 	}
 
-	ifn := inputFile.Name()
+	if c.maxDuration > 0 && probe.duration > c.maxDuration {
+		return nil, fmt.Errorf("%w: %s is longer than %s", ErrTooLong, probe.duration, c.maxDuration)
+	}
 
-	// build ffmpeg base command
-	args := []string{
-		"-i", ifn,
-		"-vn",
-		"-y",
-		"-ab", "192000",
+	// the codec is known before any pass runs, so an unsupported one fails right away
+	if _, _, err = codecArgs(probe.codec, false); err != nil {
+		return nil, err
 	}
 
-	// build an output file path
-	outputFile := strings.TrimSuffix(ifn, filepath.Ext(ifn))
+	// the audio keeps its codec unless a format is asked for
+	codec := probe.codec
+	if opts.Format != "" {
+		codec = opts.Format
+	}
 
-	switch c.extractCodec(ifn) {
+	_, ext, err := codecArgs(codec, false)
+	if err != nil {
+		return nil, err
+	}
+
+	split := opts.SplitChapters || len(opts.Cues) > 0
+	// chapters are found with a pass of their own unless the user or the container gave them
+	findChapters := split && len(opts.Cues) == 0 && len(probe.chapters) < 2
+
+	// finding chapters, trimming and normalizing take a pass each to learn about the audio, before converting it
+	n := 1
+	for _, takesPass := range []bool{findChapters, opts.TrimSilence, opts.Loudness != ""} {
+		if takesPass {
+			n++
+		}
+	}
+	progresses, pass := passes(progress, n), 0
+
+	var chapters []Chapter
+	if split {
+		if chapters, err = c.chapters(ctx, dir, input, opts, probe, progresses[pass]); err != nil {
+			return nil, err
+		}
+		if findChapters {
+			pass++
+		}
+	}
+
+	var trailing time.Duration
+	if opts.TrimSilence {
+		if trailing, err = c.trailingSilence(ctx, dir, input, opts, probe.duration, progresses[pass]); err != nil {
+			return nil, err
+		}
+		pass++
+	}
+
+	var measured *loudnorm
+	if opts.Loudness != "" {
+		measured, err = c.measureLoudness(ctx, dir, input, probe.duration, opts.graph(trailing), LoudnessPresets[opts.Loudness], progresses[pass])
+		if err != nil {
+			return nil, err
+		}
+		pass++
+	}
+
+	e := &encoding{
+		input: input, codec: codec, opts: opts, measured: measured,
+		reencode: len(opts.graph(trailing)) > 0 || measured != nil || codec != probe.codec || opts.Bitrate != 0,
+	}
+
+	if chapters == nil {
+		e.output, e.trailing, e.duration = filepath.Join(dir, "output"+ext), trailing, probe.duration
+
+		l, err := c.encode(ctx, dir, e, progresses[pass])
+		if err != nil {
+			return nil, err
+		}
+		return &Audio{Path: e.output, Loudness: l}, nil
+	}
+
+	// the chapters are cut from the input, each converted as a whole video would be,
+	// so each starts without silence when trimming. They're normalized alike, with the measurement of the whole
+	audio := &Audio{}
+	for i, chapter := range chapters {
+		length := chapter.End - chapter.Start
+		e.output, e.chapter, e.duration = filepath.Join(dir, fmt.Sprintf("output-%03d%s", i+1, ext)), &chapters[i], length
+
+		// only the last chapter ends where the audio does, its end is timed from its start
+		e.trailing = 0
+		if i == len(chapters)-1 && trailing > chapter.Start {
+			e.trailing = trailing - chapter.Start
+		}
+
+		report := progresses[pass]
+		if report != nil && probe.duration > 0 {
+			report = func(done float64) {
+				progresses[pass](min((float64(chapter.Start)+done*float64(length))/float64(probe.duration), 1))
+			}
+		}
+
+		l, err := c.encode(ctx, dir, e, report)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert chapter %d: %w", i+1, err)
+		}
+		audio.Tracks = append(audio.Tracks, Track{Chapter: chapter, Path: e.output, Loudness: l})
+	}
+
+	return audio, nil
+}
+
+// codecArgs returns the arguments writing audio of the codec, and the extension of its file.
+// Filtered aac can't be copied, it's encoded again.
+func codecArgs(codec string, reencode bool) ([]string, string, error) {
+	switch codec {
 	case "aac":
-		args = append(args, "-acodec", "copy", "-f", "adts")
-		outputFile += ".aac"
+		if reencode {
+			return []string{"-acodec", "aac", "-f", "adts"}, ".aac", nil
+		}
+		return []string{"-acodec", "copy", "-f", "adts"}, ".aac", nil
 	case "mp3":
-		args = append(args, "-acodec", "libmp3lame", "-q:a", "2", "-f", "mp3")
-		outputFile += ".mp3"
+		return []string{"-acodec", "libmp3lame", "-q:a", "2", "-f", "mp3"}, ".mp3", nil
 	case "wav":
-		args = append(args, "-acodec", "pcm_s16le", "-f", "wav")
-		outputFile += ".wav"
+		return []string{"-acodec", "pcm_s16le", "-f", "wav"}, ".wav", nil
 	default:
-		return "", fmt.Errorf("unsupported audio codec")
+		return nil, "", fmt.Errorf("%w: %q", ErrUnsupportedCodec, codec)
 	}
+}
 
-	cmd := exec.Command(c.ffp, append(args, outputFile)...)
+// encoding is the pass writing the audio, of the whole video or of a chapter of it.
+type encoding struct {
+	input, output string
+	// codec is the one of the input's audio, copied unless reencode is set
+	codec    string
+	reencode bool
+	opts     Options
+	measured *loudnorm
+	// chapter is the part of the input converted, nil for all of it
+	chapter  *Chapter
+	trailing time.Duration
+	duration time.Duration
+}
 
-	// log errors
-	cmd.Stderr = os.Stderr
+// encode runs the pass, and returns the loudness measurement when the audio was normalized.
+func (c *Converter) encode(ctx context.Context, dir string, e *encoding, progress func(done float64)) (*Loudness, error) {
+	var args []string
+	// seeking the input is fast, the chapter is then timed from zero
+	if e.chapter != nil {
+		args = append(args, "-ss", decimal(e.chapter.Start.Seconds()))
+		if e.chapter.End > e.chapter.Start {
+			args = append(args, "-t", decimal((e.chapter.End - e.chapter.Start).Seconds()))
+		}
+	}
+
+	// build ffmpeg base command
+	args = append(args,
+		"-i", e.input,
+		"-vn",
+		"-y",
+	)
 
-	// run the ffmpeg command
-	if err := cmd.Run(); err != nil {
-		return "", fmt.Errorf("failed to run ffmpeg: %v", err)
+	// a bitrate asked for comes with the codec
+	if e.opts.Bitrate == 0 {
+		args = append(args, "-ab", "192000")
 	}
 
-	return outputFile, nil
+	filters := e.opts.graph(e.trailing)
+	if e.measured != nil {
+		filters = append(filters, LoudnessPresets[e.opts.Loudness].filter(e.measured))
+	}
+	if len(filters) > 0 {
+		args = append(args, "-af", strings.Join(filters, ","))
+	}
+
+	// loudnorm resamples to 192kHz as it works, so the output is brought back to 48kHz
+	if e.measured != nil {
+		args = append(args, "-ar", "48000")
+	}
+
+	codec, _, err := codecArgs(e.codec, e.reencode)
+	if e.opts.Bitrate != 0 {
+		codec, _, err = formatArgs(e.codec, e.opts.Bitrate)
+	}
+	if err != nil {
+		return nil, err
+	}
+	args = append(args, codec...)
+
+	// the tags are sorted so that the arguments of a job are the same every time
+	names := slices.Sorted(maps.Keys(e.opts.Tags))
+	for _, name := range names {
+		args = append(args, "-metadata", name+"="+e.opts.Tags[name])
+	}
+
+	args, stdout := withProgress(args, e.duration, progress)
+
+	out, err := c.run(ctx, dir, stdout, append(args, e.output)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to run ffmpeg: %w", err)
+	}
+
+	if e.measured == nil {
+		return nil, nil
+	}
+	return loudness(e.opts.Loudness, e.measured, out)
+}
+
+type probe struct {
+	codec    string
+	duration time.Duration
+	chapters []Chapter
+	invalid  bool
+	// picture is whether the video has a video stream, not only audio
+	picture bool
+	// width and height of the first video stream, zero when unknown
+	width, height int
 }
 
-func (c *Converter) extractCodec(path string) string {
-	probeCmd := exec.Command(c.ffp, "-i", path)
-	probeOutput, _ := probeCmd.CombinedOutput()
+// writeInput writes the video into dir for ffmpeg to read, the name is ours so nothing in it comes from the user.
+func writeInput(dir string, video io.Reader) (string, error) {
+	input := filepath.Join(dir, "input.mp4")
+	inputFile, err := os.OpenFile(input, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", fmt.Errorf("failed to create input file: %v", err)
+	}
+
+	_, err = io.Copy(inputFile, video)
+	if cerr := inputFile.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to write input file: %v", err)
+	}
+	return input, nil
+}
+
+// probe reads the codec and duration of the video. ffmpeg exits with an error when given
+// no output, so only a stopped or timed out run is an error.
+func (c *Converter) probe(ctx context.Context, dir, path string) (probe, error) {
+	probeOutput, err := c.run(ctx, dir, nil, "-i", path)
+	if ctx.Err() != nil || errors.Is(err, ErrTimeout) {
+		return probe{}, fmt.Errorf("failed to probe video: %w", err)
+	}
+
+	var p probe
+	streams := false
+
+	// Extract audio codec, duration and chapter markers from probe output
+	for _, line := range strings.Split(string(probeOutput), "\n") {
+		// streams come after the chapters, their metadata isn't the chapters'
+		if strings.Contains(line, "Stream #") {
+			streams = true
+		}
 
-	// Extract audio codec from probe output
-	codecLine := strings.Split(string(probeOutput), "\n")
-	var audioCodec string
-	for _, line := range codecLine {
-		if strings.Contains(line, "Audio:") {
+		switch {
+		case strings.Contains(line, "Chapter #") && !streams:
+			if start, ok := value(line, "start "); ok {
+				end, _ := value(line, "end ")
+				p.chapters = append(p.chapters, Chapter{Start: seconds(start), End: seconds(end)})
+			}
+		case len(p.chapters) > 0 && !streams && strings.HasPrefix(strings.TrimSpace(line), "title"):
+			if _, title, ok := strings.Cut(line, ": "); ok {
+				p.chapters[len(p.chapters)-1].Title = strings.TrimSpace(title)
+			}
+		case strings.Contains(line, "Invalid data found when processing input"), strings.Contains(line, "moov atom not found"):
+			p.invalid = true
+		case strings.Contains(line, "Duration:") && p.duration == 0:
+			parts := strings.Split(line, "Duration: ")
+			if len(parts) > 1 {
+				p.duration = parseDuration(strings.Split(parts[1], ",")[0])
+			}
+		case strings.Contains(line, "Video:"):
+			if !p.picture {
+				p.width, p.height = resolution(line)
+			}
+			p.picture = true
+		case strings.Contains(line, "Audio:") && p.codec == "":
 			parts := strings.Split(line, "Audio: ")
 			if len(parts) > 1 {
-				audioCodec = strings.Split(parts[1], " ")[0]
+				p.codec = strings.Split(parts[1], " ")[0]
+			}
+		}
+	}
+
+	return p, nil
+}
+
+// resolution reads the WxH size out of a video stream line, zero when there is none.
+func resolution(line string) (int, int) {
+	for _, field := range strings.Split(line, ",") {
+		var w, h int
+		if f := strings.Fields(field); len(f) > 0 {
+			// codec tags like 0x31637661 read as a zero width
+			if _, err := fmt.Sscanf(f[0], "%dx%d", &w, &h); err == nil && w > 0 && h > 0 {
+				return w, h
 			}
-			break
 		}
 	}
+	return 0, 0
+}
+
+// parseDuration parses the HH:MM:SS.ms duration printed by ffmpeg, returning 0 when it is unknown.
+func parseDuration(s string) time.Duration {
+	parts := strings.Split(strings.TrimSpace(s), ":")
+	if len(parts) != 3 {
+		return 0
+	}
+
+	h, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0
+	}
+
+	m, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0
+	}
+
+	sec, err := strconv.ParseFloat(parts[2], 64)
+	if err != nil {
+		return 0
+	}
 
-	return audioCodec
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(sec*float64(time.Second))
 }
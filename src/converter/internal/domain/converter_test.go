@@ -0,0 +1,42 @@
+package domain
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Duration
+	}{
+		{"00:00:10.50", 10*time.Second + 500*time.Millisecond},
+		{"01:02:03.00", time.Hour + 2*time.Minute + 3*time.Second},
+		{" 00:01:00.00", time.Minute},
+		{"N/A", 0},
+		{"aa:00:00.00", 0},
+	}
+
+	for _, tt := range tests {
+		if got := parseDuration(tt.in); got != tt.want {
+			t.Errorf("parseDuration(%q): expected %s, got %s", tt.in, tt.want, got)
+		}
+	}
+}
+
+func TestResolution(t *testing.T) {
+	tests := []struct {
+		in   string
+		w, h int
+	}{
+		{"  Stream #0:0(und): Video: h264 (High) (avc1 / 0x31637661), yuv420p(tv, bt709), 1280x720 [SAR 1:1 DAR 16:9], 2500 kb/s", 1280, 720},
+		{"  Stream #0:0: Video: vp9, yuv420p, 640x360, 30 fps", 640, 360},
+		{"  Stream #0:0: Video: mjpeg (Baseline), yuvj420p(pc)", 0, 0},
+	}
+
+	for _, tt := range tests {
+		if w, h := resolution(tt.in); w != tt.w || h != tt.h {
+			t.Errorf("resolution(%q): expected %dx%d, got %dx%d", tt.in, tt.w, tt.h, w, h)
+		}
+	}
+}
@@ -0,0 +1,12 @@
+package domain
+
+import "errors"
+
+var (
+	ErrUnsupportedCodec = errors.New("unsupported audio codec")
+	ErrCorruptFile      = errors.New("corrupt or unreadable video file")
+	ErrTooLong          = errors.New("video exceeds the maximum duration")
+	ErrTimeout          = errors.New("conversion took too long")
+	ErrInvalidOptions   = errors.New("invalid conversion options")
+	ErrNoPicture        = errors.New("video has no picture")
+)
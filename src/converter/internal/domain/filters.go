@@ -0,0 +1,112 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// silenceThreshold is the level below which the audio counts as silence.
+const silenceThreshold = "-50dB"
+
+// Silence shorter than minSilence at the ends of the audio is kept, leadingSilence is what's left of a trimmed start.
+const (
+	minSilence     = 500 * time.Millisecond
+	leadingSilence = 250 * time.Millisecond
+)
+
+// clean returns the filters removing hum, hiss and noise, in the order they're applied.
+// None of them shifts the audio in time.
+func (o Options) clean() []string {
+	var filters []string
+	if o.HighPass > 0 {
+		filters = append(filters, "highpass=f="+strconv.Itoa(o.HighPass))
+	}
+	if o.LowPass > 0 {
+		filters = append(filters, "lowpass=f="+strconv.Itoa(o.LowPass))
+	}
+	if o.NoiseReduction > 0 {
+		filters = append(filters, "afftdn=nr="+decimal(o.NoiseReduction))
+	}
+	return filters
+}
+
+// graph returns the filters applied to the audio before it's normalized. The end of the audio
+// is cut first, at trailing in the time of the input, a zero trailing keeps it.
+func (o Options) graph(trailing time.Duration) []string {
+	var filters []string
+	if trailing > 0 {
+		filters = append(filters, "atrim=end="+decimal(trailing.Seconds()))
+	}
+
+	filters = append(filters, o.clean()...)
+
+	// silence is removed once the noise is gone, so what's left of it is quiet enough to be found
+	if o.TrimSilence {
+		filters = append(filters, fmt.Sprintf("silenceremove=start_periods=1:start_threshold=%s:start_silence=%s",
+			silenceThreshold, decimal(leadingSilence.Seconds())))
+	}
+	if o.CompressSilence > 0 {
+		d := decimal(o.CompressSilence.Seconds())
+		filters = append(filters, fmt.Sprintf("silenceremove=stop_periods=-1:stop_threshold=%s:stop_duration=%s:stop_silence=%s",
+			silenceThreshold, d, d))
+	}
+
+	return filters
+}
+
+// trailingSilence runs a pass finding where the silence at the end of the input starts,
+// zero when the input doesn't end in silence. It listens to the cleaned up audio, the way
+// the conversion will. Leading silence is removed as the audio streams, the end can't be.
+func (c *Converter) trailingSilence(ctx context.Context, dir, input string, o Options, duration time.Duration, progress func(done float64)) (time.Duration, error) {
+	detect := fmt.Sprintf("silencedetect=noise=%s:d=%s", silenceThreshold, decimal(minSilence.Seconds()))
+	args, stdout := withProgress([]string{"-i", input, "-vn", "-af", strings.Join(append(o.clean(), detect), ",")}, duration, progress)
+
+	out, err := c.run(ctx, dir, stdout, append(args, "-f", "null", "-")...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to detect silence: %w", err)
+	}
+
+	return parseTrailingSilence(out, duration), nil
+}
+
+// parseTrailingSilence reads the silences silencedetect printed, and returns the start of the one
+// the audio ends with. Only the end of the output is kept, so that is where it's looked for.
+// A silence still going at the end of the audio gets no silence_end line, or one at the duration.
+func parseTrailingSilence(out string, duration time.Duration) time.Duration {
+	var start, end float64
+	open := false
+
+	for _, line := range strings.Split(out, "\n") {
+		if f, ok := value(line, "silence_start: "); ok {
+			start, open = f, true
+		}
+		if f, ok := value(line, "silence_end: "); ok {
+			end, open = f, false
+		}
+	}
+
+	ending := open || (duration > 0 && end >= (duration-minSilence/10).Seconds())
+	if start <= 0 || !ending {
+		return 0
+	}
+
+	return seconds(start)
+}
+
+// value reads the number following the key in a line of ffmpeg's output.
+func value(line, key string) (float64, bool) {
+	_, v, ok := strings.Cut(line, key)
+	if fields := strings.Fields(v); ok && len(fields) > 0 {
+		f, err := strconv.ParseFloat(strings.TrimSuffix(fields[0], ","), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// decimal formats a number for a filter, without an exponent ffmpeg can't read.
+func decimal(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
@@ -0,0 +1,181 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  Options
+		valid bool
+	}{
+		{"zero", Options{}, true},
+		{"all", Options{Loudness: "podcast", TrimSilence: true, CompressSilence: 2 * time.Second, HighPass: 80, LowPass: 12000, NoiseReduction: 12}, true},
+		{"unknown preset", Options{Loudness: "loud"}, false},
+		{"short silence", Options{CompressSilence: 100 * time.Millisecond}, false},
+		{"long silence", Options{CompressSilence: time.Minute}, false},
+		{"low high-pass", Options{HighPass: 5}, false},
+		{"high high-pass", Options{HighPass: 5000}, false},
+		{"low low-pass", Options{LowPass: 500}, false},
+		{"negative noise reduction", Options{NoiseReduction: -3}, false},
+		{"high noise reduction", Options{NoiseReduction: 120}, false},
+		{"format", Options{Format: "aac", Bitrate: 96, Tags: map[string]string{"title": "Lecture", "artist": "Prof"}}, true},
+		{"unknown format", Options{Format: "flac"}, false},
+		{"wav bitrate", Options{Format: "wav", Bitrate: 128}, false},
+		{"unknown tag", Options{Tags: map[string]string{"mood": "calm"}}, false},
+		{"multiline tag", Options{Tags: map[string]string{"comment": "one\ntwo"}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.opts.Validate()
+			if tt.valid && err != nil {
+				t.Errorf("Expected valid options, got %v", err)
+			}
+			if !tt.valid && !errors.Is(err, ErrInvalidOptions) {
+				t.Errorf("Expected ErrInvalidOptions, got %v", err)
+			}
+		})
+	}
+}
+
+func TestGraph(t *testing.T) {
+	if graph := (Options{Loudness: "podcast"}).graph(0); len(graph) != 0 {
+		t.Errorf("Expected no filters, got %q", graph)
+	}
+
+	opts := Options{TrimSilence: true, CompressSilence: 1500 * time.Millisecond, HighPass: 80, LowPass: 12000, NoiseReduction: 12.5}
+	want := []string{
+		// the end is cut in the time of the input, before anything shifts it
+		"atrim=end=61.25",
+		"highpass=f=80",
+		"lowpass=f=12000",
+		"afftdn=nr=12.5",
+		"silenceremove=start_periods=1:start_threshold=-50dB:start_silence=0.25",
+		"silenceremove=stop_periods=-1:stop_threshold=-50dB:stop_duration=1.5:stop_silence=1.5",
+	}
+
+	if got := strings.Join(opts.graph(61250*time.Millisecond), ","); got != strings.Join(want, ",") {
+		t.Errorf("Expected %q, got %q", strings.Join(want, ","), got)
+	}
+}
+
+func TestParseTrailingSilence(t *testing.T) {
+	const duration = 60 * time.Second
+
+	tests := []struct {
+		name string
+		out  string
+		want time.Duration
+	}{
+		{"no silence", "size=N/A time=00:01:00.00", 0},
+		{"still silent at the end", "[silencedetect @ 0x1] silence_start: 10\n[silencedetect @ 0x1] silence_end: 12 | silence_duration: 2\n[silencedetect @ 0x1] silence_start: 55.5\n", 55500 * time.Millisecond},
+		{"ended at the duration", "[silencedetect @ 0x1] silence_start: 52.25\n[silencedetect @ 0x1] silence_end: 60 | silence_duration: 7.75\n", 52250 * time.Millisecond},
+		{"ended before the duration", "[silencedetect @ 0x1] silence_start: 40\n[silencedetect @ 0x1] silence_end: 45 | silence_duration: 5\n", 0},
+		// there is nothing left to keep of audio that's silent throughout
+		{"silent throughout", "[silencedetect @ 0x1] silence_start: 0\n", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseTrailingSilence(tt.out, duration); got != tt.want {
+				t.Errorf("Expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestConvertFilters(t *testing.T) {
+	dir := t.TempDir()
+	c := NewConverter(fakeFFmpeg(t, `for a; do last=$a; done
+case "$*" in
+*silencedetect*)
+	echo "[silencedetect @ 0x1] silence_start: 8.5" >&2
+	echo "[silencedetect @ 0x1] silence_end: 10 | silence_duration: 1.5" >&2 ;;
+*output*)
+	echo "$*" > args
+	: > "$last" ;;
+*)
+	echo '  Duration: 00:00:10.00, start: 0.000000, bitrate: 128 kb/s' >&2
+	echo '  Stream #0:1(und): Audio: aac (LC) (mp4a / 0x6134706D), 44100 Hz, stereo' >&2
+	exit 1 ;;
+esac`), 0, Limits{})
+
+	_, err := c.ConvertMP4ToMP3(context.Background(), dir, strings.NewReader("video"), Options{TrimSilence: true, HighPass: 100}, nil)
+	if err != nil {
+		t.Fatalf("ConvertMP4ToMP3 failed: %v", err)
+	}
+
+	args, err := os.ReadFile(filepath.Join(dir, "args"))
+	if err != nil {
+		t.Fatalf("Failed to read the arguments of the conversion: %v", err)
+	}
+
+	if !strings.Contains(string(args), "-af atrim=end=8.5,highpass=f=100,silenceremove=start_periods=1") || !strings.Contains(string(args), "-acodec aac") {
+		t.Errorf("Expected the trimmed and filtered audio to be encoded, got %q", args)
+	}
+}
+
+func TestPasses(t *testing.T) {
+	var got []float64
+	ps := passes(func(done float64) { got = append(got, done) }, 2)
+	ps[0](0.5)
+	ps[0](1)
+	ps[1](0.5)
+	ps[1](1)
+
+	want := []float64{0.25, 0.5, 0.75, 1}
+	for i := range want {
+		if i >= len(got) || got[i] != want[i] {
+			t.Fatalf("Expected %v, got %v", want, got)
+		}
+	}
+
+	if ps := passes(nil, 3); len(ps) != 3 || ps[0] != nil {
+		t.Errorf("Expected 3 passes reporting nothing, got %d", len(ps))
+	}
+}
+
+func TestConvertFormat(t *testing.T) {
+	dir := t.TempDir()
+	c := NewConverter(fakeFFmpeg(t, `for a; do last=$a; done
+case "$*" in
+*output*)
+	echo "$*" > args
+	: > "$last" ;;
+*)
+	echo '  Duration: 00:00:10.00, start: 0.000000, bitrate: 128 kb/s' >&2
+	echo '  Stream #0:1(und): Audio: aac (LC) (mp4a / 0x6134706D), 44100 Hz, stereo' >&2
+	exit 1 ;;
+esac`), 0, Limits{})
+
+	opts := Options{Format: "mp3", Bitrate: 128, Tags: map[string]string{"title": "Lecture 1", "album": "Calculus"}}
+	audio, err := c.ConvertMP4ToMP3(context.Background(), dir, strings.NewReader("video"), opts, nil)
+	if err != nil {
+		t.Fatalf("ConvertMP4ToMP3 failed: %v", err)
+	}
+
+	if audio.Path != filepath.Join(dir, "output.mp3") {
+		t.Errorf("Expected the aac to be converted to mp3, got %s", audio.Path)
+	}
+
+	args, err := os.ReadFile(filepath.Join(dir, "args"))
+	if err != nil {
+		t.Fatalf("Failed to read the arguments of the conversion: %v", err)
+	}
+
+	if want := "-acodec libmp3lame -b:a 128k -f mp3 -metadata album=Calculus -metadata title=Lecture 1"; !strings.Contains(string(args), want) {
+		t.Errorf("Expected %q in the arguments, got %q", want, args)
+	}
+
+	if strings.Contains(string(args), "192000") {
+		t.Errorf("Expected the bitrate asked for alone, got %q", args)
+	}
+}
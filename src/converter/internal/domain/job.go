@@ -0,0 +1,37 @@
+package domain
+
+type JobStatus string
+
+const (
+	// JobProcessing means the job was claimed but nothing durable came out of it yet.
+	JobProcessing JobStatus = "processing"
+	// JobConverted means the audio is in storage but its metadata wasn't saved.
+	JobConverted JobStatus = "converted"
+	// JobCompleted means the metadata is saved, only the notification may be missing.
+	JobCompleted JobStatus = "completed"
+	// JobFailed means the video was dropped for good.
+	JobFailed JobStatus = "failed"
+)
+
+// Job records how far the conversion of one uploaded video got,
+// so that a redelivered message resumes instead of starting over.
+// Parts are the chapters of the audio once stored, when it was split.
+// A re-encode of an existing audio names it as SourceAudioKey, the VideoKey is then the one it was converted from.
+type Job struct {
+	Id             string
+	UserId         int64
+	VideoKey       string
+	SourceAudioKey string
+	AudioKey       string
+	Parts          []Part
+	MetadataId     int64
+	Status         JobStatus
+}
+
+// JobFailure records why a job was dropped, for operators rather than users.
+// Stderr is the end of ffmpeg's output when ffmpeg failed.
+type JobFailure struct {
+	Reason string
+	Error  string
+	Stderr string
+}
@@ -0,0 +1,131 @@
+package domain
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LoudnessTarget is an EBU R128 normalization target.
+type LoudnessTarget struct {
+	// Integrated is the loudness of the whole audio, in LUFS.
+	Integrated float64
+	// TruePeak is the highest the signal may reach, in dBTP.
+	TruePeak float64
+	// Range is the loudness range kept, in LU.
+	Range float64
+}
+
+// LoudnessPresets are the targets a conversion can normalize to, by name.
+var LoudnessPresets = map[string]LoudnessTarget{
+	"podcast":   {Integrated: -16, TruePeak: -1.5, Range: 11},
+	"streaming": {Integrated: -14, TruePeak: -1, Range: 11},
+	"broadcast": {Integrated: -23, TruePeak: -1, Range: 7},
+}
+
+// Loudness is the loudness of an audio before and after it was normalized to a preset.
+type Loudness struct {
+	Preset           string  `json:"preset"`
+	InputIntegrated  float64 `json:"input_integrated"`
+	InputTruePeak    float64 `json:"input_true_peak"`
+	InputRange       float64 `json:"input_range"`
+	OutputIntegrated float64 `json:"output_integrated"`
+	OutputTruePeak   float64 `json:"output_true_peak"`
+	OutputRange      float64 `json:"output_range"`
+}
+
+// loudnorm is the measurement the loudnorm filter prints as json, every value is a string.
+type loudnorm struct {
+	InputI       string `json:"input_i"`
+	InputTP      string `json:"input_tp"`
+	InputLRA     string `json:"input_lra"`
+	InputThresh  string `json:"input_thresh"`
+	OutputI      string `json:"output_i"`
+	OutputTP     string `json:"output_tp"`
+	OutputLRA    string `json:"output_lra"`
+	TargetOffset string `json:"target_offset"`
+}
+
+// parseLoudnorm reads the measurement printed last in ffmpeg's output.
+func parseLoudnorm(out string) (*loudnorm, error) {
+	start, end := strings.LastIndex(out, "{"), strings.LastIndex(out, "}")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("no loudness measurement in the output of ffmpeg")
+	}
+
+	var l loudnorm
+	if err := json.Unmarshal([]byte(out[start:end+1]), &l); err != nil {
+		return nil, fmt.Errorf("failed to parse loudness measurement: %w", err)
+	}
+
+	return &l, nil
+}
+
+// filter returns the loudnorm filter of the target. Given the first pass measurement,
+// the second pass normalizes linearly, keeping the dynamics of the audio when it can.
+func (t LoudnessTarget) filter(measured *loudnorm) string {
+	f := fmt.Sprintf("loudnorm=I=%g:TP=%g:LRA=%g", t.Integrated, t.TruePeak, t.Range)
+	if measured != nil {
+		f += fmt.Sprintf(":measured_I=%s:measured_TP=%s:measured_LRA=%s:measured_thresh=%s:offset=%s:linear=true",
+			measured.InputI, measured.InputTP, measured.InputLRA, measured.InputThresh, measured.TargetOffset)
+	}
+	return f + ":print_format=json"
+}
+
+// measureLoudness runs the first loudnorm pass over the input, after the filters. Audio too quiet
+// to measure, silence mostly, comes back as nil since there is nothing to normalize.
+func (c *Converter) measureLoudness(ctx context.Context, dir, input string, duration time.Duration, filters []string, target LoudnessTarget, progress func(done float64)) (*loudnorm, error) {
+	af := strings.Join(append(filters, target.filter(nil)), ",")
+	args, stdout := withProgress([]string{"-i", input, "-vn", "-af", af}, duration, progress)
+
+	out, err := c.run(ctx, dir, stdout, append(args, "-f", "null", "-")...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to measure loudness: %w", err)
+	}
+
+	measured, err := parseLoudnorm(out)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, v := range []string{measured.InputI, measured.InputTP, measured.InputLRA, measured.InputThresh} {
+		if f, err := strconv.ParseFloat(v, 64); err != nil || math.IsInf(f, 0) || math.IsNaN(f) {
+			return nil, nil
+		}
+	}
+
+	return measured, nil
+}
+
+// loudness combines the measurements of both passes.
+func loudness(preset string, first *loudnorm, out string) (*Loudness, error) {
+	second, err := parseLoudnorm(out)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Loudness{Preset: preset}
+	for _, v := range []struct {
+		field *float64
+		value string
+	}{
+		{&l.InputIntegrated, first.InputI},
+		{&l.InputTruePeak, first.InputTP},
+		{&l.InputRange, first.InputLRA},
+		{&l.OutputIntegrated, second.OutputI},
+		{&l.OutputTruePeak, second.OutputTP},
+		{&l.OutputRange, second.OutputLRA},
+	} {
+		f, err := strconv.ParseFloat(v.value, 64)
+		if err != nil || math.IsInf(f, 0) || math.IsNaN(f) {
+			return nil, fmt.Errorf("invalid loudness measurement %q", v.value)
+		}
+		*v.field = f
+	}
+
+	return l, nil
+}
@@ -0,0 +1,109 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// loudnormFFmpeg fakes ffmpeg printing the given first pass measurement, it probes an aac video
+// and keeps the arguments of the conversion in the job directory.
+func loudnormFFmpeg(t *testing.T, inputI string) string {
+	return fakeFFmpeg(t, `for a; do last=$a; done
+case "$*" in
+*"-f null"*)
+	printf '%s\n' '[Parsed_loudnorm_0 @ 0x1]' '{' '"input_i" : "`+inputI+`",' '"input_tp" : "-4.47",' '"input_lra" : "18.06",' \
+		'"input_thresh" : "-39.20",' '"output_i" : "-16.58",' '"output_tp" : "-1.50",' '"output_lra" : "14.78",' \
+		'"output_thresh" : "-27.71",' '"normalization_type" : "dynamic",' '"target_offset" : "0.58"' '}' >&2 ;;
+*output*)
+	echo "$*" > args
+	printf '%s\n' '[Parsed_loudnorm_0 @ 0x1]' '{' '"input_i" : "-27.61",' '"input_tp" : "-4.47",' '"input_lra" : "18.06",' \
+		'"input_thresh" : "-39.20",' '"output_i" : "-16.02",' '"output_tp" : "-1.61",' '"output_lra" : "17.50",' \
+		'"output_thresh" : "-26.12",' '"normalization_type" : "linear",' '"target_offset" : "0.02"' '}' >&2
+	: > "$last" ;;
+*)
+	echo '  Duration: 00:00:10.00, start: 0.000000, bitrate: 128 kb/s' >&2
+	echo '  Stream #0:1(und): Audio: aac (LC) (mp4a / 0x6134706D), 44100 Hz, stereo' >&2
+	exit 1 ;;
+esac`)
+}
+
+func TestConvertLoudness(t *testing.T) {
+	t.Run("two passes", func(t *testing.T) {
+		dir := t.TempDir()
+		c := NewConverter(loudnormFFmpeg(t, "-27.61"), 0, Limits{})
+
+		audio, err := c.ConvertMP4ToMP3(context.Background(), dir, strings.NewReader("video"), Options{Loudness: "podcast"}, nil)
+		if err != nil {
+			t.Fatalf("ConvertMP4ToMP3 failed: %v", err)
+		}
+
+		want := Loudness{
+			Preset:          "podcast",
+			InputIntegrated: -27.61, InputTruePeak: -4.47, InputRange: 18.06,
+			OutputIntegrated: -16.02, OutputTruePeak: -1.61, OutputRange: 17.50,
+		}
+		if audio.Loudness == nil || *audio.Loudness != want {
+			t.Errorf("Expected %+v, got %+v", want, audio.Loudness)
+		}
+
+		args, err := os.ReadFile(filepath.Join(dir, "args"))
+		if err != nil {
+			t.Fatalf("Failed to read the arguments of the conversion: %v", err)
+		}
+
+		// the measurement drives the second pass, and the normalized aac is encoded again
+		for _, arg := range []string{"loudnorm=I=-16:TP=-1.5:LRA=11:measured_I=-27.61:measured_TP=-4.47", "offset=0.58:linear=true", "-ar 48000", "-acodec aac"} {
+			if !strings.Contains(string(args), arg) {
+				t.Errorf("Expected %q in the arguments, got %q", arg, args)
+			}
+		}
+	})
+
+	t.Run("silence", func(t *testing.T) {
+		dir := t.TempDir()
+		c := NewConverter(loudnormFFmpeg(t, "-inf"), 0, Limits{})
+
+		audio, err := c.ConvertMP4ToMP3(context.Background(), dir, strings.NewReader("video"), Options{Loudness: "broadcast"}, nil)
+		if err != nil {
+			t.Fatalf("ConvertMP4ToMP3 failed: %v", err)
+		}
+
+		if audio.Loudness != nil {
+			t.Errorf("Expected no measurement, got %+v", audio.Loudness)
+		}
+
+		// there is nothing to normalize, the audio is copied as it is
+		if args, _ := os.ReadFile(filepath.Join(dir, "args")); strings.Contains(string(args), "loudnorm") || !strings.Contains(string(args), "-acodec copy") {
+			t.Errorf("Expected the audio to be copied, got %q", args)
+		}
+	})
+
+	t.Run("unknown preset", func(t *testing.T) {
+		c := NewConverter(loudnormFFmpeg(t, "-27.61"), 0, Limits{})
+
+		_, err := c.ConvertMP4ToMP3(context.Background(), t.TempDir(), strings.NewReader("video"), Options{Loudness: "loud"}, nil)
+		if !errors.Is(err, ErrInvalidOptions) {
+			t.Errorf("Expected ErrInvalidOptions, got %v", err)
+		}
+	})
+}
+
+func TestParseLoudnorm(t *testing.T) {
+	if _, err := parseLoudnorm("Stream mapping:\n  Stream #0:1 -> #0:0 (aac (native) -> pcm_s16le (native))"); err == nil {
+		t.Error("Expected an error without a measurement")
+	}
+
+	// only the last block counts, earlier output may hold braces of its own
+	l, err := parseLoudnorm(`{"input_i" : "-1"}` + "\n[Parsed_loudnorm_0 @ 0x1]\n{\n\t\"input_i\" : \"-23.00\",\n\t\"target_offset\" : \"0.10\"\n}\n")
+	if err != nil {
+		t.Fatalf("parseLoudnorm failed: %v", err)
+	}
+
+	if l.InputI != "-23.00" || l.TargetOffset != "0.10" {
+		t.Errorf("Expected the last measurement, got %+v", l)
+	}
+}
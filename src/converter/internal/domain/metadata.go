@@ -1,8 +1,49 @@
 package domain
 
+// Metadata is a finished conversion. The keys are random ids naming the stored objects,
+// except in older rows, where they are the encrypted filenames. Loudness is nil unless the audio was normalized.
+// A conversion split into chapters has Parts, its AudioKey is then the one of the first part.
+// The waveform keys name the drawing of the audio stored next to it, empty when none was drawn.
+// Transcripts are the latest completed transcription of each audio that was transcribed.
+// A re-encode of an existing audio has the id of the conversion it came from as ParentId, zero otherwise.
+// The thumbnail keys name the pictures of the video, set by a stage of their own after the conversion.
+// StreamKey names the master playlist of the HLS version of the video, set once it's transcoded.
 type Metadata struct {
-	UserId   int64  `json:"user_id"`
-	FileName string `json:"file_name"`
-	VideoKey string `json:"video_key"`
-	AudioKey string `json:"audio_key"`
+	Id                int64           `json:"id"`
+	UserId            int64           `json:"user_id"`
+	FileName          string          `json:"file_name"`
+	EncryptedFileName string          `json:"encrypted_file_name"`
+	VideoKey          string          `json:"video_key"`
+	AudioKey          string          `json:"audio_key"`
+	Loudness          *Loudness       `json:"loudness,omitempty"`
+	PeaksKey          string          `json:"peaks_key,omitempty"`
+	WaveformKey       string          `json:"waveform_key,omitempty"`
+	Parts             []Part          `json:"parts,omitempty"`
+	Transcripts       []Transcription `json:"transcripts,omitempty"`
+	ParentId          int64           `json:"parent_id,omitempty"`
+	ThumbnailKey      string          `json:"thumbnail_key,omitempty"`
+	PreviewKey        string          `json:"preview_key,omitempty"`
+	StreamKey         string          `json:"stream_key,omitempty"`
+}
+
+// Part is the audio of one chapter of a conversion.
+type Part struct {
+	Chapter
+	AudioKey    string    `json:"audio_key"`
+	Loudness    *Loudness `json:"loudness,omitempty"`
+	PeaksKey    string    `json:"peaks_key,omitempty"`
+	WaveformKey string    `json:"waveform_key,omitempty"`
+}
+
+// AudioKeys returns the keys of all the audio of the conversion.
+func (m *Metadata) AudioKeys() []string {
+	if len(m.Parts) == 0 {
+		return []string{m.AudioKey}
+	}
+
+	keys := make([]string, len(m.Parts))
+	for i, p := range m.Parts {
+		keys[i] = p.AudioKey
+	}
+	return keys
 }
@@ -0,0 +1,111 @@
+package domain
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Options are the processing a conversion applies to the audio it extracts.
+// The zero value extracts the audio as it is.
+type Options struct {
+	// Loudness names the preset the audio is normalized to, empty to leave it as it is.
+	Loudness string
+	// TrimSilence removes the silence at the start and at the end of the audio.
+	TrimSilence bool
+	// CompressSilence shortens the silences inside the audio that are longer than it down to it, zero keeps them.
+	CompressSilence time.Duration
+	// HighPass cuts the frequencies below it, in Hz, removing hum and rumble. Zero disables it.
+	HighPass int
+	// LowPass cuts the frequencies above it, in Hz, removing hiss. Zero disables it.
+	LowPass int
+	// NoiseReduction is how much the background noise is reduced, in dB. Zero disables it.
+	NoiseReduction float64
+	// SplitChapters makes one audio file per chapter, the chapters are the Cues when there are any,
+	// else the chapter markers of the video, else they're found at its long silences.
+	SplitChapters bool
+	// Cues are the starts of the chapters, in order.
+	Cues []Cue
+	// Format is the codec of the audio, mp3, aac or wav. Empty keeps the one of the video.
+	Format string
+	// Bitrate is the constant bitrate of lossy formats, in kbps. Zero keeps the default of the converter.
+	Bitrate int
+	// Tags are written into every audio file, keyed by the names in TagNames.
+	Tags map[string]string
+	// Preset is the id of a saved preset of the user filling in the options left out, zero for their default
+	// preset if any. The converter service applies it, a conversion on its own ignores it.
+	Preset int64
+}
+
+// TagNames are the tags an audio may be given, ffmpeg writes them to every format it converts to.
+var TagNames = map[string]bool{
+	"title": true, "artist": true, "album": true, "album_artist": true,
+	"genre": true, "date": true, "comment": true, "composer": true,
+}
+
+// Bounds of the options, outside of them the filters do more harm than good or ffmpeg refuses them.
+const (
+	MinCompressSilence = 500 * time.Millisecond
+	MaxCompressSilence = 30 * time.Second
+	MinHighPass        = 20
+	MaxHighPass        = 1000
+	MinLowPass         = 2000
+	MaxLowPass         = 20000
+	MinNoiseReduction  = 1
+	MaxNoiseReduction  = 97
+	MaxCues            = 100
+	MaxCueTitle        = 255
+	MaxTagValue        = 255
+)
+
+// Validate returns an error wrapping ErrInvalidOptions when the options can't be applied.
+func (o Options) Validate() error {
+	if _, ok := LoudnessPresets[o.Loudness]; o.Loudness != "" && !ok {
+		return fmt.Errorf("%w: unknown loudness preset %q", ErrInvalidOptions, o.Loudness)
+	}
+
+	if o.CompressSilence != 0 && (o.CompressSilence < MinCompressSilence || o.CompressSilence > MaxCompressSilence) {
+		return fmt.Errorf("%w: silences can be compressed to %s up to %s, not %s", ErrInvalidOptions, MinCompressSilence, MaxCompressSilence, o.CompressSilence)
+	}
+
+	if o.HighPass != 0 && (o.HighPass < MinHighPass || o.HighPass > MaxHighPass) {
+		return fmt.Errorf("%w: high-pass must be %d to %dHz, not %dHz", ErrInvalidOptions, MinHighPass, MaxHighPass, o.HighPass)
+	}
+
+	if o.LowPass != 0 && (o.LowPass < MinLowPass || o.LowPass > MaxLowPass) {
+		return fmt.Errorf("%w: low-pass must be %d to %dHz, not %dHz", ErrInvalidOptions, MinLowPass, MaxLowPass, o.LowPass)
+	}
+
+	if o.NoiseReduction != 0 && (o.NoiseReduction < MinNoiseReduction || o.NoiseReduction > MaxNoiseReduction) {
+		return fmt.Errorf("%w: noise reduction must be %d to %ddB, not %gdB", ErrInvalidOptions, MinNoiseReduction, MaxNoiseReduction, o.NoiseReduction)
+	}
+
+	if len(o.Cues) > MaxCues {
+		return fmt.Errorf("%w: at most %d cues, not %d", ErrInvalidOptions, MaxCues, len(o.Cues))
+	}
+
+	for i, cue := range o.Cues {
+		if cue.Start < 0 || (i > 0 && cue.Start <= o.Cues[i-1].Start) {
+			return fmt.Errorf("%w: cue %d must start after the one before it", ErrInvalidOptions, i+1)
+		}
+		if utf8.RuneCountInString(cue.Title) > MaxCueTitle {
+			return fmt.Errorf("%w: the title of cue %d is longer than %d characters", ErrInvalidOptions, i+1, MaxCueTitle)
+		}
+	}
+
+	if err := (Reencoding{Format: o.Format, Bitrate: o.Bitrate}).Validate(); err != nil {
+		return err
+	}
+
+	for name, value := range o.Tags {
+		if !TagNames[name] {
+			return fmt.Errorf("%w: unknown tag %q", ErrInvalidOptions, name)
+		}
+		if utf8.RuneCountInString(value) > MaxTagValue || strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("%w: the %s tag must be one line of at most %d characters", ErrInvalidOptions, name, MaxTagValue)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,102 @@
+package domain
+
+import (
+	"fmt"
+	"maps"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// MaxPresetName is how long the name of a preset may be, in characters.
+const MaxPresetName = 100
+
+// TagVariables are what the tags of a preset may refer to, filled in for each upload:
+// {filename} is the name of the video without its extension, {date} the day it's converted on.
+var TagVariables = map[string]bool{"{filename}": true, "{date}": true}
+
+// tagVariable matches anything that looks like a variable in a tag.
+var tagVariable = regexp.MustCompile(`\{[^{}]*\}`)
+
+// Preset is a set of options a user saved under a name for their uploads. Its tags are templates
+// that may refer to the TagVariables. At most one preset of a user is the default, the one applied
+// to the uploads that name none.
+type Preset struct {
+	Id          int64             `json:"id"`
+	UserId      int64             `json:"user_id"`
+	Name        string            `json:"name"`
+	Format      string            `json:"format,omitempty"`
+	Bitrate     int               `json:"bitrate,omitempty"`
+	Loudness    string            `json:"loudness,omitempty"`
+	TrimSilence bool              `json:"trim_silence"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Default     bool              `json:"default"`
+	CreatedAt   time.Time         `json:"created_at"`
+	UpdatedAt   time.Time         `json:"updated_at"`
+}
+
+// Validate returns an error wrapping ErrInvalidOptions when the preset can't be saved.
+func (p Preset) Validate() error {
+	if name := strings.TrimSpace(p.Name); name == "" || utf8.RuneCountInString(name) > MaxPresetName {
+		return fmt.Errorf("%w: a preset needs a name of at most %d characters", ErrInvalidOptions, MaxPresetName)
+	}
+
+	for name, tag := range p.Tags {
+		for _, v := range tagVariable.FindAllString(tag, -1) {
+			if !TagVariables[v] {
+				return fmt.Errorf("%w: the %s tag refers to unknown %s", ErrInvalidOptions, name, v)
+			}
+		}
+	}
+
+	return p.options().Validate()
+}
+
+// options are the options of the preset, with its tags as they are.
+func (p Preset) options() Options {
+	return Options{Format: p.Format, Bitrate: p.Bitrate, Loudness: p.Loudness, TrimSilence: p.TrimSilence, Tags: p.Tags}
+}
+
+// Apply fills in the options the upload left out with the preset, and its tags in for the video
+// of the filename converted at the time. Tags are cut down to MaxTagValue when filled in.
+func (p Preset) Apply(opts Options, filename string, at time.Time) Options {
+	if opts.Format == "" && opts.Bitrate == 0 {
+		opts.Format, opts.Bitrate = p.Format, p.Bitrate
+	}
+
+	if opts.Loudness == "" {
+		opts.Loudness = p.Loudness
+	}
+	opts.TrimSilence = opts.TrimSilence || p.TrimSilence
+
+	if len(p.Tags) == 0 {
+		return opts
+	}
+
+	vars := strings.NewReplacer(
+		// a tag is one line, whatever the filename
+		"{filename}", strings.Join(strings.Fields(strings.TrimSuffix(filename, filepath.Ext(filename))), " "),
+		"{date}", at.Format(time.DateOnly),
+	)
+
+	tags := maps.Clone(opts.Tags)
+	if tags == nil {
+		tags = make(map[string]string, len(p.Tags))
+	}
+	for name, tag := range p.Tags {
+		if _, ok := tags[name]; ok {
+			continue
+		}
+
+		value := vars.Replace(tag)
+		if utf8.RuneCountInString(value) > MaxTagValue {
+			value = string([]rune(value)[:MaxTagValue])
+		}
+		tags[name] = value
+	}
+	opts.Tags = tags
+
+	return opts
+}
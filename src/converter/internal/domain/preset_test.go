@@ -0,0 +1,77 @@
+package domain
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPresetValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		preset Preset
+		valid  bool
+	}{
+		{"options", Preset{Name: "Lectures", Format: "mp3", Bitrate: 96, Loudness: "podcast", TrimSilence: true}, true},
+		{"tags", Preset{Name: "Lectures", Tags: map[string]string{"title": "{filename}", "date": "{date}"}}, true},
+		{"no name", Preset{Name: "  "}, false},
+		{"long name", Preset{Name: strings.Repeat("a", MaxPresetName+1)}, false},
+		{"unknown variable", Preset{Name: "Lectures", Tags: map[string]string{"title": "{user}"}}, false},
+		{"unknown tag", Preset{Name: "Lectures", Tags: map[string]string{"mood": "calm"}}, false},
+		{"wav bitrate", Preset{Name: "Lectures", Format: "wav", Bitrate: 128}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.preset.Validate()
+			if tt.valid && err != nil {
+				t.Errorf("Expected a valid preset, got %v", err)
+			}
+			if !tt.valid && !errors.Is(err, ErrInvalidOptions) {
+				t.Errorf("Expected ErrInvalidOptions, got %v", err)
+			}
+		})
+	}
+}
+
+func TestPresetApply(t *testing.T) {
+	preset := Preset{
+		Format: "aac", Bitrate: 96, Loudness: "podcast", TrimSilence: true,
+		Tags: map[string]string{"title": "{filename} ({date})", "album": "Calculus"},
+	}
+	at := time.Date(2026, 3, 14, 15, 0, 0, 0, time.UTC)
+
+	t.Run("fills in", func(t *testing.T) {
+		opts := preset.Apply(Options{HighPass: 80}, "week 1\nintro.mp4", at)
+
+		want := Options{
+			HighPass: 80, Format: "aac", Bitrate: 96, Loudness: "podcast", TrimSilence: true,
+			Tags: map[string]string{"title": "week 1 intro (2026-03-14)", "album": "Calculus"},
+		}
+		if !reflect.DeepEqual(opts, want) {
+			t.Errorf("Expected %+v, got %+v", want, opts)
+		}
+
+		if err := opts.Validate(); err != nil {
+			t.Errorf("Expected the options to be valid, got %v", err)
+		}
+	})
+
+	t.Run("upload wins", func(t *testing.T) {
+		opts := preset.Apply(Options{Loudness: "broadcast", Tags: map[string]string{"album": "Algebra"}}, "lecture.mp4", at)
+
+		if opts.Loudness != "broadcast" || opts.Tags["album"] != "Algebra" || opts.Tags["title"] != "lecture (2026-03-14)" {
+			t.Errorf("Expected the options of the upload to be kept, got %+v", opts)
+		}
+	})
+
+	t.Run("long filename", func(t *testing.T) {
+		opts := Preset{Tags: map[string]string{"title": "{filename}"}}.Apply(Options{}, strings.Repeat("a", 300)+".mp4", at)
+
+		if len(opts.Tags["title"]) != MaxTagValue {
+			t.Errorf("Expected the title to be cut to %d characters, got %d", MaxTagValue, len(opts.Tags["title"]))
+		}
+	})
+}
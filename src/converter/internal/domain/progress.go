@@ -0,0 +1,72 @@
+package domain
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// progressWriter reads the key=value lines ffmpeg writes with -progress,
+// and reports how much of the duration was converted.
+type progressWriter struct {
+	duration time.Duration
+	report   func(done float64)
+	partial  []byte
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	p.partial = append(p.partial, b...)
+	for {
+		i := bytes.IndexByte(p.partial, '\n')
+		if i < 0 {
+			break
+		}
+
+		p.line(string(p.partial[:i]))
+		p.partial = p.partial[i+1:]
+	}
+
+	return len(b), nil
+}
+
+func (p *progressWriter) line(line string) {
+	key, value, _ := strings.Cut(strings.TrimSpace(line), "=")
+	switch key {
+	case "out_time_us":
+		// N/A until the first frame is written
+		us, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || us < 0 {
+			return
+		}
+		p.report(min(float64(time.Duration(us)*time.Microsecond)/float64(p.duration), 1))
+	case "progress":
+		if value == "end" {
+			p.report(1)
+		}
+	}
+}
+
+// withProgress makes ffmpeg write its progress to stdout, and returns the writer reporting it.
+// The writer is nil, and the arguments unchanged, when there is no one to report to or the duration is unknown.
+func withProgress(args []string, duration time.Duration, report func(done float64)) ([]string, io.Writer) {
+	if report == nil || duration <= 0 {
+		return args, nil
+	}
+	return append(args, "-progress", "pipe:1", "-nostats"), &progressWriter{duration: duration, report: report}
+}
+
+// passes divides the progress of a run between its passes, taken to be of the same length.
+// Passes report nothing when the run doesn't.
+func passes(report func(done float64), n int) []func(done float64) {
+	fs := make([]func(done float64), n)
+	if report == nil {
+		return fs
+	}
+
+	for i := range fs {
+		fs[i] = func(done float64) { report((float64(i) + done) / float64(n)) }
+	}
+	return fs
+}
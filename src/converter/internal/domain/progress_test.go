@@ -0,0 +1,33 @@
+package domain
+
+import (
+	"testing"
+	"time"
+)
+
+func TestProgressWriter(t *testing.T) {
+	var reports []float64
+	p := &progressWriter{duration: 10 * time.Second, report: func(done float64) { reports = append(reports, done) }}
+
+	// lines arrive split across writes
+	chunks := []string{
+		"frame=0\nout_time_us=N/A\nprogress=continue\n",
+		"out_time_us=25000",
+		"00\nprogress=continue\nout_time_us=12000000\n",
+		"progress=end\n",
+	}
+	for _, chunk := range chunks {
+		p.Write([]byte(chunk))
+	}
+
+	want := []float64{0.25, 1, 1}
+	if len(reports) != len(want) {
+		t.Fatalf("Expected %v, got %v", want, reports)
+	}
+
+	for i := range want {
+		if reports[i] != want[i] {
+			t.Errorf("Expected %v, got %v", want, reports)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Bounds of the bitrate of a re-encoded audio, in kbps.
+const (
+	MinBitrate = 32
+	MaxBitrate = 320
+)
+
+// Reencoding is how an existing audio is encoded again. The zero value encodes it again as it is.
+type Reencoding struct {
+	// Format is the codec of the new audio, mp3, aac or wav. Empty keeps the one of the audio.
+	Format string
+	// Bitrate is the constant bitrate of lossy formats, in kbps. Zero keeps the default of the converter.
+	Bitrate int
+	// Start and End trim the audio, a zero End keeps it to its end.
+	Start, End time.Duration
+	// Loudness names the preset the audio is normalized to, empty to leave it as it is.
+	Loudness string
+}
+
+// Validate returns an error wrapping ErrInvalidOptions when the re-encoding can't be applied.
+func (r Reencoding) Validate() error {
+	switch r.Format {
+	case "", "mp3", "aac":
+	case "wav":
+		if r.Bitrate != 0 {
+			return fmt.Errorf("%w: wav has no bitrate", ErrInvalidOptions)
+		}
+	default:
+		return fmt.Errorf("%w: unknown format %q", ErrInvalidOptions, r.Format)
+	}
+
+	if r.Bitrate != 0 && (r.Bitrate < MinBitrate || r.Bitrate > MaxBitrate) {
+		return fmt.Errorf("%w: bitrate must be %d to %dkbps, not %dkbps", ErrInvalidOptions, MinBitrate, MaxBitrate, r.Bitrate)
+	}
+
+	if r.Start < 0 || r.End < 0 || (r.End > 0 && r.End <= r.Start) {
+		return fmt.Errorf("%w: the audio must end after it starts", ErrInvalidOptions)
+	}
+
+	if _, ok := LoudnessPresets[r.Loudness]; r.Loudness != "" && !ok {
+		return fmt.Errorf("%w: unknown loudness preset %q", ErrInvalidOptions, r.Loudness)
+	}
+
+	return nil
+}
+
+// trim returns the filters cutting the audio, the timestamps then start from zero again.
+func (r Reencoding) trim() []string {
+	if r.Start == 0 && r.End == 0 {
+		return nil
+	}
+
+	f := "atrim=start=" + decimal(r.Start.Seconds())
+	if r.End > 0 {
+		f += ":end=" + decimal(r.End.Seconds())
+	}
+	return []string{f, "asetpts=PTS-STARTPTS"}
+}
+
+// Reencode encodes the audio again into dir as the re-encoding says, trimmed then normalized.
+// The audio must be in dir, which should be private to the job like for a conversion.
+// The progress function, if any, is called with the share of the audio encoded so far, from 0 to 1.
+func (c *Converter) Reencode(ctx context.Context, dir, audio string, r Reencoding, progress func(done float64)) (*Audio, error) {
+	if err := r.Validate(); err != nil {
+		return nil, err
+	}
+
+	probe, err := c.probe(ctx, dir, audio)
+	if err != nil {
+		return nil, err
+	}
+
+	if probe.invalid {
+		return nil, ErrCorruptFile
+	}
+
+	format := r.Format
+	if format == "" {
+		format = probe.codec
+	}
+
+	codec, ext, err := formatArgs(format, r.Bitrate)
+	if err != nil {
+		return nil, err
+	}
+
+	// the length of what is kept times the passes, it's unknown when the audio's is
+	length := probe.duration
+	if probe.duration > 0 {
+		if r.Start >= probe.duration {
+			return nil, fmt.Errorf("%w: the audio ends before %s", ErrInvalidOptions, r.Start)
+		}
+		if r.End > 0 && r.End < probe.duration {
+			length = r.End
+		}
+		length -= r.Start
+	}
+
+	n := 1
+	if r.Loudness != "" {
+		n++
+	}
+	progresses := passes(progress, n)
+
+	filters := r.trim()
+
+	var measured *loudnorm
+	if r.Loudness != "" {
+		if measured, err = c.measureLoudness(ctx, dir, audio, length, filters, LoudnessPresets[r.Loudness], progresses[0]); err != nil {
+			return nil, err
+		}
+	}
+
+	if measured != nil {
+		filters = append(filters, LoudnessPresets[r.Loudness].filter(measured))
+	}
+
+	output := filepath.Join(dir, "output"+ext)
+	args := []string{"-i", audio, "-vn", "-y"}
+	if len(filters) > 0 {
+		args = append(args, "-af", strings.Join(filters, ","))
+	}
+
+	// loudnorm resamples to 192kHz as it works, so the output is brought back to 48kHz
+	if measured != nil {
+		args = append(args, "-ar", "48000")
+	}
+
+	args, stdout := withProgress(append(args, codec...), length, progresses[n-1])
+
+	out, err := c.run(ctx, dir, stdout, append(args, output)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to run ffmpeg: %w", err)
+	}
+
+	a := &Audio{Path: output}
+	if measured != nil {
+		if a.Loudness, err = loudness(r.Loudness, measured, out); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// formatArgs returns the arguments writing audio of the format at the bitrate, and the extension of its file.
+// Without a bitrate, audio is written the way a conversion encoding it again would.
+func formatArgs(format string, bitrate int) ([]string, string, error) {
+	if bitrate == 0 || format == "wav" {
+		args, ext, err := codecArgs(format, true)
+		if err != nil {
+			return nil, "", err
+		}
+		return append([]string{"-ab", "192000"}, args...), ext, nil
+	}
+
+	b := strconv.Itoa(bitrate) + "k"
+	switch format {
+	case "mp3":
+		return []string{"-acodec", "libmp3lame", "-b:a", b, "-f", "mp3"}, ".mp3", nil
+	case "aac":
+		return []string{"-acodec", "aac", "-b:a", b, "-f", "adts"}, ".aac", nil
+	default:
+		return nil, "", fmt.Errorf("%w: %q", ErrUnsupportedCodec, format)
+	}
+}
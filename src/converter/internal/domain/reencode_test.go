@@ -0,0 +1,89 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReencode(t *testing.T) {
+	t.Run("trimmed and normalized", func(t *testing.T) {
+		dir := t.TempDir()
+		c := NewConverter(loudnormFFmpeg(t, "-27.61"), 0, Limits{})
+
+		r := Reencoding{Format: "mp3", Bitrate: 128, Start: 2 * time.Second, End: 8500 * time.Millisecond, Loudness: "podcast"}
+		audio, err := c.Reencode(context.Background(), dir, filepath.Join(dir, "input.aac"), r, nil)
+		if err != nil {
+			t.Fatalf("Reencode failed: %v", err)
+		}
+
+		if audio.Path != filepath.Join(dir, "output.mp3") || audio.Loudness == nil || audio.Loudness.OutputIntegrated != -16.02 {
+			t.Errorf("Unexpected audio: %+v", audio)
+		}
+
+		args, err := os.ReadFile(filepath.Join(dir, "args"))
+		if err != nil {
+			t.Fatalf("Failed to read the arguments of the encoding: %v", err)
+		}
+
+		// the trimmed audio is what gets measured and normalized
+		for _, arg := range []string{"atrim=start=2:end=8.5,asetpts=PTS-STARTPTS,loudnorm=I=-16", "-acodec libmp3lame -b:a 128k"} {
+			if !strings.Contains(string(args), arg) {
+				t.Errorf("Expected %q in the arguments, got %q", arg, args)
+			}
+		}
+	})
+
+	t.Run("format is kept", func(t *testing.T) {
+		dir := t.TempDir()
+		c := NewConverter(loudnormFFmpeg(t, "-27.61"), 0, Limits{})
+
+		audio, err := c.Reencode(context.Background(), dir, filepath.Join(dir, "input.aac"), Reencoding{}, nil)
+		if err != nil {
+			t.Fatalf("Reencode failed: %v", err)
+		}
+
+		if args, _ := os.ReadFile(filepath.Join(dir, "args")); audio.Path != filepath.Join(dir, "output.aac") || !strings.Contains(string(args), "-acodec aac") {
+			t.Errorf("Expected the audio encoded as aac again, got %s with %q", audio.Path, args)
+		}
+	})
+
+	t.Run("start past the end", func(t *testing.T) {
+		dir := t.TempDir()
+		c := NewConverter(loudnormFFmpeg(t, "-27.61"), 0, Limits{})
+
+		_, err := c.Reencode(context.Background(), dir, filepath.Join(dir, "input.aac"), Reencoding{Start: time.Minute}, nil)
+		if !errors.Is(err, ErrInvalidOptions) {
+			t.Errorf("Expected ErrInvalidOptions, got %v", err)
+		}
+	})
+}
+
+func TestReencodingValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		r    Reencoding
+	}{
+		{name: "unknown format", r: Reencoding{Format: "flac"}},
+		{name: "wav bitrate", r: Reencoding{Format: "wav", Bitrate: 128}},
+		{name: "bitrate too low", r: Reencoding{Bitrate: 16}},
+		{name: "ends before it starts", r: Reencoding{Start: 5 * time.Second, End: 5 * time.Second}},
+		{name: "unknown preset", r: Reencoding{Loudness: "loud"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.r.Validate(); !errors.Is(err, ErrInvalidOptions) {
+				t.Errorf("Expected ErrInvalidOptions, got %v", err)
+			}
+		})
+	}
+
+	if err := (Reencoding{Format: "aac", Bitrate: 96, End: time.Minute}).Validate(); err != nil {
+		t.Errorf("Expected a valid re-encoding, got %v", err)
+	}
+}
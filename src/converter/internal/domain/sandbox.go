@@ -0,0 +1,122 @@
+package domain
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+// stderrLimit is how much of the end of ffmpeg's output is kept, the end holds the error.
+const stderrLimit = 16 << 10
+
+// Limits caps the resources of every ffmpeg run. Zero values leave a resource unlimited.
+type Limits struct {
+	// Timeout is the wall-clock time a run may take.
+	Timeout time.Duration
+	// CPU is the processor time a run may use.
+	CPU time.Duration
+	// Memory is the address space a run may map, in bytes.
+	Memory int64
+	// FileSize is the largest file a run may write, in bytes.
+	FileSize int64
+}
+
+// ulimits returns the shell commands applying the limits, the shell then execs ffmpeg
+// so the limits hold for ffmpeg alone and are in place before it reads any input.
+func (l Limits) ulimits() string {
+	var script string
+	if l.CPU > 0 {
+		script += "ulimit -t " + strconv.FormatInt(int64(max(l.CPU/time.Second, 1)), 10) + " && "
+	}
+	if l.Memory > 0 {
+		script += "ulimit -v " + strconv.FormatInt(max(l.Memory>>10, 1), 10) + " && "
+	}
+	if l.FileSize > 0 {
+		// blocks of 512 bytes, as POSIX shells count them
+		script += "ulimit -f " + strconv.FormatInt(max(l.FileSize>>9, 1), 10) + " && "
+	}
+	return script
+}
+
+// FFmpegError is returned when ffmpeg fails. Stderr holds the end of its output,
+// it describes untrusted input and must never reach the user.
+type FFmpegError struct {
+	Err    error
+	Stderr string
+}
+
+func (e *FFmpegError) Error() string {
+	return fmt.Sprintf("ffmpeg failed: %v", e.Err)
+}
+
+func (e *FFmpegError) Unwrap() error {
+	return e.Err
+}
+
+// tail keeps the last bytes written to it.
+type tail struct {
+	buf   bytes.Buffer
+	limit int
+}
+
+func (t *tail) Write(p []byte) (int, error) {
+	n := len(p)
+	if n >= t.limit {
+		t.buf.Reset()
+		p = p[n-t.limit:]
+	} else if over := t.buf.Len() + n - t.limit; over > 0 {
+		t.buf.Next(over)
+	}
+	t.buf.Write(p)
+	return n, nil
+}
+
+func (t *tail) String() string {
+	return t.buf.String()
+}
+
+// run runs ffmpeg in dir with the limits, and returns its output. It never reads from
+// the network or stdin, and it's killed once ctx is done or the timeout passes.
+// Stdout goes to the given writer when there is one, otherwise it's part of the output.
+func (c *Converter) run(ctx context.Context, dir string, stdout io.Writer, args ...string) (string, error) {
+	runCtx := ctx
+	if c.limits.Timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, c.limits.Timeout)
+		defer cancel()
+	}
+
+	args = append([]string{"-nostdin", "-hide_banner", "-protocol_whitelist", "file"}, args...)
+
+	var cmd *exec.Cmd
+	if script := c.limits.ulimits(); script != "" {
+		cmd = exec.CommandContext(runCtx, "/bin/sh", append([]string{"-c", script + `exec "$0" "$@"`, c.ffp}, args...)...)
+	} else {
+		cmd = exec.CommandContext(runCtx, c.ffp, args...)
+	}
+
+	out := &tail{limit: stderrLimit}
+	cmd.Dir, cmd.Env = dir, []string{"PATH=/usr/bin:/bin", "TMPDIR=" + dir}
+	cmd.Stdout, cmd.Stderr = out, out
+	if stdout != nil {
+		cmd.Stdout = stdout
+	}
+	cmd.WaitDelay = 5 * time.Second
+
+	err := cmd.Run()
+	switch {
+	case err == nil:
+		return out.String(), nil
+	case ctx.Err() != nil:
+		return out.String(), fmt.Errorf("ffmpeg was stopped: %w", ctx.Err())
+	case errors.Is(runCtx.Err(), context.DeadlineExceeded):
+		return out.String(), &FFmpegError{Err: fmt.Errorf("%w after %s", ErrTimeout, c.limits.Timeout), Stderr: out.String()}
+	default:
+		return out.String(), &FFmpegError{Err: err, Stderr: out.String()}
+	}
+}
@@ -0,0 +1,109 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeFFmpeg writes a shell script standing in for ffmpeg.
+func fakeFFmpeg(t *testing.T, script string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "ffmpeg")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+script+"\n"), 0700); err != nil {
+		t.Fatalf("Failed to write fake ffmpeg: %v", err)
+	}
+	return path
+}
+
+func TestRun(t *testing.T) {
+	t.Run("stderr is captured", func(t *testing.T) {
+		c := NewConverter(fakeFFmpeg(t, `echo "$@" >&2; exit 1`), 0, Limits{})
+
+		_, err := c.run(context.Background(), t.TempDir(), nil, "-i", "input.mp4")
+
+		var ffErr *FFmpegError
+		if !errors.As(err, &ffErr) {
+			t.Fatalf("Expected an FFmpegError, got %v", err)
+		}
+
+		// reading from anything but files is refused up front
+		if !strings.Contains(ffErr.Stderr, "-protocol_whitelist file") || !strings.Contains(ffErr.Stderr, "-i input.mp4") {
+			t.Errorf("Expected the arguments on stderr, got %q", ffErr.Stderr)
+		}
+	})
+
+	t.Run("limits are applied", func(t *testing.T) {
+		c := NewConverter(fakeFFmpeg(t, `ulimit -t; ulimit -f`), 0, Limits{CPU: 90 * time.Second, FileSize: 1 << 20})
+
+		out, err := c.run(context.Background(), t.TempDir(), nil)
+		if err != nil {
+			t.Fatalf("run failed: %v", err)
+		}
+
+		if fields := strings.Fields(out); len(fields) != 2 || fields[0] != "90" || fields[1] != "2048" {
+			t.Errorf("Expected a 90s CPU and 2048 block file limit, got %q", out)
+		}
+	})
+
+	t.Run("runs in the job directory", func(t *testing.T) {
+		dir := t.TempDir()
+		c := NewConverter(fakeFFmpeg(t, `pwd; echo "$TMPDIR"`), 0, Limits{})
+
+		out, err := c.run(context.Background(), dir, nil)
+		if err != nil {
+			t.Fatalf("run failed: %v", err)
+		}
+
+		if fields := strings.Fields(out); len(fields) != 2 || fields[0] != dir || fields[1] != dir {
+			t.Errorf("Expected ffmpeg to work in %s, got %q", dir, out)
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		c := NewConverter(fakeFFmpeg(t, `exec sleep 10`), 0, Limits{Timeout: 100 * time.Millisecond})
+
+		start := time.Now()
+		_, err := c.run(context.Background(), t.TempDir(), nil)
+		if !errors.Is(err, ErrTimeout) || errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Expected ErrTimeout alone, got %v", err)
+		}
+
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("Expected ffmpeg to be killed, it ran for %s", elapsed)
+		}
+	})
+
+	t.Run("canceled job", func(t *testing.T) {
+		c := NewConverter(fakeFFmpeg(t, `exec sleep 10`), 0, Limits{})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		_, err := c.run(ctx, t.TempDir(), nil)
+		if errors.Is(err, ErrTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Expected the context error, got %v", err)
+		}
+	})
+}
+
+func TestTail(t *testing.T) {
+	tl := &tail{limit: 8}
+	tl.Write([]byte("abc"))
+	tl.Write([]byte("defgh"))
+	tl.Write([]byte("ij"))
+
+	if got := tl.String(); got != "cdefghij" {
+		t.Errorf("Expected %q, got %q", "cdefghij", got)
+	}
+
+	tl.Write([]byte("0123456789"))
+	if got := tl.String(); got != "23456789" {
+		t.Errorf("Expected %q, got %q", "23456789", got)
+	}
+}
@@ -0,0 +1,204 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Renditions is the HLS ladder, the video bitrate in kbps of each height a video may be streamed at.
+var Renditions = map[int]int{240: 400, 360: 800, 480: 1400, 720: 2800, 1080: 5000}
+
+// MasterPlaylist is the playlist players are given, it lists every rendition of a stream.
+const MasterPlaylist = "master.m3u8"
+
+const (
+	// segmentSeconds is the length of the segments of a stream, keyframes are forced on their edges
+	segmentSeconds = 6
+	// streamAudioBitrate is the bitrate of the audio of every rendition, in kbps
+	streamAudioBitrate = 128
+)
+
+// Transcoding transcodes the video of a conversion into HLS, the stream is stored under a prefix named after its audio.
+// The key of its master playlist is set once the job completes.
+type Transcoding struct {
+	JobId       string    `json:"job_id"`
+	UserId      int64     `json:"user_id"`
+	MetadataId  int64     `json:"metadata_id"`
+	Renditions  []int     `json:"renditions,omitempty"`
+	Status      JobStatus `json:"status"`
+	PlaylistKey string    `json:"playlist_key,omitempty"`
+}
+
+// Streaming is how a video is transcoded into HLS. Its audio is always streamed on its own,
+// and its picture at each of the Renditions heights, in pixels, that the video is at least as tall as.
+type Streaming struct {
+	Renditions []int
+}
+
+// Validate returns an error wrapping ErrInvalidOptions when a rendition isn't on the ladder.
+func (s Streaming) Validate() error {
+	for i, h := range s.Renditions {
+		if _, ok := Renditions[h]; !ok {
+			return fmt.Errorf("%w: no %dp rendition", ErrInvalidOptions, h)
+		}
+		if slices.Contains(s.Renditions[:i], h) {
+			return fmt.Errorf("%w: %dp is asked for twice", ErrInvalidOptions, h)
+		}
+	}
+	return nil
+}
+
+// Stream is the HLS version of a video.
+type Stream struct {
+	// Dir is where the stream was written.
+	Dir string
+	// Files are the playlists and segments as slash separated paths relative to Dir. The master playlist
+	// comes last, so a stream stored in this order can't be played before it's whole.
+	Files []string
+}
+
+// variant is one rendition of a stream, the audio alone when it has no height.
+type variant struct {
+	name          string
+	width, height int
+	// bitrate of the video in kbps
+	bitrate int
+}
+
+// variants returns the renditions the video is streamed at, tallest first and the audio last.
+// A picture is never scaled up, and one of unknown size is scaled to every height asked for.
+func (s Streaming) variants(p probe) []variant {
+	var vs []variant
+	if p.picture {
+		heights := slices.Clone(s.Renditions)
+		slices.Sort(heights)
+		slices.Reverse(heights)
+
+		for _, h := range heights {
+			if p.height > 0 && h > p.height {
+				continue
+			}
+
+			v := variant{name: strconv.Itoa(h) + "p", height: h, bitrate: Renditions[h]}
+			if p.height > 0 {
+				// x264 wants even sizes
+				v.width = (p.width*h/p.height + 1) &^ 1
+			}
+			vs = append(vs, v)
+		}
+	}
+	return append(vs, variant{name: "audio"})
+}
+
+// args returns the arguments encoding the variant, its audio always comes from the first audio stream.
+func (v variant) args() []string {
+	audio := []string{"-c:a", "aac", "-b:a", strconv.Itoa(streamAudioBitrate) + "k", "-ac", "2"}
+	if v.height == 0 {
+		return append([]string{"-map", "0:a:0", "-vn"}, audio...)
+	}
+
+	return append([]string{
+		"-map", "0:v:0", "-map", "0:a:0",
+		"-vf", fmt.Sprintf("scale=-2:%d", v.height),
+		"-c:v", "libx264", "-preset", "veryfast", "-profile:v", "main", "-level", "4.0", "-pix_fmt", "yuv420p",
+		"-b:v", strconv.Itoa(v.bitrate) + "k", "-maxrate", strconv.Itoa(v.maxrate()) + "k", "-bufsize", strconv.Itoa(2*v.bitrate) + "k",
+		// every segment starts on a keyframe, so players can switch renditions between any two
+		"-force_key_frames", fmt.Sprintf("expr:gte(t,n_forced*%d)", segmentSeconds), "-sc_threshold", "0",
+	}, audio...)
+}
+
+// maxrate is the peak bitrate of the video of the variant in kbps, what the encoder is held to.
+func (v variant) maxrate() int {
+	return v.bitrate * 11 / 10
+}
+
+// streamInf is the line of the master playlist describing the variant.
+func (v variant) streamInf() string {
+	if v.height == 0 {
+		return fmt.Sprintf(`#EXT-X-STREAM-INF:BANDWIDTH=%d,CODECS="mp4a.40.2"`, streamAudioBitrate*1000)
+	}
+
+	inf := fmt.Sprintf("#EXT-X-STREAM-INF:BANDWIDTH=%d", (v.maxrate()+streamAudioBitrate)*1000)
+	if v.width > 0 {
+		inf += fmt.Sprintf(",RESOLUTION=%dx%d", v.width, v.height)
+	}
+	return inf + `,CODECS="avc1.4d4028,mp4a.40.2"`
+}
+
+// Stream transcodes the video into HLS in dir, one playlist of segments per rendition and a master playlist of them.
+// The dir should be private to the job, like for a conversion.
+// The progress function, if any, is called with the share of the renditions transcoded so far, from 0 to 1.
+func (c *Converter) Stream(ctx context.Context, dir string, video io.Reader, s Streaming, progress func(done float64)) (*Stream, error) {
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+
+	input, err := writeInput(dir, video)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(input)
+
+	probe, err := c.probe(ctx, dir, input)
+	if err != nil {
+		return nil, err
+	}
+
+	if probe.invalid {
+		return nil, ErrCorruptFile
+	}
+
+	out := filepath.Join(dir, "stream")
+	variants := s.variants(probe)
+	progresses := passes(progress, len(variants))
+
+	master := []string{"#EXTM3U", "#EXT-X-VERSION:3", "#EXT-X-INDEPENDENT-SEGMENTS"}
+	for i, v := range variants {
+		if err = os.MkdirAll(filepath.Join(out, v.name), 0700); err != nil {
+			return nil, fmt.Errorf("failed to create %s directory: %v", v.name, err)
+		}
+
+		args, stdout := withProgress(append([]string{"-i", input, "-y"}, v.args()...), probe.duration, progresses[i])
+		args = append(args,
+			"-f", "hls", "-hls_time", strconv.Itoa(segmentSeconds), "-hls_playlist_type", "vod",
+			"-hls_segment_filename", filepath.Join(out, v.name, "segment%03d.ts"), filepath.Join(out, v.name, "index.m3u8"),
+		)
+
+		if _, err = c.run(ctx, dir, stdout, args...); err != nil {
+			return nil, fmt.Errorf("failed to transcode %s: %w", v.name, err)
+		}
+
+		master = append(master, v.streamInf(), v.name+"/index.m3u8")
+	}
+
+	st := &Stream{Dir: out}
+	err = filepath.WalkDir(out, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		rel, err := filepath.Rel(out, p)
+		if err != nil {
+			return err
+		}
+		st.Files = append(st.Files, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list stream files: %v", err)
+	}
+
+	if err = os.WriteFile(filepath.Join(out, MasterPlaylist), []byte(strings.Join(master, "\n")+"\n"), 0600); err != nil {
+		return nil, fmt.Errorf("failed to write master playlist: %v", err)
+	}
+	st.Files = append(st.Files, MasterPlaylist)
+
+	return st, nil
+}
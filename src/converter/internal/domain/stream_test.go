@@ -0,0 +1,108 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// streamFFmpeg fakes ffmpeg probing a 20 second video with the given streams, it writes a playlist
+// of one segment for every rendition and keeps the arguments transcoding them in the job directory.
+func streamFFmpeg(t *testing.T, streams string) string {
+	return fakeFFmpeg(t, `for a; do last=$a; done
+case "$*" in
+*"-f hls"*)
+	echo "$*" >> args
+	: > "$(dirname "$last")/segment000.ts"
+	: > "$last" ;;
+*)
+	echo '  Duration: 00:00:20.00, start: 0.000000, bitrate: 900 kb/s' >&2
+	printf '%s\n' `+streams+` >&2
+	exit 1 ;;
+esac`)
+}
+
+func TestStream(t *testing.T) {
+	streams := `'  Stream #0:0(und): Video: h264 (High), yuv420p, 1280x720' '  Stream #0:1(und): Audio: aac (LC), 44100 Hz, stereo'`
+
+	t.Run("renditions", func(t *testing.T) {
+		dir := t.TempDir()
+		c := NewConverter(streamFFmpeg(t, streams), 0, Limits{})
+
+		st, err := c.Stream(context.Background(), dir, strings.NewReader("video"), Streaming{Renditions: []int{360, 1080, 720}}, nil)
+		if err != nil {
+			t.Fatalf("Stream failed: %v", err)
+		}
+
+		// 1080p would be scaled up, and the master playlist is stored last
+		want := []string{
+			"360p/index.m3u8", "360p/segment000.ts", "720p/index.m3u8", "720p/segment000.ts",
+			"audio/index.m3u8", "audio/segment000.ts", MasterPlaylist,
+		}
+		if st.Dir != filepath.Join(dir, "stream") || !reflect.DeepEqual(st.Files, want) {
+			t.Fatalf("Expected %v in %s, got %v in %s", want, filepath.Join(dir, "stream"), st.Files, st.Dir)
+		}
+
+		master, err := os.ReadFile(filepath.Join(st.Dir, MasterPlaylist))
+		if err != nil {
+			t.Fatalf("Failed to read the master playlist: %v", err)
+		}
+
+		for _, line := range []string{
+			"#EXT-X-STREAM-INF:BANDWIDTH=3208000,RESOLUTION=1280x720,CODECS=\"avc1.4d4028,mp4a.40.2\"\n720p/index.m3u8\n",
+			"#EXT-X-STREAM-INF:BANDWIDTH=1008000,RESOLUTION=640x360,CODECS=\"avc1.4d4028,mp4a.40.2\"\n360p/index.m3u8\n",
+			"#EXT-X-STREAM-INF:BANDWIDTH=128000,CODECS=\"mp4a.40.2\"\naudio/index.m3u8\n",
+		} {
+			if !strings.Contains(string(master), line) {
+				t.Errorf("Expected %q in the master playlist, got %q", line, master)
+			}
+		}
+
+		args, err := os.ReadFile(filepath.Join(dir, "args"))
+		if err != nil {
+			t.Fatalf("Failed to read the arguments of the transcoding: %v", err)
+		}
+
+		for _, arg := range []string{"scale=-2:720", "-b:v 2800k -maxrate 3080k", "expr:gte(t,n_forced*6)", "-map 0:a:0 -vn -c:a aac -b:a 128k", "-hls_time 6 -hls_playlist_type vod"} {
+			if !strings.Contains(string(args), arg) {
+				t.Errorf("Expected %q in the arguments, got %q", arg, args)
+			}
+		}
+	})
+
+	t.Run("audio only", func(t *testing.T) {
+		c := NewConverter(streamFFmpeg(t, `'  Stream #0:0(und): Audio: aac (LC), 44100 Hz, stereo'`), 0, Limits{})
+
+		st, err := c.Stream(context.Background(), t.TempDir(), strings.NewReader("video"), Streaming{Renditions: []int{360}}, nil)
+		if err != nil {
+			t.Fatalf("Stream failed: %v", err)
+		}
+
+		if want := []string{"audio/index.m3u8", "audio/segment000.ts", MasterPlaylist}; !reflect.DeepEqual(st.Files, want) {
+			t.Errorf("Expected the audio alone, got %v", st.Files)
+		}
+	})
+
+	t.Run("unknown rendition", func(t *testing.T) {
+		c := NewConverter(streamFFmpeg(t, streams), 0, Limits{})
+
+		_, err := c.Stream(context.Background(), t.TempDir(), strings.NewReader("video"), Streaming{Renditions: []int{144}}, nil)
+		if !errors.Is(err, ErrInvalidOptions) {
+			t.Errorf("Expected ErrInvalidOptions, got %v", err)
+		}
+	})
+}
+
+func TestStreamingValidate(t *testing.T) {
+	if err := (Streaming{Renditions: []int{360, 360}}).Validate(); !errors.Is(err, ErrInvalidOptions) {
+		t.Errorf("Expected a rendition asked for twice to be invalid, got %v", err)
+	}
+
+	if err := (Streaming{}).Validate(); err != nil {
+		t.Errorf("Expected the audio alone to be valid, got %v", err)
+	}
+}
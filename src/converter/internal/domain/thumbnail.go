@@ -0,0 +1,97 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Animated preview formats.
+const (
+	PreviewWebP = "webp"
+	PreviewGIF  = "gif"
+)
+
+// previewFPS is the frame rate of animated previews, enough to see what happens without weighing much.
+const previewFPS = 10
+
+// ThumbnailConfig says how the pictures of uploaded videos are drawn.
+type ThumbnailConfig struct {
+	// Width of the pictures in pixels, their height follows the video's aspect ratio. Zero draws none.
+	Width int
+	// PreviewFormat is webp or gif, empty to draw the poster frame only.
+	PreviewFormat string
+	// PreviewDuration is how much of the video the preview shows.
+	PreviewDuration time.Duration
+}
+
+// Thumbnail is where the pictures of a video were written.
+type Thumbnail struct {
+	// Poster is the jpeg file of a frame of the video.
+	Poster string
+	// Preview is the animated file of a short stretch of it, empty when none was asked for.
+	Preview string
+}
+
+// DrawThumbnail draws the poster frame of the video into dir, and its animated preview when the config asks for one.
+// Both are taken a tenth into the video, past the title cards and fades most videos start with.
+// The dir should be private to the job, like for a conversion.
+func (c *Converter) DrawThumbnail(ctx context.Context, dir string, video io.Reader, cfg ThumbnailConfig) (*Thumbnail, error) {
+	switch cfg.PreviewFormat {
+	case "", PreviewWebP, PreviewGIF:
+	default:
+		return nil, fmt.Errorf("%w: unknown preview format %q", ErrInvalidOptions, cfg.PreviewFormat)
+	}
+
+	input, err := writeInput(dir, video)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(input)
+
+	probe, err := c.probe(ctx, dir, input)
+	if err != nil {
+		return nil, err
+	}
+
+	if probe.invalid {
+		return nil, ErrCorruptFile
+	}
+
+	if !probe.picture {
+		return nil, ErrNoPicture
+	}
+
+	at := decimal((probe.duration / 10).Seconds())
+	scale := fmt.Sprintf("scale=%d:-2", cfg.Width)
+
+	t := &Thumbnail{Poster: filepath.Join(dir, "poster.jpg")}
+	if _, err = c.run(ctx, dir, nil, "-ss", at, "-i", input, "-an", "-y", "-vf", scale, "-frames:v", "1", "-q:v", "3", "-f", "image2", t.Poster); err != nil {
+		return nil, fmt.Errorf("failed to draw poster: %w", err)
+	}
+
+	if cfg.PreviewFormat == "" {
+		return t, nil
+	}
+
+	args := []string{"-ss", at, "-t", decimal(cfg.PreviewDuration.Seconds()), "-i", input, "-an", "-y", "-loop", "0"}
+	frames := fmt.Sprintf("fps=%d,%s:flags=lanczos", previewFPS, scale)
+
+	t.Preview = filepath.Join(dir, "preview."+cfg.PreviewFormat)
+	switch cfg.PreviewFormat {
+	case PreviewWebP:
+		args = append(args, "-vf", frames, "-c:v", "libwebp", "-q:v", "60", "-f", "webp")
+	case PreviewGIF:
+		// gif has 256 colors, a palette made from the frames keeps them from banding
+		args = append(args, "-vf", frames+",split[a][b];[a]palettegen[p];[b][p]paletteuse", "-f", "gif")
+	}
+
+	if _, err = c.run(ctx, dir, nil, append(args, t.Preview)...); err != nil {
+		return nil, fmt.Errorf("failed to draw preview: %w", err)
+	}
+
+	return t, nil
+}
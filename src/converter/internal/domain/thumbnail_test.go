@@ -0,0 +1,84 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// thumbnailFFmpeg fakes ffmpeg probing a 20 second video with the given streams,
+// it writes every picture and keeps the arguments drawing them in the job directory.
+func thumbnailFFmpeg(t *testing.T, streams string) string {
+	return fakeFFmpeg(t, `for a; do last=$a; done
+case "$*" in
+*-frames:v*|*-loop*)
+	echo "$*" >> args
+	: > "$last" ;;
+*)
+	echo '  Duration: 00:00:20.00, start: 0.000000, bitrate: 900 kb/s' >&2
+	printf '%s\n' `+streams+` >&2
+	exit 1 ;;
+esac`)
+}
+
+func TestDrawThumbnail(t *testing.T) {
+	streams := `'  Stream #0:0(und): Video: h264 (High), yuv420p, 1280x720' '  Stream #0:1(und): Audio: aac (LC), 44100 Hz, stereo'`
+
+	t.Run("poster and preview", func(t *testing.T) {
+		dir := t.TempDir()
+		c := NewConverter(thumbnailFFmpeg(t, streams), 0, Limits{})
+
+		th, err := c.DrawThumbnail(context.Background(), dir, strings.NewReader("video"), ThumbnailConfig{Width: 480, PreviewFormat: PreviewWebP, PreviewDuration: 3 * time.Second})
+		if err != nil {
+			t.Fatalf("DrawThumbnail failed: %v", err)
+		}
+
+		if th.Poster != filepath.Join(dir, "poster.jpg") || th.Preview != filepath.Join(dir, "preview.webp") {
+			t.Fatalf("Unexpected thumbnail files: %+v", th)
+		}
+
+		args, err := os.ReadFile(filepath.Join(dir, "args"))
+		if err != nil {
+			t.Fatalf("Failed to read the arguments of the drawing: %v", err)
+		}
+
+		// both are taken a tenth into the video
+		for _, arg := range []string{"-ss 2 -i", "scale=480:-2", "-t 3", "fps=10,scale=480:-2:flags=lanczos", "-c:v libwebp"} {
+			if !strings.Contains(string(args), arg) {
+				t.Errorf("Expected %q in the arguments, got %q", arg, args)
+			}
+		}
+	})
+
+	t.Run("poster only", func(t *testing.T) {
+		dir := t.TempDir()
+		c := NewConverter(thumbnailFFmpeg(t, streams), 0, Limits{})
+
+		th, err := c.DrawThumbnail(context.Background(), dir, strings.NewReader("video"), ThumbnailConfig{Width: 320})
+		if err != nil || th.Preview != "" {
+			t.Errorf("Expected only a poster, got %+v: %v", th, err)
+		}
+	})
+
+	t.Run("audio only", func(t *testing.T) {
+		c := NewConverter(thumbnailFFmpeg(t, `'  Stream #0:0(und): Audio: aac (LC), 44100 Hz, stereo'`), 0, Limits{})
+
+		_, err := c.DrawThumbnail(context.Background(), t.TempDir(), strings.NewReader("video"), ThumbnailConfig{Width: 320})
+		if !errors.Is(err, ErrNoPicture) {
+			t.Errorf("Expected ErrNoPicture, got %v", err)
+		}
+	})
+
+	t.Run("unknown format", func(t *testing.T) {
+		c := NewConverter(thumbnailFFmpeg(t, streams), 0, Limits{})
+
+		_, err := c.DrawThumbnail(context.Background(), t.TempDir(), strings.NewReader("video"), ThumbnailConfig{Width: 320, PreviewFormat: "apng"})
+		if !errors.Is(err, ErrInvalidOptions) {
+			t.Errorf("Expected ErrInvalidOptions, got %v", err)
+		}
+	})
+}
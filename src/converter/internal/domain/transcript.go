@@ -0,0 +1,42 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+)
+
+// Transcription transcribes the speech of one audio of a conversion, a part of it when it was split.
+// The transcripts are stored next to the audio, their keys are set once the job completes.
+type Transcription struct {
+	JobId      string    `json:"job_id"`
+	UserId     int64     `json:"user_id"`
+	MetadataId int64     `json:"metadata_id"`
+	AudioKey   string    `json:"audio_key"`
+	Language   string    `json:"language,omitempty"`
+	Status     JobStatus `json:"status"`
+	SRTKey     string    `json:"srt_key,omitempty"`
+	VTTKey     string    `json:"vtt_key,omitempty"`
+	TextKey    string    `json:"text_key,omitempty"`
+}
+
+// ValidateLanguage checks the language a transcript is asked in, an ISO 639-1 code or empty to detect it.
+func ValidateLanguage(language string) error {
+	if language == "" {
+		return nil
+	}
+
+	if len(language) != 2 || language[0] < 'a' || language[0] > 'z' || language[1] < 'a' || language[1] > 'z' {
+		return fmt.Errorf("%w: language must be a two letter code", ErrInvalidOptions)
+	}
+	return nil
+}
+
+// ExtractSpeech decodes the audio into a 16 kHz mono wav in dir, the input transcription engines take.
+func (c *Converter) ExtractSpeech(ctx context.Context, dir, audio string) (string, error) {
+	wav := filepath.Join(dir, "speech.wav")
+	if _, err := c.run(ctx, dir, nil, "-i", audio, "-vn", "-ac", "1", "-ar", "16000", "-acodec", "pcm_s16le", "-f", "wav", wav); err != nil {
+		return "", fmt.Errorf("failed to extract speech: %w", err)
+	}
+	return wav, nil
+}
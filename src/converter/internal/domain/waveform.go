@@ -0,0 +1,137 @@
+package domain
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// waveformRate is the sample rate audio is decoded at to find its peaks, plenty to draw it.
+const waveformRate = 8000
+
+// WaveformConfig says how the waveform of converted audio is drawn.
+type WaveformConfig struct {
+	// PixelsPerSecond is the resolution of the peaks, each pixel keeps the lowest and highest sample under it.
+	// Zero draws no waveform.
+	PixelsPerSecond int
+	// Width and Height are the size of the png image in pixels, which isn't drawn when either is zero.
+	Width, Height int
+}
+
+// Waveform is where the drawing of an audio was written.
+type Waveform struct {
+	// Peaks is the json file of the peaks, in the format of audiowaveform.
+	Peaks string
+	// Image is the png file of the waveform, empty when none was asked for.
+	Image string
+}
+
+// peaks is the json format of audiowaveform, Data holds a min and a max per pixel.
+type peaks struct {
+	Version         int    `json:"version"`
+	Channels        int    `json:"channels"`
+	SampleRate      int    `json:"sample_rate"`
+	SamplesPerPixel int    `json:"samples_per_pixel"`
+	Bits            int    `json:"bits"`
+	Length          int    `json:"length"`
+	Data            []int8 `json:"data"`
+}
+
+// peaksWriter reads the 16 bit little-endian mono samples ffmpeg decodes to,
+// and keeps the lowest and highest of each pixel at 8 bits.
+type peaksWriter struct {
+	perPixel int
+	n        int
+	lo, hi   int8
+	data     []int8
+	odd      []byte
+}
+
+func (p *peaksWriter) Write(b []byte) (int, error) {
+	n := len(b)
+
+	// a sample may be cut between two writes
+	if len(p.odd) > 0 {
+		b = append(p.odd, b...)
+		p.odd = nil
+	}
+
+	for ; len(b) >= 2; b = b[2:] {
+		p.sample(int8(int16(binary.LittleEndian.Uint16(b)) >> 8))
+	}
+	if len(b) == 1 {
+		p.odd = []byte{b[0]}
+	}
+
+	return n, nil
+}
+
+func (p *peaksWriter) sample(s int8) {
+	if p.n == 0 || s < p.lo {
+		p.lo = s
+	}
+	if p.n == 0 || s > p.hi {
+		p.hi = s
+	}
+
+	if p.n++; p.n == p.perPixel {
+		p.flush()
+	}
+}
+
+// flush ends the current pixel, a last pixel may have fewer samples.
+func (p *peaksWriter) flush() {
+	if p.n > 0 {
+		p.data = append(p.data, p.lo, p.hi)
+		p.n = 0
+	}
+}
+
+func (p *peaksWriter) peaks() *peaks {
+	p.flush()
+	return &peaks{
+		Version: 2, Channels: 1, SampleRate: waveformRate, SamplesPerPixel: p.perPixel,
+		Bits: 8, Length: len(p.data) / 2, Data: p.data,
+	}
+}
+
+// DrawWaveform writes the peaks of the audio, and its image when the config asks for one, next to it in dir.
+func (c *Converter) DrawWaveform(ctx context.Context, dir, audio string, cfg WaveformConfig) (*Waveform, error) {
+	if cfg.PixelsPerSecond <= 0 {
+		return nil, fmt.Errorf("%w: waveform resolution must be positive", ErrInvalidOptions)
+	}
+
+	base := strings.TrimSuffix(audio, filepath.Ext(audio))
+	w := &Waveform{Peaks: base + ".peaks.json"}
+
+	// the samples are streamed to us rather than written out, an hour of them is tens of megabytes
+	pw := &peaksWriter{perPixel: max(waveformRate/cfg.PixelsPerSecond, 1)}
+	if _, err := c.run(ctx, dir, pw, "-i", audio, "-vn", "-ac", "1", "-ar", fmt.Sprint(waveformRate), "-acodec", "pcm_s16le", "-f", "s16le", "pipe:1"); err != nil {
+		return nil, fmt.Errorf("failed to read peaks: %w", err)
+	}
+
+	b, err := json.Marshal(pw.peaks())
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode peaks: %w", err)
+	}
+
+	if err = os.WriteFile(w.Peaks, b, 0600); err != nil {
+		return nil, fmt.Errorf("failed to write peaks: %w", err)
+	}
+
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return w, nil
+	}
+
+	w.Image = base + ".waveform.png"
+	filter := fmt.Sprintf("aformat=channel_layouts=mono,showwavespic=s=%dx%d", cfg.Width, cfg.Height)
+	if _, err = c.run(ctx, dir, nil, "-i", audio, "-lavfi", filter, "-frames:v", "1", w.Image); err != nil {
+		return nil, fmt.Errorf("failed to draw waveform: %w", err)
+	}
+
+	return w, nil
+}
@@ -0,0 +1,74 @@
+package domain
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPeaksWriter(t *testing.T) {
+	pw := &peaksWriter{perPixel: 2}
+
+	// 256 and -512, then 32767 and -32768 with the second cut between writes, then a lone 768
+	for _, b := range [][]byte{{0x00, 0x01, 0x00, 0xfe, 0xff}, {0x7f, 0x00, 0x80, 0x00}, {0x03}} {
+		if n, err := pw.Write(b); n != len(b) || err != nil {
+			t.Fatalf("Expected %d bytes written, got %d: %v", len(b), n, err)
+		}
+	}
+
+	p := pw.peaks()
+	if want := []int8{-2, 1, -128, 127, 3, 3}; !reflect.DeepEqual(p.Data, want) {
+		t.Errorf("Expected data %v, got %v", want, p.Data)
+	}
+
+	if p.Length != 3 || p.SamplesPerPixel != 2 || p.Bits != 8 || p.SampleRate != waveformRate {
+		t.Errorf("Unexpected header: %+v", p)
+	}
+}
+
+func TestDrawWaveform(t *testing.T) {
+	dir := t.TempDir()
+	c := NewConverter(fakeFFmpeg(t, `for a; do last=$a; done
+case "$*" in
+*showwavespic=s=300x40*) : > "$last" ;;
+*s16le*) printf '\000\001\000\376\377\177\000\200' ;;
+*) exit 1 ;;
+esac`), 0, Limits{})
+
+	audio := filepath.Join(dir, "output-001.mp3")
+	w, err := c.DrawWaveform(context.Background(), dir, audio, WaveformConfig{PixelsPerSecond: waveformRate / 2, Width: 300, Height: 40})
+	if err != nil {
+		t.Fatalf("DrawWaveform failed: %v", err)
+	}
+
+	if w.Peaks != filepath.Join(dir, "output-001.peaks.json") || w.Image != filepath.Join(dir, "output-001.waveform.png") {
+		t.Fatalf("Unexpected waveform files: %+v", w)
+	}
+
+	b, err := os.ReadFile(w.Peaks)
+	if err != nil {
+		t.Fatalf("Failed to read peaks: %v", err)
+	}
+
+	var p peaks
+	if err = json.Unmarshal(b, &p); err != nil {
+		t.Fatalf("Failed to decode peaks: %v", err)
+	}
+
+	if p.Length != 2 || !reflect.DeepEqual(p.Data, []int8{-2, 1, -128, 127}) {
+		t.Errorf("Unexpected peaks: %s", b)
+	}
+
+	if _, err = os.Stat(w.Image); err != nil {
+		t.Errorf("Expected the image to be drawn: %v", err)
+	}
+
+	// without a size, only the peaks are written
+	w, err = c.DrawWaveform(context.Background(), dir, audio, WaveformConfig{PixelsPerSecond: 20})
+	if err != nil || w.Image != "" {
+		t.Errorf("Expected no image, got %+v: %v", w, err)
+	}
+}
@@ -0,0 +1,103 @@
+package repository
+
+import (
+	"context"
+	"crypto/rsa"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/cloudfront/sign"
+	"github.com/aws/aws-sdk-go-v2/service/cloudfront"
+	"github.com/aws/aws-sdk-go-v2/service/cloudfront/types"
+)
+
+// maxInvalidationPaths keeps every invalidation batch well under CloudFront's limit of 3000 paths.
+const maxInvalidationPaths = 1000
+
+// CDN serves the objects of a bucket through a content delivery network.
+type CDN interface {
+	// Sign returns a URL for the object that stays valid until expires.
+	Sign(fileKey string, expires time.Time) (string, error)
+	// Invalidate evicts the objects from the edge caches, so deleted or replaced
+	// objects stop being served before their cache entries expire.
+	Invalidate(ctx context.Context, fileKeys ...string) error
+}
+
+type CloudFrontConfig struct {
+	// Domain is the distribution's domain name, e.g. d111111abcdef8.cloudfront.net
+	Domain         string
+	DistributionId string
+	// KeyPairId is the id of the public key in the distribution's trusted key group
+	KeyPairId  string
+	PrivateKey *rsa.PrivateKey
+}
+
+type cloudFront struct {
+	cfc    *cloudfront.Client
+	cfg    CloudFrontConfig
+	signer *sign.URLSigner
+}
+
+// NewCloudFront creates a CDN backed by a CloudFront distribution in front of the bucket.
+func NewCloudFront(cfc *cloudfront.Client, cfg CloudFrontConfig) CDN {
+	return &cloudFront{
+		cfc:    cfc,
+		cfg:    cfg,
+		signer: sign.NewURLSigner(cfg.KeyPairId, cfg.PrivateKey),
+	}
+}
+
+// LoadCloudFrontKey reads the PEM encoded RSA private key of a CloudFront key pair.
+func LoadCloudFrontKey(path string) (*rsa.PrivateKey, error) {
+	key, err := sign.LoadPEMPrivKeyFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load cloudfront private key %s: %w", path, err)
+	}
+
+	return key, nil
+}
+
+func (c *cloudFront) url(fileKey string) string {
+	u := url.URL{Scheme: "https", Host: c.cfg.Domain, Path: "/" + fileKey}
+	return u.String()
+}
+
+// Sign creates a URL with a canned policy, which only grants access to this object.
+func (c *cloudFront) Sign(fileKey string, expires time.Time) (string, error) {
+	signed, err := c.signer.Sign(c.url(fileKey), expires)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign url for %s: %w", fileKey, err)
+	}
+
+	return signed, nil
+}
+
+func (c *cloudFront) Invalidate(ctx context.Context, fileKeys ...string) error {
+	for start := 0; start < len(fileKeys); start += maxInvalidationPaths {
+		end := min(start+maxInvalidationPaths, len(fileKeys))
+
+		paths := make([]string, 0, end-start)
+		for _, key := range fileKeys[start:end] {
+			paths = append(paths, (&url.URL{Path: "/" + key}).EscapedPath())
+		}
+
+		if _, err := c.cfc.CreateInvalidation(ctx, &cloudfront.CreateInvalidationInput{
+			DistributionId: aws.String(c.cfg.DistributionId),
+			InvalidationBatch: &types.InvalidationBatch{
+				// the reference only has to be unique per batch, retrying the exact same batch is harmless
+				CallerReference: aws.String(strconv.FormatInt(time.Now().UnixNano(), 10) + "-" + strconv.Itoa(start)),
+				Paths: &types.Paths{
+					Quantity: aws.Int32(int32(len(paths))),
+					Items:    paths,
+				},
+			},
+		}); err != nil {
+			return fmt.Errorf("failed to invalidate %d paths in distribution %s: %w", len(paths), c.cfg.DistributionId, err)
+		}
+	}
+
+	return nil
+}
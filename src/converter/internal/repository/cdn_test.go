@@ -0,0 +1,130 @@
+package repository
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/cloudfront"
+)
+
+func TestCloudFrontSign(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	// the key is loaded the way the converter loads it in production
+	path := filepath.Join(t.TempDir(), "cf.pem")
+	block := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	if err = os.WriteFile(path, block, 0o600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+
+	loaded, err := LoadCloudFrontKey(path)
+	if err != nil {
+		t.Fatalf("Failed to load key: %v", err)
+	}
+
+	cdn := NewCloudFront(nil, CloudFrontConfig{Domain: "cdn.test", KeyPairId: "K123", PrivateKey: loaded})
+
+	expires := time.Unix(1700000000, 0)
+	signed, err := cdn.Sign("a.mp3", expires)
+	if err != nil {
+		t.Fatalf("Failed to sign: %v", err)
+	}
+
+	u, err := url.Parse(signed)
+	if err != nil {
+		t.Fatalf("Failed to parse signed url: %v", err)
+	}
+
+	q := u.Query()
+	if u.Host != "cdn.test" || u.Path != "/a.mp3" || q.Get("Expires") != "1700000000" || q.Get("Key-Pair-Id") != "K123" {
+		t.Errorf("Unexpected signed url: %s", signed)
+	}
+
+	// CloudFront verifies the signature over the canned policy with the public key
+	policy := `{"Statement":[{"Resource":"https://cdn.test/a.mp3","Condition":{"DateLessThan":{"AWS:EpochTime":1700000000}}}]}`
+	signature := strings.NewReplacer("-", "+", "_", "=", "~", "/").Replace(q.Get("Signature"))
+
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		t.Fatalf("Failed to decode signature: %v", err)
+	}
+
+	hash := sha1.Sum([]byte(policy))
+	if err = rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA1, hash[:], sig); err != nil {
+		t.Errorf("Expected the signature to verify, got %v", err)
+	}
+}
+
+func TestCloudFrontInvalidate(t *testing.T) {
+	var batches []int
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch struct {
+			Paths struct {
+				Quantity int      `xml:"Quantity"`
+				Items    []string `xml:"Items>Path"`
+			} `xml:"Paths"`
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		if err := xml.Unmarshal(body, &batch); err != nil || !strings.HasSuffix(r.URL.Path, "/distribution/D123/invalidation") {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		if len(batch.Paths.Items) == 0 || batch.Paths.Quantity != len(batch.Paths.Items) || !strings.HasPrefix(batch.Paths.Items[0], "/k") {
+			http.Error(w, "bad batch", http.StatusBadRequest)
+			return
+		}
+
+		batches = append(batches, batch.Paths.Quantity)
+
+		w.Header().Set("Location", "https://cloudfront.test/invalidation/I1")
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, `<Invalidation><Id>I1</Id><Status>InProgress</Status></Invalidation>`)
+	}))
+	t.Cleanup(srv.Close)
+
+	cfc := cloudfront.New(cloudfront.Options{
+		Region:       "us-east-1",
+		Credentials:  credentials.NewStaticCredentialsProvider("key", "secret", ""),
+		BaseEndpoint: aws.String(srv.URL),
+	})
+
+	cdn := NewCloudFront(cfc, CloudFrontConfig{Domain: "cdn.test", DistributionId: "D123"})
+
+	keys := make([]string, 1500)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("k%d.mp3", i)
+	}
+
+	if err := cdn.Invalidate(context.Background(), keys...); err != nil {
+		t.Fatalf("Failed to invalidate: %v", err)
+	}
+
+	if len(batches) != 2 || batches[0] != 1000 || batches[1] != 500 {
+		t.Errorf("Expected batches of 1000 and 500 paths, got %v", batches)
+	}
+}
@@ -0,0 +1,169 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/ziliscite/video-to-mp3/converter/internal/domain"
+)
+
+var (
+	ErrRecordNotFound = errors.New("not found")
+)
+
+type JobRepository interface {
+	// Claim records the job unless its id was seen before, and returns the stored job either way.
+	Claim(ctx context.Context, job *domain.Job) (*domain.Job, error)
+	// SetAudio records the key of the audio uploaded for the job, and its parts when it was split.
+	SetAudio(ctx context.Context, jobId, audioKey string, parts []domain.Part) error
+	// Complete saves the metadata and links it to the job in a single transaction.
+	// Returns ErrDuplicateEntry if the job already has metadata.
+	Complete(ctx context.Context, jobId string, metadata *domain.Metadata) error
+	// Fail marks the job as dropped and records why. A completed job is left alone.
+	Fail(ctx context.Context, jobId string, failure *domain.JobFailure) error
+}
+
+func NewJobRepo(db *pgxpool.Pool) JobRepository {
+	return &jobRepo{db: db}
+}
+
+type jobRepo struct {
+	db *pgxpool.Pool
+}
+
+func (j jobRepo) Claim(ctx context.Context, job *domain.Job) (*domain.Job, error) {
+	query := `
+        INSERT INTO jobs(job_id, user_id, video_key, source_audio_key, status)
+        VALUES ($1, $2, $3, NULLIF($4, ''), $5)
+        ON CONFLICT (job_id) DO NOTHING
+	`
+
+	args := []any{job.Id, job.UserId, job.VideoKey, job.SourceAudioKey, domain.JobProcessing}
+
+	if _, err := j.db.Exec(ctx, query, args...); err != nil {
+		return nil, fmt.Errorf("something's wrong: %w", err)
+	}
+
+	return j.get(ctx, job.Id)
+}
+
+func (j jobRepo) get(ctx context.Context, jobId string) (*domain.Job, error) {
+	query := `
+        SELECT job_id, user_id, video_key, COALESCE(source_audio_key, ''), COALESCE(audio_key, ''), parts, COALESCE(metadata_id, 0), status
+        FROM jobs
+        WHERE job_id = $1
+	`
+
+	var (
+		job   domain.Job
+		parts []byte
+	)
+	if err := j.db.QueryRow(ctx, query, jobId).Scan(
+		&job.Id, &job.UserId, &job.VideoKey, &job.SourceAudioKey,
+		&job.AudioKey, &parts, &job.MetadataId, &job.Status,
+	); err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, fmt.Errorf("something's wrong: %w", err)
+		}
+	}
+
+	var err error
+	if job.Parts, err = decodeParts(parts); err != nil {
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+func (j jobRepo) SetAudio(ctx context.Context, jobId, audioKey string, parts []domain.Part) error {
+	encoded, err := encodeParts(parts)
+	if err != nil {
+		return err
+	}
+
+	query := `
+        UPDATE jobs
+        SET audio_key = $2, parts = $3, status = $4, updated_at = NOW()
+        WHERE job_id = $1
+	`
+
+	tag, err := j.db.Exec(ctx, query, jobId, audioKey, encoded, domain.JobConverted)
+	if err != nil {
+		return fmt.Errorf("something's wrong: %w", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (j jobRepo) Complete(ctx context.Context, jobId string, metadata *domain.Metadata) error {
+	loudness, err := encodeLoudness(metadata.Loudness)
+	if err != nil {
+		return err
+	}
+
+	return j.db.BeginFunc(ctx, func(tx pgx.Tx) error {
+		query := `
+            INSERT INTO metadata(user_id, file_name, encrypted_file_name, video_key, audio_key, loudness, peaks_key, waveform_key, parent_id)
+            VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, 0))
+            RETURNING id
+		`
+
+		args := []any{metadata.UserId, metadata.FileName, metadata.EncryptedFileName, metadata.VideoKey, metadata.AudioKey, loudness, metadata.PeaksKey, metadata.WaveformKey, metadata.ParentId}
+
+		if err := tx.QueryRow(ctx, query, args...).Scan(&metadata.Id); err != nil {
+			return fmt.Errorf("something's wrong: %w", err)
+		}
+
+		if err := insertParts(ctx, tx, metadata.Id, metadata.Parts); err != nil {
+			return err
+		}
+
+		// only the first completion may link its metadata, a second one rolls back its insert
+		query = `
+            UPDATE jobs
+            SET metadata_id = $2, audio_key = $3, status = $4, updated_at = NOW()
+            WHERE job_id = $1 AND metadata_id IS NULL
+		`
+
+		tag, err := tx.Exec(ctx, query, jobId, metadata.Id, metadata.AudioKey, domain.JobCompleted)
+		if err != nil {
+			return fmt.Errorf("something's wrong: %w", err)
+		}
+
+		if tag.RowsAffected() == 0 {
+			return ErrDuplicateEntry
+		}
+
+		return nil
+	})
+}
+
+func (j jobRepo) Fail(ctx context.Context, jobId string, failure *domain.JobFailure) error {
+	query := `
+        UPDATE jobs
+        SET status = $2, failure_reason = $3, failure_error = $4, failure_stderr = $5, updated_at = NOW()
+        WHERE job_id = $1 AND status <> $6
+	`
+
+	args := []any{jobId, domain.JobFailed, failure.Reason, failure.Error, failure.Stderr, domain.JobCompleted}
+
+	tag, err := j.db.Exec(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("something's wrong: %w", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
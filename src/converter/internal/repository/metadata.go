@@ -2,9 +2,11 @@ package repository
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/ziliscite/video-to-mp3/converter/internal/domain"
 )
@@ -15,10 +17,30 @@ var (
 
 type MetadataWriter interface {
 	Insert(ctx context.Context, metadata *domain.Metadata) error
+	// SetThumbnail records the keys of the pictures drawn from the video of the metadata.
+	SetThumbnail(ctx context.Context, id int64, thumbnailKey, previewKey string) error
+}
+
+type MetadataReader interface {
+	Get(ctx context.Context, id int64) (*domain.Metadata, error)
+	// GetByAudio returns the metadata of the user's audio still in storage, a part of a split conversion included.
+	// Returns ErrRecordNotFound if the user has no such audio.
+	GetByAudio(ctx context.Context, userId int64, audioKey string) (*domain.Metadata, error)
+	// ListByUser returns at most limit of the user's conversions still in storage, newest first.
+	// Only the ones older than the conversion of the before id are listed when it isn't zero.
+	ListByUser(ctx context.Context, userId, before int64, limit int) ([]*domain.Metadata, error)
+}
+
+type KeyReader interface {
+	// ReferencedKeys returns the video and audio keys referenced by metadata or by any job,
+	// including jobs still in flight.
+	ReferencedKeys(ctx context.Context) (videos, audios map[string]bool, err error)
 }
 
 type MetadataRepository interface {
 	MetadataWriter
+	MetadataReader
+	KeyReader
 }
 
 func NewMetadataRepo(db *pgxpool.Pool) MetadataRepository {
@@ -31,21 +53,229 @@ type metadataRepo struct {
 
 func (u metadataRepo) Insert(ctx context.Context, metadata *domain.Metadata) error {
 	query := `
-        INSERT INTO metadata(user_id, file_name, video_key, audio_key) 
-        VALUES ($1, $2, $3, $4)
+        INSERT INTO metadata(user_id, file_name, encrypted_file_name, video_key, audio_key, loudness, peaks_key, waveform_key, parent_id)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, 0))
+        RETURNING id
 	`
 
-	args := []any{metadata.UserId, metadata.FileName, metadata.VideoKey, metadata.AudioKey}
+	loudness, err := encodeLoudness(metadata.Loudness)
+	if err != nil {
+		return err
+	}
+
+	args := []any{metadata.UserId, metadata.FileName, metadata.EncryptedFileName, metadata.VideoKey, metadata.AudioKey, loudness, metadata.PeaksKey, metadata.WaveformKey, metadata.ParentId}
 
-	if _, err := u.db.Exec(ctx, query, args...); err != nil {
-		var pgErr *pgconn.PgError
+	return u.db.BeginFunc(ctx, func(tx pgx.Tx) error {
+		if err := tx.QueryRow(ctx, query, args...).Scan(&metadata.Id); err != nil {
+			var pgErr *pgconn.PgError
+			switch {
+			case errors.As(err, &pgErr) && pgErr.Code == "23505":
+				return ErrDuplicateEntry // won't be any duplicate entries since we didn't put unique constraints
+			default:
+				return fmt.Errorf("something's wrong: %w", err)
+			}
+		}
+
+		return insertParts(ctx, tx, metadata.Id, metadata.Parts)
+	})
+}
+
+func (u metadataRepo) Get(ctx context.Context, id int64) (*domain.Metadata, error) {
+	query := `
+        SELECT id, user_id, file_name, encrypted_file_name, video_key, audio_key, loudness, peaks_key, waveform_key, COALESCE(parent_id, 0),
+               thumbnail_key, preview_key, stream_key
+        FROM metadata
+        WHERE id = $1
+	`
+
+	return u.get(ctx, query, id)
+}
+
+func (u metadataRepo) GetByAudio(ctx context.Context, userId int64, audioKey string) (*domain.Metadata, error) {
+	query := `
+        SELECT id, user_id, file_name, encrypted_file_name, video_key, audio_key, loudness, peaks_key, waveform_key, COALESCE(parent_id, 0),
+               thumbnail_key, preview_key, stream_key
+        FROM metadata
+        WHERE user_id = $1 AND audio_deleted_at IS NULL
+          AND (audio_key = $2 OR id IN (SELECT metadata_id FROM audio_parts WHERE audio_key = $2))
+        ORDER BY id
+        LIMIT 1
+	`
+
+	return u.get(ctx, query, userId, audioKey)
+}
+
+func (u metadataRepo) ListByUser(ctx context.Context, userId, before int64, limit int) ([]*domain.Metadata, error) {
+	query := `
+        SELECT id, user_id, file_name, encrypted_file_name, video_key, audio_key, loudness, peaks_key, waveform_key, COALESCE(parent_id, 0),
+               thumbnail_key, preview_key, stream_key
+        FROM metadata
+        WHERE user_id = $1 AND audio_deleted_at IS NULL AND ($2::bigint = 0 OR id < $2)
+        ORDER BY id DESC
+        LIMIT $3
+	`
+
+	rows, err := u.db.Query(ctx, query, userId, before, limit)
+	if err != nil {
+		return nil, fmt.Errorf("something's wrong: %w", err)
+	}
+	defer rows.Close()
+
+	var list []*domain.Metadata
+	for rows.Next() {
+		metadata, err := scanMetadata(rows)
+		if err != nil {
+			return nil, fmt.Errorf("something's wrong: %w", err)
+		}
+		list = append(list, metadata)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("something's wrong: %w", err)
+	}
+	rows.Close()
+
+	for _, metadata := range list {
+		if err = u.details(ctx, metadata); err != nil {
+			return nil, err
+		}
+	}
+
+	return list, nil
+}
+
+// get reads the metadata selected by the query, with its parts and transcripts.
+func (u metadataRepo) get(ctx context.Context, query string, args ...any) (*domain.Metadata, error) {
+	metadata, err := scanMetadata(u.db.QueryRow(ctx, query, args...))
+	if err != nil {
 		switch {
-		case errors.As(err, &pgErr) && pgErr.Code == "23505":
-			return ErrDuplicateEntry // won't be any duplicate entries since we didn't put unique constraints
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, ErrRecordNotFound
 		default:
-			return fmt.Errorf("something's wrong: %w", err)
+			return nil, fmt.Errorf("something's wrong: %w", err)
 		}
 	}
 
+	if err = u.details(ctx, metadata); err != nil {
+		return nil, err
+	}
+
+	return metadata, nil
+}
+
+// details reads the parts and transcripts of the metadata.
+func (u metadataRepo) details(ctx context.Context, metadata *domain.Metadata) (err error) {
+	if metadata.Parts, err = getParts(ctx, u.db, metadata.Id); err != nil {
+		return err
+	}
+
+	metadata.Transcripts, err = getTranscripts(ctx, u.db, metadata.Id)
+	return err
+}
+
+// scanMetadata reads a row of the columns the metadata is selected with.
+func scanMetadata(row pgx.Row) (*domain.Metadata, error) {
+	var (
+		metadata domain.Metadata
+		loudness []byte
+	)
+	if err := row.Scan(
+		&metadata.Id, &metadata.UserId, &metadata.FileName,
+		&metadata.EncryptedFileName, &metadata.VideoKey, &metadata.AudioKey, &loudness,
+		&metadata.PeaksKey, &metadata.WaveformKey, &metadata.ParentId,
+		&metadata.ThumbnailKey, &metadata.PreviewKey, &metadata.StreamKey,
+	); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if metadata.Loudness, err = decodeLoudness(loudness); err != nil {
+		return nil, err
+	}
+
+	return &metadata, nil
+}
+
+func (u metadataRepo) SetThumbnail(ctx context.Context, id int64, thumbnailKey, previewKey string) error {
+	query := `
+        UPDATE metadata
+        SET thumbnail_key = $2, preview_key = $3
+        WHERE id = $1
+	`
+
+	tag, err := u.db.Exec(ctx, query, id, thumbnailKey, previewKey)
+	if err != nil {
+		return fmt.Errorf("something's wrong: %w", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrRecordNotFound
+	}
+
 	return nil
 }
+
+// encodeLoudness encodes the measurement for its JSONB column, nil stores a NULL.
+func encodeLoudness(loudness *domain.Loudness) ([]byte, error) {
+	if loudness == nil {
+		return nil, nil
+	}
+
+	b, err := json.Marshal(loudness)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode loudness: %w", err)
+	}
+	return b, nil
+}
+
+// decodeLoudness decodes the measurement read from its JSONB column, NULL being no measurement.
+func decodeLoudness(b []byte) (*domain.Loudness, error) {
+	if b == nil {
+		return nil, nil
+	}
+
+	var loudness domain.Loudness
+	if err := json.Unmarshal(b, &loudness); err != nil {
+		return nil, fmt.Errorf("failed to decode loudness: %w", err)
+	}
+	return &loudness, nil
+}
+
+func (u metadataRepo) ReferencedKeys(ctx context.Context) (map[string]bool, map[string]bool, error) {
+	query := `
+        SELECT video_key, audio_key FROM metadata
+        UNION
+        SELECT video_key, COALESCE(audio_key, '') FROM jobs
+        UNION
+        SELECT '', audio_key FROM audio_parts
+        UNION
+        SELECT '', part->>'audio_key' FROM jobs, jsonb_array_elements(parts) AS part
+	`
+
+	rows, err := u.db.Query(ctx, query)
+	if err != nil {
+		return nil, nil, fmt.Errorf("something's wrong: %w", err)
+	}
+	defer rows.Close()
+
+	videos, audios := make(map[string]bool), make(map[string]bool)
+	for rows.Next() {
+		var video, audio string
+		if err = rows.Scan(&video, &audio); err != nil {
+			return nil, nil, fmt.Errorf("something's wrong: %w", err)
+		}
+
+		if video != "" {
+			videos[video] = true
+		}
+		if audio != "" {
+			audios[audio] = true
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("something's wrong: %w", err)
+	}
+
+	return videos, audios, nil
+}
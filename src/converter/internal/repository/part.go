@@ -0,0 +1,100 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/ziliscite/video-to-mp3/converter/internal/domain"
+)
+
+// insertParts saves the parts of the metadata, in order.
+func insertParts(ctx context.Context, tx pgx.Tx, metadataId int64, parts []domain.Part) error {
+	query := `
+        INSERT INTO audio_parts(metadata_id, position, title, start_ms, end_ms, audio_key, loudness, peaks_key, waveform_key)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`
+
+	for i, p := range parts {
+		loudness, err := encodeLoudness(p.Loudness)
+		if err != nil {
+			return err
+		}
+
+		args := []any{metadataId, i, p.Title, p.Start.Milliseconds(), p.End.Milliseconds(), p.AudioKey, loudness, p.PeaksKey, p.WaveformKey}
+		if _, err = tx.Exec(ctx, query, args...); err != nil {
+			return fmt.Errorf("something's wrong: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// getParts returns the parts of the metadata in order, none when it wasn't split.
+func getParts(ctx context.Context, db *pgxpool.Pool, metadataId int64) ([]domain.Part, error) {
+	query := `
+        SELECT title, start_ms, end_ms, audio_key, loudness, peaks_key, waveform_key
+        FROM audio_parts
+        WHERE metadata_id = $1
+        ORDER BY position
+	`
+
+	rows, err := db.Query(ctx, query, metadataId)
+	if err != nil {
+		return nil, fmt.Errorf("something's wrong: %w", err)
+	}
+	defer rows.Close()
+
+	var parts []domain.Part
+	for rows.Next() {
+		var (
+			p          domain.Part
+			start, end int64
+			loudness   []byte
+		)
+		if err = rows.Scan(&p.Title, &start, &end, &p.AudioKey, &loudness, &p.PeaksKey, &p.WaveformKey); err != nil {
+			return nil, fmt.Errorf("something's wrong: %w", err)
+		}
+
+		p.Start, p.End = time.Duration(start)*time.Millisecond, time.Duration(end)*time.Millisecond
+		if p.Loudness, err = decodeLoudness(loudness); err != nil {
+			return nil, err
+		}
+		parts = append(parts, p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("something's wrong: %w", err)
+	}
+
+	return parts, nil
+}
+
+// encodeParts encodes the parts of a job for its JSONB column, none stores a NULL.
+func encodeParts(parts []domain.Part) ([]byte, error) {
+	if len(parts) == 0 {
+		return nil, nil
+	}
+
+	b, err := json.Marshal(parts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode parts: %w", err)
+	}
+	return b, nil
+}
+
+// decodeParts decodes the parts of a job read from its JSONB column, NULL being none.
+func decodeParts(b []byte) ([]domain.Part, error) {
+	if b == nil {
+		return nil, nil
+	}
+
+	var parts []domain.Part
+	if err := json.Unmarshal(b, &parts); err != nil {
+		return nil, fmt.Errorf("failed to decode parts: %w", err)
+	}
+	return parts, nil
+}
@@ -0,0 +1,225 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/ziliscite/video-to-mp3/converter/internal/domain"
+)
+
+type PresetRepository interface {
+	// Create saves the preset of its user, setting its id and timestamps. A default preset
+	// takes over from the user's earlier default. Returns ErrDuplicateEntry if the user has a preset of the name.
+	Create(ctx context.Context, preset *domain.Preset) error
+	// Get returns the preset of the user, or ErrRecordNotFound if the user has no such preset.
+	Get(ctx context.Context, userId, id int64) (*domain.Preset, error)
+	// GetDefault returns the default preset of the user, or ErrRecordNotFound if they have none.
+	GetDefault(ctx context.Context, userId int64) (*domain.Preset, error)
+	// List returns the presets of the user by name.
+	List(ctx context.Context, userId int64) ([]*domain.Preset, error)
+	// Update saves the preset over the one of its user with its id, setting its timestamps. A default preset
+	// takes over from the user's earlier default. Returns ErrRecordNotFound if the user has no such preset,
+	// and ErrDuplicateEntry if the user has another preset of the name.
+	Update(ctx context.Context, preset *domain.Preset) error
+	// Delete removes the preset of the user, or returns ErrRecordNotFound if the user has no such preset.
+	Delete(ctx context.Context, userId, id int64) error
+}
+
+func NewPresetRepo(db *pgxpool.Pool) PresetRepository {
+	return &presetRepo{db: db}
+}
+
+type presetRepo struct {
+	db *pgxpool.Pool
+}
+
+const presetColumns = `id, user_id, name, format, bitrate, loudness, trim_silence, tags, is_default, created_at, updated_at`
+
+func (r presetRepo) Create(ctx context.Context, preset *domain.Preset) error {
+	query := `
+        INSERT INTO presets(user_id, name, format, bitrate, loudness, trim_silence, tags, is_default)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+        RETURNING id, created_at, updated_at
+	`
+
+	tags, err := encodeTags(preset.Tags)
+	if err != nil {
+		return err
+	}
+
+	args := []any{preset.UserId, preset.Name, preset.Format, preset.Bitrate, preset.Loudness, preset.TrimSilence, tags, preset.Default}
+
+	return r.db.BeginFunc(ctx, func(tx pgx.Tx) error {
+		if err := r.clearDefault(ctx, tx, preset); err != nil {
+			return err
+		}
+
+		if err := tx.QueryRow(ctx, query, args...).Scan(&preset.Id, &preset.CreatedAt, &preset.UpdatedAt); err != nil {
+			return presetError(err)
+		}
+		return nil
+	})
+}
+
+func (r presetRepo) Get(ctx context.Context, userId, id int64) (*domain.Preset, error) {
+	query := `
+        SELECT ` + presetColumns + `
+        FROM presets
+        WHERE id = $1 AND user_id = $2
+	`
+
+	return scanPreset(r.db.QueryRow(ctx, query, id, userId))
+}
+
+func (r presetRepo) GetDefault(ctx context.Context, userId int64) (*domain.Preset, error) {
+	query := `
+        SELECT ` + presetColumns + `
+        FROM presets
+        WHERE user_id = $1 AND is_default
+	`
+
+	return scanPreset(r.db.QueryRow(ctx, query, userId))
+}
+
+func (r presetRepo) List(ctx context.Context, userId int64) ([]*domain.Preset, error) {
+	query := `
+        SELECT ` + presetColumns + `
+        FROM presets
+        WHERE user_id = $1
+        ORDER BY name
+	`
+
+	rows, err := r.db.Query(ctx, query, userId)
+	if err != nil {
+		return nil, fmt.Errorf("something's wrong: %w", err)
+	}
+	defer rows.Close()
+
+	presets := []*domain.Preset{}
+	for rows.Next() {
+		p, err := scanPreset(rows)
+		if err != nil {
+			return nil, err
+		}
+		presets = append(presets, p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("something's wrong: %w", err)
+	}
+
+	return presets, nil
+}
+
+func (r presetRepo) Update(ctx context.Context, preset *domain.Preset) error {
+	query := `
+        UPDATE presets
+        SET name = $3, format = $4, bitrate = $5, loudness = $6, trim_silence = $7, tags = $8, is_default = $9, updated_at = NOW()
+        WHERE id = $1 AND user_id = $2
+        RETURNING created_at, updated_at
+	`
+
+	tags, err := encodeTags(preset.Tags)
+	if err != nil {
+		return err
+	}
+
+	args := []any{preset.Id, preset.UserId, preset.Name, preset.Format, preset.Bitrate, preset.Loudness, preset.TrimSilence, tags, preset.Default}
+
+	return r.db.BeginFunc(ctx, func(tx pgx.Tx) error {
+		if err := r.clearDefault(ctx, tx, preset); err != nil {
+			return err
+		}
+
+		if err := tx.QueryRow(ctx, query, args...).Scan(&preset.CreatedAt, &preset.UpdatedAt); err != nil {
+			return presetError(err)
+		}
+		return nil
+	})
+}
+
+func (r presetRepo) Delete(ctx context.Context, userId, id int64) error {
+	query := `
+        DELETE FROM presets
+        WHERE id = $1 AND user_id = $2
+	`
+
+	tag, err := r.db.Exec(ctx, query, id, userId)
+	if err != nil {
+		return fmt.Errorf("something's wrong: %w", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// clearDefault lets a default preset take over from the earlier default of its user.
+func (r presetRepo) clearDefault(ctx context.Context, tx pgx.Tx, preset *domain.Preset) error {
+	if !preset.Default {
+		return nil
+	}
+
+	query := `
+        UPDATE presets
+        SET is_default = FALSE, updated_at = NOW()
+        WHERE user_id = $1 AND is_default AND id <> $2
+	`
+
+	if _, err := tx.Exec(ctx, query, preset.UserId, preset.Id); err != nil {
+		return fmt.Errorf("something's wrong: %w", err)
+	}
+	return nil
+}
+
+func scanPreset(row pgx.Row) (*domain.Preset, error) {
+	var (
+		p    domain.Preset
+		tags []byte
+	)
+
+	if err := row.Scan(
+		&p.Id, &p.UserId, &p.Name, &p.Format, &p.Bitrate, &p.Loudness, &p.TrimSilence, &tags, &p.Default, &p.CreatedAt, &p.UpdatedAt,
+	); err != nil {
+		return nil, presetError(err)
+	}
+
+	if err := json.Unmarshal(tags, &p.Tags); err != nil {
+		return nil, fmt.Errorf("failed to decode tags: %w", err)
+	}
+
+	return &p, nil
+}
+
+// presetError maps the errors of a preset query to ErrRecordNotFound and ErrDuplicateEntry.
+func presetError(err error) error {
+	var pgErr *pgconn.PgError
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		return ErrRecordNotFound
+	case errors.As(err, &pgErr) && pgErr.Code == "23505":
+		return ErrDuplicateEntry
+	default:
+		return fmt.Errorf("something's wrong: %w", err)
+	}
+}
+
+// encodeTags encodes the tags of a preset for their JSONB column, none being an empty object.
+func encodeTags(tags map[string]string) ([]byte, error) {
+	if len(tags) == 0 {
+		return []byte("{}"), nil
+	}
+
+	b, err := json.Marshal(tags)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode tags: %w", err)
+	}
+	return b, nil
+}
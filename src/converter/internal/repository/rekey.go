@@ -0,0 +1,155 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/ziliscite/video-to-mp3/converter/internal/domain"
+)
+
+type KeyRepository interface {
+	// Keys returns up to limit metadata ordered by id, starting after the given id, with the keys of the objects
+	// drawn from their audio and video and with their transcripts.
+	Keys(ctx context.Context, afterId int64, limit int) ([]domain.Metadata, error)
+	// RenameKeys replaces the encrypted filename and keys of the metadata, the keys of the job that produced it
+	// and those of its transcripts and stream, in a single transaction. The renamed transcripts are in the order of the old ones.
+	// Returns ErrRecordNotFound if the metadata changed since it was read.
+	RenameKeys(ctx context.Context, old, renamed *domain.Metadata) error
+}
+
+func NewKeyRepo(db *pgxpool.Pool) KeyRepository {
+	return &keyRepo{db: db}
+}
+
+type keyRepo struct {
+	db *pgxpool.Pool
+}
+
+func (k keyRepo) Keys(ctx context.Context, afterId int64, limit int) ([]domain.Metadata, error) {
+	query := `
+        SELECT id, user_id, file_name, encrypted_file_name, video_key, audio_key,
+               peaks_key, waveform_key, thumbnail_key, preview_key, stream_key
+        FROM metadata
+        WHERE id > $1
+        ORDER BY id
+        LIMIT $2
+	`
+
+	rows, err := k.db.Query(ctx, query, afterId, limit)
+	if err != nil {
+		return nil, fmt.Errorf("something's wrong: %w", err)
+	}
+	defer rows.Close()
+
+	var list []domain.Metadata
+	for rows.Next() {
+		var m domain.Metadata
+		if err = rows.Scan(
+			&m.Id, &m.UserId, &m.FileName, &m.EncryptedFileName, &m.VideoKey, &m.AudioKey,
+			&m.PeaksKey, &m.WaveformKey, &m.ThumbnailKey, &m.PreviewKey, &m.StreamKey,
+		); err != nil {
+			return nil, fmt.Errorf("something's wrong: %w", err)
+		}
+		list = append(list, m)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("something's wrong: %w", err)
+	}
+	rows.Close()
+
+	for i := range list {
+		if list[i].Transcripts, err = getTranscripts(ctx, k.db, list[i].Id); err != nil {
+			return nil, err
+		}
+	}
+
+	return list, nil
+}
+
+func (k keyRepo) RenameKeys(ctx context.Context, old, renamed *domain.Metadata) error {
+	return k.db.BeginFunc(ctx, func(tx pgx.Tx) error {
+		query := `
+            UPDATE metadata
+            SET encrypted_file_name = $10, video_key = $11, audio_key = $12,
+                peaks_key = $13, waveform_key = $14, thumbnail_key = $15, preview_key = $16, stream_key = $17, updated_at = NOW()
+            WHERE id = $1 AND encrypted_file_name = $2 AND video_key = $3 AND audio_key = $4
+              AND peaks_key = $5 AND waveform_key = $6 AND thumbnail_key = $7 AND preview_key = $8 AND stream_key = $9
+		`
+
+		args := []any{
+			old.Id, old.EncryptedFileName, old.VideoKey, old.AudioKey,
+			old.PeaksKey, old.WaveformKey, old.ThumbnailKey, old.PreviewKey, old.StreamKey,
+			renamed.EncryptedFileName, renamed.VideoKey, renamed.AudioKey,
+			renamed.PeaksKey, renamed.WaveformKey, renamed.ThumbnailKey, renamed.PreviewKey, renamed.StreamKey,
+		}
+
+		tag, err := tx.Exec(ctx, query, args...)
+		if err != nil {
+			return fmt.Errorf("something's wrong: %w", err)
+		}
+
+		if tag.RowsAffected() == 0 {
+			return ErrRecordNotFound
+		}
+
+		// the reconciler treats job keys as references, stale ones would keep the old objects alive
+		query = `
+            UPDATE jobs
+            SET video_key = $2, audio_key = $3, updated_at = NOW()
+            WHERE metadata_id = $1
+		`
+
+		if _, err = tx.Exec(ctx, query, old.Id, renamed.VideoKey, renamed.AudioKey); err != nil {
+			return fmt.Errorf("something's wrong: %w", err)
+		}
+
+		// the transcodings name the stream they made, like the metadata
+		query = `
+            UPDATE streams
+            SET playlist_key = $3, updated_at = NOW()
+            WHERE metadata_id = $1 AND playlist_key = $2 AND playlist_key <> ''
+		`
+
+		if _, err = tx.Exec(ctx, query, old.Id, old.StreamKey, renamed.StreamKey); err != nil {
+			return fmt.Errorf("something's wrong: %w", err)
+		}
+
+		return renameTranscripts(ctx, tx, old, renamed)
+	})
+}
+
+// renameTranscripts moves the transcripts of the metadata to the keys of their renamed audio. Every transcription
+// of an audio names the same objects, so the rows of earlier and failed ones follow the completed one.
+func renameTranscripts(ctx context.Context, tx pgx.Tx, old, renamed *domain.Metadata) error {
+	query := `
+        UPDATE transcripts
+        SET audio_key = $3,
+            srt_key = CASE WHEN srt_key = '' THEN '' ELSE $4 END,
+            vtt_key = CASE WHEN vtt_key = '' THEN '' ELSE $5 END,
+            text_key = CASE WHEN text_key = '' THEN '' ELSE $6 END,
+            updated_at = NOW()
+        WHERE metadata_id = $1 AND audio_key = $2
+	`
+
+	for i, t := range renamed.Transcripts {
+		args := []any{old.Id, old.Transcripts[i].AudioKey, t.AudioKey, t.SRTKey, t.VTTKey, t.TextKey}
+		if _, err := tx.Exec(ctx, query, args...); err != nil {
+			return fmt.Errorf("something's wrong: %w", err)
+		}
+	}
+
+	// transcriptions still in flight have no keys yet, only their audio is renamed
+	query = `
+        UPDATE transcripts
+        SET audio_key = $3, updated_at = NOW()
+        WHERE metadata_id = $1 AND audio_key = $2
+	`
+
+	if _, err := tx.Exec(ctx, query, old.Id, old.AudioKey, renamed.AudioKey); err != nil {
+		return fmt.Errorf("something's wrong: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,130 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/ziliscite/video-to-mp3/converter/internal/domain"
+	"time"
+)
+
+type RetentionRepository interface {
+	// ExpiredVideos returns the metadata whose source video is still stored and was converted before the cutoff.
+	ExpiredVideos(ctx context.Context, before time.Time) ([]domain.Metadata, error)
+	// ExpiredAudios returns the unpinned metadata whose audio is still stored and was converted before the cutoff.
+	ExpiredAudios(ctx context.Context, before time.Time) ([]domain.Metadata, error)
+	// MarkVideoDeleted records that the source video of the metadata is gone.
+	MarkVideoDeleted(ctx context.Context, id int64) error
+	// MarkAudioDeleted records that the audio of the metadata is gone.
+	MarkAudioDeleted(ctx context.Context, id int64) error
+	// SetPinned pins or unpins the user's audio, all the parts of it when given the key of one.
+	// Returns ErrRecordNotFound if the user has no such audio.
+	SetPinned(ctx context.Context, userId int64, audioKey string, pinned bool) error
+}
+
+func NewRetentionRepo(db *pgxpool.Pool) RetentionRepository {
+	return &retentionRepo{db: db}
+}
+
+type retentionRepo struct {
+	db *pgxpool.Pool
+}
+
+func (r retentionRepo) ExpiredVideos(ctx context.Context, before time.Time) ([]domain.Metadata, error) {
+	query := `
+        SELECT id, user_id, file_name, video_key, audio_key, stream_key,
+               ARRAY(SELECT audio_key FROM audio_parts WHERE metadata_id = metadata.id ORDER BY position)
+        FROM metadata
+        WHERE video_deleted_at IS NULL AND created_at < $1
+        ORDER BY created_at
+	`
+
+	return r.list(ctx, query, before)
+}
+
+func (r retentionRepo) ExpiredAudios(ctx context.Context, before time.Time) ([]domain.Metadata, error) {
+	query := `
+        SELECT id, user_id, file_name, video_key, audio_key, stream_key,
+               ARRAY(SELECT audio_key FROM audio_parts WHERE metadata_id = metadata.id ORDER BY position)
+        FROM metadata
+        WHERE audio_deleted_at IS NULL AND NOT pinned AND created_at < $1
+        ORDER BY created_at
+	`
+
+	return r.list(ctx, query, before)
+}
+
+func (r retentionRepo) list(ctx context.Context, query string, args ...any) ([]domain.Metadata, error) {
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("something's wrong: %w", err)
+	}
+	defer rows.Close()
+
+	var list []domain.Metadata
+	for rows.Next() {
+		var (
+			m     domain.Metadata
+			parts []string
+		)
+		if err = rows.Scan(&m.Id, &m.UserId, &m.FileName, &m.VideoKey, &m.AudioKey, &m.StreamKey, &parts); err != nil {
+			return nil, fmt.Errorf("something's wrong: %w", err)
+		}
+
+		// only the keys of the parts matter to retention
+		for _, key := range parts {
+			m.Parts = append(m.Parts, domain.Part{AudioKey: key})
+		}
+		list = append(list, m)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("something's wrong: %w", err)
+	}
+
+	return list, nil
+}
+
+func (r retentionRepo) MarkVideoDeleted(ctx context.Context, id int64) error {
+	query := `
+        UPDATE metadata
+        SET video_deleted_at = NOW(), updated_at = NOW()
+        WHERE id = $1 AND video_deleted_at IS NULL
+	`
+
+	return r.exec(ctx, query, id)
+}
+
+func (r retentionRepo) MarkAudioDeleted(ctx context.Context, id int64) error {
+	query := `
+        UPDATE metadata
+        SET audio_deleted_at = NOW(), updated_at = NOW()
+        WHERE id = $1 AND audio_deleted_at IS NULL
+	`
+
+	return r.exec(ctx, query, id)
+}
+
+func (r retentionRepo) SetPinned(ctx context.Context, userId int64, audioKey string, pinned bool) error {
+	query := `
+        UPDATE metadata
+        SET pinned = $3, updated_at = NOW()
+        WHERE user_id = $1 AND audio_deleted_at IS NULL
+          AND (audio_key = $2 OR id IN (SELECT metadata_id FROM audio_parts WHERE audio_key = $2))
+	`
+
+	return r.exec(ctx, query, userId, audioKey, pinned)
+}
+
+func (r retentionRepo) exec(ctx context.Context, query string, args ...any) error {
+	tag, err := r.db.Exec(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("something's wrong: %w", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,122 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/ziliscite/video-to-mp3/converter/internal/domain"
+)
+
+type StreamRepository interface {
+	// Claim records the transcoding unless its job id was seen before, and returns the stored one either way.
+	// The metadata must be one of the user's whose video is still in storage.
+	// Returns ErrRecordNotFound if the user has no such video.
+	Claim(ctx context.Context, transcoding *domain.Transcoding) (*domain.Transcoding, error)
+	// Complete records the key of the master playlist, on the transcoding and on its metadata.
+	Complete(ctx context.Context, transcoding *domain.Transcoding) error
+	// Fail marks the transcoding as dropped and records why. A completed one is left alone.
+	Fail(ctx context.Context, jobId string, failure *domain.JobFailure) error
+}
+
+func NewStreamRepo(db *pgxpool.Pool) StreamRepository {
+	return &streamRepo{db: db}
+}
+
+type streamRepo struct {
+	db *pgxpool.Pool
+}
+
+func (r streamRepo) Claim(ctx context.Context, transcoding *domain.Transcoding) (*domain.Transcoding, error) {
+	query := `
+        INSERT INTO streams(job_id, user_id, metadata_id, renditions, status)
+        SELECT $1, $2, id, COALESCE($4::INTEGER[], '{}'), $5
+        FROM metadata
+        WHERE id = $3 AND user_id = $2 AND video_deleted_at IS NULL
+        ON CONFLICT (job_id) DO NOTHING
+	`
+
+	args := []any{transcoding.JobId, transcoding.UserId, transcoding.MetadataId, transcoding.Renditions, domain.JobProcessing}
+
+	if _, err := r.db.Exec(ctx, query, args...); err != nil {
+		return nil, fmt.Errorf("something's wrong: %w", err)
+	}
+
+	query = `
+        SELECT job_id, user_id, metadata_id, renditions, status, playlist_key
+        FROM streams
+        WHERE job_id = $1
+	`
+
+	var t domain.Transcoding
+	if err := r.db.QueryRow(ctx, query, transcoding.JobId).Scan(
+		&t.JobId, &t.UserId, &t.MetadataId, &t.Renditions, &t.Status, &t.PlaylistKey,
+	); err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, fmt.Errorf("something's wrong: %w", err)
+		}
+	}
+
+	return &t, nil
+}
+
+func (r streamRepo) Complete(ctx context.Context, transcoding *domain.Transcoding) error {
+	return r.db.BeginFunc(ctx, func(tx pgx.Tx) error {
+		query := `
+            UPDATE streams
+            SET status = $2, playlist_key = $3, updated_at = NOW()
+            WHERE job_id = $1
+            RETURNING metadata_id
+		`
+
+		var metadataId int64
+		if err := tx.QueryRow(ctx, query, transcoding.JobId, domain.JobCompleted, transcoding.PlaylistKey).Scan(&metadataId); err != nil {
+			switch {
+			case errors.Is(err, pgx.ErrNoRows):
+				return ErrRecordNotFound
+			default:
+				return fmt.Errorf("something's wrong: %w", err)
+			}
+		}
+
+		// the stream of an earlier job is replaced in storage, so the latest one is the stream of the metadata
+		query = `
+            UPDATE metadata
+            SET stream_key = $2
+            WHERE id = $1
+		`
+
+		if _, err := tx.Exec(ctx, query, metadataId, transcoding.PlaylistKey); err != nil {
+			return fmt.Errorf("something's wrong: %w", err)
+		}
+
+		transcoding.Status = domain.JobCompleted
+		return nil
+	})
+}
+
+func (r streamRepo) Fail(ctx context.Context, jobId string, failure *domain.JobFailure) error {
+	query := `
+        UPDATE streams
+        SET status = $2, failure_reason = $3, failure_error = $4, failure_stderr = $5, updated_at = NOW()
+        WHERE job_id = $1 AND status <> $6
+	`
+
+	args := []any{jobId, domain.JobFailed, failure.Reason, failure.Error, failure.Stderr, domain.JobCompleted}
+
+	tag, err := r.db.Exec(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("something's wrong: %w", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,147 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/ziliscite/video-to-mp3/converter/internal/domain"
+)
+
+type TranscriptRepository interface {
+	// Claim records the transcription unless its job id was seen before, and returns the stored one either way.
+	// The audio must be one of the user's still in storage, a part of a split conversion included.
+	// Returns ErrRecordNotFound if the user has no such audio.
+	Claim(ctx context.Context, transcription *domain.Transcription) (*domain.Transcription, error)
+	// Complete records the keys of the stored transcripts.
+	Complete(ctx context.Context, transcription *domain.Transcription) error
+	// Fail marks the transcription as dropped and records why. A completed one is left alone.
+	Fail(ctx context.Context, jobId string, failure *domain.JobFailure) error
+}
+
+func NewTranscriptRepo(db *pgxpool.Pool) TranscriptRepository {
+	return &transcriptRepo{db: db}
+}
+
+type transcriptRepo struct {
+	db *pgxpool.Pool
+}
+
+func (r transcriptRepo) Claim(ctx context.Context, transcription *domain.Transcription) (*domain.Transcription, error) {
+	query := `
+        INSERT INTO transcripts(job_id, user_id, metadata_id, audio_key, language, status)
+        SELECT $1, $2, id, $3, $4, $5
+        FROM metadata
+        WHERE user_id = $2 AND audio_deleted_at IS NULL
+          AND (audio_key = $3 OR id IN (SELECT metadata_id FROM audio_parts WHERE audio_key = $3))
+        ON CONFLICT (job_id) DO NOTHING
+	`
+
+	args := []any{transcription.JobId, transcription.UserId, transcription.AudioKey, transcription.Language, domain.JobProcessing}
+
+	if _, err := r.db.Exec(ctx, query, args...); err != nil {
+		return nil, fmt.Errorf("something's wrong: %w", err)
+	}
+
+	return r.get(ctx, transcription.JobId)
+}
+
+func (r transcriptRepo) get(ctx context.Context, jobId string) (*domain.Transcription, error) {
+	query := `
+        SELECT job_id, user_id, metadata_id, audio_key, language, status, srt_key, vtt_key, text_key
+        FROM transcripts
+        WHERE job_id = $1
+	`
+
+	var t domain.Transcription
+	if err := r.db.QueryRow(ctx, query, jobId).Scan(
+		&t.JobId, &t.UserId, &t.MetadataId, &t.AudioKey, &t.Language,
+		&t.Status, &t.SRTKey, &t.VTTKey, &t.TextKey,
+	); err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, fmt.Errorf("something's wrong: %w", err)
+		}
+	}
+
+	return &t, nil
+}
+
+func (r transcriptRepo) Complete(ctx context.Context, transcription *domain.Transcription) error {
+	query := `
+        UPDATE transcripts
+        SET status = $2, srt_key = $3, vtt_key = $4, text_key = $5, updated_at = NOW()
+        WHERE job_id = $1
+	`
+
+	args := []any{transcription.JobId, domain.JobCompleted, transcription.SRTKey, transcription.VTTKey, transcription.TextKey}
+
+	tag, err := r.db.Exec(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("something's wrong: %w", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrRecordNotFound
+	}
+
+	transcription.Status = domain.JobCompleted
+	return nil
+}
+
+func (r transcriptRepo) Fail(ctx context.Context, jobId string, failure *domain.JobFailure) error {
+	query := `
+        UPDATE transcripts
+        SET status = $2, failure_reason = $3, failure_error = $4, failure_stderr = $5, updated_at = NOW()
+        WHERE job_id = $1 AND status <> $6
+	`
+
+	args := []any{jobId, domain.JobFailed, failure.Reason, failure.Error, failure.Stderr, domain.JobCompleted}
+
+	tag, err := r.db.Exec(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("something's wrong: %w", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// getTranscripts returns the latest completed transcription of each audio of the metadata.
+// Later ones replace the transcripts of earlier ones in storage, so only they name current objects.
+func getTranscripts(ctx context.Context, db *pgxpool.Pool, metadataId int64) ([]domain.Transcription, error) {
+	query := `
+        SELECT DISTINCT ON (audio_key) job_id, user_id, metadata_id, audio_key, language, status, srt_key, vtt_key, text_key
+        FROM transcripts
+        WHERE metadata_id = $1 AND status = $2
+        ORDER BY audio_key, updated_at DESC, id DESC
+	`
+
+	rows, err := db.Query(ctx, query, metadataId, domain.JobCompleted)
+	if err != nil {
+		return nil, fmt.Errorf("something's wrong: %w", err)
+	}
+	defer rows.Close()
+
+	var transcripts []domain.Transcription
+	for rows.Next() {
+		var t domain.Transcription
+		if err = rows.Scan(&t.JobId, &t.UserId, &t.MetadataId, &t.AudioKey, &t.Language, &t.Status, &t.SRTKey, &t.VTTKey, &t.TextKey); err != nil {
+			return nil, fmt.Errorf("something's wrong: %w", err)
+		}
+		transcripts = append(transcripts, t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("something's wrong: %w", err)
+	}
+
+	return transcripts, nil
+}
@@ -4,29 +4,48 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"github.com/aws/smithy-go"
+	"github.com/google/uuid"
 	"io"
+	"log/slog"
 	"os"
 	"path/filepath"
 
 	"github.com/ziliscite/video-to-mp3/converter/internal/domain"
 	"github.com/ziliscite/video-to-mp3/converter/internal/repository"
-	"github.com/ziliscite/video-to-mp3/converter/pkg/encryptor"
+	"github.com/ziliscite/video-to-mp3/events"
+	"github.com/ziliscite/video-to-mp3/platform/encryptor"
+	"github.com/ziliscite/video-to-mp3/platform/storage"
+	"github.com/ziliscite/video-to-mp3/platform/transcriber"
 )
 
 var ErrInternal = errors.New("internal error")
 
 type ConverterMP4 interface {
 	// ConvertMP4 converts the video to mp3 format.
-	// takes the user id, file size, and file key as arguments.
-	// returns the audio key and an error if any.
-	ConvertMP4(ctx context.Context, userId, filesize int64, filekey string) (*domain.Metadata, error)
+	// takes the job id, user id, file size, file key, and encrypted filename as arguments.
+	// The filename is empty for videos uploaded when the file key was the encrypted filename.
+	// The options say how the audio is processed, invalid ones fail the job for good.
+	// The progress function, if any, is called with the share of the video converted so far.
+	// returns the saved metadata and an error if any.
+	// Running a job again resumes it, and a completed job returns its existing metadata.
+	ConvertMP4(ctx context.Context, jobId string, userId, filesize int64, filekey, encryptedName string, opts domain.Options, progress func(done float64)) (*domain.Metadata, error)
+}
+
+type ConverterFailure interface {
+	// Failure describes a dropped conversion in user-safe terms.
+	// The filename is decrypted when possible.
+	Failure(video *events.VideoUploaded, err error) *events.ConversionFailed
+	// RecordFailure marks the job as dropped, keeping the error and ffmpeg's output for operators.
+	RecordFailure(ctx context.Context, jobId string, err error) error
 }
 
 type ConverterService interface {
 	ConverterMP4
-	// ConverterMP3
-	// ConverterText
+	ConverterFailure
+	ConverterMP3
+	ConverterText
+	ConverterImage
+	ConverterStream
 }
 
 type bucket struct {
@@ -34,75 +53,254 @@ type bucket struct {
 	mp3 string
 }
 
+// AudioConverter extracts the audio track of a video into a file in dir, processed as the options say.
+// The progress function may be nil. Existing audio is encoded again the same way. The waveform of the audio
+// is drawn next to it as the config says, and its speech extracted for transcription engines.
+// The pictures of the video are drawn apart from its audio, and so is its HLS version.
+type AudioConverter interface {
+	ConvertMP4ToMP3(ctx context.Context, dir string, video io.Reader, opts domain.Options, progress func(done float64)) (*domain.Audio, error)
+	Reencode(ctx context.Context, dir, audio string, r domain.Reencoding, progress func(done float64)) (*domain.Audio, error)
+	DrawWaveform(ctx context.Context, dir, audio string, cfg domain.WaveformConfig) (*domain.Waveform, error)
+	ExtractSpeech(ctx context.Context, dir, audio string) (string, error)
+	DrawThumbnail(ctx context.Context, dir string, video io.Reader, cfg domain.ThumbnailConfig) (*domain.Thumbnail, error)
+	Stream(ctx context.Context, dir string, video io.Reader, s domain.Streaming, progress func(done float64)) (*domain.Stream, error)
+}
+
 type converterService struct {
-	cv *domain.Converter
-	fr repository.FileStore
+	cv AudioConverter
+	tr transcriber.Transcriber
+	fr storage.FileStore
 	mr repository.MetadataRepository
+	jr repository.JobRepository
+	xr repository.TranscriptRepository
+	sr repository.StreamRepository
+	pr repository.PresetRepository
 	en *encryptor.Encryptor
 	b  bucket
+	wf domain.WaveformConfig
+	th domain.ThumbnailConfig
 }
 
-func NewConverterService(cv *domain.Converter, fr repository.FileStore, mr repository.MetadataRepository, en *encryptor.Encryptor, mp4Bucket, mp3Bucket string) ConverterService {
+// NewConverterService creates the converter service. Converted audio gets a waveform
+// unless the waveform config has no resolution. Without a transcriber, transcriptions are refused,
+// and without a thumbnail width so are thumbnails.
+func NewConverterService(cv AudioConverter, tr transcriber.Transcriber, fr storage.FileStore, mr repository.MetadataRepository, jr repository.JobRepository, xr repository.TranscriptRepository, sr repository.StreamRepository, pr repository.PresetRepository, en *encryptor.Encryptor, mp4Bucket, mp3Bucket string, waveform domain.WaveformConfig, thumbnail domain.ThumbnailConfig) ConverterService {
 	return &converterService{
 		cv: cv,
+		tr: tr,
 		fr: fr,
 		mr: mr,
+		jr: jr,
+		xr: xr,
+		sr: sr,
+		pr: pr,
 		en: en,
 		b: bucket{
 			mp4: mp4Bucket,
 			mp3: mp3Bucket,
 		},
+		wf: waveform,
+		th: thumbnail,
+	}
+}
+
+func (c *converterService) ConvertMP4(ctx context.Context, jobId string, userId, filesize int64, filekey, encryptedName string, opts domain.Options, progress func(done float64)) (*domain.Metadata, error) {
+	s := &conversion{
+		job:  &domain.Job{Id: jobId, UserId: userId, VideoKey: filekey},
+		name: encryptedName, filesize: filesize, opts: opts, progress: progress,
 	}
+	defer s.release()
+
+	if err := c.conversionPipeline().run(ctx, s, "job_id", jobId); err != nil {
+		return nil, err
+	}
+	return s.result, nil
+}
+
+// conversion is the state of the conversion of an uploaded video.
+type conversion struct {
+	workspace
+
+	// the request, the job is replaced by the claimed one
+	job      *domain.Job
+	name     string
+	filesize int64
+	opts     domain.Options
+	progress func(done float64)
+
+	metadata *domain.Metadata
+	audio    *domain.Audio
+	result   *domain.Metadata
 }
 
-func (c *converterService) ConvertMP4(ctx context.Context, userId, filesize int64, filekey string) (*domain.Metadata, error) {
-	// get the video file from S3
-	video, err := c.read(ctx, fmt.Sprintf("%s.mp4", filekey), filesize) // key is formatted as filekey.mp4
+// conversionPipeline converts the video into audio and saves its metadata. The audio may have been stored
+// by an earlier delivery of the same job, the loudness measurement and waveform of unsplit audio went with it,
+// and so did the options of the preset.
+func (c *converterService) conversionPipeline() *pipeline[conversion] {
+	stored := func(s *conversion) bool { return s.job.AudioKey != "" }
+
+	return &pipeline[conversion]{
+		name: "conversion",
+		stages: []stage[conversion]{
+			{name: "validate", run: func(_ context.Context, s *conversion) error { return s.opts.Validate() }},
+			{name: "claim", run: c.claimConversion, transient: always},
+			{name: "name", run: c.nameConversion},
+			{name: "preset", run: c.applyPreset, skip: stored, transient: except(ErrPresetNotFound, domain.ErrInvalidOptions)},
+			{name: "read", run: c.readConversion, skip: stored, transient: transientStorage},
+			{name: "convert", run: c.convertVideo, skip: stored},
+			{name: "store", run: c.storeConversion, skip: stored, transient: transientStorage},
+			{name: "record", run: c.recordConversion, skip: stored, transient: always},
+			{name: "complete", run: c.completeConversion, transient: always},
+		},
+		// the job is done but the message wasn't acked, hand back the existing result
+		done: func(s *conversion) bool { return s.result != nil },
+	}
+}
+
+func (c *converterService) claimConversion(ctx context.Context, s *conversion) (err error) {
+	if s.job, err = c.jr.Claim(ctx, s.job); err != nil {
+		return fmt.Errorf("failed to claim job: %w", err)
+	}
+
+	if s.job.Status == domain.JobCompleted {
+		s.result, err = c.existing(ctx, s.job)
+	}
+	return err
+}
+
+// nameConversion decrypts the filename of the video into the metadata of the conversion.
+func (c *converterService) nameConversion(_ context.Context, s *conversion) error {
+	// older file keys are the encrypted filename itself
+	if s.name == "" {
+		s.name = s.job.VideoKey
+	}
+
+	fb, err := c.en.Decrypt(s.name)
 	if err != nil {
-		var apiErr smithy.APIError
-		switch {
-		case errors.As(err, &apiErr):
-			switch apiErr.ErrorCode() {
-			case "SlowDown", "RequestTimeout", "RequestTimeTooSkewed", "OperationAborted", "ServiceUnavailable", "InternalError":
-				return nil, fmt.Errorf("%w: transient error occurred: %w", ErrInternal, err)
-			}
-		default:
-			return nil, fmt.Errorf("failed to read video file: %w", err)
+		return fmt.Errorf("failed to decrypt filename: %v", err)
+	}
+
+	s.metadata = &domain.Metadata{
+		UserId: s.job.UserId, FileName: string(fb), EncryptedFileName: s.name,
+		VideoKey: s.job.VideoKey, AudioKey: s.job.AudioKey, Parts: s.job.Parts,
+	}
+	return nil
+}
+
+func (c *converterService) readConversion(ctx context.Context, s *conversion) (err error) {
+	s.video, err = c.readVideo(ctx, s.job.VideoKey, s.filesize)
+	return err
+}
+
+func (c *converterService) convertVideo(ctx context.Context, s *conversion) (err error) {
+	if err = s.mkdir("job-*"); err != nil {
+		return err
+	}
+
+	if s.audio, err = c.cv.ConvertMP4ToMP3(ctx, s.dir, s.video, s.opts, s.progress); err != nil {
+		return fmt.Errorf("failed to convert video: %w", err)
+	}
+	return nil
+}
+
+// storeConversion encrypts and stores the audio, recording it in the metadata: its key, the loudness
+// measurement if the audio was normalized, its waveform, and its parts if it was split.
+func (c *converterService) storeConversion(ctx context.Context, s *conversion) (err error) {
+	metadata, audio := s.metadata, s.audio
+	if len(audio.Tracks) == 0 {
+		if metadata.AudioKey, err = c.storeMP3(ctx, audio.Path); err != nil {
+			return fmt.Errorf("failed to process and store mp3: %w", err)
 		}
+		metadata.Loudness = audio.Loudness
+		metadata.PeaksKey, metadata.WaveformKey = c.storeWaveform(ctx, s.dir, audio.Path, metadata.AudioKey)
+		return nil
 	}
-	defer video.Close()
 
-	// decrypt filekey to get filename
-	fb, err := c.en.Decrypt(filekey)
-	if err != nil {
-		return nil, fmt.Errorf("failed to decode filekey: %v", err)
+	// parts stored before a failure are left to the reconciler
+	for i, track := range audio.Tracks {
+		key, err := c.storeMP3(ctx, track.Path)
+		if err != nil {
+			return fmt.Errorf("failed to process and store part %d: %w", i+1, err)
+		}
+		part := domain.Part{Chapter: track.Chapter, AudioKey: key, Loudness: track.Loudness}
+		part.PeaksKey, part.WaveformKey = c.storeWaveform(ctx, s.dir, track.Path, key)
+		metadata.Parts = append(metadata.Parts, part)
+	}
+	metadata.AudioKey = metadata.Parts[0].AudioKey
+
+	return nil
+}
+
+func (c *converterService) recordConversion(ctx context.Context, s *conversion) error {
+	if err := c.jr.SetAudio(ctx, s.job.Id, s.metadata.AudioKey, s.metadata.Parts); err != nil {
+		return fmt.Errorf("failed to record audio: %w", err)
 	}
-	filename := string(fb)
+	return nil
+}
 
-	// convert the video to mp3
-	out, err := c.cv.ConvertMP4ToMP3(filename, video)
+func (c *converterService) completeConversion(ctx context.Context, s *conversion) (err error) {
+	s.result, err = c.complete(ctx, s.job, s.metadata)
+	return err
+}
+
+// complete saves the metadata of the job, or returns the one saved by another delivery of it.
+func (c *converterService) complete(ctx context.Context, job *domain.Job, metadata *domain.Metadata) (*domain.Metadata, error) {
+	err := c.saveMetadata(ctx, job.Id, metadata)
+	if err == nil {
+		return metadata, nil
+	}
+
+	if !errors.Is(err, repository.ErrDuplicateEntry) {
+		return nil, fmt.Errorf("failed to save metadata: %w", err)
+	}
+
+	// another delivery of the job completed it first
+	job, err = c.jr.Claim(ctx, job)
 	if err != nil {
-		return nil, fmt.Errorf("failed to convert video: %v", err)
+		return nil, fmt.Errorf("failed to reload job: %w", err)
 	}
-	defer os.Remove(out)
+	return c.existing(ctx, job)
+}
 
-	// encrypt and store the mp3
-	audioKey, err := c.storeMP3(ctx, out)
+// readVideo gets the video file from S3.
+func (c *converterService) readVideo(ctx context.Context, videoKey string, filesize int64) (io.ReadCloser, error) {
+	return c.read(ctx, fmt.Sprintf("%s.mp4", videoKey), filesize) // key is formatted as filekey.mp4
+}
+
+func (c *converterService) existing(ctx context.Context, job *domain.Job) (*domain.Metadata, error) {
+	metadata, err := c.mr.Get(ctx, job.MetadataId)
 	if err != nil {
-		return nil, fmt.Errorf("failed to process and store mp3: %v", err)
+		return nil, fmt.Errorf("failed to load metadata of job %s: %w", job.Id, err)
 	}
 
-	metadata := &domain.Metadata{
-		UserId: userId, FileName: filename,
-		VideoKey: filekey, AudioKey: audioKey,
+	return metadata, nil
+}
+
+func (c *converterService) Failure(video *events.VideoUploaded, err error) *events.ConversionFailed {
+	failure := &events.ConversionFailed{
+		UserId: video.UserId, UserEmail: video.UserEmail,
+		VideoKey: video.FileKey,
 	}
+	failure.SetReason(reasonOf(err))
 
-	// if all is well, save the metadata to the database;
-	if err = c.saveMetadata(ctx, metadata); err != nil {
-		return nil, fmt.Errorf("failed to save metadata: %v", err)
+	encryptedName := video.FileName
+	if encryptedName == "" {
+		encryptedName = video.FileKey
 	}
 
-	return metadata, nil
+	if fb, derr := c.en.Decrypt(encryptedName); derr == nil {
+		failure.FileName = string(fb)
+	}
+
+	return failure
+}
+
+func (c *converterService) RecordFailure(ctx context.Context, jobId string, err error) error {
+	if err = c.jr.Fail(ctx, jobId, jobFailure(err)); err != nil {
+		return fmt.Errorf("failed to record failure of job %s: %w", jobId, err)
+	}
+
+	return nil
 }
 
 func (c *converterService) storeMP3(ctx context.Context, mp3Path string) (string, error) {
@@ -113,36 +311,88 @@ func (c *converterService) storeMP3(ctx context.Context, mp3Path string) (string
 	}
 	defer mp3.Close()
 
-	// encrypt the converted file
-	key, err := c.en.Encrypt(mp3Path)
+	id, err := uuid.NewV7()
 	if err != nil {
-		return "", fmt.Errorf("failed to encrypt converted file: %v", err)
+		return "", fmt.Errorf("%w: failed to generate audio key: %w", ErrInternal, err)
 	}
 
-	ext := filepath.Ext(mp3Path)
+	// the audio key is the object name, extension included, so the object can be found from its metadata
+	key := id.String() + filepath.Ext(mp3Path)
 
-	// save the encrypted file to S3
-	if err = c.fr.Save(ctx, fmt.Sprintf("%s.%s", key, ext), c.mime(ext), c.b.mp3, mp3); err != nil {
-		var apiErr smithy.APIError
-		switch {
-		case errors.As(err, &apiErr):
-			switch apiErr.ErrorCode() {
-			case "SlowDown", "RequestTimeout", "RequestTimeTooSkewed", "OperationAborted", "ServiceUnavailable", "InternalError":
-				return "", fmt.Errorf("%w: transient error occurred: %w", ErrInternal, err)
-			}
-		default:
-			return "", fmt.Errorf("failed to upload file to bucket: %w", err)
-		}
+	// the store encrypts the audio at rest when it's configured to, the key is random either way
+	if err = c.save(ctx, key, mp3); err != nil {
+		return "", err
 	}
 
 	return key, nil
 }
 
-func (c *converterService) saveMetadata(ctx context.Context, data *domain.Metadata) error {
-	if err := c.mr.Insert(ctx, data); err != nil {
+// storeWaveform draws the waveform of the audio and stores it next to the audio, returning the keys
+// of the peaks and of the image. The waveform only serves the player, so when it can't be drawn or
+// stored the audio goes without one rather than failing the job, and the keys are empty.
+func (c *converterService) storeWaveform(ctx context.Context, dir, audioPath, audioKey string) (string, string) {
+	if c.wf.PixelsPerSecond <= 0 {
+		return "", ""
+	}
+
+	w, err := c.cv.DrawWaveform(ctx, dir, audioPath, c.wf)
+	if err != nil {
+		slog.Warn("Failed to draw waveform", "audio_key", audioKey, "error", err)
+		return "", ""
+	}
+
+	peaksKey, waveformKey := peaksObject(audioKey), ""
+	if err = c.storeFile(ctx, w.Peaks, peaksKey); err != nil {
+		slog.Warn("Failed to store peaks", "audio_key", audioKey, "error", err)
+		return "", ""
+	}
+
+	if w.Image != "" {
+		if err = c.storeFile(ctx, w.Image, waveformObject(audioKey)); err != nil {
+			slog.Warn("Failed to store waveform image", "audio_key", audioKey, "error", err)
+		} else {
+			waveformKey = waveformObject(audioKey)
+		}
+	}
+
+	return peaksKey, waveformKey
+}
+
+// storeFile saves the file to the audio bucket under the key.
+func (c *converterService) storeFile(ctx context.Context, path, key string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %v", filepath.Base(path), err)
+	}
+	defer f.Close()
+
+	return c.save(ctx, key, f)
+}
+
+// save uploads to the audio bucket, the content type is taken from the extension of the key.
+func (c *converterService) save(ctx context.Context, key string, r io.Reader) error {
+	if err := c.fr.Save(ctx, key, c.mime(filepath.Ext(key)), c.b.mp3, r); err != nil {
+		return fmt.Errorf("failed to upload file to bucket: %w", err)
+	}
+
+	return nil
+}
+
+// peaksObject and waveformObject name the drawing of an audio. It is stored next to the audio under
+// the same id, so whatever keeps the audio, like the reconciler, keeps its drawing too.
+func peaksObject(audioKey string) string {
+	return objectKey(audioKey) + ".peaks.json"
+}
+
+func waveformObject(audioKey string) string {
+	return objectKey(audioKey) + ".waveform.png"
+}
+
+func (c *converterService) saveMetadata(ctx context.Context, jobId string, data *domain.Metadata) error {
+	if err := c.jr.Complete(ctx, jobId, data); err != nil {
 		switch {
 		case errors.Is(err, repository.ErrDuplicateEntry):
-			return fmt.Errorf("metadata already exists: %v", err)
+			return fmt.Errorf("metadata already exists: %w", err)
 		default:
 			return fmt.Errorf("failed to save metadata: %v", err)
 		}
@@ -166,7 +416,7 @@ func (c *converterService) read(ctx context.Context, filekey string, filesize in
 	}
 
 	if err != nil {
-		return nil, fmt.Errorf("failed to read video file: %v", err)
+		return nil, fmt.Errorf("failed to read video file: %w", err)
 	}
 
 	return video, nil
@@ -194,7 +444,31 @@ func (c *converterService) mime(ext string) string {
 		return "audio/mpeg"
 	case ".wav":
 		return "audio/wav"
-	// should not happen. as in convert, we only support these 3 formats
+	// the drawing of the audio
+	case ".json":
+		return "application/json"
+	case ".png":
+		return "image/png"
+	// its transcripts
+	case ".srt":
+		return "application/x-subrip"
+	case ".vtt":
+		return "text/vtt"
+	case ".txt":
+		return "text/plain; charset=utf-8"
+	// the pictures of the video
+	case ".jpg":
+		return "image/jpeg"
+	case ".webp":
+		return "image/webp"
+	case ".gif":
+		return "image/gif"
+	// and its stream
+	case ".m3u8":
+		return "application/vnd.apple.mpegurl"
+	case ".ts":
+		return "video/mp2t"
+	// should not happen, every file the converter stores has one of the formats above
 	default:
 		return ""
 	}
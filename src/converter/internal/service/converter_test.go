@@ -0,0 +1,1272 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ziliscite/video-to-mp3/converter/internal/domain"
+	"github.com/ziliscite/video-to-mp3/converter/internal/repository"
+	"github.com/ziliscite/video-to-mp3/events"
+	"github.com/ziliscite/video-to-mp3/platform/encryptor"
+	"github.com/ziliscite/video-to-mp3/platform/envelope"
+	"github.com/ziliscite/video-to-mp3/platform/storage"
+	"github.com/ziliscite/video-to-mp3/platform/transcriber"
+)
+
+var errCrash = errors.New("crash")
+
+// harness fakes every dependency of the converter service in memory.
+// Failing a stage makes the next call to it return errCrash, the way a
+// crash there would leave things before the message is redelivered.
+type harness struct {
+	storage.FileStore
+	fails map[string]int
+
+	uploads     int
+	conversions int
+	// options of the last conversion
+	options domain.Options
+	// convertErr is returned by every conversion
+	convertErr error
+
+	jobs        map[string]*domain.Job
+	metadata    map[int64]*domain.Metadata
+	failures    map[string]*domain.JobFailure
+	transcripts map[string]*domain.Transcription
+	streams     map[string]*domain.Transcoding
+	presets     map[int64]*domain.Preset
+
+	// engineErr is returned by every transcription
+	engineErr      error
+	transcriptions int
+
+	invalidated []string
+
+	beforeComplete func()
+}
+
+func newHarness() *harness {
+	return &harness{
+		FileStore: storage.NewMemoryStore(),
+		fails:     make(map[string]int),
+		jobs:      make(map[string]*domain.Job),
+		metadata:  make(map[int64]*domain.Metadata),
+		failures:  make(map[string]*domain.JobFailure),
+
+		transcripts: make(map[string]*domain.Transcription),
+		streams:     make(map[string]*domain.Transcoding),
+		presets:     make(map[int64]*domain.Preset),
+	}
+}
+
+func (h *harness) fail(stage string) error {
+	if h.fails[stage] > 0 {
+		h.fails[stage]--
+		return errCrash
+	}
+	return nil
+}
+
+// AudioConverter
+
+func (h *harness) ConvertMP4ToMP3(ctx context.Context, dir string, video io.Reader, opts domain.Options, progress func(done float64)) (*domain.Audio, error) {
+	if err := h.fail("convert"); err != nil {
+		return nil, err
+	}
+	if h.convertErr != nil {
+		return nil, h.convertErr
+	}
+	h.conversions++
+	h.options = opts
+
+	body, err := io.ReadAll(video)
+	if err != nil {
+		return nil, err
+	}
+
+	if progress != nil {
+		progress(1)
+	}
+
+	if opts.SplitChapters {
+		audio := &domain.Audio{}
+		for i, title := range []string{"Intro", "Lecture"} {
+			track := domain.Track{
+				Chapter: domain.Chapter{Title: title, Start: time.Duration(i) * time.Minute, End: time.Duration(i+1) * time.Minute},
+				Path:    filepath.Join(dir, fmt.Sprintf("output-%03d.mp3", i+1)),
+			}
+			if err = os.WriteFile(track.Path, body, 0600); err != nil {
+				return nil, err
+			}
+			audio.Tracks = append(audio.Tracks, track)
+		}
+		return audio, nil
+	}
+
+	audio := &domain.Audio{Path: filepath.Join(dir, "output.mp3")}
+	if opts.Loudness != "" {
+		audio.Loudness = &domain.Loudness{Preset: opts.Loudness, InputIntegrated: -27.5, OutputIntegrated: -16}
+	}
+	return audio, os.WriteFile(audio.Path, body, 0600)
+}
+
+func (h *harness) Reencode(ctx context.Context, dir, audio string, r domain.Reencoding, progress func(done float64)) (*domain.Audio, error) {
+	if err := h.fail("convert"); err != nil {
+		return nil, err
+	}
+	h.conversions++
+
+	body, err := os.ReadFile(audio)
+	if err != nil {
+		return nil, err
+	}
+
+	ext := filepath.Ext(audio)
+	if r.Format != "" {
+		ext = "." + r.Format
+	}
+
+	out := &domain.Audio{Path: filepath.Join(dir, "output"+ext)}
+	if r.Loudness != "" {
+		out.Loudness = &domain.Loudness{Preset: r.Loudness, InputIntegrated: -20, OutputIntegrated: -16}
+	}
+	return out, os.WriteFile(out.Path, body, 0600)
+}
+
+func (h *harness) DrawWaveform(ctx context.Context, dir, audio string, cfg domain.WaveformConfig) (*domain.Waveform, error) {
+	if err := h.fail("waveform"); err != nil {
+		return nil, err
+	}
+
+	base := strings.TrimSuffix(audio, filepath.Ext(audio))
+	w := &domain.Waveform{Peaks: base + ".peaks.json"}
+	if err := os.WriteFile(w.Peaks, []byte(`{"version":2}`), 0600); err != nil {
+		return nil, err
+	}
+
+	if cfg.Width > 0 {
+		w.Image = base + ".waveform.png"
+		return w, os.WriteFile(w.Image, []byte("png"), 0600)
+	}
+	return w, nil
+}
+
+func (h *harness) DrawThumbnail(ctx context.Context, dir string, video io.Reader, cfg domain.ThumbnailConfig) (*domain.Thumbnail, error) {
+	if err := h.fail("thumbnail"); err != nil {
+		return nil, err
+	}
+
+	th := &domain.Thumbnail{Poster: filepath.Join(dir, "poster.jpg")}
+	if err := os.WriteFile(th.Poster, []byte("jpeg"), 0600); err != nil {
+		return nil, err
+	}
+
+	if cfg.PreviewFormat != "" {
+		th.Preview = filepath.Join(dir, "preview."+cfg.PreviewFormat)
+		return th, os.WriteFile(th.Preview, []byte(cfg.PreviewFormat), 0600)
+	}
+	return th, nil
+}
+
+func (h *harness) Stream(ctx context.Context, dir string, video io.Reader, s domain.Streaming, progress func(done float64)) (*domain.Stream, error) {
+	if err := h.fail("stream"); err != nil {
+		return nil, err
+	}
+
+	st := &domain.Stream{Dir: filepath.Join(dir, "stream")}
+	files := map[string]string{
+		"audio/index.m3u8":    "#EXTM3U\nsegment000.ts\nsegment001.ts\n",
+		"audio/segment000.ts": "ts",
+		"audio/segment001.ts": "ts",
+		domain.MasterPlaylist: "#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=128000\naudio/index.m3u8\n",
+	}
+	for _, name := range []string{"audio/index.m3u8", "audio/segment000.ts", "audio/segment001.ts", domain.MasterPlaylist} {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(st.Dir, name)), 0700); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(filepath.Join(st.Dir, name), []byte(files[name]), 0600); err != nil {
+			return nil, err
+		}
+		st.Files = append(st.Files, name)
+	}
+	return st, nil
+}
+
+func (h *harness) ExtractSpeech(ctx context.Context, dir, audio string) (string, error) {
+	if err := h.fail("speech"); err != nil {
+		return "", err
+	}
+
+	body, err := os.ReadFile(audio)
+	if err != nil {
+		return "", err
+	}
+
+	wav := filepath.Join(dir, "speech.wav")
+	return wav, os.WriteFile(wav, body, 0600)
+}
+
+// transcriber.Transcriber
+
+func (h *harness) Transcribe(ctx context.Context, wav, language string) (*transcriber.Transcript, error) {
+	if h.engineErr != nil {
+		return nil, h.engineErr
+	}
+	h.transcriptions++
+
+	if language == "" {
+		language = "en"
+	}
+	return &transcriber.Transcript{Language: language, Segments: []transcriber.Segment{
+		{Start: 0, End: 2 * time.Second, Text: "Hello there."},
+	}}, nil
+}
+
+// storage.FileStore, the memory store with failure injection on the stages that matter
+
+func (h *harness) Save(ctx context.Context, fileKey, types, bucket string, file io.Reader) error {
+	if err := h.fail("upload"); err != nil {
+		return err
+	}
+	h.uploads++
+
+	return h.FileStore.Save(ctx, fileKey, types, bucket, file)
+}
+
+func (h *harness) Read(ctx context.Context, bucket string, fileKey string) (io.ReadCloser, error) {
+	if err := h.fail("read"); err != nil {
+		return nil, err
+	}
+
+	return h.FileStore.Read(ctx, bucket, fileKey)
+}
+
+func (h *harness) ReadLarge(ctx context.Context, bucket string, fileKey string) (io.ReadCloser, error) {
+	return h.Read(ctx, bucket, fileKey)
+}
+
+// put stores an object without counting it as an upload.
+func (h *harness) put(bucket, fileKey, body string) {
+	_ = h.FileStore.Save(context.Background(), fileKey, "", bucket, strings.NewReader(body))
+}
+
+func (h *harness) has(bucket, fileKey string) bool {
+	_, err := h.Stat(context.Background(), bucket, fileKey)
+	return err == nil
+}
+
+// count returns the number of objects in the buckets.
+func (h *harness) count(buckets ...string) int {
+	n := 0
+	for _, bucket := range buckets {
+		files, _ := h.List(context.Background(), bucket)
+		n += len(files)
+	}
+	return n
+}
+
+// repository.CDN
+
+func (h *harness) Sign(fileKey string, expires time.Time) (string, error) {
+	return "https://cdn.test/" + fileKey, nil
+}
+
+func (h *harness) Invalidate(ctx context.Context, fileKeys ...string) error {
+	h.invalidated = append(h.invalidated, fileKeys...)
+	return nil
+}
+
+// repository.MetadataRepository
+
+func (h *harness) Insert(ctx context.Context, metadata *domain.Metadata) error {
+	metadata.Id = int64(len(h.metadata) + 1)
+	stored := *metadata
+	h.metadata[metadata.Id] = &stored
+	return nil
+}
+
+func (h *harness) ReferencedKeys(ctx context.Context) (map[string]bool, map[string]bool, error) {
+	videos, audios := make(map[string]bool), make(map[string]bool)
+	for _, m := range h.metadata {
+		videos[m.VideoKey] = true
+		for _, key := range m.AudioKeys() {
+			audios[key] = true
+		}
+	}
+	for _, j := range h.jobs {
+		videos[j.VideoKey] = true
+		if j.AudioKey != "" {
+			audios[j.AudioKey] = true
+		}
+		for _, p := range j.Parts {
+			audios[p.AudioKey] = true
+		}
+	}
+	return videos, audios, nil
+}
+
+func (h *harness) Get(ctx context.Context, id int64) (*domain.Metadata, error) {
+	metadata, ok := h.metadata[id]
+	if !ok {
+		return nil, repository.ErrRecordNotFound
+	}
+
+	found := *metadata
+	return &found, nil
+}
+
+func (h *harness) GetByAudio(ctx context.Context, userId int64, audioKey string) (*domain.Metadata, error) {
+	for _, m := range h.metadata {
+		for _, key := range m.AudioKeys() {
+			if m.UserId == userId && key == audioKey {
+				found := *m
+				return &found, nil
+			}
+		}
+	}
+	return nil, repository.ErrRecordNotFound
+}
+
+func (h *harness) ListByUser(ctx context.Context, userId, before int64, limit int) ([]*domain.Metadata, error) {
+	var list []*domain.Metadata
+	for id := int64(len(h.metadata)); id > 0 && len(list) < limit; id-- {
+		if m, ok := h.metadata[id]; ok && m.UserId == userId && (before == 0 || id < before) {
+			found := *m
+			list = append(list, &found)
+		}
+	}
+	return list, nil
+}
+
+func (h *harness) SetThumbnail(ctx context.Context, id int64, thumbnailKey, previewKey string) error {
+	metadata, ok := h.metadata[id]
+	if !ok {
+		return repository.ErrRecordNotFound
+	}
+
+	metadata.ThumbnailKey, metadata.PreviewKey = thumbnailKey, previewKey
+	return nil
+}
+
+// repository.JobRepository
+
+type jobs struct{ *harness }
+
+func (j jobs) Claim(ctx context.Context, job *domain.Job) (*domain.Job, error) {
+	if err := j.fail("claim"); err != nil {
+		return nil, err
+	}
+
+	if _, ok := j.harness.jobs[job.Id]; !ok {
+		j.harness.jobs[job.Id] = &domain.Job{
+			Id: job.Id, UserId: job.UserId,
+			VideoKey: job.VideoKey, SourceAudioKey: job.SourceAudioKey, Status: domain.JobProcessing,
+		}
+	}
+
+	found := *j.harness.jobs[job.Id]
+	return &found, nil
+}
+
+func (j jobs) SetAudio(ctx context.Context, jobId, audioKey string, parts []domain.Part) error {
+	if err := j.fail("set_audio"); err != nil {
+		return err
+	}
+
+	job := j.harness.jobs[jobId]
+	job.AudioKey, job.Parts, job.Status = audioKey, parts, domain.JobConverted
+	return nil
+}
+
+func (j jobs) Complete(ctx context.Context, jobId string, metadata *domain.Metadata) error {
+	if j.beforeComplete != nil {
+		hook := j.beforeComplete
+		j.beforeComplete = nil
+		hook()
+	}
+
+	if err := j.fail("complete"); err != nil {
+		return err
+	}
+
+	job := j.harness.jobs[jobId]
+	if job.MetadataId != 0 {
+		return repository.ErrDuplicateEntry
+	}
+
+	if err := j.Insert(ctx, metadata); err != nil {
+		return err
+	}
+
+	job.MetadataId, job.AudioKey, job.Status = metadata.Id, metadata.AudioKey, domain.JobCompleted
+	return nil
+}
+
+func (j jobs) Fail(ctx context.Context, jobId string, failure *domain.JobFailure) error {
+	job, ok := j.harness.jobs[jobId]
+	if !ok || job.Status == domain.JobCompleted {
+		return repository.ErrRecordNotFound
+	}
+
+	job.Status = domain.JobFailed
+	j.failures[jobId] = failure
+	return nil
+}
+
+// repository.TranscriptRepository
+
+type transcripts struct{ *harness }
+
+func (x transcripts) Claim(ctx context.Context, transcription *domain.Transcription) (*domain.Transcription, error) {
+	if _, ok := x.harness.transcripts[transcription.JobId]; !ok {
+		var owner *domain.Metadata
+		for _, m := range x.metadata {
+			for _, key := range m.AudioKeys() {
+				if m.UserId == transcription.UserId && key == transcription.AudioKey {
+					owner = m
+				}
+			}
+		}
+		if owner == nil {
+			return nil, repository.ErrRecordNotFound
+		}
+
+		stored := *transcription
+		stored.MetadataId, stored.Status = owner.Id, domain.JobProcessing
+		x.harness.transcripts[transcription.JobId] = &stored
+	}
+
+	found := *x.harness.transcripts[transcription.JobId]
+	return &found, nil
+}
+
+func (x transcripts) Complete(ctx context.Context, transcription *domain.Transcription) error {
+	stored, ok := x.harness.transcripts[transcription.JobId]
+	if !ok {
+		return repository.ErrRecordNotFound
+	}
+
+	transcription.Status = domain.JobCompleted
+	*stored = *transcription
+	return nil
+}
+
+func (x transcripts) Fail(ctx context.Context, jobId string, failure *domain.JobFailure) error {
+	stored, ok := x.harness.transcripts[jobId]
+	if !ok || stored.Status == domain.JobCompleted {
+		return repository.ErrRecordNotFound
+	}
+
+	stored.Status = domain.JobFailed
+	x.failures[jobId] = failure
+	return nil
+}
+
+// repository.StreamRepository
+
+type streams struct{ *harness }
+
+func (st streams) Claim(ctx context.Context, transcoding *domain.Transcoding) (*domain.Transcoding, error) {
+	if _, ok := st.harness.streams[transcoding.JobId]; !ok {
+		owner, ok := st.metadata[transcoding.MetadataId]
+		if !ok || owner.UserId != transcoding.UserId {
+			return nil, repository.ErrRecordNotFound
+		}
+
+		stored := *transcoding
+		stored.Status = domain.JobProcessing
+		st.harness.streams[transcoding.JobId] = &stored
+	}
+
+	found := *st.harness.streams[transcoding.JobId]
+	return &found, nil
+}
+
+func (st streams) Complete(ctx context.Context, transcoding *domain.Transcoding) error {
+	stored, ok := st.harness.streams[transcoding.JobId]
+	if !ok {
+		return repository.ErrRecordNotFound
+	}
+
+	transcoding.Status = domain.JobCompleted
+	*stored = *transcoding
+	st.metadata[stored.MetadataId].StreamKey = stored.PlaylistKey
+	return nil
+}
+
+func (st streams) Fail(ctx context.Context, jobId string, failure *domain.JobFailure) error {
+	stored, ok := st.harness.streams[jobId]
+	if !ok || stored.Status == domain.JobCompleted {
+		return repository.ErrRecordNotFound
+	}
+
+	stored.Status = domain.JobFailed
+	st.failures[jobId] = failure
+	return nil
+}
+
+// repository.PresetRepository
+
+type presets struct{ *harness }
+
+func (p presets) Create(ctx context.Context, preset *domain.Preset) error {
+	for _, stored := range p.harness.presets {
+		if stored.UserId == preset.UserId && stored.Name == preset.Name {
+			return repository.ErrDuplicateEntry
+		}
+	}
+
+	preset.Id = int64(len(p.harness.presets) + 1)
+	return p.save(preset)
+}
+
+func (p presets) Get(ctx context.Context, userId, id int64) (*domain.Preset, error) {
+	stored, ok := p.harness.presets[id]
+	if !ok || stored.UserId != userId {
+		return nil, repository.ErrRecordNotFound
+	}
+
+	found := *stored
+	return &found, nil
+}
+
+func (p presets) GetDefault(ctx context.Context, userId int64) (*domain.Preset, error) {
+	for _, stored := range p.harness.presets {
+		if stored.UserId == userId && stored.Default {
+			return p.Get(ctx, userId, stored.Id)
+		}
+	}
+	return nil, repository.ErrRecordNotFound
+}
+
+func (p presets) List(ctx context.Context, userId int64) ([]*domain.Preset, error) {
+	var found []*domain.Preset
+	for _, stored := range p.harness.presets {
+		if stored.UserId == userId {
+			preset := *stored
+			found = append(found, &preset)
+		}
+	}
+
+	slices.SortFunc(found, func(a, b *domain.Preset) int { return strings.Compare(a.Name, b.Name) })
+	return found, nil
+}
+
+func (p presets) Update(ctx context.Context, preset *domain.Preset) error {
+	if _, err := p.Get(ctx, preset.UserId, preset.Id); err != nil {
+		return err
+	}
+	return p.save(preset)
+}
+
+func (p presets) Delete(ctx context.Context, userId, id int64) error {
+	if _, err := p.Get(ctx, userId, id); err != nil {
+		return err
+	}
+
+	delete(p.harness.presets, id)
+	return nil
+}
+
+// save stores the preset, a default one takes over from the earlier default of its user.
+func (p presets) save(preset *domain.Preset) error {
+	if preset.Default {
+		for _, stored := range p.harness.presets {
+			if stored.UserId == preset.UserId {
+				stored.Default = false
+			}
+		}
+	}
+
+	stored := *preset
+	p.harness.presets[preset.Id] = &stored
+	return nil
+}
+
+// setup stores an uploaded video and returns its file key and encrypted filename.
+// The service draws no waveforms.
+func setup(t *testing.T) (*harness, ConverterService, string, string) {
+	t.Helper()
+	return setupWaveform(t, domain.WaveformConfig{})
+}
+
+// setupWaveform is setup with a service drawing waveforms as the config says.
+func setupWaveform(t *testing.T, waveform domain.WaveformConfig) (*harness, ConverterService, string, string) {
+	t.Helper()
+	return setupService(t, waveform, domain.ThumbnailConfig{})
+}
+
+// setupThumbnail is setup with a service drawing the pictures of videos as the config says.
+func setupThumbnail(t *testing.T, thumbnail domain.ThumbnailConfig) (*harness, ConverterService, string, string) {
+	t.Helper()
+	return setupService(t, domain.WaveformConfig{}, thumbnail)
+}
+
+func setupService(t *testing.T, waveform domain.WaveformConfig, thumbnail domain.ThumbnailConfig) (*harness, ConverterService, string, string) {
+	t.Helper()
+
+	en, err := encryptor.NewEncryptor("0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef")
+	if err != nil {
+		t.Fatalf("Failed to create encryptor: %v", err)
+	}
+
+	name, err := en.Encrypt("lecture.mp4")
+	if err != nil {
+		t.Fatalf("Failed to encrypt filename: %v", err)
+	}
+
+	filekey := uuid.NewString()
+
+	h := newHarness()
+	h.put("mp4", filekey+".mp4", "video")
+
+	return h, NewConverterService(h, h, h, h, jobs{h}, transcripts{h}, streams{h}, presets{h}, en, "mp4", "mp3", waveform, thumbnail), filekey, name
+}
+
+func TestConvertMP4Redelivery(t *testing.T) {
+	tests := []struct {
+		stage string
+		// conversions and uploads expected once the redelivery succeeds
+		conversions int
+		uploads     int
+	}{
+		{stage: "claim", conversions: 1, uploads: 1},
+		{stage: "read", conversions: 1, uploads: 1},
+		{stage: "convert", conversions: 1, uploads: 1},
+		{stage: "upload", conversions: 2, uploads: 1},
+		// the audio was uploaded but never recorded, so it is uploaded again
+		{stage: "set_audio", conversions: 2, uploads: 2},
+		{stage: "complete", conversions: 1, uploads: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.stage, func(t *testing.T) {
+			h, svc, filekey, name := setup(t)
+			ctx := context.Background()
+			h.fails[tt.stage] = 1
+
+			if _, err := svc.ConvertMP4(ctx, "job-1", 1, 5, filekey, name, domain.Options{}, nil); err == nil {
+				t.Fatalf("Expected the first delivery to fail at %s", tt.stage)
+			}
+
+			first, err := svc.ConvertMP4(ctx, "job-1", 1, 5, filekey, name, domain.Options{}, nil)
+			if err != nil {
+				t.Fatalf("Redelivery failed: %v", err)
+			}
+
+			// the message is redelivered again after the ack got lost
+			again, err := svc.ConvertMP4(ctx, "job-1", 1, 5, filekey, name, domain.Options{}, nil)
+			if err != nil {
+				t.Fatalf("Redelivery of a completed job failed: %v", err)
+			}
+
+			if !reflect.DeepEqual(again, first) {
+				t.Errorf("Expected the existing result %+v, got %+v", first, again)
+			}
+
+			if len(h.metadata) != 1 {
+				t.Errorf("Expected 1 metadata row, got %d", len(h.metadata))
+			}
+
+			if h.conversions != tt.conversions {
+				t.Errorf("Expected %d conversions, got %d", tt.conversions, h.conversions)
+			}
+
+			if h.uploads != tt.uploads {
+				t.Errorf("Expected %d uploads, got %d", tt.uploads, h.uploads)
+			}
+
+			if first.FileName != "lecture.mp4" || first.EncryptedFileName != name || first.VideoKey != filekey || first.AudioKey == "" {
+				t.Errorf("Unexpected metadata: %+v", first)
+			}
+
+			// the audio key is random rather than derived from the converted file
+			if key, ext, _ := strings.Cut(first.AudioKey, "."); uuid.Validate(key) != nil || ext != "mp3" {
+				t.Errorf("Expected a UUID audio key with its extension, got %q", first.AudioKey)
+			}
+
+			if job := h.jobs["job-1"]; job.Status != domain.JobCompleted || job.MetadataId != first.Id {
+				t.Errorf("Expected job to be completed with metadata %d, got %+v", first.Id, job)
+			}
+		})
+	}
+}
+
+func TestConvertMP4RetryableErrors(t *testing.T) {
+	for _, stage := range []string{"claim", "set_audio", "complete"} {
+		t.Run(stage, func(t *testing.T) {
+			h, svc, filekey, name := setup(t)
+			h.fails[stage] = 1
+
+			// bookkeeping failures must be retried, otherwise the job is stuck half done
+			if _, err := svc.ConvertMP4(context.Background(), "job-1", 1, 5, filekey, name, domain.Options{}, nil); !errors.Is(err, ErrInternal) {
+				t.Errorf("Expected ErrInternal, got %v", err)
+			}
+		})
+	}
+}
+
+func TestConvertMP4ConcurrentDelivery(t *testing.T) {
+	h, svc, filekey, name := setup(t)
+	ctx := context.Background()
+
+	// a second delivery of the same job completes while the first is about to
+	var concurrent *domain.Metadata
+	h.beforeComplete = func() {
+		var err error
+		if concurrent, err = svc.ConvertMP4(ctx, "job-1", 1, 5, filekey, name, domain.Options{}, nil); err != nil {
+			t.Fatalf("Concurrent delivery failed: %v", err)
+		}
+	}
+
+	result, err := svc.ConvertMP4(ctx, "job-1", 1, 5, filekey, name, domain.Options{}, nil)
+	if err != nil {
+		t.Fatalf("Expected the losing delivery to return the existing result, got %v", err)
+	}
+
+	if concurrent == nil || !reflect.DeepEqual(result, concurrent) {
+		t.Errorf("Expected %+v, got %+v", concurrent, result)
+	}
+
+	if len(h.metadata) != 1 {
+		t.Errorf("Expected 1 metadata row, got %d", len(h.metadata))
+	}
+}
+
+func TestConvertMP4LegacyKey(t *testing.T) {
+	h, svc, _, name := setup(t)
+
+	// videos uploaded before random keys are stored under the encrypted filename
+	h.put("mp4", name+".mp4", "video")
+
+	var done float64
+	result, err := svc.ConvertMP4(context.Background(), "job-1", 1, 5, name, "", domain.Options{}, func(d float64) { done = d })
+	if err != nil {
+		t.Fatalf("ConvertMP4 failed: %v", err)
+	}
+
+	if done != 1 {
+		t.Errorf("Expected the progress to reach 1, got %v", done)
+	}
+
+	if result.FileName != "lecture.mp4" || result.EncryptedFileName != name || result.VideoKey != name {
+		t.Errorf("Unexpected metadata: %+v", result)
+	}
+}
+
+func TestConvertMP4Loudness(t *testing.T) {
+	t.Run("measurement is saved", func(t *testing.T) {
+		h, svc, filekey, name := setup(t)
+
+		result, err := svc.ConvertMP4(context.Background(), "job-1", 1, 5, filekey, name, domain.Options{Loudness: "podcast"}, nil)
+		if err != nil {
+			t.Fatalf("ConvertMP4 failed: %v", err)
+		}
+
+		if result.Loudness == nil || result.Loudness.Preset != "podcast" || result.Loudness.OutputIntegrated != -16 {
+			t.Errorf("Expected the podcast measurement, got %+v", result.Loudness)
+		}
+
+		if saved := h.metadata[result.Id]; saved.Loudness != result.Loudness {
+			t.Errorf("Expected the measurement to be saved, got %+v", saved.Loudness)
+		}
+	})
+
+	t.Run("unknown preset", func(t *testing.T) {
+		h, svc, filekey, name := setup(t)
+
+		_, err := svc.ConvertMP4(context.Background(), "job-1", 1, 5, filekey, name, domain.Options{Loudness: "loud"}, nil)
+		if !errors.Is(err, domain.ErrInvalidOptions) || errors.Is(err, ErrInternal) {
+			t.Errorf("Expected ErrInvalidOptions alone, got %v", err)
+		}
+
+		if h.conversions != 0 || len(h.jobs) != 0 {
+			t.Errorf("Expected nothing to be converted, got %d conversions and %d jobs", h.conversions, len(h.jobs))
+		}
+	})
+}
+
+func TestConvertMP4Chapters(t *testing.T) {
+	h, svc, filekey, name := setup(t)
+	ctx := context.Background()
+	opts := domain.Options{SplitChapters: true}
+
+	// the parts are stored but the job is lost before its metadata is saved
+	h.fails["complete"] = 1
+	if _, err := svc.ConvertMP4(ctx, "job-1", 1, 5, filekey, name, opts, nil); err == nil {
+		t.Fatal("Expected the first delivery to fail")
+	}
+
+	result, err := svc.ConvertMP4(ctx, "job-1", 1, 5, filekey, name, opts, nil)
+	if err != nil {
+		t.Fatalf("Redelivery failed: %v", err)
+	}
+
+	if h.conversions != 1 || h.uploads != 2 {
+		t.Errorf("Expected the parts to be converted and uploaded once, got %d conversions and %d uploads", h.conversions, h.uploads)
+	}
+
+	if len(result.Parts) != 2 || result.Parts[0].Title != "Intro" || result.Parts[1].Start != time.Minute {
+		t.Fatalf("Expected the two chapters, got %+v", result.Parts)
+	}
+
+	if result.AudioKey != result.Parts[0].AudioKey {
+		t.Errorf("Expected the audio key of the first part, got %q", result.AudioKey)
+	}
+
+	for _, key := range result.AudioKeys() {
+		if !h.has("mp3", key) {
+			t.Errorf("Expected part %s to be stored", key)
+		}
+	}
+}
+
+func TestConvertMP4Waveform(t *testing.T) {
+	t.Run("stored next to the audio", func(t *testing.T) {
+		h, svc, filekey, name := setupWaveform(t, domain.WaveformConfig{PixelsPerSecond: 20, Width: 600, Height: 80})
+
+		result, err := svc.ConvertMP4(context.Background(), "job-1", 1, 5, filekey, name, domain.Options{}, nil)
+		if err != nil {
+			t.Fatalf("ConvertMP4 failed: %v", err)
+		}
+
+		id := strings.TrimSuffix(result.AudioKey, ".mp3")
+		if result.PeaksKey != id+".peaks.json" || result.WaveformKey != id+".waveform.png" {
+			t.Fatalf("Expected the waveform next to %s, got %q and %q", result.AudioKey, result.PeaksKey, result.WaveformKey)
+		}
+
+		for _, key := range []string{result.PeaksKey, result.WaveformKey} {
+			if !h.has("mp3", key) {
+				t.Errorf("Expected %s to be stored", key)
+			}
+		}
+
+		if saved := h.metadata[result.Id]; saved.PeaksKey != result.PeaksKey {
+			t.Errorf("Expected the peaks to be saved, got %q", saved.PeaksKey)
+		}
+	})
+
+	t.Run("each part gets one", func(t *testing.T) {
+		_, svc, filekey, name := setupWaveform(t, domain.WaveformConfig{PixelsPerSecond: 20})
+
+		result, err := svc.ConvertMP4(context.Background(), "job-1", 1, 5, filekey, name, domain.Options{SplitChapters: true}, nil)
+		if err != nil {
+			t.Fatalf("ConvertMP4 failed: %v", err)
+		}
+
+		for _, p := range result.Parts {
+			if p.PeaksKey != strings.TrimSuffix(p.AudioKey, ".mp3")+".peaks.json" || p.WaveformKey != "" {
+				t.Errorf("Expected only peaks for part %s, got %q and %q", p.AudioKey, p.PeaksKey, p.WaveformKey)
+			}
+		}
+	})
+
+	t.Run("failing to draw keeps the audio", func(t *testing.T) {
+		h, svc, filekey, name := setupWaveform(t, domain.WaveformConfig{PixelsPerSecond: 20})
+		h.fails["waveform"] = 1
+
+		result, err := svc.ConvertMP4(context.Background(), "job-1", 1, 5, filekey, name, domain.Options{}, nil)
+		if err != nil {
+			t.Fatalf("Expected the conversion to succeed, got %v", err)
+		}
+
+		if result.PeaksKey != "" || !h.has("mp3", result.AudioKey) {
+			t.Errorf("Expected the audio without a waveform, got %+v", result)
+		}
+	})
+}
+
+func TestConvertMP4Timeout(t *testing.T) {
+	h, svc, filekey, name := setup(t)
+	h.convertErr = &domain.FFmpegError{Err: fmt.Errorf("%w after 30m0s", domain.ErrTimeout)}
+
+	_, err := svc.ConvertMP4(context.Background(), "job-1", 1, 5, filekey, name, domain.Options{}, nil)
+	if !errors.Is(err, domain.ErrTimeout) || errors.Is(err, ErrInternal) {
+		t.Errorf("Expected the timeout to fail the job for good, got %v", err)
+	}
+
+	// consumers that don't know it yet fall back to the reason of v1
+	if failure := svc.Failure(&events.VideoUploaded{}, err); failure.Why() != events.ReasonTimedOut || failure.Reason != events.ReasonInternal {
+		t.Errorf("Expected reason %q of %q, got %+v", events.ReasonTimedOut, events.ReasonInternal, failure)
+	}
+}
+
+func TestRecordFailure(t *testing.T) {
+	h, svc, filekey, _ := setup(t)
+	ctx := context.Background()
+	h.jobs["job-1"] = &domain.Job{Id: "job-1", VideoKey: filekey, Status: domain.JobProcessing}
+
+	ffErr := &domain.FFmpegError{Err: domain.ErrTimeout, Stderr: "frame=  100 time=00:10:00.00"}
+	if err := svc.RecordFailure(ctx, "job-1", fmt.Errorf("failed to convert video: %w", ffErr)); err != nil {
+		t.Fatalf("RecordFailure failed: %v", err)
+	}
+
+	failure := h.failures["job-1"]
+	if h.jobs["job-1"].Status != domain.JobFailed || failure == nil {
+		t.Fatalf("Expected the job to be failed, got %+v", h.jobs["job-1"])
+	}
+
+	if failure.Reason != events.ReasonTimedOut || failure.Stderr != ffErr.Stderr || !strings.Contains(failure.Error, domain.ErrTimeout.Error()) {
+		t.Errorf("Unexpected failure record: %+v", failure)
+	}
+
+	// a job that completed in the meantime keeps its result
+	h.jobs["job-2"] = &domain.Job{Id: "job-2", Status: domain.JobCompleted}
+	if err := svc.RecordFailure(ctx, "job-2", domain.ErrCorruptFile); !errors.Is(err, repository.ErrRecordNotFound) {
+		t.Errorf("Expected ErrRecordNotFound, got %v", err)
+	}
+}
+
+// converted runs a conversion of the uploaded video for user 1 and returns its result.
+func converted(t *testing.T, svc ConverterService, filekey, name string) *domain.Metadata {
+	t.Helper()
+
+	result, err := svc.ConvertMP4(context.Background(), "job-1", 1, 5, filekey, name, domain.Options{}, nil)
+	if err != nil {
+		t.Fatalf("ConvertMP4 failed: %v", err)
+	}
+	return result
+}
+
+func TestTranscribe(t *testing.T) {
+	t.Run("stored next to the audio", func(t *testing.T) {
+		h, svc, filekey, name := setup(t)
+		result := converted(t, svc, filekey, name)
+
+		tr, err := svc.Transcribe(context.Background(), "transcript-1", 1, result.AudioKey, "")
+		if err != nil {
+			t.Fatalf("Transcribe failed: %v", err)
+		}
+
+		id := strings.TrimSuffix(result.AudioKey, ".mp3")
+		if tr.SRTKey != id+".srt" || tr.VTTKey != id+".vtt" || tr.TextKey != id+".txt" {
+			t.Fatalf("Expected the transcripts next to %s, got %+v", result.AudioKey, tr)
+		}
+
+		r, err := h.FileStore.Read(context.Background(), "mp3", tr.VTTKey)
+		if err != nil {
+			t.Fatalf("Expected %s to be stored: %v", tr.VTTKey, err)
+		}
+		defer r.Close()
+
+		vtt, _ := io.ReadAll(r)
+		if !strings.HasPrefix(string(vtt), "WEBVTT") || !strings.Contains(string(vtt), "Hello there.") {
+			t.Errorf("Unexpected vtt transcript: %q", vtt)
+		}
+
+		if stored := h.transcripts["transcript-1"]; stored.Status != domain.JobCompleted || stored.MetadataId != result.Id {
+			t.Errorf("Expected the transcription to be completed for metadata %d, got %+v", result.Id, stored)
+		}
+	})
+
+	t.Run("redelivery returns the transcripts", func(t *testing.T) {
+		h, svc, filekey, name := setup(t)
+		result := converted(t, svc, filekey, name)
+
+		first, err := svc.Transcribe(context.Background(), "transcript-1", 1, result.AudioKey, "fr")
+		if err != nil {
+			t.Fatalf("Transcribe failed: %v", err)
+		}
+
+		again, err := svc.Transcribe(context.Background(), "transcript-1", 1, result.AudioKey, "fr")
+		if err != nil {
+			t.Fatalf("Redelivery failed: %v", err)
+		}
+
+		if !reflect.DeepEqual(again, first) || h.transcriptions != 1 {
+			t.Errorf("Expected the existing transcripts %+v after 1 transcription, got %+v after %d", first, again, h.transcriptions)
+		}
+	})
+
+	t.Run("audio of someone else", func(t *testing.T) {
+		_, svc, filekey, name := setup(t)
+		result := converted(t, svc, filekey, name)
+
+		if _, err := svc.Transcribe(context.Background(), "transcript-1", 2, result.AudioKey, ""); !errors.Is(err, ErrAudioNotFound) {
+			t.Errorf("Expected ErrAudioNotFound, got %v", err)
+		}
+	})
+
+	t.Run("unavailable engine is retried", func(t *testing.T) {
+		h, svc, filekey, name := setup(t)
+		result := converted(t, svc, filekey, name)
+		h.engineErr = fmt.Errorf("%w: connection refused", transcriber.ErrUnavailable)
+
+		if _, err := svc.Transcribe(context.Background(), "transcript-1", 1, result.AudioKey, ""); !errors.Is(err, ErrInternal) {
+			t.Errorf("Expected ErrInternal, got %v", err)
+		}
+
+		// a permanent failure is recorded, the transcription can't complete anymore
+		h.engineErr = errors.New("unsupported audio")
+		_, err := svc.Transcribe(context.Background(), "transcript-1", 1, result.AudioKey, "")
+		if err == nil || errors.Is(err, ErrInternal) {
+			t.Fatalf("Expected a permanent error, got %v", err)
+		}
+
+		if err = svc.RecordTranscriptionFailure(context.Background(), "transcript-1", err); err != nil {
+			t.Fatalf("RecordTranscriptionFailure failed: %v", err)
+		}
+
+		if stored := h.transcripts["transcript-1"]; stored.Status != domain.JobFailed || h.failures["transcript-1"] == nil {
+			t.Errorf("Expected the transcription to be failed, got %+v", stored)
+		}
+	})
+
+	t.Run("invalid language", func(t *testing.T) {
+		_, svc, _, _ := setup(t)
+
+		if _, err := svc.Transcribe(context.Background(), "transcript-1", 1, "audio.mp3", "english"); !errors.Is(err, domain.ErrInvalidOptions) {
+			t.Errorf("Expected ErrInvalidOptions, got %v", err)
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		h := newHarness()
+		svc := NewConverterService(h, nil, h, h, jobs{h}, transcripts{h}, streams{h}, presets{h}, nil, "mp4", "mp3", domain.WaveformConfig{}, domain.ThumbnailConfig{})
+
+		if _, err := svc.Transcribe(context.Background(), "transcript-1", 1, "audio.mp3", ""); !errors.Is(err, ErrTranscriptionDisabled) {
+			t.Errorf("Expected ErrTranscriptionDisabled, got %v", err)
+		}
+	})
+}
+
+func TestReencode(t *testing.T) {
+	t.Run("saved as a new conversion", func(t *testing.T) {
+		h, svc, filekey, name := setup(t)
+		parent := converted(t, svc, filekey, name)
+
+		result, err := svc.Reencode(context.Background(), "job-2", 1, parent.AudioKey, domain.Reencoding{Format: "aac", Bitrate: 96, Loudness: "podcast"}, nil)
+		if err != nil {
+			t.Fatalf("Reencode failed: %v", err)
+		}
+
+		if result.ParentId != parent.Id || result.Id == parent.Id || result.VideoKey != parent.VideoKey || result.FileName != "lecture.mp4" {
+			t.Errorf("Expected a new conversion linked to %d, got %+v", parent.Id, result)
+		}
+
+		if !strings.HasSuffix(result.AudioKey, ".aac") || !h.has("mp3", result.AudioKey) || result.Loudness == nil {
+			t.Errorf("Expected normalized aac to be stored, got %+v", result)
+		}
+
+		// the audio it came from is left as it is
+		if !h.has("mp3", parent.AudioKey) || h.metadata[parent.Id].AudioKey != parent.AudioKey {
+			t.Errorf("Expected %s to be kept", parent.AudioKey)
+		}
+
+		if job := h.jobs["job-2"]; job.SourceAudioKey != parent.AudioKey || job.MetadataId != result.Id {
+			t.Errorf("Expected the job to name the audio it encoded again, got %+v", job)
+		}
+	})
+
+	t.Run("redelivery", func(t *testing.T) {
+		h, svc, filekey, name := setup(t)
+		parent := converted(t, svc, filekey, name)
+		h.fails["complete"] = 1
+
+		if _, err := svc.Reencode(context.Background(), "job-2", 1, parent.AudioKey, domain.Reencoding{}, nil); !errors.Is(err, ErrInternal) {
+			t.Fatalf("Expected the first delivery to be retried, got %v", err)
+		}
+
+		first, err := svc.Reencode(context.Background(), "job-2", 1, parent.AudioKey, domain.Reencoding{}, nil)
+		if err != nil {
+			t.Fatalf("Redelivery failed: %v", err)
+		}
+
+		again, err := svc.Reencode(context.Background(), "job-2", 1, parent.AudioKey, domain.Reencoding{}, nil)
+		if err != nil {
+			t.Fatalf("Redelivery of a completed job failed: %v", err)
+		}
+
+		// the stored audio is picked up again rather than encoded twice
+		if !reflect.DeepEqual(again, first) || h.conversions != 2 || len(h.metadata) != 2 {
+			t.Errorf("Expected the existing result %+v after 2 conversions, got %+v after %d", first, again, h.conversions)
+		}
+	})
+
+	t.Run("audio of someone else", func(t *testing.T) {
+		_, svc, filekey, name := setup(t)
+		parent := converted(t, svc, filekey, name)
+
+		if _, err := svc.Reencode(context.Background(), "job-2", 2, parent.AudioKey, domain.Reencoding{}, nil); !errors.Is(err, ErrAudioNotFound) {
+			t.Errorf("Expected ErrAudioNotFound, got %v", err)
+		}
+	})
+
+	t.Run("invalid re-encoding", func(t *testing.T) {
+		_, svc, filekey, name := setup(t)
+		parent := converted(t, svc, filekey, name)
+
+		if _, err := svc.Reencode(context.Background(), "job-2", 1, parent.AudioKey, domain.Reencoding{Format: "flac"}, nil); !errors.Is(err, domain.ErrInvalidOptions) {
+			t.Errorf("Expected ErrInvalidOptions, got %v", err)
+		}
+	})
+}
+
+func TestThumbnail(t *testing.T) {
+	t.Run("stored with the conversion", func(t *testing.T) {
+		h, svc, filekey, name := setupThumbnail(t, domain.ThumbnailConfig{Width: 480})
+		result := converted(t, svc, filekey, name)
+
+		thumbnailKey, previewKey, err := svc.Thumbnail(context.Background(), result.Id, result.VideoKey, result.AudioKey, 5, false)
+		if err != nil {
+			t.Fatalf("Thumbnail failed: %v", err)
+		}
+
+		if thumbnailKey != thumbnailObject(result.AudioKey, ".jpg") || previewKey != "" || !h.has("mp3", thumbnailKey) {
+			t.Errorf("Expected a stored poster only, got %q and %q", thumbnailKey, previewKey)
+		}
+
+		if m := h.metadata[result.Id]; m.ThumbnailKey != thumbnailKey || m.PreviewKey != "" {
+			t.Errorf("Expected the poster to be recorded, got %+v", m)
+		}
+	})
+
+	t.Run("preview", func(t *testing.T) {
+		h, svc, filekey, name := setupThumbnail(t, domain.ThumbnailConfig{Width: 480, PreviewFormat: domain.PreviewWebP, PreviewDuration: 3 * time.Second})
+		result := converted(t, svc, filekey, name)
+
+		// without asking for it, only the poster is drawn
+		if _, previewKey, err := svc.Thumbnail(context.Background(), result.Id, result.VideoKey, result.AudioKey, 5, false); err != nil || previewKey != "" {
+			t.Fatalf("Expected no preview, got %q, %v", previewKey, err)
+		}
+
+		_, previewKey, err := svc.Thumbnail(context.Background(), result.Id, result.VideoKey, result.AudioKey, 5, true)
+		if err != nil {
+			t.Fatalf("Thumbnail failed: %v", err)
+		}
+
+		if previewKey != thumbnailObject(result.AudioKey, ".webp") || !h.has("mp3", previewKey) || h.metadata[result.Id].PreviewKey != previewKey {
+			t.Errorf("Expected the preview to be stored and recorded, got %q", previewKey)
+		}
+	})
+
+	t.Run("redelivery", func(t *testing.T) {
+		h, svc, filekey, name := setupThumbnail(t, domain.ThumbnailConfig{Width: 480})
+		result := converted(t, svc, filekey, name)
+		h.fails["upload"] = 1
+
+		if _, _, err := svc.Thumbnail(context.Background(), result.Id, result.VideoKey, result.AudioKey, 5, false); !errors.Is(err, errCrash) {
+			t.Fatalf("Expected the crash, got %v", err)
+		}
+
+		// the poster is drawn and stored again over what the crash left
+		if thumbnailKey, _, err := svc.Thumbnail(context.Background(), result.Id, result.VideoKey, result.AudioKey, 5, false); err != nil || h.metadata[result.Id].ThumbnailKey != thumbnailKey {
+			t.Errorf("Redelivery failed: %v", err)
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		_, svc, filekey, name := setup(t)
+		result := converted(t, svc, filekey, name)
+
+		if _, _, err := svc.Thumbnail(context.Background(), result.Id, result.VideoKey, result.AudioKey, 5, false); !errors.Is(err, ErrThumbnailDisabled) {
+			t.Errorf("Expected ErrThumbnailDisabled, got %v", err)
+		}
+	})
+}
+
+func TestStream(t *testing.T) {
+	t.Run("stored under the audio", func(t *testing.T) {
+		h, svc, filekey, name := setup(t)
+		result := converted(t, svc, filekey, name)
+
+		tc, err := svc.Stream(context.Background(), "stream-1", 1, result.Id, result.VideoKey, result.AudioKey, 5, domain.Streaming{}, nil)
+		if err != nil {
+			t.Fatalf("Stream failed: %v", err)
+		}
+
+		if tc.Status != domain.JobCompleted || tc.PlaylistKey != streamObject(result.AudioKey, domain.MasterPlaylist) {
+			t.Errorf("Unexpected transcoding: %+v", tc)
+		}
+
+		for _, name := range []string{"audio/index.m3u8", "audio/segment000.ts", "audio/segment001.ts", domain.MasterPlaylist} {
+			if !h.has("mp3", streamObject(result.AudioKey, name)) {
+				t.Errorf("Expected %s to be stored", streamObject(result.AudioKey, name))
+			}
+		}
+
+		if h.metadata[result.Id].StreamKey != tc.PlaylistKey {
+			t.Errorf("Expected the stream to be recorded, got %+v", h.metadata[result.Id])
+		}
+	})
+
+	t.Run("redelivery", func(t *testing.T) {
+		h, svc, filekey, name := setup(t)
+		result := converted(t, svc, filekey, name)
+		h.fails["upload"] = 1
+
+		if _, err := svc.Stream(context.Background(), "stream-1", 1, result.Id, result.VideoKey, result.AudioKey, 5, domain.Streaming{}, nil); !errors.Is(err, errCrash) {
+			t.Fatalf("Expected the crash, got %v", err)
+		}
+
+		first, err := svc.Stream(context.Background(), "stream-1", 1, result.Id, result.VideoKey, result.AudioKey, 5, domain.Streaming{}, nil)
+		if err != nil {
+			t.Fatalf("Redelivery failed: %v", err)
+		}
+
+		// a completed job isn't transcoded again
+		h.fails["stream"] = 1
+		again, err := svc.Stream(context.Background(), "stream-1", 1, result.Id, result.VideoKey, result.AudioKey, 5, domain.Streaming{}, nil)
+		if err != nil || !reflect.DeepEqual(again, first) {
+			t.Errorf("Expected the completed transcoding %+v, got %+v: %v", first, again, err)
+		}
+	})
+
+	t.Run("video of someone else", func(t *testing.T) {
+		_, svc, filekey, name := setup(t)
+		result := converted(t, svc, filekey, name)
+
+		if _, err := svc.Stream(context.Background(), "stream-1", 2, result.Id, result.VideoKey, result.AudioKey, 5, domain.Streaming{}, nil); !errors.Is(err, ErrVideoNotFound) {
+			t.Errorf("Expected ErrVideoNotFound, got %v", err)
+		}
+	})
+
+	t.Run("encrypted audio", func(t *testing.T) {
+		h, svc, filekey, name := setup(t)
+		result := converted(t, svc, filekey, name)
+
+		kr, err := envelope.ParseKeyring("1", "1:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef")
+		if err != nil {
+			t.Fatalf("Failed to parse keyring: %v", err)
+		}
+		h.FileStore = storage.NewEncryptedStore(h.FileStore, kr, "mp3")
+
+		_, err = svc.Stream(context.Background(), "stream-1", 1, result.Id, result.VideoKey, result.AudioKey, 5, domain.Streaming{}, nil)
+		if !errors.Is(err, ErrStreamEncrypted) || errors.Is(err, ErrInternal) {
+			t.Fatalf("Expected the transcoding to be refused for good, got %v", err)
+		}
+
+		if h.has("mp3", streamObject(result.AudioKey, domain.MasterPlaylist)) {
+			t.Errorf("Expected nothing to be stored")
+		}
+	})
+
+	t.Run("failure recorded", func(t *testing.T) {
+		h, svc, filekey, name := setup(t)
+		result := converted(t, svc, filekey, name)
+		h.fails["stream"] = 1
+
+		_, err := svc.Stream(context.Background(), "stream-1", 1, result.Id, result.VideoKey, result.AudioKey, 5, domain.Streaming{}, nil)
+		if err == nil || errors.Is(err, ErrInternal) {
+			t.Fatalf("Expected the transcoding to fail for good, got %v", err)
+		}
+
+		if err = svc.RecordStreamFailure(context.Background(), "stream-1", err); err != nil {
+			t.Fatalf("Failed to record failure: %v", err)
+		}
+
+		if h.streams["stream-1"].Status != domain.JobFailed || h.failures["stream-1"] == nil {
+			t.Errorf("Expected the transcoding to be failed, got %+v", h.streams["stream-1"])
+		}
+	})
+}
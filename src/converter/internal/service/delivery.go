@@ -0,0 +1,51 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/ziliscite/video-to-mp3/converter/internal/repository"
+	"github.com/ziliscite/video-to-mp3/platform/storage"
+)
+
+type DeliveryService interface {
+	// AudioURL returns a link to the audio that expires after the configured period.
+	AudioURL(ctx context.Context, audioKey string) (string, error)
+	// Invalidate stops the CDN from serving audio that was deleted or replaced.
+	Invalidate(ctx context.Context, audioKeys ...string) error
+}
+
+type deliveryService struct {
+	fp      storage.FilePresigner
+	cdn     repository.CDN
+	bucket  string
+	expires time.Duration
+	now     func() time.Time
+}
+
+// NewDeliveryService serves audio through the CDN, or through presigned storage URLs when cdn is nil.
+func NewDeliveryService(fp storage.FilePresigner, cdn repository.CDN, mp3Bucket string, expires time.Duration) DeliveryService {
+	return &deliveryService{
+		fp:      fp,
+		cdn:     cdn,
+		bucket:  mp3Bucket,
+		expires: expires,
+		now:     time.Now,
+	}
+}
+
+func (d *deliveryService) AudioURL(ctx context.Context, audioKey string) (string, error) {
+	if d.cdn == nil {
+		return d.fp.Presign(ctx, d.bucket, audioKey, d.expires)
+	}
+
+	return d.cdn.Sign(audioKey, d.now().Add(d.expires))
+}
+
+func (d *deliveryService) Invalidate(ctx context.Context, audioKeys ...string) error {
+	if d.cdn == nil || len(audioKeys) == 0 {
+		return nil
+	}
+
+	return d.cdn.Invalidate(ctx, audioKeys...)
+}
@@ -0,0 +1,48 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ziliscite/video-to-mp3/platform/storage"
+)
+
+func TestDelivery(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("signed by the cdn", func(t *testing.T) {
+		h := newHarness()
+		ds := NewDeliveryService(h, h, "mp3", time.Hour)
+
+		u, err := ds.AudioURL(ctx, "a.mp3")
+		if err != nil || u != "https://cdn.test/a.mp3" {
+			t.Errorf("Expected the cdn url, got %q, %v", u, err)
+		}
+
+		if err = ds.Invalidate(ctx, "a.mp3"); err != nil || len(h.invalidated) != 1 {
+			t.Errorf("Expected a.mp3 to be invalidated, got %v, %v", h.invalidated, err)
+		}
+	})
+
+	t.Run("presigned without a cdn", func(t *testing.T) {
+		h := newHarness()
+		h.put("mp3", "a.mp3", "audio")
+		ds := NewDeliveryService(h, nil, "mp3", time.Hour)
+
+		u, err := ds.AudioURL(ctx, "a.mp3")
+		if err != nil || !strings.HasSuffix(u, "/a.mp3") || strings.HasPrefix(u, "https://cdn.test") {
+			t.Errorf("Expected a presigned storage url, got %q, %v", u, err)
+		}
+
+		if _, err = ds.AudioURL(ctx, "missing.mp3"); !errors.Is(err, storage.ErrNotExist) {
+			t.Errorf("Expected ErrNotExist, got %v", err)
+		}
+
+		if err = ds.Invalidate(ctx, "a.mp3"); err != nil {
+			t.Errorf("Expected invalidation to be a no-op, got %v", err)
+		}
+	})
+}
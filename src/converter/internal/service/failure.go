@@ -0,0 +1,38 @@
+package service
+
+import (
+	"errors"
+
+	"github.com/ziliscite/video-to-mp3/converter/internal/domain"
+	"github.com/ziliscite/video-to-mp3/events"
+)
+
+// jobFailure records why a job was dropped, with the end of ffmpeg's output when ffmpeg failed.
+func jobFailure(err error) *domain.JobFailure {
+	failure := &domain.JobFailure{Reason: reasonOf(err), Error: err.Error()}
+
+	var ffErr *domain.FFmpegError
+	if errors.As(err, &ffErr) {
+		failure.Stderr = ffErr.Stderr
+	}
+	return failure
+}
+
+// reasonOf maps a conversion error to its user-safe category.
+func reasonOf(err error) string {
+	switch {
+	case errors.Is(err, domain.ErrUnsupportedCodec):
+		return events.ReasonUnsupportedCodec
+	case errors.Is(err, domain.ErrCorruptFile):
+		return events.ReasonCorruptFile
+	case errors.Is(err, domain.ErrTooLong):
+		return events.ReasonTooLong
+	case errors.Is(err, domain.ErrTimeout):
+		// it would take as long again, so it's never retried
+		return events.ReasonTimedOut
+	case errors.Is(err, ErrPresetNotFound):
+		return events.ReasonPresetNotFound
+	default:
+		return events.ReasonInternal
+	}
+}
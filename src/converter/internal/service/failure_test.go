@@ -0,0 +1,33 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/ziliscite/video-to-mp3/converter/internal/domain"
+	"github.com/ziliscite/video-to-mp3/events"
+)
+
+func TestReasonOf(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"unsupported codec", fmt.Errorf("failed to convert video: %w", domain.ErrUnsupportedCodec), events.ReasonUnsupportedCodec},
+		{"corrupt file", fmt.Errorf("failed to convert video: %w", domain.ErrCorruptFile), events.ReasonCorruptFile},
+		{"too long", fmt.Errorf("failed to convert video: %w", domain.ErrTooLong), events.ReasonTooLong},
+		{"timed out", fmt.Errorf("failed to convert video: %w", &domain.FFmpegError{Err: domain.ErrTimeout}), events.ReasonTimedOut},
+		{"preset not found", fmt.Errorf("%w: %d", ErrPresetNotFound, 7), events.ReasonPresetNotFound},
+		{"anything else", errors.New("failed to run ffmpeg"), events.ReasonInternal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := reasonOf(tt.err); got != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/ziliscite/video-to-mp3/converter/internal/repository"
+	"github.com/ziliscite/video-to-mp3/platform/storage"
+)
+
+// MaxConversions is how many conversions are listed at once at most.
+const MaxConversions = 100
+
+// LibraryService lists the conversions of users with links to their files.
+type LibraryService interface {
+	// Conversions returns at most limit of the user's conversions still in storage, newest first.
+	// Only the ones older than the conversion of the before id are listed when it isn't zero.
+	Conversions(ctx context.Context, userId, before int64, limit int) ([]*Conversion, error)
+}
+
+// Conversion is a finished conversion as its user sees it. The links expire like the ones to audio,
+// each is empty when its file wasn't made or can't be linked to.
+// PeaksURL links to the JSON peaks players draw the audio from, WaveformURL to its picture.
+type Conversion struct {
+	Id           int64            `json:"id"`
+	FileName     string           `json:"file_name"`
+	AudioURL     string           `json:"audio_url,omitempty"`
+	PeaksURL     string           `json:"peaks_url,omitempty"`
+	WaveformURL  string           `json:"waveform_url,omitempty"`
+	ThumbnailURL string           `json:"thumbnail_url,omitempty"`
+	PreviewURL   string           `json:"preview_url,omitempty"`
+	Parts        []ConversionPart `json:"parts,omitempty"`
+}
+
+// ConversionPart is the audio of one chapter of a conversion.
+type ConversionPart struct {
+	Title       string `json:"title,omitempty"`
+	AudioURL    string `json:"audio_url,omitempty"`
+	PeaksURL    string `json:"peaks_url,omitempty"`
+	WaveformURL string `json:"waveform_url,omitempty"`
+}
+
+type libraryService struct {
+	mr repository.MetadataReader
+	ds DeliveryService
+}
+
+// NewLibraryService links to the files the way ds links to audio, they're all stored next to it.
+func NewLibraryService(mr repository.MetadataReader, ds DeliveryService) LibraryService {
+	return &libraryService{mr: mr, ds: ds}
+}
+
+func (l *libraryService) Conversions(ctx context.Context, userId, before int64, limit int) ([]*Conversion, error) {
+	if limit <= 0 || limit > MaxConversions {
+		limit = MaxConversions
+	}
+
+	list, err := l.mr.ListByUser(ctx, userId, before, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list conversions: %w", err)
+	}
+
+	conversions := make([]*Conversion, len(list))
+	for i, m := range list {
+		conversion := &Conversion{
+			Id:           m.Id,
+			FileName:     m.FileName,
+			AudioURL:     l.link(ctx, m.Id, m.AudioKey),
+			PeaksURL:     l.link(ctx, m.Id, m.PeaksKey),
+			WaveformURL:  l.link(ctx, m.Id, m.WaveformKey),
+			ThumbnailURL: l.link(ctx, m.Id, m.ThumbnailKey),
+			PreviewURL:   l.link(ctx, m.Id, m.PreviewKey),
+		}
+
+		for _, p := range m.Parts {
+			conversion.Parts = append(conversion.Parts, ConversionPart{
+				Title:       p.Title,
+				AudioURL:    l.link(ctx, m.Id, p.AudioKey),
+				PeaksURL:    l.link(ctx, m.Id, p.PeaksKey),
+				WaveformURL: l.link(ctx, m.Id, p.WaveformKey),
+			})
+		}
+
+		conversions[i] = conversion
+	}
+
+	return conversions, nil
+}
+
+// link returns a link to the file of the key, empty when there's no file or it can't be linked to.
+func (l *libraryService) link(ctx context.Context, metadataId int64, key string) string {
+	if key == "" {
+		return ""
+	}
+
+	u, err := l.ds.AudioURL(ctx, key)
+	switch {
+	case errors.Is(err, storage.ErrEncrypted):
+		// none of the files of an envelope encrypted bucket can be linked to, the audio is downloaded instead
+		return ""
+	case err != nil:
+		slog.Warn("Failed to create url", "error", err, "metadata_id", metadataId, "key", key)
+		return ""
+	}
+
+	return u
+}
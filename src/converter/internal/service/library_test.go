@@ -0,0 +1,110 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ziliscite/video-to-mp3/converter/internal/domain"
+	"github.com/ziliscite/video-to-mp3/platform/envelope"
+	"github.com/ziliscite/video-to-mp3/platform/storage"
+)
+
+func TestConversions(t *testing.T) {
+	ctx := context.Background()
+
+	// library stores a conversion of user 1 with pictures, a split one of user 1 with peaks and one of user 2
+	library := func(t *testing.T) *harness {
+		t.Helper()
+
+		h := newHarness()
+		for _, m := range []*domain.Metadata{
+			{UserId: 1, FileName: "lecture.mp4", AudioKey: "a.mp3", PeaksKey: "a.json", ThumbnailKey: "thumbnails/a.jpg", PreviewKey: "thumbnails/a.webp"},
+			{UserId: 2, FileName: "talk.mp4", AudioKey: "b.mp3"},
+			{UserId: 1, FileName: "book.mp4", AudioKey: "c-1.mp3", Parts: []domain.Part{
+				{Chapter: domain.Chapter{Title: "One"}, AudioKey: "c-1.mp3"},
+				{Chapter: domain.Chapter{Title: "Two"}, AudioKey: "c-2.mp3", PeaksKey: "c-2.json", WaveformKey: "c-2.png"},
+			}},
+		} {
+			if err := h.Insert(ctx, m); err != nil {
+				t.Fatalf("Failed to insert metadata: %v", err)
+			}
+		}
+
+		// the preview of a.mp3 was never stored
+		for _, key := range []string{"a.mp3", "a.json", "thumbnails/a.jpg", "b.mp3", "c-1.mp3", "c-2.mp3", "c-2.json", "c-2.png"} {
+			h.put("mp3", key, "file")
+		}
+		return h
+	}
+
+	t.Run("links to the files", func(t *testing.T) {
+		h := library(t)
+		ls := NewLibraryService(h, NewDeliveryService(h, nil, "mp3", time.Hour))
+
+		conversions, err := ls.Conversions(ctx, 1, 0, 0)
+		if err != nil {
+			t.Fatalf("Failed to list conversions: %v", err)
+		}
+
+		if len(conversions) != 2 || conversions[0].Id != 3 || conversions[1].Id != 1 {
+			t.Fatalf("Expected the conversions of user 1 newest first, got %+v", conversions)
+		}
+
+		split, lecture := conversions[0], conversions[1]
+		if len(split.Parts) != 2 || split.Parts[1].Title != "Two" || !strings.HasSuffix(split.Parts[1].AudioURL, "/c-2.mp3") {
+			t.Errorf("Expected a link to each part, got %+v", split.Parts)
+		}
+
+		if two := split.Parts[1]; !strings.HasSuffix(two.PeaksURL, "/c-2.json") || !strings.HasSuffix(two.WaveformURL, "/c-2.png") {
+			t.Errorf("Expected links to the peaks and the waveform of the part, got %+v", two)
+		}
+
+		if split.Parts[0].PeaksURL != "" || split.PeaksURL != "" {
+			t.Errorf("Expected no links to peaks that weren't drawn, got %+v", split)
+		}
+
+		if !strings.HasSuffix(lecture.AudioURL, "/a.mp3") || !strings.HasSuffix(lecture.PeaksURL, "/a.json") || !strings.HasSuffix(lecture.ThumbnailURL, "/thumbnails/a.jpg") {
+			t.Errorf("Expected links to the audio, its peaks and the thumbnail, got %+v", lecture)
+		}
+
+		if lecture.PreviewURL != "" {
+			t.Errorf("Expected no link to a preview that isn't stored, got %q", lecture.PreviewURL)
+		}
+	})
+
+	t.Run("pages", func(t *testing.T) {
+		h := library(t)
+		ls := NewLibraryService(h, NewDeliveryService(h, nil, "mp3", time.Hour))
+
+		conversions, err := ls.Conversions(ctx, 1, 0, 1)
+		if err != nil || len(conversions) != 1 || conversions[0].Id != 3 {
+			t.Fatalf("Expected the newest conversion only, got %+v, %v", conversions, err)
+		}
+
+		conversions, err = ls.Conversions(ctx, 1, conversions[0].Id, 1)
+		if err != nil || len(conversions) != 1 || conversions[0].Id != 1 {
+			t.Errorf("Expected the conversion before it, got %+v, %v", conversions, err)
+		}
+	})
+
+	t.Run("encrypted audio", func(t *testing.T) {
+		h := library(t)
+
+		kr, err := envelope.ParseKeyring("1", "1:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef")
+		if err != nil {
+			t.Fatalf("Failed to parse keyring: %v", err)
+		}
+		ls := NewLibraryService(h, NewDeliveryService(storage.NewEncryptedStore(h.FileStore, kr, "mp3"), nil, "mp3", time.Hour))
+
+		conversions, err := ls.Conversions(ctx, 1, 0, 0)
+		if err != nil || len(conversions) != 2 {
+			t.Fatalf("Expected the conversions without links, got %+v, %v", conversions, err)
+		}
+
+		if c := conversions[1]; c.AudioURL != "" || c.ThumbnailURL != "" {
+			t.Errorf("Expected no links to encrypted files, got %+v", c)
+		}
+	})
+}
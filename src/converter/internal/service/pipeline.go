@@ -0,0 +1,147 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/aws/smithy-go"
+)
+
+// stage is one step of a job. It takes its input from the state of the job, what the stages
+// before it left there, and leaves its output in the state for the stages after it.
+type stage[S any] struct {
+	name string
+	run  func(ctx context.Context, s *S) error
+	// skip, if set, reports whether the output of the stage is in the state already,
+	// like when an earlier delivery of the job got past it.
+	skip func(s *S) bool
+	// transient, if set, tells the errors of the stage that trying the job again may get past.
+	// Errors of an interrupted stage are always, and errors wrapping ErrInternal stay so.
+	transient func(err error) bool
+}
+
+// pipeline is the declaration of a job type, its stages run in order on the state of a job
+// until one fails or the job is done.
+type pipeline[S any] struct {
+	name   string
+	stages []stage[S]
+	// done, if set, reports whether the job is over before its last stage, like a completed job delivered again.
+	done func(s *S) bool
+	// timed, if set, is told how long each stage that ran took and how it ended. Stages are logged otherwise.
+	timed func(stage string, took time.Duration, err error)
+}
+
+// run runs the job. A failed stage ends it with the error classified, wrapping ErrInternal when it is worth
+// trying the job again. The attributes, like the id of the job, go with the log of every stage.
+func (p *pipeline[S]) run(ctx context.Context, s *S, attrs ...any) error {
+	for _, st := range p.stages {
+		if p.done != nil && p.done(s) {
+			return nil
+		}
+
+		if st.skip != nil && st.skip(s) {
+			continue
+		}
+
+		start := time.Now()
+		err := st.run(ctx, s)
+		p.time(st.name, time.Since(start), err, attrs)
+
+		if err != nil {
+			return st.classify(ctx, err)
+		}
+	}
+
+	return nil
+}
+
+func (p *pipeline[S]) time(name string, took time.Duration, err error, attrs []any) {
+	if p.timed != nil {
+		p.timed(name, took, err)
+		return
+	}
+
+	attrs = append([]any{"pipeline", p.name, "stage", name, "duration", took}, attrs...)
+	if err != nil {
+		attrs = append(attrs, "error", err)
+	}
+	slog.Debug("Stage finished", attrs...)
+}
+
+// classify makes the error of the stage retryable when trying the job again may get past it.
+func (st stage[S]) classify(ctx context.Context, err error) error {
+	switch {
+	case errors.Is(err, ErrInternal):
+		return err
+	case ctx.Err() != nil:
+		// the stage was interrupted, not refused
+		return fmt.Errorf("%w: %s stopped: %w", ErrInternal, st.name, err)
+	case st.transient != nil && st.transient(err):
+		return fmt.Errorf("%w: %w", ErrInternal, err)
+	default:
+		return err
+	}
+}
+
+// always is for stages only failing on the database or the like, whatever went wrong isn't the job's fault.
+func always(error) bool {
+	return true
+}
+
+// except is for stages failing on the database or the like, or for good with one of the errors.
+func except(targets ...error) func(error) bool {
+	return func(err error) bool {
+		for _, target := range targets {
+			if errors.Is(err, target) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// transientStorage tells the errors of S3 that go away on their own.
+func transientStorage(err error) bool {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "SlowDown", "RequestTimeout", "RequestTimeTooSkewed", "OperationAborted", "ServiceUnavailable", "InternalError":
+			return true
+		}
+	}
+	return false
+}
+
+// workspace is what a job holds on the machine while it runs, released once its pipeline is over.
+type workspace struct {
+	// dir is the job's own directory, ffmpeg works on untrusted input
+	dir string
+	// video is the video being read from storage, if any
+	video io.ReadCloser
+}
+
+// mkdir creates the job directory, the pattern is as for os.MkdirTemp.
+func (w *workspace) mkdir(pattern string) error {
+	dir, err := os.MkdirTemp("", pattern)
+	if err != nil {
+		return fmt.Errorf("%w: failed to create job directory: %w", ErrInternal, err)
+	}
+
+	w.dir = dir
+	return nil
+}
+
+func (w *workspace) release() {
+	if w.video != nil {
+		w.video.Close()
+	}
+
+	if w.dir != "" {
+		os.RemoveAll(w.dir)
+	}
+}
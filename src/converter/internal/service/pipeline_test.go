@@ -0,0 +1,123 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fakeJob records the stages that ran on it.
+type fakeJob struct {
+	ran  []string
+	done bool
+}
+
+// fakeStage records itself and fails with the error, if any.
+func fakeStage(name string, err error) stage[fakeJob] {
+	return stage[fakeJob]{name: name, run: func(_ context.Context, s *fakeJob) error {
+		s.ran = append(s.ran, name)
+		return err
+	}}
+}
+
+func TestPipeline(t *testing.T) {
+	t.Run("stages in order", func(t *testing.T) {
+		skipped := fakeStage("skipped", nil)
+		skipped.skip = func(*fakeJob) bool { return true }
+
+		var timed []string
+		p := &pipeline[fakeJob]{
+			name:   "fake",
+			stages: []stage[fakeJob]{fakeStage("first", nil), skipped, fakeStage("second", nil)},
+			timed: func(stage string, took time.Duration, err error) {
+				if took < 0 || err != nil {
+					t.Errorf("Unexpected timing of %s: %v, %v", stage, took, err)
+				}
+				timed = append(timed, stage)
+			},
+		}
+
+		s := &fakeJob{}
+		if err := p.run(context.Background(), s); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+
+		if want := []string{"first", "second"}; !reflect.DeepEqual(s.ran, want) || !reflect.DeepEqual(timed, want) {
+			t.Errorf("Expected %v to run and be timed, got %v and %v", want, s.ran, timed)
+		}
+	})
+
+	t.Run("done early", func(t *testing.T) {
+		first := fakeStage("first", nil)
+		first.run = func(_ context.Context, s *fakeJob) error {
+			s.ran, s.done = append(s.ran, "first"), true
+			return nil
+		}
+
+		p := &pipeline[fakeJob]{
+			stages: []stage[fakeJob]{first, fakeStage("second", nil)},
+			done:   func(s *fakeJob) bool { return s.done },
+			timed:  func(string, time.Duration, error) {},
+		}
+
+		s := &fakeJob{}
+		if err := p.run(context.Background(), s); err != nil || !reflect.DeepEqual(s.ran, []string{"first"}) {
+			t.Errorf("Expected the job to end after the first stage, got %v: %v", s.ran, err)
+		}
+	})
+
+	t.Run("failed stage", func(t *testing.T) {
+		var failed error
+		p := &pipeline[fakeJob]{
+			stages: []stage[fakeJob]{fakeStage("first", errCrash), fakeStage("second", nil)},
+			timed:  func(_ string, _ time.Duration, err error) { failed = err },
+		}
+
+		s := &fakeJob{}
+		err := p.run(context.Background(), s)
+		if !errors.Is(err, errCrash) || errors.Is(err, ErrInternal) {
+			t.Errorf("Expected the crash to be permanent, got %v", err)
+		}
+
+		if !reflect.DeepEqual(s.ran, []string{"first"}) || failed != errCrash {
+			t.Errorf("Expected the job to stop at the failed stage, got %v timed with %v", s.ran, failed)
+		}
+	})
+}
+
+func TestStageClassify(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	transient := fakeStage("transient", nil)
+	transient.transient = except(ErrAudioNotFound)
+
+	tests := []struct {
+		name      string
+		ctx       context.Context
+		stage     stage[fakeJob]
+		err       error
+		retryable bool
+	}{
+		{"permanent", context.Background(), fakeStage("plain", nil), errCrash, false},
+		{"already internal", context.Background(), fakeStage("plain", nil), ErrInternal, true},
+		{"interrupted", cancelled, fakeStage("plain", nil), errCrash, true},
+		{"transient", context.Background(), transient, errCrash, true},
+		{"excepted", context.Background(), transient, ErrAudioNotFound, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.stage.classify(tt.ctx, tt.err)
+			if !errors.Is(err, tt.err) {
+				t.Errorf("Expected %v to be kept, got %v", tt.err, err)
+			}
+
+			if errors.Is(err, ErrInternal) != tt.retryable {
+				t.Errorf("Expected retryable to be %v, got %v", tt.retryable, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ziliscite/video-to-mp3/converter/internal/domain"
+	"github.com/ziliscite/video-to-mp3/converter/internal/repository"
+)
+
+var (
+	// ErrPresetNotFound is returned when the user has no such preset.
+	ErrPresetNotFound = errors.New("preset not found")
+	// ErrPresetExists is returned when the user has another preset of the same name.
+	ErrPresetExists = errors.New("preset name is taken")
+)
+
+// PresetService manages the presets users save for their uploads, each user sees their own only.
+// Invalid presets are refused with an error wrapping domain.ErrInvalidOptions.
+type PresetService interface {
+	// Create saves the preset of its user, a default one takes over from the user's earlier default.
+	Create(ctx context.Context, preset *domain.Preset) error
+	// Get returns the preset of the user.
+	Get(ctx context.Context, userId, id int64) (*domain.Preset, error)
+	// List returns the presets of the user by name.
+	List(ctx context.Context, userId int64) ([]*domain.Preset, error)
+	// Update saves the preset over the one of its user with its id.
+	Update(ctx context.Context, preset *domain.Preset) error
+	// Delete removes the preset of the user, the uploads naming it fail from then on.
+	Delete(ctx context.Context, userId, id int64) error
+}
+
+type presetService struct {
+	pr repository.PresetRepository
+}
+
+func NewPresetService(pr repository.PresetRepository) PresetService {
+	return &presetService{pr: pr}
+}
+
+func (p *presetService) Create(ctx context.Context, preset *domain.Preset) error {
+	if err := preset.Validate(); err != nil {
+		return err
+	}
+
+	if err := p.pr.Create(ctx, preset); err != nil {
+		return presetError(preset.Id, err)
+	}
+
+	return nil
+}
+
+func (p *presetService) Get(ctx context.Context, userId, id int64) (*domain.Preset, error) {
+	preset, err := p.pr.Get(ctx, userId, id)
+	if err != nil {
+		return nil, presetError(id, err)
+	}
+
+	return preset, nil
+}
+
+func (p *presetService) List(ctx context.Context, userId int64) ([]*domain.Preset, error) {
+	presets, err := p.pr.List(ctx, userId)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to list presets: %w", ErrInternal, err)
+	}
+
+	return presets, nil
+}
+
+func (p *presetService) Update(ctx context.Context, preset *domain.Preset) error {
+	if err := preset.Validate(); err != nil {
+		return err
+	}
+
+	if err := p.pr.Update(ctx, preset); err != nil {
+		return presetError(preset.Id, err)
+	}
+
+	return nil
+}
+
+func (p *presetService) Delete(ctx context.Context, userId, id int64) error {
+	if err := p.pr.Delete(ctx, userId, id); err != nil {
+		return presetError(id, err)
+	}
+
+	return nil
+}
+
+// presetError maps an error of the repository about the preset to the errors of the service.
+func presetError(id int64, err error) error {
+	switch {
+	case errors.Is(err, repository.ErrRecordNotFound):
+		return fmt.Errorf("%w: %d", ErrPresetNotFound, id)
+	case errors.Is(err, repository.ErrDuplicateEntry):
+		return ErrPresetExists
+	default:
+		return fmt.Errorf("%w: failed to access preset: %w", ErrInternal, err)
+	}
+}
+
+// applyPreset fills in the options the upload left out with the preset it names, or else with the default preset
+// of the user if they have one. The tags of the preset are filled in for the video.
+func (c *converterService) applyPreset(ctx context.Context, s *conversion) error {
+	var (
+		preset *domain.Preset
+		err    error
+	)
+
+	if s.opts.Preset != 0 {
+		preset, err = c.pr.Get(ctx, s.job.UserId, s.opts.Preset)
+	} else if preset, err = c.pr.GetDefault(ctx, s.job.UserId); errors.Is(err, repository.ErrRecordNotFound) {
+		return nil
+	}
+
+	if err != nil {
+		if errors.Is(err, repository.ErrRecordNotFound) {
+			return fmt.Errorf("%w: %d", ErrPresetNotFound, s.opts.Preset)
+		}
+		return fmt.Errorf("failed to load preset: %w", err)
+	}
+
+	s.opts = preset.Apply(s.opts, s.metadata.FileName, time.Now())
+	return s.opts.Validate()
+}
@@ -0,0 +1,163 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ziliscite/video-to-mp3/converter/internal/domain"
+	"github.com/ziliscite/video-to-mp3/events"
+)
+
+func TestPresetService(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("default takes over", func(t *testing.T) {
+		h := newHarness()
+		ps := NewPresetService(presets{h})
+
+		first := &domain.Preset{UserId: 1, Name: "Lectures", Loudness: "podcast", Default: true}
+		second := &domain.Preset{UserId: 1, Name: "Music", Format: "aac", Bitrate: 256, Default: true}
+		for _, p := range []*domain.Preset{first, second} {
+			if err := ps.Create(ctx, p); err != nil {
+				t.Fatalf("Create failed: %v", err)
+			}
+		}
+
+		list, err := ps.List(ctx, 1)
+		if err != nil || len(list) != 2 {
+			t.Fatalf("Expected 2 presets, got %d: %v", len(list), err)
+		}
+
+		if list[0].Name != "Lectures" || list[0].Default || !list[1].Default {
+			t.Errorf("Expected the second preset to be the only default, got %+v and %+v", list[0], list[1])
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		ps := NewPresetService(presets{newHarness()})
+
+		if err := ps.Create(ctx, &domain.Preset{UserId: 1, Name: "Lectures", Tags: map[string]string{"title": "{user}"}}); !errors.Is(err, domain.ErrInvalidOptions) {
+			t.Errorf("Expected ErrInvalidOptions, got %v", err)
+		}
+	})
+
+	t.Run("name taken", func(t *testing.T) {
+		ps := NewPresetService(presets{newHarness()})
+
+		if err := ps.Create(ctx, &domain.Preset{UserId: 1, Name: "Lectures"}); err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+
+		if err := ps.Create(ctx, &domain.Preset{UserId: 1, Name: "Lectures"}); !errors.Is(err, ErrPresetExists) {
+			t.Errorf("Expected ErrPresetExists, got %v", err)
+		}
+
+		// names are per user
+		if err := ps.Create(ctx, &domain.Preset{UserId: 2, Name: "Lectures"}); err != nil {
+			t.Errorf("Expected another user to have the name, got %v", err)
+		}
+	})
+
+	t.Run("preset of someone else", func(t *testing.T) {
+		ps := NewPresetService(presets{newHarness()})
+
+		p := &domain.Preset{UserId: 1, Name: "Lectures"}
+		if err := ps.Create(ctx, p); err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+
+		if _, err := ps.Get(ctx, 2, p.Id); !errors.Is(err, ErrPresetNotFound) {
+			t.Errorf("Expected ErrPresetNotFound, got %v", err)
+		}
+
+		if err := ps.Update(ctx, &domain.Preset{Id: p.Id, UserId: 2, Name: "Mine"}); !errors.Is(err, ErrPresetNotFound) {
+			t.Errorf("Expected ErrPresetNotFound, got %v", err)
+		}
+
+		if err := ps.Delete(ctx, 2, p.Id); !errors.Is(err, ErrPresetNotFound) {
+			t.Errorf("Expected ErrPresetNotFound, got %v", err)
+		}
+
+		if err := ps.Delete(ctx, 1, p.Id); err != nil {
+			t.Errorf("Delete failed: %v", err)
+		}
+	})
+}
+
+func TestConvertMP4Preset(t *testing.T) {
+	ctx := context.Background()
+	lectures := &domain.Preset{
+		UserId: 1, Name: "Lectures", Format: "mp3", Bitrate: 96, Loudness: "podcast",
+		Tags: map[string]string{"title": "{filename}", "date": "{date}"},
+	}
+
+	t.Run("named", func(t *testing.T) {
+		h, svc, filekey, name := setup(t)
+		preset := *lectures
+		if err := (presets{h}).Create(ctx, &preset); err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+
+		if _, err := svc.ConvertMP4(ctx, "job-1", 1, 5, filekey, name, domain.Options{Loudness: "broadcast", Preset: preset.Id}, nil); err != nil {
+			t.Fatalf("ConvertMP4 failed: %v", err)
+		}
+
+		opts := h.options
+		if opts.Format != "mp3" || opts.Bitrate != 96 || opts.Loudness != "broadcast" {
+			t.Errorf("Expected the preset to fill in what the upload left out, got %+v", opts)
+		}
+
+		if opts.Tags["title"] != "lecture" || opts.Tags["date"] != time.Now().Format(time.DateOnly) {
+			t.Errorf("Expected the tags to be filled in for the video, got %v", opts.Tags)
+		}
+	})
+
+	t.Run("default", func(t *testing.T) {
+		h, svc, filekey, name := setup(t)
+		preset := *lectures
+		preset.Default = true
+		if err := (presets{h}).Create(ctx, &preset); err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+
+		if _, err := svc.ConvertMP4(ctx, "job-1", 1, 5, filekey, name, domain.Options{}, nil); err != nil {
+			t.Fatalf("ConvertMP4 failed: %v", err)
+		}
+
+		if h.options.Loudness != "podcast" {
+			t.Errorf("Expected the default preset to be applied, got %+v", h.options)
+		}
+	})
+
+	t.Run("no default", func(t *testing.T) {
+		h, svc, filekey, name := setup(t)
+
+		if _, err := svc.ConvertMP4(ctx, "job-1", 1, 5, filekey, name, domain.Options{}, nil); err != nil || h.options.Format != "" {
+			t.Errorf("Expected the options as they are, got %+v: %v", h.options, err)
+		}
+	})
+
+	t.Run("preset of someone else", func(t *testing.T) {
+		h, svc, filekey, name := setup(t)
+		preset := *lectures
+		preset.UserId = 2
+		if err := (presets{h}).Create(ctx, &preset); err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+
+		_, err := svc.ConvertMP4(ctx, "job-1", 1, 5, filekey, name, domain.Options{Preset: preset.Id}, nil)
+		if !errors.Is(err, ErrPresetNotFound) || errors.Is(err, ErrInternal) {
+			t.Errorf("Expected the job to fail for good with ErrPresetNotFound, got %v", err)
+		}
+
+		if failure := svc.Failure(&events.VideoUploaded{}, err); failure.Why() != events.ReasonPresetNotFound || failure.Reason != events.ReasonInternal {
+			t.Errorf("Expected reason %q of %q, got %+v", events.ReasonPresetNotFound, events.ReasonInternal, failure)
+		}
+
+		if h.conversions != 0 {
+			t.Errorf("Expected no conversion, got %d", h.conversions)
+		}
+	})
+}
@@ -0,0 +1,19 @@
+package service
+
+// ProgressStep is how far a conversion moves between two progress reports, in percent.
+const ProgressStep = 5
+
+// Throttle turns the share of a video converted into percentages, reported each time
+// the conversion moves by another step. Reports never go backwards, and 100 is reported once.
+func Throttle(step int, report func(percent int)) func(done float64) {
+	last := 0
+	return func(done float64) {
+		percent := min(max(int(done*100), 0), 100)
+		if percent/step <= last/step {
+			return
+		}
+
+		last = percent
+		report(percent)
+	}
+}
@@ -0,0 +1,19 @@
+package service
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestThrottle(t *testing.T) {
+	var reports []int
+	progress := Throttle(ProgressStep, func(percent int) { reports = append(reports, percent) })
+
+	for _, done := range []float64{0, 0.01, 0.049, 0.05, 0.07, 0.12, 0.11, 0.5, 0.999, 1, 1, 1.2} {
+		progress(done)
+	}
+
+	if want := []int{5, 12, 50, 99, 100}; !slices.Equal(reports, want) {
+		t.Errorf("Expected %v, got %v", want, reports)
+	}
+}
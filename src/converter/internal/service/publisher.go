@@ -2,68 +2,112 @@ package service
 
 import (
 	"context"
-	"encoding/json"
 	amqp "github.com/rabbitmq/amqp091-go"
 	"github.com/ziliscite/video-to-mp3/converter/internal/domain"
+	"github.com/ziliscite/video-to-mp3/events"
+	"github.com/ziliscite/video-to-mp3/platform/mq"
 )
 
 type EmailNotification interface {
-	PublishEmailNotification(ctx context.Context, data *domain.Metadata, email string) error
+	// PublishEmailNotification tells the user that their audio is ready.
+	// The correlation id is the one carried by the video event that caused the conversion.
+	// The urls are the download links by audio key, audio without one is left without a link.
+	PublishEmailNotification(ctx context.Context, correlationId string, data *domain.Metadata, email string, urls map[string]string) error
+}
+
+type FailureNotification interface {
+	// PublishFailureNotification tells the user that their video will not be converted.
+	PublishFailureNotification(ctx context.Context, correlationId string, data *events.ConversionFailed) error
+}
+
+type ProgressNotification interface {
+	// PublishProgress tells the gateway how far the job got, it's dropped if no gateway is listening.
+	PublishProgress(ctx context.Context, correlationId string, progress *events.JobProgress) error
+}
+
+type TranscriptionRequest interface {
+	// PublishTranscription queues the transcription of an audio, the converter consumes it from its video queue.
+	PublishTranscription(ctx context.Context, correlationId string, request *events.TranscriptionRequested) error
+}
+
+type ThumbnailRequest interface {
+	// PublishThumbnail queues the drawing of the pictures of a video, the converter consumes it from its video queue.
+	PublishThumbnail(ctx context.Context, correlationId string, request *events.ThumbnailRequested) error
+}
+
+type StreamRequest interface {
+	// PublishStream queues the transcoding of a video into HLS, the converter consumes it from its video queue.
+	PublishStream(ctx context.Context, correlationId string, request *events.StreamRequested) error
 }
 
 type NotificationService interface {
 	EmailNotification
+	FailureNotification
+	ProgressNotification
+	TranscriptionRequest
+	ThumbnailRequest
+	StreamRequest
 }
 
 type Publisher struct {
-	ac *amqp.Connection
-	nq amqp.Queue
+	mp *mq.Publisher
+	pp *mq.Publisher
+	vp *mq.Publisher
 }
 
-func NewPublisher(ac *amqp.Connection, queueName string) (NotificationService, error) {
-	// create a new channel for the publisher
-	ch, err := ac.Channel()
+// NewPublisher creates a publisher of the notification queue, of the progress exchange and of the video queue.
+func NewPublisher(ac *amqp.Connection, queueName, progressExchange, videoQueue string) (NotificationService, error) {
+	mp, err := mq.NewPublisher(ac, queueName)
+	if err != nil {
+		return nil, err
+	}
+
+	pp, err := mq.NewExchangePublisher(ac, progressExchange)
 	if err != nil {
 		return nil, err
 	}
-	defer ch.Close()
 
-	// declare the video queue once during publisher initialization
-	nq, err := ch.QueueDeclare(queueName, true, false, false, false, nil)
+	vp, err := mq.NewPublisher(ac, videoQueue)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Publisher{
-		ac: ac,
-		nq: nq,
-	}, nil
+	return &Publisher{mp: mp, pp: pp, vp: vp}, nil
 }
 
-func (p *Publisher) PublishEmailNotification(ctx context.Context, data *domain.Metadata, email string) error {
-	ch, err := p.ac.Channel()
-	if err != nil {
-		return err
+func (p *Publisher) PublishEmailNotification(ctx context.Context, correlationId string, data *domain.Metadata, email string, urls map[string]string) error {
+	succeeded := &events.ConversionSucceeded{
+		UserId: data.UserId, UserEmail: email,
+		FileName: data.FileName, VideoKey: data.VideoKey, AudioKey: data.AudioKey,
+		AudioURL: urls[data.AudioKey],
 	}
-	defer ch.Close()
 
-	msg, err := json.Marshal(&data)
-	if err != nil {
-		return err
+	for _, part := range data.Parts {
+		succeeded.Parts = append(succeeded.Parts, events.AudioPart{
+			Title: part.Title, Start: part.Start.Seconds(), End: part.End.Seconds(),
+			AudioKey: part.AudioKey, AudioURL: urls[part.AudioKey],
+		})
 	}
 
-	return ch.PublishWithContext(ctx,
-		"",
-		p.nq.Name,
-		false,
-		false,
-		amqp.Publishing{
-			DeliveryMode: amqp.Persistent,
-			ContentType:  "application/json",
-			Body:         msg,
-			Headers: amqp.Table{
-				"email": email,
-			},
-		},
-	)
+	return p.mp.Publish(ctx, events.TypeConversionSucceeded, correlationId, succeeded)
+}
+
+func (p *Publisher) PublishFailureNotification(ctx context.Context, correlationId string, data *events.ConversionFailed) error {
+	return p.mp.Publish(ctx, events.TypeConversionFailed, correlationId, data)
+}
+
+func (p *Publisher) PublishProgress(ctx context.Context, correlationId string, progress *events.JobProgress) error {
+	return p.pp.Publish(ctx, events.TypeJobProgress, correlationId, progress)
+}
+
+func (p *Publisher) PublishTranscription(ctx context.Context, correlationId string, request *events.TranscriptionRequested) error {
+	return p.vp.Publish(ctx, events.TypeTranscriptionRequested, correlationId, request)
+}
+
+func (p *Publisher) PublishThumbnail(ctx context.Context, correlationId string, request *events.ThumbnailRequested) error {
+	return p.vp.Publish(ctx, events.TypeThumbnailRequested, correlationId, request)
+}
+
+func (p *Publisher) PublishStream(ctx context.Context, correlationId string, request *events.StreamRequested) error {
+	return p.vp.Publish(ctx, events.TypeStreamRequested, correlationId, request)
 }
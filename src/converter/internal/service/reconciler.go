@@ -0,0 +1,138 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/ziliscite/video-to-mp3/converter/internal/repository"
+	"github.com/ziliscite/video-to-mp3/platform/storage"
+)
+
+// Orphan is a stored object that nothing references.
+type Orphan struct {
+	Bucket       string
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+type ReconcileReport struct {
+	// Scanned is the number of objects listed across both buckets.
+	Scanned int
+	// Orphans are the unreferenced objects older than the grace period.
+	Orphans []Orphan
+	// Deleted is the number of orphans removed, always 0 in a dry run.
+	Deleted int
+	// Failed is the number of orphans that could not be removed.
+	Failed int
+}
+
+type Reconciler interface {
+	// Reconcile finds objects in the video and audio buckets that no metadata or job refers to,
+	// and deletes those older than the grace period unless dryRun is set.
+	Reconcile(ctx context.Context, dryRun bool) (*ReconcileReport, error)
+}
+
+type reconciler struct {
+	fr    storage.FileStore
+	kr    repository.KeyReader
+	b     bucket
+	grace time.Duration
+	now   func() time.Time
+}
+
+// NewReconciler creates a reconciler for the video and audio buckets.
+// The grace period must outlast the time a video can wait in the queue,
+// as queued videos have no job yet.
+func NewReconciler(fr storage.FileStore, kr repository.KeyReader, mp4Bucket, mp3Bucket string, grace time.Duration) Reconciler {
+	return &reconciler{
+		fr: fr,
+		kr: kr,
+		b: bucket{
+			mp4: mp4Bucket,
+			mp3: mp3Bucket,
+		},
+		grace: grace,
+		now:   time.Now,
+	}
+}
+
+func (r *reconciler) Reconcile(ctx context.Context, dryRun bool) (*ReconcileReport, error) {
+	// list before loading references, so an object stored while we scan is never seen unreferenced
+	videos, err := r.fr.List(ctx, r.b.mp4)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list videos: %w", err)
+	}
+
+	audios, err := r.fr.List(ctx, r.b.mp3)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list audios: %w", err)
+	}
+
+	videoKeys, audioKeys, err := r.kr.ReferencedKeys(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load referenced keys: %w", err)
+	}
+
+	report := &ReconcileReport{Scanned: len(videos) + len(audios)}
+	report.Orphans = append(report.Orphans, r.orphans(r.b.mp4, videos, videoKeys)...)
+	report.Orphans = append(report.Orphans, r.orphans(r.b.mp3, audios, audioKeys)...)
+
+	if dryRun {
+		return report, nil
+	}
+
+	for _, o := range report.Orphans {
+		if err = r.fr.Delete(ctx, o.Bucket, o.Key); err != nil {
+			slog.Error("Failed to delete orphan", "bucket", o.Bucket, "key", o.Key, "error", err)
+			report.Failed++
+			continue
+		}
+		report.Deleted++
+	}
+
+	return report, nil
+}
+
+func (r *reconciler) orphans(bucket string, files []storage.FileInfo, referenced map[string]bool) []Orphan {
+	cutoff := r.now().Add(-r.grace)
+
+	// compare without extensions, as older audio keys were stored without theirs
+	keys := make(map[string]bool, len(referenced))
+	for k := range referenced {
+		keys[objectKey(k)] = true
+	}
+
+	var orphans []Orphan
+	for _, f := range files {
+		if keys[ownerKey(f.Key)] || f.LastModified.After(cutoff) {
+			continue
+		}
+
+		orphans = append(orphans, Orphan{
+			Bucket: bucket, Key: f.Key,
+			Size: f.Size, LastModified: f.LastModified,
+		})
+	}
+
+	return orphans
+}
+
+// ownerKey returns the key an object is stored under. The pictures of a video and its stream
+// are stored under prefixes, named after its audio.
+func ownerKey(name string) string {
+	if rest, ok := strings.CutPrefix(name, streamPrefix); ok {
+		name, _, _ = strings.Cut(rest, "/")
+	}
+	return objectKey(strings.TrimPrefix(name, thumbnailPrefix))
+}
+
+// objectKey strips the extension from an object name or a stored key.
+// Keys are UUIDs, or base64url encoded for older objects, so they never contain a dot.
+func objectKey(name string) string {
+	key, _, _ := strings.Cut(name, ".")
+	return key
+}
@@ -0,0 +1,167 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+
+	"github.com/ziliscite/video-to-mp3/platform/storage"
+)
+
+type keys struct {
+	videos, audios map[string]bool
+}
+
+func (k keys) ReferencedKeys(ctx context.Context) (map[string]bool, map[string]bool, error) {
+	return k.videos, k.audios, nil
+}
+
+// setupBuckets stores the objects in a local S3-compatible server.
+func setupBuckets(t *testing.T, objects map[string][]string) storage.FileStore {
+	t.Helper()
+
+	backend := s3mem.New()
+	srv := httptest.NewServer(gofakes3.New(backend).Server())
+	t.Cleanup(srv.Close)
+
+	fs := storage.NewStore(s3.New(s3.Options{
+		Region:       "us-east-1",
+		Credentials:  credentials.NewStaticCredentialsProvider("key", "secret", ""),
+		BaseEndpoint: aws.String(srv.URL),
+		UsePathStyle: true,
+	}), storage.SSE{})
+
+	for bucket, names := range objects {
+		if err := backend.CreateBucket(bucket); err != nil {
+			t.Fatalf("Failed to create bucket: %v", err)
+		}
+
+		for _, name := range names {
+			if err := fs.Save(context.Background(), name, "", bucket, bytes.NewReader([]byte(name))); err != nil {
+				t.Fatalf("Failed to save %s: %v", name, err)
+			}
+		}
+	}
+
+	return fs
+}
+
+func remaining(t *testing.T, fs storage.FileStore, bucket string) []string {
+	t.Helper()
+
+	files, err := fs.List(context.Background(), bucket)
+	if err != nil {
+		t.Fatalf("Failed to list %s: %v", bucket, err)
+	}
+
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Key)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestReconcile(t *testing.T) {
+	objects := map[string][]string{
+		// video of a completed conversion, video still in flight and a video whose publish failed
+		"mp4": {"done.mp4", "inflight.mp4", "unpublished.mp4"},
+		// audio of a completed conversion, audio uploaded before the metadata failed to save
+		// and audio stored under an older key format
+		"mp3": {"doneaudio.mp3", "lost.mp3", "legacy..mp3"},
+	}
+	refs := keys{
+		videos: map[string]bool{"done": true, "inflight": true},
+		audios: map[string]bool{"doneaudio.mp3": true, "legacy": true},
+	}
+
+	t.Run("dry run reports without deleting", func(t *testing.T) {
+		fs := setupBuckets(t, objects)
+		rec := NewReconciler(fs, refs, "mp4", "mp3", time.Hour).(*reconciler)
+		rec.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+
+		report, err := rec.Reconcile(context.Background(), true)
+		if err != nil {
+			t.Fatalf("Failed to reconcile: %v", err)
+		}
+
+		if report.Scanned != 6 || len(report.Orphans) != 2 || report.Deleted != 0 {
+			t.Errorf("Unexpected report: %+v", report)
+		}
+
+		if got := remaining(t, fs, "mp4"); len(got) != 3 {
+			t.Errorf("Expected nothing deleted in a dry run, got %v", got)
+		}
+	})
+
+	t.Run("deletes orphans past the grace period", func(t *testing.T) {
+		fs := setupBuckets(t, objects)
+		rec := NewReconciler(fs, refs, "mp4", "mp3", time.Hour).(*reconciler)
+		rec.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+
+		report, err := rec.Reconcile(context.Background(), false)
+		if err != nil {
+			t.Fatalf("Failed to reconcile: %v", err)
+		}
+
+		if report.Deleted != 2 || report.Failed != 0 {
+			t.Errorf("Unexpected report: %+v", report)
+		}
+
+		if got := remaining(t, fs, "mp4"); len(got) != 2 || got[0] != "done.mp4" || got[1] != "inflight.mp4" {
+			t.Errorf("Unexpected videos left: %v", got)
+		}
+
+		if got := remaining(t, fs, "mp3"); len(got) != 2 || got[0] != "doneaudio.mp3" || got[1] != "legacy..mp3" {
+			t.Errorf("Unexpected audios left: %v", got)
+		}
+	})
+
+	t.Run("keeps the pictures and streams of known audio", func(t *testing.T) {
+		fs := setupBuckets(t, map[string][]string{
+			"mp4": {"done.mp4"},
+			"mp3": {
+				"doneaudio.mp3", "thumbnails/doneaudio.jpg", "thumbnails/doneaudio.webp", "thumbnails/lost.jpg",
+				"streams/doneaudio/master.m3u8", "streams/doneaudio/audio/segment000.ts", "streams/lost/master.m3u8",
+			},
+		})
+		rec := NewReconciler(fs, refs, "mp4", "mp3", time.Hour).(*reconciler)
+		rec.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+
+		if _, err := rec.Reconcile(context.Background(), false); err != nil {
+			t.Fatalf("Failed to reconcile: %v", err)
+		}
+
+		want := []string{
+			"doneaudio.mp3", "streams/doneaudio/audio/segment000.ts", "streams/doneaudio/master.m3u8",
+			"thumbnails/doneaudio.jpg", "thumbnails/doneaudio.webp",
+		}
+		if got := remaining(t, fs, "mp3"); !reflect.DeepEqual(got, want) {
+			t.Errorf("Unexpected audios left: %v", got)
+		}
+	})
+
+	t.Run("keeps recent orphans", func(t *testing.T) {
+		fs := setupBuckets(t, objects)
+		rec := NewReconciler(fs, refs, "mp4", "mp3", time.Hour)
+
+		report, err := rec.Reconcile(context.Background(), false)
+		if err != nil {
+			t.Fatalf("Failed to reconcile: %v", err)
+		}
+
+		if len(report.Orphans) != 0 || report.Deleted != 0 {
+			t.Errorf("Expected objects within the grace period to be kept, got %+v", report)
+		}
+	})
+}
@@ -0,0 +1,142 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/ziliscite/video-to-mp3/converter/internal/domain"
+	"github.com/ziliscite/video-to-mp3/converter/internal/repository"
+)
+
+type ConverterMP3 interface {
+	// Reencode encodes the user's audio again as the re-encoding says, a part of a split conversion included,
+	// and saves the result as a new conversion linked to the one the audio came from.
+	// The progress function, if any, is called with the share of the audio encoded so far.
+	// Running a job again resumes it, and a completed job returns its existing metadata.
+	Reencode(ctx context.Context, jobId string, userId int64, audioKey string, r domain.Reencoding, progress func(done float64)) (*domain.Metadata, error)
+}
+
+func (c *converterService) Reencode(ctx context.Context, jobId string, userId int64, audioKey string, r domain.Reencoding, progress func(done float64)) (*domain.Metadata, error) {
+	s := &reencoding{
+		job:    &domain.Job{Id: jobId, UserId: userId, SourceAudioKey: audioKey},
+		source: audioKey, encoding: r, progress: progress,
+	}
+	defer s.release()
+
+	if err := c.reencodePipeline().run(ctx, s, "job_id", jobId); err != nil {
+		return nil, err
+	}
+	return s.result, nil
+}
+
+// reencoding is the state of the re-encoding of stored audio.
+type reencoding struct {
+	workspace
+
+	// the request, the job is replaced by the claimed one
+	job      *domain.Job
+	source   string
+	encoding domain.Reencoding
+	progress func(done float64)
+
+	parent   *domain.Metadata
+	input    string
+	metadata *domain.Metadata
+	audio    *domain.Audio
+	result   *domain.Metadata
+}
+
+// reencodePipeline encodes the audio again and saves its metadata as a new conversion.
+// The audio may have been stored by an earlier delivery of the same job.
+func (c *converterService) reencodePipeline() *pipeline[reencoding] {
+	stored := func(s *reencoding) bool { return s.job.AudioKey != "" }
+
+	return &pipeline[reencoding]{
+		name: "reencode",
+		stages: []stage[reencoding]{
+			{name: "validate", run: func(_ context.Context, s *reencoding) error { return s.encoding.Validate() }},
+			{name: "parent", run: c.findParent, transient: except(ErrAudioNotFound)},
+			{name: "claim", run: c.claimReencoding, transient: always},
+			{name: "download", run: c.downloadReencoding, skip: stored},
+			{name: "reencode", run: c.reencodeAudio, skip: stored},
+			{name: "store", run: c.storeReencoding, skip: stored, transient: transientStorage},
+			{name: "record", run: c.recordReencoding, skip: stored, transient: always},
+			{name: "complete", run: c.completeReencoding, transient: always},
+		},
+		// the job is done but the message wasn't acked, hand back the existing result
+		done: func(s *reencoding) bool { return s.result != nil },
+	}
+}
+
+func (c *converterService) findParent(ctx context.Context, s *reencoding) (err error) {
+	s.parent, err = c.mr.GetByAudio(ctx, s.job.UserId, s.source)
+	if err != nil {
+		if errors.Is(err, repository.ErrRecordNotFound) {
+			return fmt.Errorf("%w: %s", ErrAudioNotFound, s.source)
+		}
+		return fmt.Errorf("failed to load audio: %w", err)
+	}
+
+	s.job.VideoKey = s.parent.VideoKey
+	return nil
+}
+
+func (c *converterService) claimReencoding(ctx context.Context, s *reencoding) (err error) {
+	if s.job, err = c.jr.Claim(ctx, s.job); err != nil {
+		return fmt.Errorf("failed to claim job: %w", err)
+	}
+
+	if s.job.Status == domain.JobCompleted {
+		s.result, err = c.existing(ctx, s.job)
+		return err
+	}
+
+	s.metadata = &domain.Metadata{
+		UserId: s.job.UserId, FileName: s.parent.FileName, EncryptedFileName: s.parent.EncryptedFileName,
+		VideoKey: s.parent.VideoKey, AudioKey: s.job.AudioKey, ParentId: s.parent.Id,
+	}
+	return nil
+}
+
+func (c *converterService) downloadReencoding(ctx context.Context, s *reencoding) error {
+	// ffmpeg works on the stored audio
+	if err := s.mkdir("job-*"); err != nil {
+		return err
+	}
+
+	s.input = filepath.Join(s.dir, "input"+filepath.Ext(s.source))
+	return c.download(ctx, s.job.SourceAudioKey, s.input)
+}
+
+func (c *converterService) reencodeAudio(ctx context.Context, s *reencoding) (err error) {
+	if s.audio, err = c.cv.Reencode(ctx, s.dir, s.input, s.encoding, s.progress); err != nil {
+		return fmt.Errorf("failed to re-encode audio: %w", err)
+	}
+	return nil
+}
+
+// storeReencoding stores the audio, recording it in the metadata: its key,
+// the loudness measurement if it was normalized, and its waveform.
+func (c *converterService) storeReencoding(ctx context.Context, s *reencoding) (err error) {
+	if s.metadata.AudioKey, err = c.storeMP3(ctx, s.audio.Path); err != nil {
+		return fmt.Errorf("failed to process and store audio: %w", err)
+	}
+	s.metadata.Loudness = s.audio.Loudness
+	s.metadata.PeaksKey, s.metadata.WaveformKey = c.storeWaveform(ctx, s.dir, s.audio.Path, s.metadata.AudioKey)
+
+	return nil
+}
+
+func (c *converterService) recordReencoding(ctx context.Context, s *reencoding) error {
+	if err := c.jr.SetAudio(ctx, s.job.Id, s.metadata.AudioKey, nil); err != nil {
+		return fmt.Errorf("failed to record audio: %w", err)
+	}
+	return nil
+}
+
+func (c *converterService) completeReencoding(ctx context.Context, s *reencoding) (err error) {
+	s.result, err = c.complete(ctx, s.job, s.metadata)
+	return err
+}
@@ -0,0 +1,332 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/ziliscite/video-to-mp3/converter/internal/domain"
+	"github.com/ziliscite/video-to-mp3/converter/internal/repository"
+	"github.com/ziliscite/video-to-mp3/platform/encryptor"
+	"github.com/ziliscite/video-to-mp3/platform/storage"
+)
+
+const rekeyBatch = 500
+
+type RekeyReport struct {
+	// Scanned is the number of metadata rows read.
+	Scanned int
+	// Rekeyed is the number of rows whose filename was not encrypted with the active key
+	// or whose objects were stored under older keys, in a dry run the number that would be re-keyed.
+	Rekeyed int
+	// Failed is the number of rows that could not be re-keyed, they keep their old keys.
+	Failed int
+}
+
+type Rekeyer interface {
+	// Rekey encrypts the filename of every metadata row again with the active key. Objects still stored
+	// under the encrypted filename are moved to random keys, the transcripts, drawings, pictures and stream
+	// named after the audio along with it.
+	// Rows already up to date are left alone.
+	Rekey(ctx context.Context, dryRun bool) (*RekeyReport, error)
+}
+
+type rekeyer struct {
+	fr storage.FileStore
+	kr repository.KeyRepository
+	en *encryptor.Encryptor
+	ds DeliveryService
+	b  bucket
+}
+
+// NewRekeyer creates a rekeyer for the video and audio buckets.
+// The old keys must still be in the encryptor's keyring.
+func NewRekeyer(fr storage.FileStore, kr repository.KeyRepository, en *encryptor.Encryptor, ds DeliveryService, mp4Bucket, mp3Bucket string) Rekeyer {
+	return &rekeyer{
+		fr: fr,
+		kr: kr,
+		en: en,
+		ds: ds,
+		b: bucket{
+			mp4: mp4Bucket,
+			mp3: mp3Bucket,
+		},
+	}
+}
+
+// move renames a stored object.
+type move struct {
+	bucket string
+	from   string
+	to     string
+}
+
+func (r *rekeyer) Rekey(ctx context.Context, dryRun bool) (*RekeyReport, error) {
+	report := &RekeyReport{}
+
+	var replaced []string
+	for afterId := int64(0); ; {
+		batch, err := r.kr.Keys(ctx, afterId, rekeyBatch)
+		if err != nil {
+			return report, fmt.Errorf("failed to load metadata: %w", err)
+		}
+
+		for i := range batch {
+			m := &batch[i]
+			afterId = m.Id
+			report.Scanned++
+
+			audios, changed, err := r.rekey(ctx, m, dryRun)
+			if err != nil {
+				slog.Error("Failed to rekey", "metadata_id", m.Id, "error", err)
+				report.Failed++
+				continue
+			}
+
+			if changed {
+				report.Rekeyed++
+				replaced = append(replaced, audios...)
+			}
+		}
+
+		if len(batch) < rekeyBatch {
+			break
+		}
+	}
+
+	// links to the old names of audio and the files made from it stop working, the CDN shouldn't keep serving them
+	if err := r.ds.Invalidate(ctx, replaced...); err != nil {
+		slog.Error("Failed to invalidate re-keyed audio", "error", err)
+	}
+
+	return report, nil
+}
+
+// rekey re-keys one metadata row. Objects are copied before the row is updated and deleted after,
+// so a failure in between leaves either the old or the new objects referenced, never neither.
+// Copies left behind are unreferenced and removed by the reconciler.
+func (r *rekeyer) rekey(ctx context.Context, m *domain.Metadata, dryRun bool) ([]string, bool, error) {
+	name, err := r.en.Rekey(m.EncryptedFileName)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to rekey filename: %w", err)
+	}
+
+	renamed := &domain.Metadata{
+		Id: m.Id, EncryptedFileName: name, VideoKey: m.VideoKey,
+		PeaksKey: m.PeaksKey, WaveformKey: m.WaveformKey, ThumbnailKey: m.ThumbnailKey, PreviewKey: m.PreviewKey, StreamKey: m.StreamKey,
+	}
+
+	var moves []move
+	if !randomKey(m.VideoKey) {
+		if renamed.VideoKey, err = newKey(); err != nil {
+			return nil, false, err
+		}
+		moves = append(moves, move{bucket: r.b.mp4, from: videoObject(m.VideoKey), to: videoObject(renamed.VideoKey)})
+	}
+
+	audioKey, audioMoves, err := r.audio(ctx, m.AudioKey)
+	if err != nil {
+		return nil, false, err
+	}
+	renamed.AudioKey, moves = audioKey, append(moves, audioMoves...)
+
+	renamed.Transcripts, audioMoves = r.transcripts(m, renamed.AudioKey)
+	moves = append(moves, audioMoves...)
+
+	if audioMoves, err = r.derived(ctx, m, renamed); err != nil {
+		return nil, false, err
+	}
+	moves = append(moves, audioMoves...)
+
+	if renamed.EncryptedFileName == m.EncryptedFileName && len(moves) == 0 && renamed.AudioKey == m.AudioKey {
+		return nil, false, nil
+	}
+
+	if dryRun {
+		return nil, true, nil
+	}
+
+	var copied []move
+	for _, mv := range moves {
+		ok, err := r.copy(ctx, mv)
+		if err != nil {
+			r.remove(ctx, copied, func(mv move) string { return mv.to })
+			return nil, false, err
+		}
+
+		// retention may have deleted the object already, only the key is left to rename
+		if ok {
+			copied = append(copied, mv)
+		}
+	}
+
+	if err = r.kr.RenameKeys(ctx, m, renamed); err != nil {
+		r.remove(ctx, copied, func(mv move) string { return mv.to })
+		return nil, false, fmt.Errorf("failed to rename keys: %w", err)
+	}
+
+	r.remove(ctx, copied, func(mv move) string { return mv.from })
+
+	var audios []string
+	for _, mv := range copied {
+		if mv.bucket == r.b.mp3 {
+			audios = append(audios, mv.from)
+		}
+	}
+
+	return audios, true, nil
+}
+
+// audio gives an audio stored under an older key a random one and returns the move of its object.
+// Older audio keys stored without their extension get the extension of the object that is found.
+func (r *rekeyer) audio(ctx context.Context, audioKey string) (string, []move, error) {
+	key, ext, _ := strings.Cut(audioKey, ".")
+	if randomKey(key) {
+		return audioKey, nil, nil
+	}
+
+	renamed, err := newKey()
+	if err != nil {
+		return "", nil, err
+	}
+
+	if ext != "" {
+		renamed += "." + ext
+		return renamed, []move{{bucket: r.b.mp3, from: audioKey, to: renamed}}, nil
+	}
+
+	for _, object := range audioObjects(audioKey) {
+		if _, err = r.fr.Stat(ctx, r.b.mp3, object); errors.Is(err, storage.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return "", nil, fmt.Errorf("failed to stat %s: %w", object, err)
+		}
+
+		renamed += "." + strings.TrimLeft(strings.TrimPrefix(object, key), ".")
+		return renamed, []move{{bucket: r.b.mp3, from: object, to: renamed}}, nil
+	}
+
+	return renamed, nil, nil
+}
+
+// transcripts renames the transcripts of the metadata after its renamed audio, and returns the moves of their objects.
+func (r *rekeyer) transcripts(m *domain.Metadata, audioKey string) ([]domain.Transcription, []move) {
+	if audioKey == m.AudioKey {
+		return m.Transcripts, nil
+	}
+
+	var (
+		renamed []domain.Transcription
+		moves   []move
+	)
+	for _, t := range m.Transcripts {
+		if t.AudioKey != m.AudioKey {
+			renamed = append(renamed, t)
+			continue
+		}
+
+		t.AudioKey = audioKey
+		for _, key := range []*string{&t.SRTKey, &t.VTTKey, &t.TextKey} {
+			if *key == "" {
+				continue
+			}
+
+			to := transcriptObject(audioKey, path.Ext(*key))
+			moves = append(moves, move{bucket: r.b.mp3, from: *key, to: to})
+			*key = to
+		}
+		renamed = append(renamed, t)
+	}
+
+	return renamed, moves
+}
+
+// derived renames the objects drawn from the audio and its video after the renamed audio, and returns their moves.
+// The files of the stream are found through its playlists, which name each other relative to the stream.
+func (r *rekeyer) derived(ctx context.Context, m, renamed *domain.Metadata) ([]move, error) {
+	if renamed.AudioKey == m.AudioKey {
+		return nil, nil
+	}
+
+	var moves []move
+	rename := func(key, to string) string {
+		if key == "" {
+			return ""
+		}
+		moves = append(moves, move{bucket: r.b.mp3, from: key, to: to})
+		return to
+	}
+
+	renamed.PeaksKey = rename(m.PeaksKey, peaksObject(renamed.AudioKey))
+	renamed.WaveformKey = rename(m.WaveformKey, waveformObject(renamed.AudioKey))
+	renamed.ThumbnailKey = rename(m.ThumbnailKey, thumbnailObject(renamed.AudioKey, path.Ext(m.ThumbnailKey)))
+	renamed.PreviewKey = rename(m.PreviewKey, thumbnailObject(renamed.AudioKey, path.Ext(m.PreviewKey)))
+
+	if m.StreamKey == "" {
+		return moves, nil
+	}
+
+	objects, err := streamObjects(ctx, r.fr, r.b.mp3, m.StreamKey)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := streamObject(m.AudioKey, "") + "/"
+	for _, object := range objects {
+		to := rename(object, streamObject(renamed.AudioKey, strings.TrimPrefix(object, dir)))
+		if object == m.StreamKey {
+			renamed.StreamKey = to
+		}
+	}
+
+	return moves, nil
+}
+
+// randomKey reports whether the key is a random id rather than an older encrypted filename.
+func randomKey(key string) bool {
+	_, err := uuid.Parse(key)
+	return err == nil
+}
+
+func newKey() (string, error) {
+	id, err := uuid.NewV7()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate key: %w", err)
+	}
+	return id.String(), nil
+}
+
+// copy copies an object to its new name, it returns false if there is no object to copy.
+func (r *rekeyer) copy(ctx context.Context, mv move) (bool, error) {
+	info, err := r.fr.Stat(ctx, mv.bucket, mv.from)
+	if errors.Is(err, storage.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failed to stat %s: %w", mv.from, err)
+	}
+
+	file, err := r.fr.ReadLarge(ctx, mv.bucket, mv.from)
+	if err != nil {
+		return false, fmt.Errorf("failed to read %s: %w", mv.from, err)
+	}
+	defer file.Close()
+
+	if err = r.fr.SaveLarge(ctx, mv.to, info.ContentType, mv.bucket, file); err != nil {
+		return false, fmt.Errorf("failed to copy %s to %s: %w", mv.from, mv.to, err)
+	}
+
+	return true, nil
+}
+
+// remove deletes one side of the moves, failures only leave unreferenced objects behind.
+func (r *rekeyer) remove(ctx context.Context, moves []move, object func(move) string) {
+	for _, mv := range moves {
+		if err := r.fr.Delete(ctx, mv.bucket, object(mv)); err != nil && !errors.Is(err, storage.ErrNotExist) {
+			slog.Error("Failed to delete object", "bucket", mv.bucket, "key", object(mv), "error", err)
+		}
+	}
+}
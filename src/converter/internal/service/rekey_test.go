@@ -0,0 +1,352 @@
+package service
+
+import (
+	"context"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ziliscite/video-to-mp3/converter/internal/domain"
+	"github.com/ziliscite/video-to-mp3/converter/internal/repository"
+	"github.com/ziliscite/video-to-mp3/platform/encryptor"
+)
+
+// keyRepo is the repository.KeyRepository of the harness metadata.
+type keyRepo struct{ *harness }
+
+func (k keyRepo) Keys(ctx context.Context, afterId int64, limit int) ([]domain.Metadata, error) {
+	var ids []int64
+	for id := range k.metadata {
+		if id > afterId {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+
+	var list []domain.Metadata
+	for _, id := range ids[:min(limit, len(ids))] {
+		m := *k.metadata[id]
+		for _, t := range k.transcripts {
+			if t.MetadataId == id && t.Status == domain.JobCompleted {
+				m.Transcripts = append(m.Transcripts, *t)
+			}
+		}
+		list = append(list, m)
+	}
+	return list, nil
+}
+
+func (k keyRepo) RenameKeys(ctx context.Context, old, renamed *domain.Metadata) error {
+	m, ok := k.metadata[old.Id]
+	if !ok || m.EncryptedFileName != old.EncryptedFileName || m.VideoKey != old.VideoKey || m.AudioKey != old.AudioKey ||
+		m.PeaksKey != old.PeaksKey || m.WaveformKey != old.WaveformKey || m.ThumbnailKey != old.ThumbnailKey ||
+		m.PreviewKey != old.PreviewKey || m.StreamKey != old.StreamKey {
+		return repository.ErrRecordNotFound
+	}
+
+	m.EncryptedFileName, m.VideoKey, m.AudioKey = renamed.EncryptedFileName, renamed.VideoKey, renamed.AudioKey
+	m.PeaksKey, m.WaveformKey, m.ThumbnailKey, m.PreviewKey = renamed.PeaksKey, renamed.WaveformKey, renamed.ThumbnailKey, renamed.PreviewKey
+	m.StreamKey = renamed.StreamKey
+	for _, s := range k.streams {
+		if s.MetadataId == old.Id && s.PlaylistKey != "" && s.PlaylistKey == old.StreamKey {
+			s.PlaylistKey = renamed.StreamKey
+		}
+	}
+	for _, j := range k.jobs {
+		if j.MetadataId == old.Id {
+			j.VideoKey, j.AudioKey = renamed.VideoKey, renamed.AudioKey
+		}
+	}
+
+	// keys that were never set stay empty
+	rename := func(key, to string) string {
+		if key == "" {
+			return ""
+		}
+		return to
+	}
+
+	for i, r := range renamed.Transcripts {
+		for _, t := range k.transcripts {
+			if t.MetadataId == old.Id && t.AudioKey == old.Transcripts[i].AudioKey {
+				t.AudioKey = r.AudioKey
+				t.SRTKey, t.VTTKey, t.TextKey = rename(t.SRTKey, r.SRTKey), rename(t.VTTKey, r.VTTKey), rename(t.TextKey, r.TextKey)
+			}
+		}
+	}
+	for _, t := range k.transcripts {
+		if t.MetadataId == old.Id && t.AudioKey == old.AudioKey {
+			t.AudioKey = renamed.AudioKey
+		}
+	}
+	return nil
+}
+
+func TestRekey(t *testing.T) {
+	const legacyKey = "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	secrets := map[string][]byte{
+		"0": []byte(strings.Repeat("0", 32)),
+		"1": []byte(strings.Repeat("1", 32)),
+		"2": []byte(strings.Repeat("2", 32)),
+	}
+
+	legacy, _ := encryptor.NewEncryptor(legacyKey)
+	retired, _ := encryptor.NewKeyring("0", map[string][]byte{"0": secrets["0"]}, "")
+	old, _ := encryptor.NewKeyring("1", map[string][]byte{"1": secrets["1"]}, "")
+	rotated, err := encryptor.NewKeyring("2", map[string][]byte{"1": secrets["1"], "2": secrets["2"]}, legacyKey)
+	if err != nil {
+		t.Fatalf("Failed to create keyring: %v", err)
+	}
+
+	encrypt := func(en *encryptor.Encryptor, plaintext string) string {
+		key, _ := en.Encrypt(plaintext)
+		return key
+	}
+
+	audioId, videoId := uuid.NewString(), uuid.NewString()
+
+	setup := func() (*harness, Rekeyer) {
+		h := newHarness()
+
+		rows := []*domain.Metadata{
+			// legacy keys, the audio stored without its extension
+			{EncryptedFileName: encrypt(legacy, "a.mp4"), VideoKey: encrypt(legacy, "a.mp4"), AudioKey: encrypt(legacy, "/tmp/a.mp3")},
+			{EncryptedFileName: encrypt(old, "b.mp4"), VideoKey: encrypt(old, "b.mp4"), AudioKey: encrypt(old, "/tmp/b.mp3") + ".mp3"},
+			// the filename is up to date, the objects are not
+			{EncryptedFileName: encrypt(rotated, "c.mp4"), VideoKey: encrypt(rotated, "c.mp4"), AudioKey: encrypt(rotated, "/tmp/c.mp3") + ".mp3"},
+			// the video was expired by retention
+			{EncryptedFileName: encrypt(old, "d.mp4"), VideoKey: encrypt(old, "d.mp4"), AudioKey: encrypt(old, "/tmp/d.mp3") + ".mp3"},
+			// the key was removed from the keyring
+			{EncryptedFileName: encrypt(retired, "e.mp4"), VideoKey: encrypt(retired, "e.mp4"), AudioKey: encrypt(retired, "/tmp/e.mp3") + ".mp3"},
+			// random keys, only the filename is encrypted again
+			{EncryptedFileName: encrypt(old, "f.mp4"), VideoKey: videoId, AudioKey: audioId + ".mp3"},
+			{EncryptedFileName: encrypt(rotated, "g.mp4"), VideoKey: uuid.NewString(), AudioKey: uuid.NewString() + ".mp3"},
+		}
+
+		for i, m := range rows {
+			m.Id = int64(i + 1)
+			h.metadata[m.Id] = m
+			h.jobs[m.VideoKey] = &domain.Job{Id: m.VideoKey, VideoKey: m.VideoKey, AudioKey: m.AudioKey, MetadataId: m.Id}
+
+			if m.Id != 4 {
+				h.put("mp4", m.VideoKey+".mp4", "video "+m.VideoKey)
+			}
+		}
+
+		h.put("mp3", rows[0].AudioKey+"..mp3", "audio a")
+		for _, m := range rows[1:] {
+			h.put("mp3", m.AudioKey, "audio "+m.AudioKey)
+		}
+
+		// transcripts are named after their audio, the one of a.mp4 has its text only
+		// and the transcription of c.mp4 is still in flight
+		h.transcripts["transcript-a"] = &domain.Transcription{
+			JobId: "transcript-a", MetadataId: 1, AudioKey: rows[0].AudioKey, Status: domain.JobCompleted,
+			TextKey: transcriptObject(rows[0].AudioKey, ".txt"),
+		}
+		h.transcripts["transcript-b"] = &domain.Transcription{
+			JobId: "transcript-b", MetadataId: 2, AudioKey: rows[1].AudioKey, Status: domain.JobCompleted,
+			SRTKey: transcriptObject(rows[1].AudioKey, ".srt"), VTTKey: transcriptObject(rows[1].AudioKey, ".vtt"), TextKey: transcriptObject(rows[1].AudioKey, ".txt"),
+		}
+		h.transcripts["transcript-c"] = &domain.Transcription{JobId: "transcript-c", MetadataId: 3, AudioKey: rows[2].AudioKey, Status: domain.JobProcessing}
+
+		for _, t := range h.transcripts {
+			for _, key := range []string{t.SRTKey, t.VTTKey, t.TextKey} {
+				if key != "" {
+					h.put("mp3", key, "transcript "+key)
+				}
+			}
+		}
+
+		// b.mp4 was drawn, pictured and streamed, all under the name of its audio
+		b := rows[1]
+		b.PeaksKey, b.WaveformKey = peaksObject(b.AudioKey), waveformObject(b.AudioKey)
+		b.ThumbnailKey, b.PreviewKey = thumbnailObject(b.AudioKey, ".jpg"), thumbnailObject(b.AudioKey, ".webp")
+		b.StreamKey = streamObject(b.AudioKey, domain.MasterPlaylist)
+		for _, key := range []string{b.PeaksKey, b.WaveformKey, b.ThumbnailKey, b.PreviewKey, streamObject(b.AudioKey, "audio/segment000.ts")} {
+			h.put("mp3", key, "file "+key)
+		}
+		h.put("mp3", b.StreamKey, "#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=128000\naudio/index.m3u8\n")
+		h.put("mp3", streamObject(b.AudioKey, "audio/index.m3u8"), "#EXTM3U\n#EXTINF:6.0,\nsegment000.ts\n")
+		h.streams["stream-b"] = &domain.Transcoding{JobId: "stream-b", MetadataId: 2, Status: domain.JobCompleted, PlaylistKey: b.StreamKey}
+
+		return h, NewRekeyer(h, keyRepo{h}, rotated, NewDeliveryService(h, h, "mp3", time.Hour), "mp4", "mp3")
+	}
+
+	read := func(t *testing.T, h *harness, bucket, key string) string {
+		t.Helper()
+
+		file, err := h.FileStore.Read(context.Background(), bucket, key)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", key, err)
+		}
+		defer file.Close()
+
+		body, _ := io.ReadAll(file)
+		return string(body)
+	}
+
+	t.Run("dry run", func(t *testing.T) {
+		h, rk := setup()
+		before := h.count("mp4", "mp3")
+
+		report, err := rk.Rekey(context.Background(), true)
+		if err != nil {
+			t.Fatalf("Rekey failed: %v", err)
+		}
+
+		if report.Scanned != 7 || report.Rekeyed != 5 || report.Failed != 1 {
+			t.Errorf("Expected 7 scanned, 5 rekeyed and 1 failed, got %+v", report)
+		}
+
+		if h.count("mp4", "mp3") != before || h.metadata[1].VideoKey != encrypt(legacy, "a.mp4") {
+			t.Error("Expected a dry run to change nothing")
+		}
+	})
+
+	t.Run("rekey", func(t *testing.T) {
+		h, rk := setup()
+		oldAudio := h.metadata[2].AudioKey
+		oldVideo := h.metadata[2].VideoKey
+		oldTranscript := *h.transcripts["transcript-b"]
+		oldDerived := *h.metadata[2]
+
+		report, err := rk.Rekey(context.Background(), false)
+		if err != nil {
+			t.Fatalf("Rekey failed: %v", err)
+		}
+
+		if report.Scanned != 7 || report.Rekeyed != 5 || report.Failed != 1 {
+			t.Errorf("Expected 7 scanned, 5 rekeyed and 1 failed, got %+v", report)
+		}
+
+		for id, name := range map[int64]string{1: "a", 2: "b", 3: "c", 4: "d"} {
+			m := h.metadata[id]
+			if m.EncryptedFileName != encrypt(rotated, name+".mp4") {
+				t.Errorf("Expected the filename of metadata %d to be encrypted with the active key", id)
+			}
+
+			audioId, ext, _ := strings.Cut(m.AudioKey, ".")
+			if uuid.Validate(m.VideoKey) != nil || uuid.Validate(audioId) != nil || ext != "mp3" {
+				t.Errorf("Expected metadata %d to have random keys, got %q and %q", id, m.VideoKey, m.AudioKey)
+			}
+
+			for _, j := range h.jobs {
+				if j.MetadataId == id && (j.VideoKey != m.VideoKey || j.AudioKey != m.AudioKey) {
+					t.Errorf("Expected the job of metadata %d to have the new keys", id)
+				}
+			}
+
+			if !h.has("mp3", m.AudioKey) {
+				t.Errorf("Expected audio of metadata %d under its new key", id)
+			}
+		}
+
+		// random keys stay, the objects aren't moved
+		if m := h.metadata[6]; m.EncryptedFileName != encrypt(rotated, "f.mp4") || m.VideoKey != videoId || m.AudioKey != audioId+".mp3" {
+			t.Errorf("Expected only the filename of metadata 6 to change, got %+v", m)
+		}
+
+		// the objects are moved with their content
+		if got := read(t, h, "mp3", h.metadata[1].AudioKey); got != "audio a" {
+			t.Errorf("Expected %q, got %q", "audio a", got)
+		}
+
+		if got := read(t, h, "mp4", h.metadata[2].VideoKey+".mp4"); got != "video "+oldVideo {
+			t.Errorf("Expected %q, got %q", "video "+oldVideo, got)
+		}
+
+		if h.has("mp3", oldAudio) || h.has("mp4", oldVideo+".mp4") {
+			t.Error("Expected the old objects to be deleted")
+		}
+
+		if h.has("mp4", h.metadata[4].VideoKey+".mp4") {
+			t.Error("Expected no video for metadata whose video was expired")
+		}
+
+		if h.metadata[5].EncryptedFileName != encrypt(retired, "e.mp4") || !h.has("mp4", h.metadata[5].VideoKey+".mp4") {
+			t.Error("Expected metadata that can't be decrypted to be left alone")
+		}
+
+		// transcripts follow their audio, the reconciler keeps them as long as it
+		for id, jobId := range map[int64]string{1: "transcript-a", 2: "transcript-b", 3: "transcript-c"} {
+			tr := h.transcripts[jobId]
+			if tr.AudioKey != h.metadata[id].AudioKey {
+				t.Errorf("Expected the transcript of metadata %d to name its new audio, got %q", id, tr.AudioKey)
+			}
+
+			for _, key := range []string{tr.SRTKey, tr.VTTKey, tr.TextKey} {
+				if key != "" && (ownerKey(key) != objectKey(tr.AudioKey) || !h.has("mp3", key)) {
+					t.Errorf("Expected transcript %s under the key of its audio, got %q", jobId, key)
+				}
+			}
+		}
+
+		if tr := h.transcripts["transcript-a"]; tr.SRTKey != "" || tr.VTTKey != "" {
+			t.Errorf("Expected the transcript of metadata 1 to keep its text only, got %+v", tr)
+		}
+
+		if got := read(t, h, "mp3", h.transcripts["transcript-b"].VTTKey); got != "transcript "+oldTranscript.VTTKey {
+			t.Errorf("Expected %q, got %q", "transcript "+oldTranscript.VTTKey, got)
+		}
+
+		if h.has("mp3", oldTranscript.SRTKey) || h.has("mp3", oldTranscript.TextKey) {
+			t.Error("Expected the old transcripts to be deleted")
+		}
+
+		// the drawings, pictures and stream follow the audio too
+		b := h.metadata[2]
+		derived := map[string]string{
+			b.PeaksKey: oldDerived.PeaksKey, b.WaveformKey: oldDerived.WaveformKey,
+			b.ThumbnailKey: oldDerived.ThumbnailKey, b.PreviewKey: oldDerived.PreviewKey,
+			b.StreamKey: oldDerived.StreamKey, streamObject(b.AudioKey, "audio/segment000.ts"): streamObject(oldAudio, "audio/segment000.ts"),
+		}
+		for key, old := range derived {
+			if ownerKey(key) != objectKey(b.AudioKey) || !h.has("mp3", key) || h.has("mp3", old) {
+				t.Errorf("Expected %s to be moved under the key of its audio, got %q", old, key)
+			}
+		}
+
+		if !strings.HasSuffix(b.ThumbnailKey, ".jpg") || !strings.HasSuffix(b.PreviewKey, ".webp") || h.streams["stream-b"].PlaylistKey != b.StreamKey {
+			t.Errorf("Expected the pictures to keep their format and the transcoding to name the moved stream, got %+v", b)
+		}
+
+		if got := read(t, h, "mp3", streamObject(b.AudioKey, "audio/index.m3u8")); got != "#EXTM3U\n#EXTINF:6.0,\nsegment000.ts\n" {
+			t.Errorf("Expected the playlist to be moved as it was, got %q", got)
+		}
+
+		// links to the 4 moved audios, the 4 moved transcripts and the 7 files made from b.mp3 stop working
+		if len(h.invalidated) != 15 || !slices.Contains(h.invalidated, oldAudio) || !slices.Contains(h.invalidated, oldTranscript.SRTKey) ||
+			!slices.Contains(h.invalidated, oldDerived.StreamKey) {
+			t.Errorf("Expected the 15 moved objects to be invalidated, got %v", h.invalidated)
+		}
+
+		// a second run finds nothing left to do
+		report, err = rk.Rekey(context.Background(), false)
+		if err != nil || report.Rekeyed != 0 {
+			t.Errorf("Expected nothing to rekey, got %+v, %v", report, err)
+		}
+	})
+
+	t.Run("failed copy keeps the old keys", func(t *testing.T) {
+		h, rk := setup()
+		before, objects := *h.metadata[1], h.count("mp4", "mp3")
+		h.fails["read"] = 1
+
+		report, err := rk.Rekey(context.Background(), false)
+		if err != nil {
+			t.Fatalf("Rekey failed: %v", err)
+		}
+
+		if report.Failed != 2 || h.metadata[1].VideoKey != before.VideoKey || h.metadata[1].AudioKey != before.AudioKey {
+			t.Errorf("Expected metadata 1 to keep its keys, got %+v", report)
+		}
+
+		if !h.has("mp3", before.AudioKey+"..mp3") || h.count("mp4", "mp3") != objects {
+			t.Errorf("Expected the old objects to stay and no copies to be left, got %d objects", h.count("mp4", "mp3"))
+		}
+	})
+}
@@ -0,0 +1,241 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/ziliscite/video-to-mp3/converter/internal/domain"
+	"github.com/ziliscite/video-to-mp3/converter/internal/repository"
+	"github.com/ziliscite/video-to-mp3/platform/storage"
+)
+
+type RetentionPolicy struct {
+	// DeleteVideoOnSuccess removes the source video as soon as its conversion completes.
+	DeleteVideoOnSuccess bool
+	// VideoDays is how long source videos are kept after conversion, 0 keeps them forever.
+	VideoDays int
+	// AudioDays is how long unpinned audio is kept after conversion, 0 keeps it forever.
+	AudioDays int
+}
+
+// Expired is an object past its retention period.
+type Expired struct {
+	MetadataId int64
+	Bucket     string
+	Key        string
+}
+
+type RetentionReport struct {
+	Videos []Expired
+	Audios []Expired
+	// Deleted is the number of expired objects removed, always 0 in a dry run.
+	Deleted int
+	// Failed is the number of expired objects that could not be removed.
+	Failed int
+}
+
+type RetentionService interface {
+	// AfterConversion applies the policy to a freshly completed conversion.
+	AfterConversion(ctx context.Context, metadata *domain.Metadata) error
+	// Expire deletes the videos and audio past their retention period,
+	// or only reports them when dryRun is set.
+	Expire(ctx context.Context, dryRun bool) (*RetentionReport, error)
+	// Pin exempts the user's audio from expiry, or lets it expire again.
+	Pin(ctx context.Context, userId int64, audioKey string, pinned bool) error
+}
+
+type retentionService struct {
+	fr  storage.FileStore
+	rr  repository.RetentionRepository
+	ds  DeliveryService
+	p   RetentionPolicy
+	b   bucket
+	now func() time.Time
+}
+
+func NewRetentionService(fr storage.FileStore, rr repository.RetentionRepository, ds DeliveryService, policy RetentionPolicy, mp4Bucket, mp3Bucket string) RetentionService {
+	return &retentionService{
+		fr: fr,
+		rr: rr,
+		ds: ds,
+		p:  policy,
+		b: bucket{
+			mp4: mp4Bucket,
+			mp3: mp3Bucket,
+		},
+		now: time.Now,
+	}
+}
+
+func (r *retentionService) AfterConversion(ctx context.Context, metadata *domain.Metadata) error {
+	if !r.p.DeleteVideoOnSuccess {
+		return nil
+	}
+
+	return r.deleteVideo(ctx, metadata)
+}
+
+func (r *retentionService) Expire(ctx context.Context, dryRun bool) (*RetentionReport, error) {
+	report := &RetentionReport{}
+
+	var videos, audios []domain.Metadata
+	if r.p.VideoDays > 0 {
+		list, err := r.rr.ExpiredVideos(ctx, r.cutoff(r.p.VideoDays))
+		if err != nil {
+			return nil, fmt.Errorf("failed to list expired videos: %w", err)
+		}
+		videos = list
+	}
+
+	if r.p.AudioDays > 0 {
+		list, err := r.rr.ExpiredAudios(ctx, r.cutoff(r.p.AudioDays))
+		if err != nil {
+			return nil, fmt.Errorf("failed to list expired audio: %w", err)
+		}
+		audios = list
+	}
+
+	for _, m := range videos {
+		report.Videos = append(report.Videos, Expired{MetadataId: m.Id, Bucket: r.b.mp4, Key: videoObject(m.VideoKey)})
+	}
+
+	for _, m := range audios {
+		for _, key := range m.AudioKeys() {
+			report.Audios = append(report.Audios, Expired{MetadataId: m.Id, Bucket: r.b.mp3, Key: key})
+		}
+	}
+
+	if dryRun {
+		return report, nil
+	}
+
+	for i := range videos {
+		if err := r.deleteVideo(ctx, &videos[i]); err != nil {
+			slog.Error("Failed to expire video", "metadata_id", videos[i].Id, "error", err)
+			report.Failed++
+			continue
+		}
+		report.Deleted++
+	}
+
+	var evicted []string
+	for i := range audios {
+		if err := r.deleteAudio(ctx, &audios[i]); err != nil {
+			slog.Error("Failed to expire audio", "metadata_id", audios[i].Id, "error", err)
+			report.Failed++
+			continue
+		}
+		report.Deleted++
+		for _, key := range audios[i].AudioKeys() {
+			evicted = append(evicted, audioObjects(key)...)
+		}
+	}
+
+	// the audio is gone either way, a failed invalidation only leaves it cached until the cache expires
+	if err := r.ds.Invalidate(ctx, evicted...); err != nil {
+		slog.Error("Failed to invalidate expired audio", "count", len(evicted), "error", err)
+	}
+
+	return report, nil
+}
+
+func (r *retentionService) Pin(ctx context.Context, userId int64, audioKey string, pinned bool) error {
+	if err := r.rr.SetPinned(ctx, userId, audioKey, pinned); err != nil {
+		switch {
+		case errors.Is(err, repository.ErrRecordNotFound):
+			return fmt.Errorf("no audio %s to pin: %w", audioKey, err)
+		default:
+			return fmt.Errorf("%w: failed to pin audio: %w", ErrInternal, err)
+		}
+	}
+
+	return nil
+}
+
+func (r *retentionService) cutoff(days int) time.Time {
+	return r.now().AddDate(0, 0, -days)
+}
+
+// deleteVideo removes the object before marking the row, so a failure in between is retried on the next run.
+func (r *retentionService) deleteVideo(ctx context.Context, m *domain.Metadata) error {
+	if err := r.delete(ctx, r.b.mp4, videoObject(m.VideoKey)); err != nil {
+		return err
+	}
+
+	if err := r.rr.MarkVideoDeleted(ctx, m.Id); err != nil && !errors.Is(err, repository.ErrRecordNotFound) {
+		return fmt.Errorf("failed to mark video deleted: %w", err)
+	}
+
+	return nil
+}
+
+func (r *retentionService) deleteAudio(ctx context.Context, m *domain.Metadata) error {
+	for _, audioKey := range m.AudioKeys() {
+		objects := audioObjects(audioKey)
+		// older audio, stored without its extension, was never drawn, nor was the video it came from
+		if strings.Contains(audioKey, ".") {
+			objects = append(objects, peaksObject(audioKey), waveformObject(audioKey))
+			for _, ext := range []string{".jpg", ".webp", ".gif"} {
+				objects = append(objects, thumbnailObject(audioKey, ext))
+			}
+		}
+		// but any audio may have been transcribed
+		for _, ext := range []string{".srt", ".vtt", ".txt"} {
+			objects = append(objects, transcriptObject(audioKey, ext))
+		}
+
+		for _, key := range objects {
+			if err := r.delete(ctx, r.b.mp3, key); err != nil {
+				return err
+			}
+		}
+	}
+
+	if m.StreamKey != "" {
+		objects, err := streamObjects(ctx, r.fr, r.b.mp3, m.StreamKey)
+		if err != nil {
+			return err
+		}
+
+		for _, key := range objects {
+			if err = r.delete(ctx, r.b.mp3, key); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := r.rr.MarkAudioDeleted(ctx, m.Id); err != nil && !errors.Is(err, repository.ErrRecordNotFound) {
+		return fmt.Errorf("failed to mark audio deleted: %w", err)
+	}
+
+	return nil
+}
+
+// delete treats an object that is already gone as deleted.
+func (r *retentionService) delete(ctx context.Context, bucket, key string) error {
+	if err := r.fr.Delete(ctx, bucket, key); err != nil && !errors.Is(err, storage.ErrNotExist) {
+		return fmt.Errorf("failed to delete %s from %s: %w", key, bucket, err)
+	}
+
+	return nil
+}
+
+// videoObject returns the object name of a video, see the gateway's UploadVideo.
+func videoObject(videoKey string) string {
+	return fmt.Sprintf("%s.mp4", videoKey)
+}
+
+// audioObjects returns the object names an audio key may be stored under.
+// Older audio keys were stored without their extension, and the object had a doubled dot
+// before it, so every extension the converter produces is tried.
+func audioObjects(audioKey string) []string {
+	if strings.Contains(audioKey, ".") {
+		return []string{audioKey}
+	}
+
+	return []string{audioKey + "..aac", audioKey + "..mp3", audioKey + "..wav"}
+}
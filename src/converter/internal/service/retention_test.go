@@ -0,0 +1,322 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ziliscite/video-to-mp3/converter/internal/domain"
+	"github.com/ziliscite/video-to-mp3/converter/internal/repository"
+)
+
+type retained struct {
+	domain.Metadata
+	createdAt    time.Time
+	pinned       bool
+	videoDeleted bool
+	audioDeleted bool
+}
+
+// retentionRepo mirrors the queries of the postgres retention repository.
+type retentionRepo struct {
+	rows []*retained
+}
+
+func (r *retentionRepo) ExpiredVideos(ctx context.Context, before time.Time) ([]domain.Metadata, error) {
+	var list []domain.Metadata
+	for _, row := range r.rows {
+		if !row.videoDeleted && row.createdAt.Before(before) {
+			list = append(list, row.Metadata)
+		}
+	}
+	return list, nil
+}
+
+func (r *retentionRepo) ExpiredAudios(ctx context.Context, before time.Time) ([]domain.Metadata, error) {
+	var list []domain.Metadata
+	for _, row := range r.rows {
+		if !row.audioDeleted && !row.pinned && row.createdAt.Before(before) {
+			list = append(list, row.Metadata)
+		}
+	}
+	return list, nil
+}
+
+func (r *retentionRepo) find(id int64) *retained {
+	for _, row := range r.rows {
+		if row.Id == id {
+			return row
+		}
+	}
+	return nil
+}
+
+func (r *retentionRepo) MarkVideoDeleted(ctx context.Context, id int64) error {
+	row := r.find(id)
+	if row == nil || row.videoDeleted {
+		return repository.ErrRecordNotFound
+	}
+	row.videoDeleted = true
+	return nil
+}
+
+func (r *retentionRepo) MarkAudioDeleted(ctx context.Context, id int64) error {
+	row := r.find(id)
+	if row == nil || row.audioDeleted {
+		return repository.ErrRecordNotFound
+	}
+	row.audioDeleted = true
+	return nil
+}
+
+func (r *retentionRepo) SetPinned(ctx context.Context, userId int64, audioKey string, pinned bool) error {
+	for _, row := range r.rows {
+		if row.UserId == userId && row.AudioKey == audioKey && !row.audioDeleted {
+			row.pinned = pinned
+			return nil
+		}
+	}
+	return repository.ErrRecordNotFound
+}
+
+func setupRetention(policy RetentionPolicy) (*harness, *retentionRepo, *retentionService) {
+	now := time.Now()
+	h := newHarness()
+	rr := &retentionRepo{rows: []*retained{
+		{Metadata: domain.Metadata{Id: 1, UserId: 1, VideoKey: "old", AudioKey: "old.mp3"}, createdAt: now.AddDate(0, 0, -40)},
+		{Metadata: domain.Metadata{Id: 2, UserId: 1, VideoKey: "pinned", AudioKey: "pinned.mp3"}, createdAt: now.AddDate(0, 0, -40), pinned: true},
+		{Metadata: domain.Metadata{Id: 3, UserId: 1, VideoKey: "legacy", AudioKey: "legacy"}, createdAt: now.AddDate(0, 0, -40)},
+		{Metadata: domain.Metadata{Id: 4, UserId: 1, VideoKey: "new", AudioKey: "new.mp3"}, createdAt: now.AddDate(0, 0, -1)},
+	}}
+
+	for _, row := range rr.rows {
+		h.put("mp4", row.VideoKey+".mp4", "video")
+	}
+	h.put("mp3", "old.mp3", "audio")
+	h.put("mp3", "pinned.mp3", "audio")
+	h.put("mp3", "legacy..mp3", "audio")
+	h.put("mp3", "new.mp3", "audio")
+
+	rs := NewRetentionService(h, rr, NewDeliveryService(h, h, "mp3", time.Hour), policy, "mp4", "mp3").(*retentionService)
+	return h, rr, rs
+}
+
+func TestRetention(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("dry run reports without deleting", func(t *testing.T) {
+		h, _, rs := setupRetention(RetentionPolicy{VideoDays: 7, AudioDays: 30})
+
+		report, err := rs.Expire(ctx, true)
+		if err != nil {
+			t.Fatalf("Failed to expire: %v", err)
+		}
+
+		if len(report.Videos) != 3 || len(report.Audios) != 2 || report.Deleted != 0 {
+			t.Errorf("Unexpected report: %+v", report)
+		}
+
+		if h.count("mp4", "mp3") != 8 {
+			t.Errorf("Expected nothing deleted in a dry run, %d objects left", h.count("mp4", "mp3"))
+		}
+	})
+
+	t.Run("expires old objects except pinned audio", func(t *testing.T) {
+		h, rr, rs := setupRetention(RetentionPolicy{VideoDays: 7, AudioDays: 30})
+
+		report, err := rs.Expire(ctx, false)
+		if err != nil {
+			t.Fatalf("Failed to expire: %v", err)
+		}
+
+		if report.Deleted != 5 || report.Failed != 0 {
+			t.Errorf("Unexpected report: %+v", report)
+		}
+
+		for _, key := range []string{"mp4/old.mp4", "mp4/pinned.mp4", "mp4/legacy.mp4", "mp3/old.mp3", "mp3/legacy..mp3"} {
+			if bucket, name, _ := strings.Cut(key, "/"); h.has(bucket, name) {
+				t.Errorf("Expected %s to be deleted", key)
+			}
+		}
+
+		for _, key := range []string{"mp4/new.mp4", "mp3/pinned.mp3", "mp3/new.mp3"} {
+			if bucket, name, _ := strings.Cut(key, "/"); !h.has(bucket, name) {
+				t.Errorf("Expected %s to be kept", key)
+			}
+		}
+
+		if !rr.rows[0].videoDeleted || !rr.rows[0].audioDeleted || rr.rows[1].audioDeleted || rr.rows[3].videoDeleted {
+			t.Errorf("Metadata not marked as expected: %+v %+v %+v", rr.rows[0], rr.rows[1], rr.rows[3])
+		}
+
+		// every name the expired audio may be cached under, in one batch
+		if len(h.invalidated) != 4 || h.invalidated[0] != "old.mp3" {
+			t.Errorf("Expected the expired audio to be invalidated, got %v", h.invalidated)
+		}
+
+		// a second run has nothing left to do
+		report, err = rs.Expire(ctx, false)
+		if err != nil || report.Deleted != 0 {
+			t.Errorf("Expected an idle second run, got %+v, %v", report, err)
+		}
+	})
+
+	t.Run("split audio expires every part", func(t *testing.T) {
+		h, rr, rs := setupRetention(RetentionPolicy{AudioDays: 30})
+		rr.rows = append(rr.rows, &retained{Metadata: domain.Metadata{
+			Id: 5, UserId: 1, VideoKey: "split", AudioKey: "part-1.mp3",
+			Parts: []domain.Part{{AudioKey: "part-1.mp3"}, {AudioKey: "part-2.mp3"}},
+		}, createdAt: time.Now().AddDate(0, 0, -40)})
+		h.put("mp3", "part-1.mp3", "audio")
+		h.put("mp3", "part-2.mp3", "audio")
+
+		if _, err := rs.Expire(ctx, false); err != nil {
+			t.Fatalf("Failed to expire: %v", err)
+		}
+
+		if h.has("mp3", "part-1.mp3") || h.has("mp3", "part-2.mp3") || !rr.rows[4].audioDeleted {
+			t.Errorf("Expected every part to be deleted, got %+v", rr.rows[4])
+		}
+	})
+
+	t.Run("waveform expires with its audio", func(t *testing.T) {
+		h, rr, rs := setupRetention(RetentionPolicy{AudioDays: 30})
+		rr.rows = append(rr.rows, &retained{Metadata: domain.Metadata{
+			Id: 5, UserId: 1, VideoKey: "drawn", AudioKey: "drawn.mp3",
+		}, createdAt: time.Now().AddDate(0, 0, -40)})
+		h.put("mp3", "drawn.mp3", "audio")
+		h.put("mp3", "drawn.peaks.json", "peaks")
+		h.put("mp3", "drawn.waveform.png", "image")
+
+		if _, err := rs.Expire(ctx, false); err != nil {
+			t.Fatalf("Failed to expire: %v", err)
+		}
+
+		if h.has("mp3", "drawn.peaks.json") || h.has("mp3", "drawn.waveform.png") {
+			t.Errorf("Expected the waveform to be deleted with the audio")
+		}
+	})
+
+	t.Run("transcripts expire with their audio", func(t *testing.T) {
+		h, rr, rs := setupRetention(RetentionPolicy{AudioDays: 30})
+		rr.rows = append(rr.rows, &retained{Metadata: domain.Metadata{
+			Id: 5, UserId: 1, VideoKey: "spoken", AudioKey: "spoken.mp3",
+		}, createdAt: time.Now().AddDate(0, 0, -40)})
+		h.put("mp3", "spoken.mp3", "audio")
+		for _, ext := range []string{".srt", ".vtt", ".txt"} {
+			h.put("mp3", "spoken"+ext, "transcript")
+		}
+
+		if _, err := rs.Expire(ctx, false); err != nil {
+			t.Fatalf("Failed to expire: %v", err)
+		}
+
+		for _, ext := range []string{".srt", ".vtt", ".txt"} {
+			if h.has("mp3", "spoken"+ext) {
+				t.Errorf("Expected spoken%s to be deleted with the audio", ext)
+			}
+		}
+	})
+
+	t.Run("thumbnails expire with their audio", func(t *testing.T) {
+		h, rr, rs := setupRetention(RetentionPolicy{AudioDays: 30})
+		rr.rows = append(rr.rows, &retained{Metadata: domain.Metadata{
+			Id: 5, UserId: 1, VideoKey: "filmed", AudioKey: "filmed.mp3",
+			ThumbnailKey: "thumbnails/filmed.jpg", PreviewKey: "thumbnails/filmed.gif",
+		}, createdAt: time.Now().AddDate(0, 0, -40)})
+		h.put("mp3", "filmed.mp3", "audio")
+		h.put("mp3", "thumbnails/filmed.jpg", "poster")
+		h.put("mp3", "thumbnails/filmed.gif", "preview")
+
+		if _, err := rs.Expire(ctx, false); err != nil {
+			t.Fatalf("Failed to expire: %v", err)
+		}
+
+		if h.has("mp3", "thumbnails/filmed.jpg") || h.has("mp3", "thumbnails/filmed.gif") {
+			t.Errorf("Expected the thumbnails to be deleted with the audio")
+		}
+	})
+
+	t.Run("streams expire with their audio", func(t *testing.T) {
+		h, rr, rs := setupRetention(RetentionPolicy{AudioDays: 30})
+		rr.rows = append(rr.rows, &retained{Metadata: domain.Metadata{
+			Id: 5, UserId: 1, VideoKey: "streamed", AudioKey: "streamed.mp3", StreamKey: "streams/streamed/master.m3u8",
+		}, createdAt: time.Now().AddDate(0, 0, -40)})
+		h.put("mp3", "streamed.mp3", "audio")
+		h.put("mp3", "streams/streamed/master.m3u8", "#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=128000\naudio/index.m3u8\n")
+		h.put("mp3", "streams/streamed/audio/index.m3u8", "#EXTM3U\n#EXTINF:6.0,\nsegment000.ts\n../../other/index.m3u8\n")
+		h.put("mp3", "streams/streamed/audio/segment000.ts", "ts")
+		h.put("mp3", "streams/other/index.m3u8", "#EXTM3U\n")
+
+		if _, err := rs.Expire(ctx, false); err != nil {
+			t.Fatalf("Failed to expire: %v", err)
+		}
+
+		for _, key := range []string{"streams/streamed/master.m3u8", "streams/streamed/audio/index.m3u8", "streams/streamed/audio/segment000.ts"} {
+			if h.has("mp3", key) {
+				t.Errorf("Expected %s to be deleted with the audio", key)
+			}
+		}
+
+		// playlists only name files of their own stream
+		if !h.has("mp3", "streams/other/index.m3u8") {
+			t.Errorf("Expected the playlist of another stream to be kept")
+		}
+	})
+
+	t.Run("zero days keeps objects forever", func(t *testing.T) {
+		h, _, rs := setupRetention(RetentionPolicy{})
+
+		report, err := rs.Expire(ctx, false)
+		if err != nil {
+			t.Fatalf("Failed to expire: %v", err)
+		}
+
+		if report.Deleted != 0 || h.count("mp4", "mp3") != 8 {
+			t.Errorf("Expected nothing to expire, got %+v", report)
+		}
+	})
+
+	t.Run("video deleted after conversion", func(t *testing.T) {
+		h, rr, rs := setupRetention(RetentionPolicy{DeleteVideoOnSuccess: true})
+
+		if err := rs.AfterConversion(ctx, &rr.rows[3].Metadata); err != nil {
+			t.Fatalf("Failed to apply policy: %v", err)
+		}
+
+		if h.has("mp4", "new.mp4") || !rr.rows[3].videoDeleted {
+			t.Errorf("Expected the source video to be deleted")
+		}
+
+		// a redelivered conversion applies the policy again
+		if err := rs.AfterConversion(ctx, &rr.rows[3].Metadata); err != nil {
+			t.Errorf("Expected the second application to be a no-op, got %v", err)
+		}
+	})
+
+	t.Run("video kept after conversion", func(t *testing.T) {
+		h, rr, rs := setupRetention(RetentionPolicy{})
+
+		if err := rs.AfterConversion(ctx, &rr.rows[3].Metadata); err != nil {
+			t.Fatalf("Failed to apply policy: %v", err)
+		}
+
+		if !h.has("mp4", "new.mp4") {
+			t.Errorf("Expected the source video to be kept")
+		}
+	})
+
+	t.Run("pin", func(t *testing.T) {
+		_, rr, rs := setupRetention(RetentionPolicy{AudioDays: 30})
+
+		if err := rs.Pin(ctx, 1, "old.mp3", true); err != nil || !rr.rows[0].pinned {
+			t.Errorf("Expected audio to be pinned, got %v", err)
+		}
+
+		if err := rs.Pin(ctx, 2, "old.mp3", true); !errors.Is(err, repository.ErrRecordNotFound) || errors.Is(err, ErrInternal) {
+			t.Errorf("Expected pinning another user's audio to fail permanently, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,213 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ziliscite/video-to-mp3/converter/internal/domain"
+	"github.com/ziliscite/video-to-mp3/converter/internal/repository"
+	"github.com/ziliscite/video-to-mp3/platform/storage"
+)
+
+// streamPrefix is where the HLS versions of videos are stored in the audio bucket, each in a directory named after its audio.
+const streamPrefix = "streams/"
+
+var (
+	// ErrVideoNotFound is returned when the user has no such conversion, or its video was deleted.
+	ErrVideoNotFound = errors.New("video not found")
+	// ErrStreamEncrypted is returned when streams would be envelope encrypted, no player could play them.
+	ErrStreamEncrypted = errors.New("streams can't be served from encrypted storage")
+)
+
+type ConverterStream interface {
+	// Stream transcodes the video of the user's conversion into HLS as the streaming says,
+	// and stores it under the streams prefix named after its audio, replacing an earlier one.
+	// The progress function, if any, is called with the share of the renditions transcoded so far.
+	// Running a job again resumes it, and a completed job returns as it was.
+	Stream(ctx context.Context, jobId string, userId, metadataId int64, videoKey, audioKey string, filesize int64, s domain.Streaming, progress func(done float64)) (*domain.Transcoding, error)
+	// RecordStreamFailure marks the transcoding as dropped, keeping the error for operators.
+	// Requests for videos that are gone were never recorded, so there is nothing to mark.
+	RecordStreamFailure(ctx context.Context, jobId string, err error) error
+}
+
+func (c *converterService) Stream(ctx context.Context, jobId string, userId, metadataId int64, videoKey, audioKey string, filesize int64, s domain.Streaming, progress func(done float64)) (*domain.Transcoding, error) {
+	st := &streaming{
+		transcoding: &domain.Transcoding{JobId: jobId, UserId: userId, MetadataId: metadataId, Renditions: s.Renditions},
+		videoKey:    videoKey, audioKey: audioKey, filesize: filesize, streaming: s, progress: progress,
+	}
+	defer st.release()
+
+	if err := c.streamPipeline().run(ctx, st, "job_id", jobId); err != nil {
+		return nil, err
+	}
+	return st.result, nil
+}
+
+// streaming is the state of the transcoding of a video into HLS.
+type streaming struct {
+	workspace
+
+	// the request, the transcoding is replaced by the claimed one
+	transcoding        *domain.Transcoding
+	videoKey, audioKey string
+	filesize           int64
+	streaming          domain.Streaming
+	progress           func(done float64)
+
+	stream *domain.Stream
+	result *domain.Transcoding
+}
+
+// streamPipeline transcodes the video into HLS and stores it under the streams prefix.
+func (c *converterService) streamPipeline() *pipeline[streaming] {
+	return &pipeline[streaming]{
+		name: "stream",
+		stages: []stage[streaming]{
+			{name: "validate", run: func(_ context.Context, s *streaming) error { return s.streaming.Validate() }},
+			{name: "claim", run: c.claimTranscoding, transient: except(ErrVideoNotFound)},
+			{name: "servable", run: c.streamServable},
+			{name: "read", run: c.readStream, transient: transientStorage},
+			{name: "transcode", run: c.transcode},
+			{name: "store", run: c.storeStream, transient: transientStorage},
+			{name: "complete", run: c.completeTranscoding, transient: always},
+		},
+		// the stream is stored but the message wasn't acked
+		done: func(s *streaming) bool { return s.result != nil },
+	}
+}
+
+func (c *converterService) claimTranscoding(ctx context.Context, s *streaming) error {
+	t, err := c.sr.Claim(ctx, s.transcoding)
+	if err != nil {
+		if errors.Is(err, repository.ErrRecordNotFound) {
+			return fmt.Errorf("%w: %s", ErrVideoNotFound, s.videoKey)
+		}
+		return fmt.Errorf("failed to claim transcoding: %w", err)
+	}
+
+	if t.Status == domain.JobCompleted {
+		s.result = t
+	}
+	s.transcoding = t
+	return nil
+}
+
+// streamServable refuses to transcode the video when the stream would be stored envelope encrypted, players
+// fetch its segments through direct links and there are none to encrypted files. Any other error of the link
+// is left for whoever serves the stream, there's nothing stored under its key yet.
+func (c *converterService) streamServable(ctx context.Context, s *streaming) error {
+	_, err := c.fr.Presign(ctx, c.b.mp3, streamObject(s.audioKey, domain.MasterPlaylist), time.Minute)
+	if errors.Is(err, storage.ErrEncrypted) {
+		return fmt.Errorf("%w: %s", ErrStreamEncrypted, c.b.mp3)
+	}
+	return nil
+}
+
+func (c *converterService) readStream(ctx context.Context, s *streaming) (err error) {
+	s.video, err = c.readVideo(ctx, s.videoKey, s.filesize)
+	return err
+}
+
+func (c *converterService) transcode(ctx context.Context, s *streaming) (err error) {
+	if err = s.mkdir("stream-*"); err != nil {
+		return err
+	}
+
+	if s.stream, err = c.cv.Stream(ctx, s.dir, s.video, s.streaming, s.progress); err != nil {
+		return fmt.Errorf("failed to transcode video: %w", err)
+	}
+	return nil
+}
+
+func (c *converterService) storeStream(ctx context.Context, s *streaming) error {
+	// the master playlist is stored last, players can't find a stream missing segments
+	for _, name := range s.stream.Files {
+		if err := c.storeFile(ctx, filepath.Join(s.stream.Dir, filepath.FromSlash(name)), streamObject(s.audioKey, name)); err != nil {
+			return fmt.Errorf("failed to store stream: %w", err)
+		}
+	}
+	return nil
+}
+
+func (c *converterService) completeTranscoding(ctx context.Context, s *streaming) error {
+	s.transcoding.PlaylistKey = streamObject(s.audioKey, domain.MasterPlaylist)
+	if err := c.sr.Complete(ctx, s.transcoding); err != nil {
+		return fmt.Errorf("failed to complete transcoding: %w", err)
+	}
+
+	s.result = s.transcoding
+	return nil
+}
+
+func (c *converterService) RecordStreamFailure(ctx context.Context, jobId string, err error) error {
+	if err = c.sr.Fail(ctx, jobId, jobFailure(err)); err != nil && !errors.Is(err, repository.ErrRecordNotFound) {
+		return fmt.Errorf("failed to record failure of transcoding %s: %w", jobId, err)
+	}
+
+	return nil
+}
+
+// streamObject names a file of the stream of the video of an audio, name is its path in the stream.
+// The reconciler keeps it for as long as the audio is known.
+func streamObject(audioKey, name string) string {
+	return streamPrefix + path.Join(objectKey(audioKey), name)
+}
+
+// streamObjects returns the files of the stream whose master playlist is stored under the key, as its playlists name them.
+// The playlists come after their segments and the master playlist last, so a stream partly deleted is found again by the next run.
+func streamObjects(ctx context.Context, fr storage.FileReader, bucket, masterKey string) ([]string, error) {
+	variants, err := playlist(ctx, fr, bucket, masterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var objects []string
+	for _, variant := range variants {
+		segments, err := playlist(ctx, fr, bucket, variant)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(append(objects, segments...), variant)
+	}
+
+	return append(objects, masterKey), nil
+}
+
+// playlist returns the keys of the entries of the playlist stored under the key, none when it's already gone.
+func playlist(ctx context.Context, fr storage.FileReader, bucket, key string) ([]string, error) {
+	f, err := fr.Read(ctx, bucket, key)
+	if errors.Is(err, storage.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s from %s: %w", key, bucket, err)
+	}
+	defer f.Close()
+
+	dir := path.Dir(key)
+
+	var keys []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// entries are relative to the playlist, nothing outside of the stream is reached through them
+		if entry := path.Join(dir, line); strings.HasPrefix(entry, dir+"/") {
+			keys = append(keys, entry)
+		}
+	}
+
+	if err = sc.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s from %s: %w", key, bucket, err)
+	}
+	return keys, nil
+}
@@ -0,0 +1,119 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/ziliscite/video-to-mp3/converter/internal/domain"
+	"github.com/ziliscite/video-to-mp3/converter/internal/repository"
+)
+
+// thumbnailPrefix is where the pictures of videos are stored in the audio bucket.
+const thumbnailPrefix = "thumbnails/"
+
+// ErrThumbnailDisabled is returned when the converter is configured to draw no pictures.
+var ErrThumbnailDisabled = errors.New("thumbnails are disabled")
+
+type ConverterImage interface {
+	// Thumbnail draws the poster frame of the video of the conversion, and its animated preview when asked for
+	// and configured, and stores them under the thumbnails prefix named after its audio, replacing earlier ones.
+	// Returns the keys of the poster and of the preview, which is empty when none was drawn.
+	Thumbnail(ctx context.Context, metadataId int64, videoKey, audioKey string, filesize int64, preview bool) (string, string, error)
+}
+
+func (c *converterService) Thumbnail(ctx context.Context, metadataId int64, videoKey, audioKey string, filesize int64, preview bool) (string, string, error) {
+	s := &thumbnail{metadataId: metadataId, videoKey: videoKey, audioKey: audioKey, filesize: filesize, preview: preview}
+	defer s.release()
+
+	if err := c.thumbnailPipeline().run(ctx, s, "metadata_id", metadataId); err != nil {
+		return "", "", err
+	}
+	return s.thumbnailKey, s.previewKey, nil
+}
+
+// thumbnail is the state of the drawing of the pictures of a video.
+type thumbnail struct {
+	workspace
+
+	metadataId         int64
+	videoKey, audioKey string
+	filesize           int64
+	preview            bool
+
+	pictures                 *domain.Thumbnail
+	thumbnailKey, previewKey string
+}
+
+// thumbnailPipeline draws the pictures of the video and stores them, replacing earlier ones.
+func (c *converterService) thumbnailPipeline() *pipeline[thumbnail] {
+	return &pipeline[thumbnail]{
+		name: "thumbnail",
+		stages: []stage[thumbnail]{
+			{name: "enabled", run: c.thumbnailEnabled},
+			{name: "read", run: c.readThumbnail, transient: transientStorage},
+			{name: "draw", run: c.drawThumbnail},
+			{name: "store", run: c.storeThumbnail, transient: transientStorage},
+			{name: "record", run: c.recordThumbnail, transient: except(repository.ErrRecordNotFound)},
+		},
+	}
+}
+
+func (c *converterService) thumbnailEnabled(context.Context, *thumbnail) error {
+	if c.th.Width <= 0 {
+		return ErrThumbnailDisabled
+	}
+	return nil
+}
+
+func (c *converterService) readThumbnail(ctx context.Context, s *thumbnail) (err error) {
+	s.video, err = c.readVideo(ctx, s.videoKey, s.filesize)
+	return err
+}
+
+func (c *converterService) drawThumbnail(ctx context.Context, s *thumbnail) (err error) {
+	if err = s.mkdir("thumbnail-*"); err != nil {
+		return err
+	}
+
+	cfg := c.th
+	if !s.preview {
+		cfg.PreviewFormat = ""
+	}
+
+	if s.pictures, err = c.cv.DrawThumbnail(ctx, s.dir, s.video, cfg); err != nil {
+		return fmt.Errorf("failed to draw thumbnail: %w", err)
+	}
+	return nil
+}
+
+func (c *converterService) storeThumbnail(ctx context.Context, s *thumbnail) error {
+	s.thumbnailKey = thumbnailObject(s.audioKey, filepath.Ext(s.pictures.Poster))
+	if err := c.storeFile(ctx, s.pictures.Poster, s.thumbnailKey); err != nil {
+		return fmt.Errorf("failed to store thumbnail: %w", err)
+	}
+
+	if s.pictures.Preview != "" {
+		s.previewKey = thumbnailObject(s.audioKey, filepath.Ext(s.pictures.Preview))
+		if err := c.storeFile(ctx, s.pictures.Preview, s.previewKey); err != nil {
+			return fmt.Errorf("failed to store preview: %w", err)
+		}
+	}
+	return nil
+}
+
+func (c *converterService) recordThumbnail(ctx context.Context, s *thumbnail) error {
+	if err := c.mr.SetThumbnail(ctx, s.metadataId, s.thumbnailKey, s.previewKey); err != nil {
+		if errors.Is(err, repository.ErrRecordNotFound) {
+			return fmt.Errorf("failed to record thumbnail of metadata %d: %w", s.metadataId, err)
+		}
+		return fmt.Errorf("failed to record thumbnail: %w", err)
+	}
+	return nil
+}
+
+// thumbnailObject names a picture of the video of an audio. The reconciler keeps it for as long as the audio is known.
+func thumbnailObject(audioKey, ext string) string {
+	return thumbnailPrefix + objectKey(audioKey) + ext
+}
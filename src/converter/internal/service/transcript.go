@@ -0,0 +1,186 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ziliscite/video-to-mp3/converter/internal/domain"
+	"github.com/ziliscite/video-to-mp3/converter/internal/repository"
+	"github.com/ziliscite/video-to-mp3/platform/storage"
+	"github.com/ziliscite/video-to-mp3/platform/transcriber"
+)
+
+var (
+	// ErrAudioNotFound is returned when the user has no such audio, or it was deleted.
+	ErrAudioNotFound = errors.New("audio not found")
+	// ErrTranscriptionDisabled is returned when no transcription engine is configured.
+	ErrTranscriptionDisabled = errors.New("transcription is disabled")
+)
+
+type ConverterText interface {
+	// Transcribe transcribes the speech of the user's audio, a part of a split conversion included,
+	// and stores its srt, vtt and plain text transcripts next to it, replacing earlier ones.
+	// The language is an ISO 639-1 code, the engine detects it when empty.
+	// Running a job again resumes it, and a completed job returns its existing transcripts.
+	Transcribe(ctx context.Context, jobId string, userId int64, audioKey, language string) (*domain.Transcription, error)
+	// RecordTranscriptionFailure marks the transcription as dropped, keeping the error for operators.
+	// Requests for audio the user doesn't have were never recorded, so there is nothing to mark.
+	RecordTranscriptionFailure(ctx context.Context, jobId string, err error) error
+}
+
+func (c *converterService) Transcribe(ctx context.Context, jobId string, userId int64, audioKey, language string) (*domain.Transcription, error) {
+	s := &transcription{request: &domain.Transcription{JobId: jobId, UserId: userId, AudioKey: audioKey, Language: language}}
+	defer s.release()
+
+	if err := c.transcriptionPipeline().run(ctx, s, "job_id", jobId); err != nil {
+		return nil, err
+	}
+	return s.result, nil
+}
+
+// transcription is the state of the transcription of stored audio.
+type transcription struct {
+	workspace
+
+	request *domain.Transcription
+
+	audio      string
+	speech     string
+	transcript *transcriber.Transcript
+	result     *domain.Transcription
+}
+
+// transcriptionPipeline transcribes the speech of the audio and stores its transcripts next to it.
+func (c *converterService) transcriptionPipeline() *pipeline[transcription] {
+	return &pipeline[transcription]{
+		name: "transcription",
+		stages: []stage[transcription]{
+			{name: "validate", run: c.validateTranscription},
+			{name: "claim", run: c.claimTranscription, transient: except(ErrAudioNotFound)},
+			{name: "download", run: c.downloadTranscription},
+			{name: "speech", run: c.extractSpeech},
+			// the engine being down doesn't make the audio untranscribable
+			{name: "transcribe", run: c.transcribeSpeech, transient: func(err error) bool { return errors.Is(err, transcriber.ErrUnavailable) }},
+			{name: "store", run: c.storeTranscripts, transient: transientStorage},
+			{name: "complete", run: c.completeTranscription, transient: always},
+		},
+		// the transcription is done but the message wasn't acked
+		done: func(s *transcription) bool { return s.result != nil },
+	}
+}
+
+func (c *converterService) validateTranscription(_ context.Context, s *transcription) error {
+	if c.tr == nil {
+		return ErrTranscriptionDisabled
+	}
+	return domain.ValidateLanguage(s.request.Language)
+}
+
+func (c *converterService) claimTranscription(ctx context.Context, s *transcription) error {
+	t, err := c.xr.Claim(ctx, s.request)
+	if err != nil {
+		if errors.Is(err, repository.ErrRecordNotFound) {
+			return fmt.Errorf("%w: %s", ErrAudioNotFound, s.request.AudioKey)
+		}
+		return fmt.Errorf("failed to claim transcription: %w", err)
+	}
+
+	if t.Status == domain.JobCompleted {
+		s.result = t
+	}
+	s.request = t
+	return nil
+}
+
+func (c *converterService) downloadTranscription(ctx context.Context, s *transcription) error {
+	// ffmpeg works on the stored audio
+	if err := s.mkdir("transcript-*"); err != nil {
+		return err
+	}
+
+	s.audio = filepath.Join(s.dir, "audio"+filepath.Ext(s.request.AudioKey))
+	return c.download(ctx, s.request.AudioKey, s.audio)
+}
+
+func (c *converterService) extractSpeech(ctx context.Context, s *transcription) (err error) {
+	s.speech, err = c.cv.ExtractSpeech(ctx, s.dir, s.audio)
+	return err
+}
+
+func (c *converterService) transcribeSpeech(ctx context.Context, s *transcription) (err error) {
+	if s.transcript, err = c.tr.Transcribe(ctx, s.speech, s.request.Language); err != nil {
+		return fmt.Errorf("failed to transcribe: %w", err)
+	}
+	return nil
+}
+
+func (c *converterService) storeTranscripts(ctx context.Context, s *transcription) error {
+	t, audioKey := s.request, s.request.AudioKey
+
+	t.SRTKey, t.VTTKey, t.TextKey = transcriptObject(audioKey, ".srt"), transcriptObject(audioKey, ".vtt"), transcriptObject(audioKey, ".txt")
+	for key, body := range map[string]string{t.SRTKey: s.transcript.SRT(), t.VTTKey: s.transcript.VTT(), t.TextKey: s.transcript.Text()} {
+		if err := c.save(ctx, key, strings.NewReader(body)); err != nil {
+			return fmt.Errorf("failed to store transcript: %w", err)
+		}
+	}
+	return nil
+}
+
+func (c *converterService) completeTranscription(ctx context.Context, s *transcription) error {
+	if err := c.xr.Complete(ctx, s.request); err != nil {
+		return fmt.Errorf("failed to complete transcription: %w", err)
+	}
+
+	s.result = s.request
+	return nil
+}
+
+func (c *converterService) RecordTranscriptionFailure(ctx context.Context, jobId string, err error) error {
+	if err = c.xr.Fail(ctx, jobId, jobFailure(err)); err != nil && !errors.Is(err, repository.ErrRecordNotFound) {
+		return fmt.Errorf("failed to record failure of transcription %s: %w", jobId, err)
+	}
+
+	return nil
+}
+
+// download writes the stored audio to the path. Older audio keys name their object
+// without its extension, so each name it may be stored under is tried.
+func (c *converterService) download(ctx context.Context, audioKey, path string) error {
+	for _, object := range audioObjects(audioKey) {
+		r, err := c.fr.Read(ctx, c.b.mp3, object)
+		if errors.Is(err, storage.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("%w: failed to read audio: %w", ErrInternal, err)
+		}
+
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			r.Close()
+			return fmt.Errorf("%w: failed to create audio file: %w", ErrInternal, err)
+		}
+
+		_, err = io.Copy(f, r)
+		r.Close()
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return fmt.Errorf("%w: failed to write audio file: %w", ErrInternal, err)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("%w: %s is not in storage", ErrAudioNotFound, audioKey)
+}
+
+// transcriptObject names a transcript of an audio, stored next to it under the same id like its drawing.
+func transcriptObject(audioKey, ext string) string {
+	return objectKey(audioKey) + ext
+}
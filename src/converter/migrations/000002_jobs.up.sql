@@ -0,0 +1,12 @@
+-- one row per video message, so redeliveries are detected by the unique job_id
+CREATE TABLE IF NOT EXISTS jobs (
+    id BIGSERIAL PRIMARY KEY,
+    job_id VARCHAR(64) NOT NULL UNIQUE,
+    user_id BIGINT NOT NULL,
+    video_key VARCHAR(255) NOT NULL,
+    audio_key VARCHAR(255),
+    metadata_id BIGINT REFERENCES metadata(id) ON DELETE SET NULL,
+    status VARCHAR(16) NOT NULL DEFAULT 'processing',
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
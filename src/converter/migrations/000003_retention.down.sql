@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS metadata_audio_retention_idx;
+DROP INDEX IF EXISTS metadata_video_retention_idx;
+
+ALTER TABLE metadata
+    DROP COLUMN IF EXISTS audio_deleted_at,
+    DROP COLUMN IF EXISTS video_deleted_at,
+    DROP COLUMN IF EXISTS pinned;
@@ -0,0 +1,7 @@
+ALTER TABLE metadata
+    ADD COLUMN IF NOT EXISTS pinned BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN IF NOT EXISTS video_deleted_at TIMESTAMP(0) WITH TIME ZONE,
+    ADD COLUMN IF NOT EXISTS audio_deleted_at TIMESTAMP(0) WITH TIME ZONE;
+
+CREATE INDEX IF NOT EXISTS metadata_video_retention_idx ON metadata (created_at) WHERE video_deleted_at IS NULL;
+CREATE INDEX IF NOT EXISTS metadata_audio_retention_idx ON metadata (created_at) WHERE audio_deleted_at IS NULL AND NOT pinned;
@@ -0,0 +1 @@
+ALTER TABLE metadata DROP COLUMN IF EXISTS encrypted_file_name;
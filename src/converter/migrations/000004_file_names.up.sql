@@ -0,0 +1,7 @@
+-- object keys are random ids now, the original filename is kept encrypted here instead.
+-- older video keys are the encrypted filename, so they fill the column for existing rows
+ALTER TABLE metadata ADD COLUMN IF NOT EXISTS encrypted_file_name TEXT;
+
+UPDATE metadata SET encrypted_file_name = video_key WHERE encrypted_file_name IS NULL;
+
+ALTER TABLE metadata ALTER COLUMN encrypted_file_name SET NOT NULL;
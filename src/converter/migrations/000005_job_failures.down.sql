@@ -0,0 +1,4 @@
+ALTER TABLE jobs
+    DROP COLUMN IF EXISTS failure_stderr,
+    DROP COLUMN IF EXISTS failure_error,
+    DROP COLUMN IF EXISTS failure_reason;
@@ -0,0 +1,5 @@
+-- why a job was dropped, the stderr of ffmpeg included, so failures can be looked into
+ALTER TABLE jobs
+    ADD COLUMN IF NOT EXISTS failure_reason VARCHAR(32),
+    ADD COLUMN IF NOT EXISTS failure_error TEXT,
+    ADD COLUMN IF NOT EXISTS failure_stderr TEXT;
@@ -0,0 +1,3 @@
+-- the loudness measured when the audio was normalized, NULL when it wasn't
+ALTER TABLE metadata
+    ADD COLUMN IF NOT EXISTS loudness JSONB;
@@ -0,0 +1,4 @@
+ALTER TABLE jobs
+    DROP COLUMN IF EXISTS parts;
+
+DROP TABLE IF EXISTS audio_parts;
@@ -0,0 +1,18 @@
+-- the chapters a conversion was split into, one audio each. The parts of a job are kept
+-- with it until its metadata is saved, so a redelivered job doesn't convert them again
+CREATE TABLE IF NOT EXISTS audio_parts (
+    id BIGSERIAL PRIMARY KEY,
+    metadata_id BIGINT NOT NULL REFERENCES metadata(id) ON DELETE CASCADE,
+    position INT NOT NULL,
+    title VARCHAR(255) NOT NULL DEFAULT '',
+    start_ms BIGINT NOT NULL,
+    end_ms BIGINT NOT NULL,
+    audio_key VARCHAR(255) NOT NULL,
+    loudness JSONB,
+    UNIQUE (metadata_id, position)
+);
+
+CREATE INDEX IF NOT EXISTS audio_parts_audio_key_idx ON audio_parts (audio_key);
+
+ALTER TABLE jobs
+    ADD COLUMN IF NOT EXISTS parts JSONB;
@@ -0,0 +1,7 @@
+ALTER TABLE audio_parts
+    DROP COLUMN IF EXISTS peaks_key,
+    DROP COLUMN IF EXISTS waveform_key;
+
+ALTER TABLE metadata
+    DROP COLUMN IF EXISTS peaks_key,
+    DROP COLUMN IF EXISTS waveform_key;
@@ -0,0 +1,8 @@
+-- the peaks json and waveform png drawn from the audio, stored next to it, empty when none was drawn
+ALTER TABLE metadata
+    ADD COLUMN IF NOT EXISTS peaks_key VARCHAR(255) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS waveform_key VARCHAR(255) NOT NULL DEFAULT '';
+
+ALTER TABLE audio_parts
+    ADD COLUMN IF NOT EXISTS peaks_key VARCHAR(255) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS waveform_key VARCHAR(255) NOT NULL DEFAULT '';
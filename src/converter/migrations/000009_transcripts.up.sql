@@ -0,0 +1,21 @@
+-- one row per transcription message, so redeliveries are detected by the unique job_id.
+-- the transcripts are stored next to their audio, the keys are set once the job completes
+CREATE TABLE IF NOT EXISTS transcripts (
+    id BIGSERIAL PRIMARY KEY,
+    job_id VARCHAR(64) NOT NULL UNIQUE,
+    user_id BIGINT NOT NULL,
+    metadata_id BIGINT NOT NULL REFERENCES metadata(id) ON DELETE CASCADE,
+    audio_key VARCHAR(255) NOT NULL,
+    language VARCHAR(8) NOT NULL DEFAULT '',
+    status VARCHAR(16) NOT NULL DEFAULT 'processing',
+    srt_key VARCHAR(255) NOT NULL DEFAULT '',
+    vtt_key VARCHAR(255) NOT NULL DEFAULT '',
+    text_key VARCHAR(255) NOT NULL DEFAULT '',
+    failure_reason VARCHAR(32),
+    failure_error TEXT,
+    failure_stderr TEXT,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS transcripts_metadata_id_idx ON transcripts (metadata_id);
@@ -0,0 +1,7 @@
+ALTER TABLE jobs
+    DROP COLUMN IF EXISTS source_audio_key;
+
+DROP INDEX IF EXISTS metadata_parent_id_idx;
+
+ALTER TABLE metadata
+    DROP COLUMN IF EXISTS parent_id;
@@ -0,0 +1,9 @@
+-- a re-encode of an existing audio is a conversion of its own, linked to the one it came from.
+-- its job names the audio it encodes again, conversions of uploaded videos leave it NULL
+ALTER TABLE metadata
+    ADD COLUMN IF NOT EXISTS parent_id BIGINT REFERENCES metadata(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS metadata_parent_id_idx ON metadata (parent_id);
+
+ALTER TABLE jobs
+    ADD COLUMN IF NOT EXISTS source_audio_key VARCHAR(255);
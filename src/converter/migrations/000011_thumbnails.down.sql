@@ -0,0 +1,3 @@
+ALTER TABLE metadata
+    DROP COLUMN IF EXISTS thumbnail_key,
+    DROP COLUMN IF EXISTS preview_key;
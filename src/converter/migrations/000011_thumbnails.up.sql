@@ -0,0 +1,5 @@
+-- the poster frame and animated preview drawn from the video, stored under the thumbnails prefix
+-- once the stage drawing them is done, empty until then or when none could be drawn
+ALTER TABLE metadata
+    ADD COLUMN IF NOT EXISTS thumbnail_key VARCHAR(255) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS preview_key VARCHAR(255) NOT NULL DEFAULT '';
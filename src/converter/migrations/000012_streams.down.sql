@@ -0,0 +1,4 @@
+ALTER TABLE metadata
+    DROP COLUMN IF EXISTS stream_key;
+
+DROP TABLE IF EXISTS streams;
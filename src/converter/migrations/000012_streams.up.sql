@@ -0,0 +1,21 @@
+-- one row per transcoding message, so redeliveries are detected by the unique job_id.
+-- the stream is stored under the streams prefix, its master playlist is named once the job completes
+CREATE TABLE IF NOT EXISTS streams (
+    id BIGSERIAL PRIMARY KEY,
+    job_id VARCHAR(64) NOT NULL UNIQUE,
+    user_id BIGINT NOT NULL,
+    metadata_id BIGINT NOT NULL REFERENCES metadata(id) ON DELETE CASCADE,
+    renditions INTEGER[] NOT NULL DEFAULT '{}',
+    status VARCHAR(16) NOT NULL DEFAULT 'processing',
+    playlist_key VARCHAR(255) NOT NULL DEFAULT '',
+    failure_reason VARCHAR(32),
+    failure_error TEXT,
+    failure_stderr TEXT,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS streams_metadata_id_idx ON streams (metadata_id);
+
+ALTER TABLE metadata
+    ADD COLUMN IF NOT EXISTS stream_key VARCHAR(255) NOT NULL DEFAULT '';
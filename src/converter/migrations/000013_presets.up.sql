@@ -0,0 +1,18 @@
+-- options users saved under a name for their uploads, the tags are templates filled in per upload.
+-- at most one preset of a user is the default, applied to the uploads that name none
+CREATE TABLE IF NOT EXISTS presets (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    format VARCHAR(8) NOT NULL DEFAULT '',
+    bitrate INTEGER NOT NULL DEFAULT 0,
+    loudness VARCHAR(16) NOT NULL DEFAULT '',
+    trim_silence BOOLEAN NOT NULL DEFAULT FALSE,
+    tags JSONB NOT NULL DEFAULT '{}',
+    is_default BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    UNIQUE (user_id, name)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS presets_default_idx ON presets (user_id) WHERE is_default;
@@ -0,0 +1,231 @@
+// Package events defines the messages exchanged between services over RabbitMQ.
+//
+// Every message is wrapped in a versioned Envelope. Payload changes within a version
+// must be additive, a breaking change bumps the version and registers an upgrade so
+// consumers can still read older messages while producers are being rolled out.
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrUnknownType    = errors.New("unknown event type")
+	ErrInvalidMessage = errors.New("invalid message")
+)
+
+const (
+	TypeVideoUploaded          = "video.uploaded"
+	TypeConversionSucceeded    = "conversion.succeeded"
+	TypeConversionFailed       = "conversion.failed"
+	TypeAudioPinned            = "audio.pinned"
+	TypeVideoRejected          = "video.rejected"
+	TypeJobProgress            = "job.progress"
+	TypeTranscriptionRequested = "transcription.requested"
+	TypeReencodeRequested      = "reencode.requested"
+	TypeThumbnailRequested     = "thumbnail.requested"
+	TypeStreamRequested        = "stream.requested"
+)
+
+// current is the version producers publish for each event type.
+var current = map[string]int{
+	// v2 file keys are random and the encrypted filename comes on its own, roll out converters first
+	TypeVideoUploaded:          2,
+	TypeConversionSucceeded:    1,
+	TypeConversionFailed:       1,
+	TypeAudioPinned:            1,
+	TypeVideoRejected:          1,
+	TypeJobProgress:            1,
+	TypeTranscriptionRequested: 1,
+	TypeReencodeRequested:      1,
+	TypeThumbnailRequested:     1,
+	TypeStreamRequested:        1,
+}
+
+type Envelope struct {
+	Type          string          `json:"type"`
+	Version       int             `json:"version"`
+	ID            string          `json:"id"`
+	OccurredAt    time.Time       `json:"occurred_at"`
+	CorrelationID string          `json:"correlation_id,omitempty"`
+	Payload       json.RawMessage `json:"payload"`
+}
+
+// New wraps the payload in an envelope of the current version for the event type.
+// The correlation id ties together all events caused by the same upload, pass an
+// empty string to start a new chain.
+func New(eventType, correlationId string, payload any) (*Envelope, error) {
+	version, ok := current[eventType]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownType, eventType)
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal payload: %w", err)
+	}
+
+	id, err := uuid.NewV7()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate event id: %w", err)
+	}
+
+	env := &Envelope{
+		Type:          eventType,
+		Version:       version,
+		ID:            id.String(),
+		OccurredAt:    time.Now().UTC(),
+		CorrelationID: correlationId,
+		Payload:       body,
+	}
+
+	if env.CorrelationID == "" {
+		env.CorrelationID = env.ID
+	}
+
+	return env, nil
+}
+
+// Correlation returns the correlation id that events caused by this one should carry.
+func (e *Envelope) Correlation() string {
+	if e.CorrelationID != "" {
+		return e.CorrelationID
+	}
+	return e.ID
+}
+
+// Unmarshal decodes the payload into v.
+func (e *Envelope) Unmarshal(v any) error {
+	if err := json.Unmarshal(e.Payload, v); err != nil {
+		return fmt.Errorf("%w: failed to decode %s payload: %w", ErrInvalidMessage, e.Type, err)
+	}
+	return nil
+}
+
+// Decode reads a message body, validates it against the schema of its type and version,
+// and upgrades it to the current version.
+//
+// Bodies published before envelopes existed are accepted as version 0. Their type is
+// taken from the "type" header, or fallbackType when the header is missing, and the
+// "email" header is folded into the payload's user_email.
+func Decode(body []byte, fallbackType string, headers map[string]any) (*Envelope, error) {
+	var probe map[string]json.RawMessage
+	if err := json.Unmarshal(body, &probe); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidMessage, err)
+	}
+
+	var env Envelope
+	if _, ok := probe["payload"]; ok {
+		if err := validateEnvelope(body); err != nil {
+			return nil, err
+		}
+
+		if err := json.Unmarshal(body, &env); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidMessage, err)
+		}
+	} else {
+		legacy, err := fromLegacy(body, fallbackType, headers)
+		if err != nil {
+			return nil, err
+		}
+		env = *legacy
+	}
+
+	latest, ok := current[env.Type]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownType, env.Type)
+	}
+
+	// upgrade older payloads one version at a time
+	for env.Version < latest {
+		upgrade, ok := upgrades[env.Type][env.Version]
+		if !ok {
+			return nil, fmt.Errorf("%w: no upgrade for %s v%d", ErrInvalidMessage, env.Type, env.Version)
+		}
+
+		payload, err := upgrade(env.Payload)
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to upgrade %s v%d: %w", ErrInvalidMessage, env.Type, env.Version, err)
+		}
+
+		env.Payload = payload
+		env.Version++
+	}
+
+	// newer versions from producers deployed ahead of us only add fields,
+	// so they are checked against the latest schema we know
+	if err := validatePayload(env.Type, latest, env.Payload); err != nil {
+		return nil, err
+	}
+
+	return &env, nil
+}
+
+func fromLegacy(body []byte, fallbackType string, headers map[string]any) (*Envelope, error) {
+	eventType := fallbackType
+	if t, ok := headers["type"].(string); ok && t != "" {
+		eventType = t
+	}
+
+	var payload map[string]any
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidMessage, err)
+	}
+
+	if email, ok := headers["email"].(string); ok {
+		if _, exists := payload["user_email"]; !exists {
+			payload["user_email"] = email
+		}
+	}
+
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidMessage, err)
+	}
+
+	id, err := uuid.NewV7()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate event id: %w", err)
+	}
+
+	return &Envelope{
+		Type:       eventType,
+		Version:    0,
+		ID:         id.String(),
+		OccurredAt: time.Now().UTC(),
+		Payload:    bytes.TrimSpace(raw),
+	}, nil
+}
+
+// upgrades converts a payload of version n (the inner key) to version n+1.
+var upgrades = map[string]map[int]func(json.RawMessage) (json.RawMessage, error){
+	// version 0 is the bare body sent before envelopes, which is already shaped like v1
+	TypeVideoUploaded:       {0: identity, 1: videoUploadedV2},
+	TypeConversionSucceeded: {0: identity},
+	TypeConversionFailed:    {0: identity},
+}
+
+func identity(payload json.RawMessage) (json.RawMessage, error) {
+	return payload, nil
+}
+
+// videoUploadedV2 names the video of a v1 upload. The file key of a v1 upload without a file name
+// is the encrypted filename itself, v2 keys are random and always come with the file name.
+func videoUploadedV2(payload json.RawMessage) (json.RawMessage, error) {
+	var video map[string]any
+	if err := json.Unmarshal(payload, &video); err != nil {
+		return nil, err
+	}
+
+	if name, _ := video["file_name"].(string); name == "" {
+		video["file_name"] = video["file_key"]
+	}
+
+	return json.Marshal(video)
+}
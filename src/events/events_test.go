@@ -0,0 +1,215 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestEvents(t *testing.T) {
+	t.Run("round trip", func(t *testing.T) {
+		env, err := New(TypeVideoUploaded, "", &VideoUploaded{
+			UserId: 1, UserEmail: "user@test.com",
+			FileSize: 1024, FileKey: "key", FileName: "name", Preview: true,
+		})
+		if err != nil {
+			t.Fatalf("Failed to create envelope: %v", err)
+		}
+
+		if env.CorrelationID != env.ID {
+			t.Errorf("Expected new chain to correlate to itself, got %q", env.CorrelationID)
+		}
+
+		body, err := json.Marshal(env)
+		if err != nil {
+			t.Fatalf("Failed to marshal envelope: %v", err)
+		}
+
+		decoded, err := Decode(body, "", nil)
+		if err != nil {
+			t.Fatalf("Failed to decode envelope: %v", err)
+		}
+
+		var video VideoUploaded
+		if err = decoded.Unmarshal(&video); err != nil {
+			t.Fatalf("Failed to unmarshal payload: %v", err)
+		}
+
+		if decoded.ID != env.ID || video.FileKey != "key" || video.UserEmail != "user@test.com" || !video.Preview {
+			t.Errorf("Unexpected decoded event: %+v %+v", decoded, video)
+		}
+	})
+
+	t.Run("correlation is carried over", func(t *testing.T) {
+		env, err := New(TypeConversionFailed, "upload-1", &ConversionFailed{
+			UserId: 1, UserEmail: "user@test.com",
+			VideoKey: "key", Reason: ReasonCorruptFile,
+		})
+		if err != nil {
+			t.Fatalf("Failed to create envelope: %v", err)
+		}
+
+		if env.Correlation() != "upload-1" {
+			t.Errorf("Expected correlation %q, got %q", "upload-1", env.Correlation())
+		}
+	})
+
+	t.Run("failure of a reason v1 didn't come with", func(t *testing.T) {
+		failure := &ConversionFailed{UserId: 1, UserEmail: "user@test.com", VideoKey: "key"}
+		failure.SetReason(ReasonTimedOut)
+
+		env, err := New(TypeConversionFailed, "", failure)
+		if err != nil {
+			t.Fatalf("Failed to create envelope: %v", err)
+		}
+
+		body, err := json.Marshal(env)
+		if err != nil {
+			t.Fatalf("Failed to marshal envelope: %v", err)
+		}
+
+		// consumers on the schema without the detail validate it all the same
+		decoded, err := Decode(body, "", nil)
+		if err != nil {
+			t.Fatalf("Failed to decode envelope: %v", err)
+		}
+
+		var got ConversionFailed
+		if err = decoded.Unmarshal(&got); err != nil {
+			t.Fatalf("Failed to unmarshal payload: %v", err)
+		}
+
+		if got.Reason != ReasonInternal || got.Why() != ReasonTimedOut {
+			t.Errorf("Expected %q detailing %q, got %+v", ReasonInternal, ReasonTimedOut, got)
+		}
+
+		// as a reason, it would be refused by the consumers that don't know it
+		env.Payload = json.RawMessage(`{"user_id":1,"user_email":"user@test.com","video_key":"key","reason":"timed_out"}`)
+		if body, err = json.Marshal(env); err != nil {
+			t.Fatalf("Failed to marshal envelope: %v", err)
+		}
+
+		if _, err = Decode(body, "", nil); !errors.Is(err, ErrInvalidMessage) {
+			t.Errorf("Expected ErrInvalidMessage, got %v", err)
+		}
+	})
+
+	t.Run("legacy video body", func(t *testing.T) {
+		body := []byte(`{"user_id":1,"user_email":"user@test.com","file_size":1024,"file_key":"key"}`)
+
+		env, err := Decode(body, TypeVideoUploaded, nil)
+		if err != nil {
+			t.Fatalf("Failed to decode legacy body: %v", err)
+		}
+
+		if env.Type != TypeVideoUploaded || env.Version != current[TypeVideoUploaded] {
+			t.Errorf("Expected upgraded %s, got %s v%d", TypeVideoUploaded, env.Type, env.Version)
+		}
+	})
+
+	t.Run("video of v1", func(t *testing.T) {
+		tests := []struct {
+			name    string
+			payload string
+			want    string
+		}{
+			{"key is the filename", `{"user_id":1,"user_email":"user@test.com","file_size":1024,"file_key":"encrypted"}`, "encrypted"},
+			{"filename of its own", `{"user_id":1,"user_email":"user@test.com","file_size":1024,"file_key":"key","file_name":"encrypted"}`, "encrypted"},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				body := []byte(`{"type":"video.uploaded","version":1,"id":"1","occurred_at":"2025-01-01T00:00:00Z","payload":` + tt.payload + `}`)
+
+				env, err := Decode(body, "", nil)
+				if err != nil {
+					t.Fatalf("Failed to decode v1 video: %v", err)
+				}
+
+				var video VideoUploaded
+				if err = env.Unmarshal(&video); err != nil {
+					t.Fatalf("Failed to unmarshal payload: %v", err)
+				}
+
+				if env.Version != 2 || video.FileName != tt.want || video.FileKey == "" {
+					t.Errorf("Expected v2 with file name %q, got v%d %+v", tt.want, env.Version, video)
+				}
+			})
+		}
+	})
+
+	t.Run("video of v2 without a filename", func(t *testing.T) {
+		body := []byte(`{"type":"video.uploaded","version":2,"id":"1","occurred_at":"2025-01-01T00:00:00Z",
+			"payload":{"user_id":1,"user_email":"user@test.com","file_size":1024,"file_key":"key"}}`)
+
+		if _, err := Decode(body, "", nil); !errors.Is(err, ErrInvalidMessage) {
+			t.Errorf("Expected ErrInvalidMessage, got %v", err)
+		}
+	})
+
+	t.Run("legacy notification with headers", func(t *testing.T) {
+		body := []byte(`{"user_id":1,"file_name":"a.mp4","video_key":"key","reason":"too_long"}`)
+		headers := map[string]any{"email": "user@test.com", "type": TypeConversionFailed}
+
+		env, err := Decode(body, TypeConversionSucceeded, headers)
+		if err != nil {
+			t.Fatalf("Failed to decode legacy body: %v", err)
+		}
+
+		if env.Type != TypeConversionFailed {
+			t.Fatalf("Expected type from header, got %s", env.Type)
+		}
+
+		var failure ConversionFailed
+		if err = env.Unmarshal(&failure); err != nil {
+			t.Fatalf("Failed to unmarshal payload: %v", err)
+		}
+
+		if failure.UserEmail != "user@test.com" || failure.Reason != ReasonTooLong {
+			t.Errorf("Unexpected payload: %+v", failure)
+		}
+	})
+
+	t.Run("newer version with extra fields", func(t *testing.T) {
+		body := []byte(`{"type":"conversion.succeeded","version":2,"id":"1","occurred_at":"2025-01-01T00:00:00Z",
+			"payload":{"user_id":1,"user_email":"user@test.com","file_name":"a.mp4","video_key":"v","audio_key":"a","duration":12}}`)
+
+		if _, err := Decode(body, "", nil); err != nil {
+			t.Fatalf("Expected additive change to decode, got %v", err)
+		}
+	})
+
+	t.Run("payload fails schema", func(t *testing.T) {
+		body := []byte(`{"type":"video.uploaded","version":1,"id":"1","occurred_at":"2025-01-01T00:00:00Z",
+			"payload":{"user_id":"one","file_key":""}}`)
+
+		if _, err := Decode(body, "", nil); !errors.Is(err, ErrInvalidMessage) {
+			t.Errorf("Expected ErrInvalidMessage, got %v", err)
+		}
+	})
+
+	t.Run("envelope fails schema", func(t *testing.T) {
+		body := []byte(`{"type":"video.uploaded","occurred_at":"yesterday","payload":{}}`)
+
+		if _, err := Decode(body, "", nil); !errors.Is(err, ErrInvalidMessage) {
+			t.Errorf("Expected ErrInvalidMessage, got %v", err)
+		}
+	})
+
+	t.Run("unknown type", func(t *testing.T) {
+		if _, err := New("video.deleted", "", struct{}{}); !errors.Is(err, ErrUnknownType) {
+			t.Errorf("Expected ErrUnknownType, got %v", err)
+		}
+
+		body := []byte(`{"type":"video.deleted","version":1,"id":"1","occurred_at":"2025-01-01T00:00:00Z","payload":{}}`)
+		if _, err := Decode(body, "", nil); !errors.Is(err, ErrUnknownType) {
+			t.Errorf("Expected ErrUnknownType, got %v", err)
+		}
+	})
+
+	t.Run("not json", func(t *testing.T) {
+		if _, err := Decode([]byte("video"), TypeVideoUploaded, nil); !errors.Is(err, ErrInvalidMessage) {
+			t.Errorf("Expected ErrInvalidMessage, got %v", err)
+		}
+	})
+}
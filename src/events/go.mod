@@ -0,0 +1,10 @@
+module github.com/ziliscite/video-to-mp3/events
+
+go 1.24.0
+
+require (
+	github.com/google/uuid v1.6.0
+	github.com/santhosh-tekuri/jsonschema/v6 v6.0.2
+)
+
+require golang.org/x/text v0.14.0 // indirect
@@ -0,0 +1,228 @@
+package events
+
+// VideoUploaded is published by the gateway once the video is in storage.
+// JobId identifies the conversion across redeliveries, it's empty in messages from older gateways.
+// FileKey is a random id naming the stored video and FileName the encrypted original filename.
+// In v1 the FileKey was the encrypted filename itself, it's the FileName too once upgraded.
+// Loudness names the preset the audio is normalized to, it's empty to leave the audio as it is.
+// Filters clean up the audio first, they're nil in messages from older gateways.
+// Chapters asks for one audio file per chapter, it's nil to keep the audio whole.
+// Transcript asks for the speech of the audio to be transcribed once converted, it's nil for none.
+// Stream asks for a web-playable version of the video too, it's nil for none.
+// Preview asks for an animated preview of the video along with its poster frame, it's false in v1 messages.
+// PresetId names a saved preset of the user filling in the options the upload leaves out,
+// it's zero for the user's default preset, if they have one.
+type VideoUploaded struct {
+	JobId      string             `json:"job_id,omitempty"`
+	UserId     int64              `json:"user_id"`
+	UserEmail  string             `json:"user_email"`
+	FileSize   int64              `json:"file_size"`
+	FileKey    string             `json:"file_key"`
+	FileName   string             `json:"file_name,omitempty"`
+	Loudness   string             `json:"loudness,omitempty"`
+	Filters    *AudioFilters      `json:"filters,omitempty"`
+	Chapters   *ChapterSplit      `json:"chapters,omitempty"`
+	Transcript *TranscriptRequest `json:"transcript,omitempty"`
+	Stream     *StreamRequest     `json:"stream,omitempty"`
+	Preview    bool               `json:"preview,omitempty"`
+	PresetId   int64              `json:"preset_id,omitempty"`
+}
+
+// TranscriptRequest asks for a transcript in the language, an ISO 639-1 code, or detected when empty.
+type TranscriptRequest struct {
+	Language string `json:"language,omitempty"`
+}
+
+// StreamRequest asks for the video to be transcoded into HLS. Its audio is always streamed,
+// and its picture at each of the Renditions heights, in pixels.
+type StreamRequest struct {
+	Renditions []int `json:"renditions,omitempty"`
+}
+
+// AudioFilters clean up the audio of a video, zero values leave it as it is.
+// CompressSilence is in seconds, HighPass and LowPass in Hz, and NoiseReduction in dB.
+type AudioFilters struct {
+	TrimSilence     bool    `json:"trim_silence,omitempty"`
+	CompressSilence float64 `json:"compress_silence,omitempty"`
+	HighPass        int     `json:"high_pass,omitempty"`
+	LowPass         int     `json:"low_pass,omitempty"`
+	NoiseReduction  float64 `json:"noise_reduction,omitempty"`
+}
+
+// Loudness presets a video can be normalized to, following EBU R128.
+const (
+	LoudnessPodcast   = "podcast"
+	LoudnessStreaming = "streaming"
+	LoudnessBroadcast = "broadcast"
+)
+
+// ChapterSplit asks for the audio to be split into chapters. Cues are the chapters the user gave,
+// without them the chapter markers of the video are used, or else its long silences.
+type ChapterSplit struct {
+	Cues []Cue `json:"cues,omitempty"`
+}
+
+// Cue starts a chapter, Start is in seconds from the start of the video.
+type Cue struct {
+	Start float64 `json:"start"`
+	Title string  `json:"title,omitempty"`
+}
+
+// ConversionSucceeded is published by the converter once the audio is stored.
+// AudioURL is a time-limited download link, it's empty when none could be made.
+// Audio split into chapters has Parts, AudioKey and AudioURL are then the ones of the first.
+type ConversionSucceeded struct {
+	UserId    int64       `json:"user_id"`
+	UserEmail string      `json:"user_email"`
+	FileName  string      `json:"file_name"`
+	VideoKey  string      `json:"video_key"`
+	AudioKey  string      `json:"audio_key"`
+	AudioURL  string      `json:"audio_url,omitempty"`
+	Parts     []AudioPart `json:"parts,omitempty"`
+}
+
+// AudioPart is the audio of one chapter, Start and End are in seconds from the start of the video.
+type AudioPart struct {
+	Title    string  `json:"title,omitempty"`
+	Start    float64 `json:"start"`
+	End      float64 `json:"end"`
+	AudioKey string  `json:"audio_key"`
+	AudioURL string  `json:"audio_url,omitempty"`
+}
+
+// Failure reasons are user-safe categories, they must never carry internal error details.
+// The reasons after ReasonInternal came after v1, consumers may not know them yet.
+const (
+	ReasonUnsupportedCodec = "unsupported_codec"
+	ReasonCorruptFile      = "corrupt_file"
+	ReasonTooLong          = "too_long"
+	ReasonInternal         = "internal"
+	ReasonTimedOut         = "timed_out"
+	ReasonPresetNotFound   = "preset_not_found"
+)
+
+// ConversionFailed is published by the converter when a video is dropped for good.
+// Reason is one of the reasons v1 came with, Detail is a reason that came later, empty for none.
+// Consumers that don't know the Detail go by the Reason.
+type ConversionFailed struct {
+	UserId    int64  `json:"user_id"`
+	UserEmail string `json:"user_email"`
+	FileName  string `json:"file_name,omitempty"`
+	VideoKey  string `json:"video_key"`
+	Reason    string `json:"reason"`
+	Detail    string `json:"detail,omitempty"`
+}
+
+// SetReason sets the reason of the failure, one that came after v1 as the Detail of ReasonInternal.
+func (f *ConversionFailed) SetReason(reason string) {
+	switch reason {
+	case ReasonTimedOut, ReasonPresetNotFound:
+		f.Reason, f.Detail = ReasonInternal, reason
+	default:
+		f.Reason, f.Detail = reason, ""
+	}
+}
+
+// Why returns the most precise reason of the failure.
+func (f *ConversionFailed) Why() string {
+	if f.Detail != "" {
+		return f.Detail
+	}
+	return f.Reason
+}
+
+// AudioPinned is published by the gateway when a user pins or unpins their audio.
+// Pinned audio is exempt from the converter's retention policy.
+type AudioPinned struct {
+	UserId   int64  `json:"user_id"`
+	AudioKey string `json:"audio_key"`
+	Pinned   bool   `json:"pinned"`
+}
+
+// TranscriptionRequested asks the converter to transcribe an audio of the user. It's published by the gateway
+// for existing audio, and by the converter for each audio of a conversion that asked for a transcript.
+// JobId identifies the transcription across redeliveries, Language is as in TranscriptRequest.
+type TranscriptionRequested struct {
+	JobId    string `json:"job_id"`
+	UserId   int64  `json:"user_id"`
+	AudioKey string `json:"audio_key"`
+	Language string `json:"language,omitempty"`
+}
+
+// Formats an audio can be encoded again to.
+const (
+	FormatMP3 = "mp3"
+	FormatAAC = "aac"
+	FormatWAV = "wav"
+)
+
+// ReencodeRequested asks the converter to encode an existing audio of the user again, as a new audio
+// linked to the conversion it came from. It's published by the gateway.
+// JobId identifies the job across redeliveries. Format is empty to keep the one of the audio, and Bitrate
+// is in kbps, zero for the default of the format. Start and End trim the audio, in seconds, a zero End
+// keeps it to its end. Loudness is as in VideoUploaded.
+type ReencodeRequested struct {
+	JobId     string  `json:"job_id"`
+	UserId    int64   `json:"user_id"`
+	UserEmail string  `json:"user_email"`
+	AudioKey  string  `json:"audio_key"`
+	Format    string  `json:"format,omitempty"`
+	Bitrate   int     `json:"bitrate,omitempty"`
+	Start     float64 `json:"start,omitempty"`
+	End       float64 `json:"end,omitempty"`
+	Loudness  string  `json:"loudness,omitempty"`
+}
+
+// ThumbnailRequested asks the converter to draw the pictures of the video of a finished conversion.
+// It's published by the converter itself, so the audio isn't held up by them. MetadataId names the conversion,
+// its pictures are named after its AudioKey. KeepVideo is set when another stage still reads the video.
+// Preview asks for the animated preview along with the poster frame, as in VideoUploaded.
+type ThumbnailRequested struct {
+	UserId     int64  `json:"user_id"`
+	MetadataId int64  `json:"metadata_id"`
+	VideoKey   string `json:"video_key"`
+	FileSize   int64  `json:"file_size"`
+	AudioKey   string `json:"audio_key"`
+	KeepVideo  bool   `json:"keep_video,omitempty"`
+	Preview    bool   `json:"preview,omitempty"`
+}
+
+// StreamRequested asks the converter to transcode the video of a finished conversion into HLS, it's published
+// by the converter for conversions that asked for a stream. JobId identifies the transcoding across redeliveries,
+// the other fields are as in ThumbnailRequested and Renditions as in StreamRequest.
+type StreamRequested struct {
+	JobId      string `json:"job_id"`
+	UserId     int64  `json:"user_id"`
+	MetadataId int64  `json:"metadata_id"`
+	VideoKey   string `json:"video_key"`
+	FileSize   int64  `json:"file_size"`
+	AudioKey   string `json:"audio_key"`
+	Renditions []int  `json:"renditions,omitempty"`
+	KeepVideo  bool   `json:"keep_video,omitempty"`
+}
+
+// VideoRejected is published by the gateway when malware is found in an upload, which is never stored or converted.
+// QuarantineKey names the copy kept for inspection in the quarantine bucket, it's empty when none is kept.
+type VideoRejected struct {
+	UserId        int64  `json:"user_id"`
+	UserEmail     string `json:"user_email"`
+	FileName      string `json:"file_name"`
+	Signature     string `json:"signature"`
+	QuarantineKey string `json:"quarantine_key,omitempty"`
+}
+
+// Job statuses reported by JobProgress.
+const (
+	JobProcessing = "processing"
+	JobCompleted  = "completed"
+	JobFailed     = "failed"
+)
+
+// JobProgress is published by the converter as a conversion moves along, and once it completes or fails.
+// Percent is how much of the video was converted, 100 once completed.
+type JobProgress struct {
+	JobId   string `json:"job_id"`
+	UserId  int64  `json:"user_id"`
+	Status  string `json:"status"`
+	Percent int    `json:"percent"`
+}
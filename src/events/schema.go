@@ -0,0 +1,100 @@
+package events
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"sync"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+)
+
+//go:embed schemas
+var schemaFS embed.FS
+
+var (
+	compileOnce sync.Once
+	compileErr  error
+	schemas     map[string]*jsonschema.Schema
+)
+
+// schemaName returns the schema file of an event type at a version, e.g. video.uploaded.v1.json.
+func schemaName(eventType string, version int) string {
+	return fmt.Sprintf("%s.v%d.json", eventType, version)
+}
+
+// compile loads every embedded schema once. The schemas ship with the binary,
+// so failing to compile them is a programming error surfaced on first use.
+func compile() error {
+	compileOnce.Do(func() {
+		entries, err := schemaFS.ReadDir("schemas")
+		if err != nil {
+			compileErr = err
+			return
+		}
+
+		c := jsonschema.NewCompiler()
+		c.AssertFormat()
+
+		for _, e := range entries {
+			data, err := schemaFS.ReadFile("schemas/" + e.Name())
+			if err != nil {
+				compileErr = err
+				return
+			}
+
+			doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(data))
+			if err != nil {
+				compileErr = fmt.Errorf("failed to parse schema %s: %w", e.Name(), err)
+				return
+			}
+
+			if err = c.AddResource(e.Name(), doc); err != nil {
+				compileErr = fmt.Errorf("failed to add schema %s: %w", e.Name(), err)
+				return
+			}
+		}
+
+		schemas = make(map[string]*jsonschema.Schema, len(entries))
+		for _, e := range entries {
+			sch, err := c.Compile(e.Name())
+			if err != nil {
+				compileErr = fmt.Errorf("failed to compile schema %s: %w", e.Name(), err)
+				return
+			}
+			schemas[e.Name()] = sch
+		}
+	})
+
+	return compileErr
+}
+
+func validate(name string, data []byte) error {
+	if err := compile(); err != nil {
+		return err
+	}
+
+	sch, ok := schemas[name]
+	if !ok {
+		return fmt.Errorf("%w: no schema %s", ErrUnknownType, name)
+	}
+
+	inst, err := jsonschema.UnmarshalJSON(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidMessage, err)
+	}
+
+	if err = sch.Validate(inst); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidMessage, err)
+	}
+
+	return nil
+}
+
+func validateEnvelope(body []byte) error {
+	return validate("envelope.json", body)
+}
+
+func validatePayload(eventType string, version int, payload []byte) error {
+	return validate(schemaName(eventType, version), payload)
+}
@@ -2,42 +2,107 @@ package main
 
 import (
 	"flag"
-	"fmt"
 	"os"
 	"sync"
+	"time"
+
+	"github.com/ziliscite/video-to-mp3/platform/mq"
+	"github.com/ziliscite/video-to-mp3/platform/storage"
 )
 
 type AWS struct {
 	s3Bucket                 string
 	s3Region                 string
+	s3Endpoint               string
+	s3PathStyle              bool
+	s3CABundle               string
+	profile                  string
 	s3CloudFrontDistribution string
-	accessKeyId              string
-	secretAccessKey          string
+	sse                      struct {
+		algorithm string
+		kmsKeyId  string
+	}
+	accessKeyId     string
+	secretAccessKey string
+}
+
+type Storage struct {
+	backend  string
+	dir      string
+	url      string
+	secret   string
+	envelope struct {
+		keys   string
+		active string
+	}
+}
+
+func (a AWS) s3SSE() storage.SSE {
+	return storage.SSE{
+		Algorithm: a.sse.algorithm,
+		KMSKeyId:  a.sse.kmsKeyId,
+	}
+}
+
+func (a AWS) s3Config() storage.S3Config {
+	return storage.S3Config{
+		Region:          a.s3Region,
+		Endpoint:        a.s3Endpoint,
+		PathStyle:       a.s3PathStyle,
+		CABundle:        a.s3CABundle,
+		Profile:         a.profile,
+		AccessKeyId:     a.accessKeyId,
+		SecretAccessKey: a.secretAccessKey,
+	}
 }
 
 type RabbitMQ struct {
-	host     string
-	username string
-	password string
-	port     string
-	queue    string
+	host              string
+	username          string
+	password          string
+	port              string
+	queue             string
+	notificationQueue string
+	progressExchange  string
 }
 
 func (r RabbitMQ) dsn() string {
-	return fmt.Sprintf("amqps://%s:%s@%s:%s", r.username, r.password, r.host, r.port)
+	return mq.DSN(r.username, r.password, r.host, r.port)
+}
+
+type Scanner struct {
+	clamd      string
+	timeout    time.Duration
+	quarantine string
 }
 
 type Address struct {
-	auth string
+	auth      string
+	converter string
 }
 
 type Config struct {
-	port       int
-	encryptKey string
-	secrets    string
-	addr       Address
-	aws        AWS
-	rabbit     RabbitMQ
+	port        int
+	encryptKey  string
+	encryptKeys struct {
+		keys   string
+		active string
+	}
+	secrets        string
+	converterToken string
+	addr           Address
+	aws            AWS
+	storage        Storage
+	scanner        Scanner
+	rabbit         RabbitMQ
+}
+
+// envOr reads an environment variable, defaulting to def when it is unset.
+func envOr(key, def string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return def
 }
 
 var (
@@ -57,21 +122,44 @@ func getConfig() Config {
 		flag.IntVar(&instance.port, "port", 8080, "Server Port")
 
 		flag.StringVar(&instance.secrets, "secrets", os.Getenv("JWT_SECRETS"), "256 bytes of secrets")
-		flag.StringVar(&instance.encryptKey, "key", os.Getenv("ENCRYPT_KEY"), "Encryption key")
+		flag.StringVar(&instance.encryptKey, "key", os.Getenv("ENCRYPT_KEY"), "Legacy 64-byte encryption key, decrypts keys made before the keyring")
+		flag.StringVar(&instance.encryptKeys.keys, "encrypt-keys", os.Getenv("ENCRYPT_KEYS"), "Keyring of file key secrets as id:hex pairs, empty to use the legacy key only")
+		flag.StringVar(&instance.encryptKeys.active, "encrypt-active-key", os.Getenv("ENCRYPT_ACTIVE_KEY"), "Keyring secret id that encrypts new file keys")
 
 		flag.StringVar(&instance.addr.auth, "auth-addr", os.Getenv("AUTH_SERVICE_ADDRESS"), "Authentication Service Address")
+		flag.StringVar(&instance.addr.converter, "converter-addr", os.Getenv("CONVERTER_SERVICE_ADDRESS"), "Converter Service Address, presets of users are kept there")
+		flag.StringVar(&instance.converterToken, "converter-token", os.Getenv("CONVERTER_SERVICE_TOKEN"), "Token the converter's API is called with")
 
 		flag.StringVar(&instance.aws.s3Bucket, "s3-bucket", os.Getenv("S3_BUCKET"), "S3 bucket name")
 		flag.StringVar(&instance.aws.s3Region, "s3-region", os.Getenv("S3_REGION"), "S3 region")
+		flag.StringVar(&instance.aws.s3Endpoint, "s3-endpoint", os.Getenv("S3_ENDPOINT"), "S3-compatible endpoint URL, e.g. MinIO, empty for AWS")
+		flag.BoolVar(&instance.aws.s3PathStyle, "s3-path-style", os.Getenv("S3_PATH_STYLE") == "true", "Use path-style bucket addressing")
+		flag.StringVar(&instance.aws.s3CABundle, "s3-ca-bundle", os.Getenv("S3_CA_BUNDLE"), "PEM file of extra certificate authorities for the S3 endpoint")
+		flag.StringVar(&instance.aws.sse.algorithm, "s3-sse", os.Getenv("S3_SSE"), "Server-side encryption of uploads (AES256|aws:kms), empty for the bucket default")
+		flag.StringVar(&instance.aws.sse.kmsKeyId, "s3-sse-kms-key", os.Getenv("S3_SSE_KMS_KEY_ID"), "KMS key for aws:kms server-side encryption")
+		flag.StringVar(&instance.aws.profile, "aws-profile", os.Getenv("AWS_PROFILE"), "Shared config profile")
 		flag.StringVar(&instance.aws.s3CloudFrontDistribution, "s3-cf", os.Getenv("S3_CLOUDFRONT_DISTRIBUTION"), "S3 CloudFront distribution ID")
 		flag.StringVar(&instance.aws.accessKeyId, "aws-access-key-id", os.Getenv("AWS_ACCESS_KEY_ID"), "AWS access key ID")
-		flag.StringVar(&instance.aws.secretAccessKey, "aws-secret-access-key", os.Getenv("AWS_SECRET_ACCESS_KEY"), "AWS secret access key")
+		flag.StringVar(&instance.aws.secretAccessKey, "aws-secret-access-key", os.Getenv("AWS_SECRET_ACCESS_KEY"), "AWS secret access key, leave both keys empty to use the default credential chain")
+
+		flag.StringVar(&instance.storage.backend, "storage", envOr("STORAGE_BACKEND", "s3"), "Storage backend (s3|disk|memory)")
+		flag.StringVar(&instance.storage.dir, "storage-dir", os.Getenv("STORAGE_DIR"), "Root directory of the disk storage backend")
+		flag.StringVar(&instance.storage.url, "storage-url", os.Getenv("STORAGE_URL"), "Public base URL of the file route, e.g. http://localhost:8080/v1/files")
+		flag.StringVar(&instance.storage.secret, "storage-secret", os.Getenv("STORAGE_SECRET"), "Secret used to sign disk storage URLs")
+		flag.StringVar(&instance.storage.envelope.keys, "envelope-keys", os.Getenv("ENVELOPE_KEYS"), "Master keys for envelope encryption of videos as id:hex pairs, empty to disable it")
+		flag.StringVar(&instance.storage.envelope.active, "envelope-active-key", os.Getenv("ENVELOPE_ACTIVE_KEY"), "Master key id that wraps new data keys")
+
+		flag.StringVar(&instance.scanner.clamd, "clamd-addr", os.Getenv("CLAMD_ADDRESS"), "clamd address to scan uploads with, tcp://host:port or unix:///path, empty to disable scanning")
+		flag.DurationVar(&instance.scanner.timeout, "clamd-timeout", 2*time.Minute, "Time limit of a scan")
+		flag.StringVar(&instance.scanner.quarantine, "quarantine-bucket", os.Getenv("QUARANTINE_BUCKET"), "Bucket infected uploads are kept in, empty to discard them")
 
 		flag.StringVar(&instance.rabbit.host, "rabbit-host", os.Getenv("AMQP_HOST"), "RabbitMQ host")
 		flag.StringVar(&instance.rabbit.username, "rabbit-username", os.Getenv("AMQP_USERNAME"), "RabbitMQ username")
 		flag.StringVar(&instance.rabbit.password, "rabbit-password", os.Getenv("AMQP_PASSWORD"), "RabbitMQ password")
 		flag.StringVar(&instance.rabbit.port, "rabbit-port", os.Getenv("AMQP_PORT"), "RabbitMQ password")
 		flag.StringVar(&instance.rabbit.queue, "rabbit-queue", os.Getenv("AMQP_QUEUE_NAME"), "RabbitMQ queue")
+		flag.StringVar(&instance.rabbit.progressExchange, "rabbit-progress-exchange", envOr("AMQP_PROGRESS_EXCHANGE", "job_progress"), "RabbitMQ fanout exchange of job progress events")
+		flag.StringVar(&instance.rabbit.notificationQueue, "rabbit-notif-queue", os.Getenv("AMQP_NOTIFICATION_QUEUE_NAME"), "RabbitMQ notification queue, needed to tell users about rejected uploads")
 
 		flag.Parse()
 	})
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// listConversions lists the user's conversions with links to their files, newest first.
+// A page ends with the conversion whose id is given as before to get the next one, limit sets its size.
+func (app *application) listConversions(c *gin.Context) {
+	user, err := app.extractUser(c)
+	if err != nil {
+		app.serverError(c)
+		return
+	}
+
+	req := app.converterRequest(c.Request.Context(), user.ID)
+	for _, key := range []string{"before", "limit"} {
+		if v := c.Query(key); v != "" {
+			req.SetQueryParam(key, v)
+		}
+	}
+
+	resp, err := req.Get(app.cfg.addr.converter + "/v1/conversions")
+	app.relayConverter(c, resp, err)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-resty/resty/v2"
+	"github.com/ziliscite/video-to-mp3/gateway/internal/domain"
+)
+
+func TestListConversions(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// converter answers as the converter's API does, recording the request it got
+	converter := func(t *testing.T, status int, body string) (*httptest.Server, *http.Request) {
+		t.Helper()
+
+		got := &http.Request{}
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			*got = *r.Clone(r.Context())
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			_, _ = w.Write([]byte(body))
+		}))
+		t.Cleanup(srv.Close)
+		return srv, got
+	}
+
+	list := func(converterAddr, query string) *httptest.ResponseRecorder {
+		app := &application{rc: resty.New()}
+		app.cfg.addr.converter, app.cfg.converterToken = converterAddr, "secret"
+
+		router := gin.New()
+		router.GET("/v1/conversions", func(c *gin.Context) {
+			c.Set("user", domain.User{ID: 7, Email: "user@test.com"})
+		}, app.listConversions)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/conversions"+query, nil))
+		return w
+	}
+
+	t.Run("on behalf of the user", func(t *testing.T) {
+		body := `{"conversions":[{"id":3,"file_name":"lecture.mp4","thumbnail_url":"https://cdn.test/thumbnails/a.jpg"}]}`
+		srv, got := converter(t, http.StatusOK, body)
+
+		w := list(srv.URL, "?before=9&limit=5&user_id=1")
+		if w.Code != http.StatusOK || w.Body.String() != body {
+			t.Fatalf("Expected the conversions of the converter, got %d: %s", w.Code, w.Body)
+		}
+
+		if got.URL.Path != "/v1/conversions" || got.Header.Get("Authorization") != "Bearer secret" || got.Header.Get("X-User-Id") != "7" {
+			t.Errorf("Expected the conversions of user 7 with the service token, got %s %v", got.URL.Path, got.Header)
+		}
+
+		if q := got.URL.Query(); q.Get("before") != "9" || q.Get("limit") != "5" || q.Has("user_id") {
+			t.Errorf("Expected the page only to be passed on, got %s", got.URL.RawQuery)
+		}
+	})
+
+	t.Run("invalid page", func(t *testing.T) {
+		srv, _ := converter(t, http.StatusBadRequest, `{"error":"limit must be between 1 and 100"}`)
+
+		if w := list(srv.URL, "?limit=1000"); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected %d, got %d", http.StatusBadRequest, w.Code)
+		}
+	})
+
+	t.Run("service token refused", func(t *testing.T) {
+		srv, _ := converter(t, http.StatusUnauthorized, `{"error":"invalid service token"}`)
+
+		if w := list(srv.URL, ""); w.Code != http.StatusInternalServerError {
+			t.Errorf("Expected %d, got %d", http.StatusInternalServerError, w.Code)
+		}
+	})
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-resty/resty/v2"
+)
+
+// converterRequest is a request to the converter's API on behalf of the user. The converter trusts
+// the user it's given only from a caller holding the service token.
+func (app *application) converterRequest(ctx context.Context, userId int64) *resty.Request {
+	return app.rc.R().SetContext(ctx).
+		SetAuthToken(app.cfg.converterToken).
+		SetHeader("X-User-Id", strconv.FormatInt(userId, 10))
+}
+
+// relayConverter responds with the response of the converter to a request made for the user.
+// The converter refuses invalid requests with a message meant for the user.
+func (app *application) relayConverter(c *gin.Context, resp *resty.Response, err error) {
+	if err != nil {
+		slog.Error("Failed to reach converter", "error", err)
+		app.serverError(c)
+		return
+	}
+
+	// the token is ours, the user can't have got it wrong
+	if resp.StatusCode() >= http.StatusInternalServerError || resp.StatusCode() == http.StatusUnauthorized {
+		slog.Error("Converter failed the request", "url", resp.Request.URL, "status", resp.StatusCode(), "body", resp.String())
+		app.serverError(c)
+		return
+	}
+
+	if resp.StatusCode() == http.StatusNoContent {
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	c.Data(resp.StatusCode(), "application/json", resp.Body())
+}
@@ -1,11 +1,21 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/ziliscite/video-to-mp3/events"
 	"github.com/ziliscite/video-to-mp3/gateway/internal/domain"
+	"github.com/ziliscite/video-to-mp3/gateway/internal/service"
+	"github.com/ziliscite/video-to-mp3/platform/scanner"
+	"github.com/ziliscite/video-to-mp3/platform/storage"
+	"log/slog"
 	"net/http"
+	"strings"
+	"time"
 )
 
 const maxSize = 1 << 29 // 512 MB
@@ -80,6 +90,12 @@ func (app *application) upload(c *gin.Context) {
 		return
 	}
 
+	opts, err := uploadOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	video, contentType, err := app.extractFile(file)
 	if err != nil {
 		app.serverError(c)
@@ -92,17 +108,38 @@ func (app *application) upload(c *gin.Context) {
 		return
 	}
 
-	// store to s3 here
-	key, err := app.fs.UploadVideo(c.Request.Context(), file.Size, file.Filename, app.cfg.aws.s3Bucket, video)
+	user, err := app.extractUser(c)
 	if err != nil {
+		// cuz previously we authorized it, then now the error is internal
 		app.serverError(c)
 		return
 	}
 
-	user, err := app.extractUser(c)
+	if opts.preset != 0 {
+		if err = app.checkPreset(c.Request.Context(), user.ID, opts.preset); err != nil {
+			if errors.Is(err, errPresetNotFound) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			} else {
+				slog.Error("Failed to check preset", "error", err)
+				app.serverError(c)
+			}
+			return
+		}
+	}
+
+	// store to s3 here
+	key, name, err := app.fs.UploadVideo(c.Request.Context(), file.Size, file.Filename, app.cfg.aws.s3Bucket, video)
 	if err != nil {
-		// cuz previously we authorized it, then now the error is internal
-		app.serverError(c)
+		var infected *service.InfectedError
+		switch {
+		case errors.As(err, &infected):
+			app.rejectVideo(c, user, file.Filename, infected)
+		case errors.Is(err, scanner.ErrTooLarge):
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "video is too large to be scanned"})
+		default:
+			slog.Error("Failed to upload video", "error", err)
+			app.serverError(c)
+		}
 		return
 	}
 
@@ -111,13 +148,29 @@ func (app *application) upload(c *gin.Context) {
 	// the metadata (name, key, user id) will be stored in the database
 	// with the mp3 key as well, maybe with status
 
-	if err = app.fp.PublishVideo(c.Request.Context(), &domain.Video{
-		UserId: user.ID, UserEmail: user.Email,
-		FileSize: file.Size, FileKey: key,
+	// the job id lets the converter recognize redeliveries of this upload
+	jobId, err := uuid.NewV7()
+	if err != nil {
+		app.serverError(c)
+		return
+	}
+
+	if err = app.fp.PublishVideo(c.Request.Context(), c.GetHeader("X-Request-ID"), &events.VideoUploaded{
+		JobId: jobId.String(), UserId: user.ID, UserEmail: user.Email,
+		FileSize: file.Size, FileKey: key, FileName: name,
+		Loudness: opts.loudness, Filters: opts.filters, Chapters: opts.chapters,
+		Transcript: opts.transcript, Stream: opts.stream, Preview: opts.preview, PresetId: opts.preset,
 	}); err != nil {
 
+		// the request context is canceled once we respond, so the cleanup gets its own.
+		// if it still fails, the converter's reconciler removes the orphaned video later
 		app.background(func() {
-			_ = app.fs.DeleteVideo(c.Request.Context(), app.cfg.aws.s3Bucket, key)
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+			defer cancel()
+
+			if err := app.fs.DeleteVideo(ctx, app.cfg.aws.s3Bucket, key); err != nil {
+				slog.Error("Failed to delete unpublished video", "key", key, "error", err)
+			}
 		})
 
 		app.serverError(c)
@@ -126,7 +179,179 @@ func (app *application) upload(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": fmt.Sprintf("video has been uploaded, you will be notified through %s soon", user.Email),
+		"job_id":  jobId.String(),
 		// the other service doesn't need file url
-		"video_url": app.fileUrl(key, app.cfg.aws.s3Bucket),
+		"video_url": app.fileUrl(key+".mp4", app.cfg.aws.s3Bucket),
+	})
+}
+
+// rejectVideo tells the user, now and by email, that malware was found in their upload.
+func (app *application) rejectVideo(c *gin.Context, user *domain.User, filename string, infected *service.InfectedError) {
+	slog.Warn("Rejected infected upload", "user_id", user.ID, "signature", infected.Signature, "quarantine_key", infected.QuarantineKey)
+
+	if err := app.fp.PublishRejection(c.Request.Context(), c.GetHeader("X-Request-ID"), &events.VideoRejected{
+		UserId: user.ID, UserEmail: user.Email, FileName: filename,
+		Signature: infected.Signature, QuarantineKey: infected.QuarantineKey,
+	}); err != nil {
+		slog.Error("Failed to publish rejection", "error", err)
+	}
+
+	c.JSON(http.StatusUnprocessableEntity, gin.H{
+		"error":     "video was rejected, malware was found in it",
+		"signature": infected.Signature,
+	})
+}
+
+func (app *application) pin(c *gin.Context) {
+	app.setPinned(c, true)
+}
+
+func (app *application) unpin(c *gin.Context) {
+	app.setPinned(c, false)
+}
+
+// setPinned asks the converter to exempt the audio from expiry, or to let it expire again.
+// The converter only pins audio owned by the user, so unknown keys are silently ignored.
+func (app *application) setPinned(c *gin.Context, pinned bool) {
+	user, err := app.extractUser(c)
+	if err != nil {
+		app.serverError(c)
+		return
+	}
+
+	if err = app.fp.PublishPin(c.Request.Context(), c.GetHeader("X-Request-ID"), &events.AudioPinned{
+		UserId: user.ID, AudioKey: c.Param("key"), Pinned: pinned,
+	}); err != nil {
+		app.serverError(c)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"audio_key": c.Param("key"),
+		"pinned":    pinned,
 	})
 }
+
+// transcribe asks the converter to transcribe an audio of the user, a part of a split conversion included.
+// The converter only transcribes audio owned by the user, the job fails otherwise.
+// Its progress is streamed like the one of a conversion.
+func (app *application) transcribe(c *gin.Context) {
+	var input struct {
+		Language string `json:"language"`
+	}
+
+	// the body is optional, the language is detected without one
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+			return
+		}
+	}
+
+	if err := validateLanguage(input.Language); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := app.extractUser(c)
+	if err != nil {
+		app.serverError(c)
+		return
+	}
+
+	jobId, err := uuid.NewV7()
+	if err != nil {
+		app.serverError(c)
+		return
+	}
+
+	if err = app.fp.PublishTranscription(c.Request.Context(), c.GetHeader("X-Request-ID"), &events.TranscriptionRequested{
+		JobId: jobId.String(), UserId: user.ID, AudioKey: c.Param("key"), Language: input.Language,
+	}); err != nil {
+		app.serverError(c)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"job_id":    jobId.String(),
+		"audio_key": c.Param("key"),
+	})
+}
+
+// reencode asks the converter to encode an audio of the user again, as a new audio.
+// The converter only encodes audio owned by the user again, the job fails otherwise.
+// Its progress is streamed like the one of a conversion, and the user is emailed the new audio.
+func (app *application) reencode(c *gin.Context) {
+	var input reencodeInput
+
+	// the body is optional, the audio is encoded again as it is without one
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+			return
+		}
+	}
+
+	request, err := input.reencoding()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := app.extractUser(c)
+	if err != nil {
+		app.serverError(c)
+		return
+	}
+
+	jobId, err := uuid.NewV7()
+	if err != nil {
+		app.serverError(c)
+		return
+	}
+
+	request.JobId, request.UserId, request.UserEmail, request.AudioKey = jobId.String(), user.ID, user.Email, c.Param("key")
+	if err = app.fp.PublishReencode(c.Request.Context(), c.GetHeader("X-Request-ID"), request); err != nil {
+		app.serverError(c)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"job_id":    jobId.String(),
+		"audio_key": c.Param("key"),
+	})
+}
+
+// serveFile streams a file from disk storage to anyone holding a valid signed URL.
+func (app *application) serveFile(c *gin.Context) {
+	bucket, key := c.Param("bucket"), strings.TrimPrefix(c.Param("key"), "/")
+	if err := app.signer.Verify(bucket, key, c.Query("expires"), c.Query("signature")); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	info, file, err := app.fs.OpenFile(c.Request.Context(), bucket, key)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNotExist), errors.Is(err, storage.ErrInvalidKey):
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "file not found",
+			})
+		default:
+			slog.Error("Failed to open file", "error", err, "bucket", bucket, "key", key)
+			app.serverError(c)
+		}
+		return
+	}
+	defer file.Close()
+
+	// the stored size is the ciphertext size when files are envelope encrypted
+	size := info.Size
+	if app.cfg.storage.envelope.keys != "" {
+		size = -1
+	}
+
+	c.DataFromReader(http.StatusOK, size, info.ContentType, file, nil)
+}
@@ -8,8 +8,12 @@ import (
 	"log/slog"
 	"mime/multipart"
 	"net/http"
+	"time"
 )
 
+// fileUrlExpiry is how long signed links to files on disk stay valid.
+const fileUrlExpiry = 24 * time.Hour
+
 func (app *application) serverError(c *gin.Context) {
 	c.JSON(http.StatusInternalServerError, gin.H{
 		"error": "something went wrong",
@@ -17,7 +21,11 @@ func (app *application) serverError(c *gin.Context) {
 }
 
 func (app *application) fileUrl(key, bucket string) string {
-	return fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", bucket, app.cfg.aws.s3Region, key)
+	if app.signer != nil {
+		return app.signer.Sign(bucket, key, time.Now().Add(fileUrlExpiry))
+	}
+
+	return app.cfg.aws.s3Config().ObjectURL(bucket, key)
 }
 
 func (app *application) extractUser(c *gin.Context) (*domain.User, error) {
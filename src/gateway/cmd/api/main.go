@@ -1,19 +1,18 @@
 package main
 
 import (
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/credentials"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"context"
 	"github.com/go-resty/resty/v2"
 	amqp "github.com/rabbitmq/amqp091-go"
 
-	"github.com/ziliscite/video-to-mp3/gateway/internal/repository"
 	"github.com/ziliscite/video-to-mp3/gateway/internal/service"
-	"github.com/ziliscite/video-to-mp3/gateway/pkg/encryptor"
+	"github.com/ziliscite/video-to-mp3/platform/mq"
+	"github.com/ziliscite/video-to-mp3/platform/storage"
 
 	"log/slog"
 	"os"
 	"sync"
+	"time"
 )
 
 type application struct {
@@ -21,19 +20,14 @@ type application struct {
 	rc  *resty.Client
 	fs  service.FileService
 	fp  service.FilePublisher
-	wg  sync.WaitGroup
+	ph  service.ProgressHub
+	// signer is only set when files are stored on disk and served by the gateway
+	signer *storage.URLSigner
+	wg     sync.WaitGroup
 }
 
 func main() {
 	cfg := getConfig()
-	s3c := s3.NewFromConfig(aws.Config{
-		Region: cfg.aws.s3Region,
-		Credentials: credentials.NewStaticCredentialsProvider(
-			cfg.aws.accessKeyId,
-			cfg.aws.secretAccessKey,
-			"",
-		),
-	})
 
 	conn, err := amqp.Dial(cfg.rabbit.dsn())
 	if err != nil {
@@ -42,28 +36,54 @@ func main() {
 	}
 	defer conn.Close()
 
-	enc, err := encryptor.NewEncryptor(cfg.encryptKey)
+	enc, err := newEncryptor(cfg)
 	if err != nil {
 		slog.Error("Failed to create encryptor", "error", err)
 		os.Exit(1)
 	}
 
-	fileRepository := repository.NewStore(s3c)
-	fileService := service.NewFileService(enc, fileRepository)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
 
-	filePublisher, err := service.NewPublisher(conn, cfg.rabbit.queue)
+	fileRepository, signer, err := newFileStore(ctx, cfg)
+	if err != nil {
+		slog.Error("Failed to create file store", "error", err)
+		os.Exit(1)
+	}
+
+	sc, err := newScanner(cfg)
+	if err != nil {
+		slog.Error("Failed to create scanner", "error", err)
+		os.Exit(1)
+	}
+
+	fileService := service.NewFileService(enc, fileRepository, sc, cfg.scanner.quarantine)
+
+	filePublisher, err := service.NewPublisher(conn, cfg.rabbit.queue, cfg.rabbit.notificationQueue)
 	if err != nil {
 		slog.Error("Failed to create publisher", "error", err)
 		os.Exit(1)
 	}
 
+	subscription, progress, err := mq.Subscribe(conn, cfg.rabbit.progressExchange)
+	if err != nil {
+		slog.Error("Failed to subscribe to job progress", "error", err)
+		os.Exit(1)
+	}
+	defer subscription.Close()
+
 	app := application{
 		cfg: cfg,
 		rc:  resty.New(),
 		fs:  fileService,
 		fp:  filePublisher,
+		ph:  service.NewProgressHub(),
+
+		signer: signer,
 	}
 
+	go app.listenProgress(progress)
+
 	if err = app.run(); err != nil {
 		slog.Error("Error running application", "error", err.Error())
 		os.Exit(1)
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ziliscite/video-to-mp3/events"
+)
+
+// Bounds of the audio filters, the converter refuses anything outside of them.
+const (
+	minCompressSilence = 0.5
+	maxCompressSilence = 30
+	minHighPass        = 20
+	maxHighPass        = 1000
+	minLowPass         = 2000
+	maxLowPass         = 20000
+	minNoiseReduction  = 1
+	maxNoiseReduction  = 97
+	maxCues            = 100
+	maxCueTitle        = 255
+	minBitrate         = 32
+	maxBitrate         = 320
+)
+
+// renditionHeights are the heights a video may be streamed at, the converter refuses any other.
+var renditionHeights = map[int]bool{240: true, 360: true, 480: true, 720: true, 1080: true}
+
+// audioOptions is how the audio of an upload should be processed, nil parts are left out of the event.
+type audioOptions struct {
+	loudness string
+	filters  *events.AudioFilters
+	chapters *events.ChapterSplit
+	// transcript is nil unless the speech should be transcribed once converted
+	transcript *events.TranscriptRequest
+	// stream is nil unless the video should be transcoded into HLS too
+	stream *events.StreamRequest
+	// preview asks for an animated preview of the video along with its poster frame
+	preview bool
+	// preset is the saved preset filling in the rest, zero for the default preset of the user
+	preset int64
+}
+
+// uploadOptions reads how the audio of an upload should be processed from its form.
+// The errors are meant for the user.
+func uploadOptions(c *gin.Context) (*audioOptions, error) {
+	// the audio is left as it is unless a loudness preset is asked for
+	loudness := c.PostForm("loudness")
+	switch loudness {
+	case "", events.LoudnessPodcast, events.LoudnessStreaming, events.LoudnessBroadcast:
+	default:
+		return nil, errors.New("loudness must be podcast, streaming or broadcast")
+	}
+
+	filters, err := audioFilters(c)
+	if err != nil {
+		return nil, err
+	}
+
+	chapters, err := chapterSplit(c)
+	if err != nil {
+		return nil, err
+	}
+
+	transcript, err := transcriptRequest(c)
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := streamRequest(c)
+	if err != nil {
+		return nil, err
+	}
+
+	preview := false
+	if v := c.PostForm("preview"); v != "" {
+		if preview, err = strconv.ParseBool(v); err != nil {
+			return nil, errors.New("preview must be true or false")
+		}
+	}
+
+	var preset int64
+	if v := c.PostForm("preset_id"); v != "" {
+		if preset, err = strconv.ParseInt(v, 10, 64); err != nil || preset < 1 {
+			return nil, errors.New("preset_id must be the id of a preset")
+		}
+	}
+
+	return &audioOptions{
+		loudness: loudness, filters: filters, chapters: chapters, transcript: transcript, stream: stream, preview: preview, preset: preset,
+	}, nil
+}
+
+// streamRequest reads whether the video should be transcoded into HLS, nil when it shouldn't.
+// Renditions are heights separated by commas, "360,720", and imply the stream. Its audio is always streamed.
+func streamRequest(c *gin.Context) (*events.StreamRequest, error) {
+	stream := false
+	if v := c.PostForm("stream"); v != "" {
+		var err error
+		if stream, err = strconv.ParseBool(v); err != nil {
+			return nil, errors.New("stream must be true or false")
+		}
+	}
+
+	var renditions []int
+	for _, field := range strings.Split(c.PostForm("renditions"), ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+
+		h, err := strconv.Atoi(strings.TrimSuffix(field, "p"))
+		if err != nil || !renditionHeights[h] {
+			return nil, errors.New("renditions must be among 240, 360, 480, 720 and 1080")
+		}
+
+		if slices.Contains(renditions, h) {
+			return nil, fmt.Errorf("rendition %d is given twice", h)
+		}
+		renditions = append(renditions, h)
+	}
+
+	if !stream && len(renditions) == 0 {
+		return nil, nil
+	}
+	return &events.StreamRequest{Renditions: renditions}, nil
+}
+
+// transcriptRequest reads whether the audio should be transcribed, nil when it shouldn't.
+// A language implies the transcript.
+func transcriptRequest(c *gin.Context) (*events.TranscriptRequest, error) {
+	transcribe := false
+	if v := c.PostForm("transcribe"); v != "" {
+		var err error
+		if transcribe, err = strconv.ParseBool(v); err != nil {
+			return nil, errors.New("transcribe must be true or false")
+		}
+	}
+
+	language := c.PostForm("language")
+	if err := validateLanguage(language); err != nil {
+		return nil, err
+	}
+
+	if !transcribe && language == "" {
+		return nil, nil
+	}
+	return &events.TranscriptRequest{Language: language}, nil
+}
+
+// validateLanguage checks the language of a transcript, a lowercase ISO 639-1 code or empty to detect it.
+func validateLanguage(language string) error {
+	if language == "" {
+		return nil
+	}
+
+	if len(language) != 2 || language[0] < 'a' || language[0] > 'z' || language[1] < 'a' || language[1] > 'z' {
+		return errors.New("language must be a two letter code like en")
+	}
+	return nil
+}
+
+// audioFilters reads the filters from the form, nil when none were asked for.
+func audioFilters(c *gin.Context) (*events.AudioFilters, error) {
+
+	var (
+		f   events.AudioFilters
+		err error
+	)
+
+	if v := c.PostForm("trim_silence"); v != "" {
+		if f.TrimSilence, err = strconv.ParseBool(v); err != nil {
+			return nil, errors.New("trim_silence must be true or false")
+		}
+	}
+
+	if f.CompressSilence, err = formFloat(c, "compress_silence", minCompressSilence, maxCompressSilence); err != nil {
+		return nil, err
+	}
+
+	if f.HighPass, err = formInt(c, "high_pass", minHighPass, maxHighPass); err != nil {
+		return nil, err
+	}
+
+	if f.LowPass, err = formInt(c, "low_pass", minLowPass, maxLowPass); err != nil {
+		return nil, err
+	}
+
+	if f.NoiseReduction, err = formFloat(c, "noise_reduction", minNoiseReduction, maxNoiseReduction); err != nil {
+		return nil, err
+	}
+
+	if f == (events.AudioFilters{}) {
+		return nil, nil
+	}
+	return &f, nil
+}
+
+// chapterSplit reads whether the audio should be split into chapters, nil when it shouldn't.
+// Cues are given one per line as a timestamp and an optional title, "1:02:03 Proofs" or "2:03 Intro",
+// and imply the split.
+func chapterSplit(c *gin.Context) (*events.ChapterSplit, error) {
+	split := false
+	if v := c.PostForm("split_chapters"); v != "" {
+		var err error
+		if split, err = strconv.ParseBool(v); err != nil {
+			return nil, errors.New("split_chapters must be true or false")
+		}
+	}
+
+	cues, err := parseCues(c.PostForm("cues"))
+	if err != nil {
+		return nil, err
+	}
+
+	if !split && len(cues) == 0 {
+		return nil, nil
+	}
+	return &events.ChapterSplit{Cues: cues}, nil
+}
+
+// parseCues reads the cue list of the form, skipping blank lines.
+func parseCues(text string) ([]events.Cue, error) {
+	var cues []events.Cue
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		stamp, title, _ := strings.Cut(line, " ")
+		start, ok := parseTimestamp(stamp)
+		if !ok {
+			return nil, fmt.Errorf("cue %d must start with a timestamp like 2:03 or 1:02:03", len(cues)+1)
+		}
+
+		title = strings.TrimSpace(title)
+		if utf8.RuneCountInString(title) > maxCueTitle {
+			return nil, fmt.Errorf("cue %d title must be at most %d characters", len(cues)+1, maxCueTitle)
+		}
+
+		if len(cues) > 0 && start <= cues[len(cues)-1].Start {
+			return nil, fmt.Errorf("cue %d must start after the one before it", len(cues)+1)
+		}
+
+		if cues = append(cues, events.Cue{Start: start, Title: title}); len(cues) > maxCues {
+			return nil, fmt.Errorf("at most %d cues can be given", maxCues)
+		}
+	}
+	return cues, nil
+}
+
+// parseTimestamp reads a MM:SS or H:MM:SS timestamp into seconds. The seconds may have a fraction.
+func parseTimestamp(stamp string) (float64, bool) {
+	fields := strings.Split(stamp, ":")
+	if len(fields) < 2 || len(fields) > 3 {
+		return 0, false
+	}
+
+	seconds, err := strconv.ParseFloat(fields[len(fields)-1], 64)
+	if err != nil || !(seconds >= 0 && seconds < 60) {
+		return 0, false
+	}
+
+	// the hours, if any, then the minutes
+	total := seconds
+	for i, field := range fields[:len(fields)-1] {
+		n, err := strconv.Atoi(field)
+		if err != nil || n < 0 || (i > 0 && n >= 60) {
+			return 0, false
+		}
+		total += float64(n) * math.Pow(60, float64(len(fields)-1-i))
+	}
+	return total, true
+}
+
+// reencodeInput is the body of a re-encode request, every field is optional.
+// Start and End are timestamps like those of the cues.
+type reencodeInput struct {
+	Format   string `json:"format"`
+	Bitrate  int    `json:"bitrate"`
+	Start    string `json:"start"`
+	End      string `json:"end"`
+	Loudness string `json:"loudness"`
+}
+
+// reencoding checks the input and returns the re-encode it asks for. The errors are meant for the user.
+func (in *reencodeInput) reencoding() (*events.ReencodeRequested, error) {
+	r := &events.ReencodeRequested{Format: in.Format, Bitrate: in.Bitrate, Loudness: in.Loudness}
+
+	switch in.Format {
+	case "", events.FormatMP3, events.FormatAAC:
+	case events.FormatWAV:
+		if in.Bitrate != 0 {
+			return nil, errors.New("wav has no bitrate")
+		}
+	default:
+		return nil, errors.New("format must be mp3, aac or wav")
+	}
+
+	if in.Bitrate != 0 && (in.Bitrate < minBitrate || in.Bitrate > maxBitrate) {
+		return nil, fmt.Errorf("bitrate must be from %d to %d kbps", minBitrate, maxBitrate)
+	}
+
+	switch in.Loudness {
+	case "", events.LoudnessPodcast, events.LoudnessStreaming, events.LoudnessBroadcast:
+	default:
+		return nil, errors.New("loudness must be podcast, streaming or broadcast")
+	}
+
+	var ok bool
+	if in.Start != "" {
+		if r.Start, ok = parseTimestamp(in.Start); !ok {
+			return nil, errors.New("start must be a timestamp like 2:03 or 1:02:03")
+		}
+	}
+
+	if in.End != "" {
+		if r.End, ok = parseTimestamp(in.End); !ok {
+			return nil, errors.New("end must be a timestamp like 2:03 or 1:02:03")
+		}
+		if r.End <= r.Start {
+			return nil, errors.New("end must be after start")
+		}
+	}
+
+	return r, nil
+}
+
+// formFloat reads an optional number from the form, zero when it's missing.
+func formFloat(c *gin.Context, key string, lo, hi float64) (float64, error) {
+	v := c.PostForm(key)
+	if v == "" {
+		return 0, nil
+	}
+
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || f < lo || f > hi {
+		return 0, fmt.Errorf("%s must be a number from %g to %g", key, lo, hi)
+	}
+	return f, nil
+}
+
+// formInt reads an optional integer from the form, zero when it's missing.
+func formInt(c *gin.Context, key string, lo, hi int) (int, error) {
+	v := c.PostForm(key)
+	if v == "" {
+		return 0, nil
+	}
+
+	i, err := strconv.Atoi(v)
+	if err != nil || i < lo || i > hi {
+		return 0, fmt.Errorf("%s must be a whole number from %d to %d", key, lo, hi)
+	}
+	return i, nil
+}
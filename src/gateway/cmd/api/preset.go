@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxPresetBody is how large the body of a request saving a preset may be.
+const maxPresetBody = 1 << 16 // 64 KB
+
+// errPresetNotFound is returned when the user has no such preset.
+var errPresetNotFound = errors.New("preset not found")
+
+// presetsUrl is the address of the presets in the converter, or of one of them when the id is given.
+func (app *application) presetsUrl(id string) string {
+	url := app.cfg.addr.converter + "/v1/presets"
+	if id != "" {
+		url += "/" + id
+	}
+	return url
+}
+
+func (app *application) listPresets(c *gin.Context) {
+	app.forwardPreset(c, http.MethodGet, false, false)
+}
+
+func (app *application) createPreset(c *gin.Context) {
+	app.forwardPreset(c, http.MethodPost, false, true)
+}
+
+func (app *application) getPreset(c *gin.Context) {
+	app.forwardPreset(c, http.MethodGet, true, false)
+}
+
+func (app *application) updatePreset(c *gin.Context) {
+	app.forwardPreset(c, http.MethodPut, true, true)
+}
+
+func (app *application) deletePreset(c *gin.Context) {
+	app.forwardPreset(c, http.MethodDelete, true, false)
+}
+
+// forwardPreset makes the request of the user to their presets in the converter, and responds with its response.
+// The converter keeps the presets, and refuses the invalid ones with a message meant for the user.
+func (app *application) forwardPreset(c *gin.Context, method string, byId, withBody bool) {
+	var id string
+	if byId {
+		id = c.Param("id")
+		if n, err := strconv.ParseInt(id, 10, 64); err != nil || n < 1 {
+			c.JSON(http.StatusNotFound, gin.H{"error": errPresetNotFound.Error()})
+			return
+		}
+	}
+
+	user, err := app.extractUser(c)
+	if err != nil {
+		app.serverError(c)
+		return
+	}
+
+	req := app.converterRequest(c.Request.Context(), user.ID)
+	if withBody {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxPresetBody)
+
+		body, err := c.GetRawData()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+			return
+		}
+		req.SetHeader("Content-Type", "application/json").SetBody(body)
+	}
+
+	resp, err := req.Execute(method, app.presetsUrl(id))
+	app.relayConverter(c, resp, err)
+}
+
+// checkPreset returns errPresetNotFound when the user has no such preset, so that an upload naming it
+// is refused before it's stored rather than failing once converted.
+func (app *application) checkPreset(ctx context.Context, userId, id int64) error {
+	resp, err := app.converterRequest(ctx, userId).Get(app.presetsUrl(strconv.FormatInt(id, 10)))
+	if err != nil {
+		return fmt.Errorf("failed to reach converter: %w", err)
+	}
+
+	switch {
+	case resp.StatusCode() == http.StatusNotFound:
+		return errPresetNotFound
+	case resp.IsError():
+		return fmt.Errorf("converter failed to get preset: %s", resp.Status())
+	default:
+		return nil
+	}
+}
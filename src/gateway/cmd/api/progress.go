@@ -0,0 +1,77 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/ziliscite/video-to-mp3/events"
+)
+
+// keepAliveInterval is how often an idle event stream gets a comment, so proxies keep it open.
+const keepAliveInterval = 15 * time.Second
+
+// listenProgress feeds the progress events of the converters to the hub. Every gateway replica
+// subscribes with a queue of its own, so each one sees every job whichever replica the client is on.
+func (app *application) listenProgress(deliveries <-chan amqp.Delivery) {
+	for d := range deliveries {
+		env, err := events.Decode(d.Body, events.TypeJobProgress, d.Headers)
+		if err != nil {
+			slog.Warn("Failed to decode progress", "error", err)
+			continue
+		}
+
+		var progress events.JobProgress
+		if err = env.Unmarshal(&progress); err != nil {
+			slog.Warn("Failed to unmarshal progress", "error", err)
+			continue
+		}
+
+		app.ph.Publish(&progress)
+	}
+
+	slog.Error("Progress subscription closed, job events are no longer streamed")
+}
+
+// jobEvents streams the progress of a job as Server-Sent Events until it completes or fails.
+// Jobs of other users look like jobs that haven't started yet.
+func (app *application) jobEvents(c *gin.Context) {
+	user, err := app.extractUser(c)
+	if err != nil {
+		app.serverError(c)
+		return
+	}
+
+	progress, stop := app.ph.Watch(c.Param("id"))
+	defer stop()
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+
+	rc := http.NewResponseController(c.Writer)
+	c.Stream(func(w io.Writer) bool {
+		// the server's write timeout would end the stream, so every write gets a deadline of its own
+		_ = rc.SetWriteDeadline(time.Now().Add(2 * keepAliveInterval))
+
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case <-keepAlive.C:
+			_, err := io.WriteString(w, ": keep-alive\n\n")
+			return err == nil
+		case p := <-progress:
+			if p.UserId != user.ID {
+				return true
+			}
+
+			c.SSEvent("progress", p)
+			return p.Status == events.JobProcessing
+		}
+	})
+}
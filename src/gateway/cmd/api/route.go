@@ -27,8 +27,24 @@ func (app *application) route() *gin.Engine {
 	v1.POST("/register", app.register)
 	v1.POST("/login", app.login)
 
+	// files on disk have no other way to be downloaded, the signature stands in for auth
+	if app.signer != nil {
+		v1.GET("/files/:bucket/*key", app.serveFile)
+	}
+
 	authenticated := v1.Group("/", app.auth())
 	// get
+	authenticated.PUT("/audio/:key/pin", app.pin)
+	authenticated.DELETE("/audio/:key/pin", app.unpin)
+	authenticated.POST("/audio/:key/transcript", app.transcribe)
+	authenticated.POST("/audio/:key/reencode", app.reencode)
+	authenticated.GET("/jobs/:id/events", app.jobEvents)
+	authenticated.GET("/conversions", app.listConversions)
+	authenticated.GET("/presets", app.listPresets)
+	authenticated.POST("/presets", app.createPreset)
+	authenticated.GET("/presets/:id", app.getPreset)
+	authenticated.PUT("/presets/:id", app.updatePreset)
+	authenticated.DELETE("/presets/:id", app.deletePreset)
 
 	admin := authenticated.Group("/", app.admin())
 	admin.POST("/upload", app.upload)
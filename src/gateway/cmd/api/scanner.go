@@ -0,0 +1,21 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/ziliscite/video-to-mp3/platform/scanner"
+)
+
+// newScanner creates the malware scanner of uploads, nil when no clamd is configured.
+// Rejections are sent to the mailer, so scanning needs the notification queue.
+func newScanner(cfg Config) (scanner.Scanner, error) {
+	if cfg.scanner.clamd == "" {
+		return nil, nil
+	}
+
+	if cfg.rabbit.notificationQueue == "" {
+		return nil, errors.New("scanning uploads needs a notification queue to report rejections")
+	}
+
+	return scanner.NewClamd(cfg.scanner.clamd, cfg.scanner.timeout)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ziliscite/video-to-mp3/platform/envelope"
+	"github.com/ziliscite/video-to-mp3/platform/storage"
+)
+
+// newFileStore creates the configured storage backend, wrapped in envelope encryption of
+// the video bucket when master keys are set. The converter needs the same keys to read the videos.
+func newFileStore(ctx context.Context, cfg Config) (storage.FileStore, *storage.URLSigner, error) {
+	fs, signer, err := newBackend(ctx, cfg)
+	if err != nil || cfg.storage.envelope.keys == "" {
+		return fs, signer, err
+	}
+
+	kr, err := envelope.ParseKeyring(cfg.storage.envelope.active, cfg.storage.envelope.keys)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return storage.NewEncryptedStore(fs, kr, cfg.aws.s3Bucket), signer, nil
+}
+
+// newBackend creates the configured storage backend. The disk backend also returns
+// the signer for the file route, since nothing else can serve those files.
+func newBackend(ctx context.Context, cfg Config) (storage.FileStore, *storage.URLSigner, error) {
+	switch cfg.storage.backend {
+	case "s3":
+		sse := cfg.aws.s3SSE()
+		if err := sse.Validate(); err != nil {
+			return nil, nil, err
+		}
+
+		s3c, err := storage.NewS3Client(ctx, cfg.aws.s3Config())
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return storage.NewStore(s3c, sse), nil, nil
+	case "disk":
+		if cfg.storage.dir == "" || cfg.storage.url == "" || cfg.storage.secret == "" {
+			return nil, nil, fmt.Errorf("disk storage needs a directory, url and secret")
+		}
+
+		signer := storage.NewURLSigner(cfg.storage.url, []byte(cfg.storage.secret))
+		fs, err := storage.NewDiskStore(cfg.storage.dir, signer)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return fs, signer, nil
+	case "memory":
+		return storage.NewMemoryStore(), nil, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown storage backend %q", cfg.storage.backend)
+	}
+}
@@ -2,46 +2,144 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"github.com/ziliscite/video-to-mp3/gateway/internal/repository"
-	"github.com/ziliscite/video-to-mp3/gateway/pkg/encryptor"
+	"github.com/google/uuid"
+	"github.com/ziliscite/video-to-mp3/platform/encryptor"
+	"github.com/ziliscite/video-to-mp3/platform/scanner"
+	"github.com/ziliscite/video-to-mp3/platform/storage"
 	"io"
+	"log/slog"
 )
 
+// ErrInfected is returned by UploadVideo when malware is found in the video.
+var ErrInfected = errors.New("file is infected")
+
+// InfectedError describes a video rejected by the scanner, it wraps ErrInfected.
+// QuarantineKey names the copy kept in the quarantine bucket, it's empty when none is kept.
+type InfectedError struct {
+	Signature     string
+	QuarantineKey string
+}
+
+func (e *InfectedError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrInfected, e.Signature)
+}
+
+func (e *InfectedError) Unwrap() error {
+	return ErrInfected
+}
+
 type FileService interface {
-	// UploadVideo saves video to the storage and returns the file key.
+	// UploadVideo saves video to the storage under a random file key,
+	// and returns the key along with the encrypted filename.
 	// Filename is the original filename of the file.
 	// Bucket is the bucket name where the file will be saved.
-	UploadVideo(ctx context.Context, filesize int64, filename, bucket string, file io.Reader) (string, error)
+	// The video is scanned first when there is a scanner, an infected one returns an *InfectedError.
+	UploadVideo(ctx context.Context, filesize int64, filename, bucket string, file io.ReadSeeker) (fileKey, encryptedName string, err error)
 	DeleteVideo(ctx context.Context, bucket, fileKey string) error
+	// OpenFile returns a stored file and its info, the caller must close it.
+	OpenFile(ctx context.Context, bucket, fileKey string) (*storage.FileInfo, io.ReadCloser, error)
 }
 
 type fileService struct {
 	en *encryptor.Encryptor
-	wr repository.FileStore
+	wr storage.FileStore
+	sc scanner.Scanner
+	// quarantine is the bucket infected videos are kept in, empty to discard them
+	quarantine string
 }
 
-func NewFileService(en *encryptor.Encryptor, r repository.FileStore) FileService {
+// NewFileService creates the file service, a nil scanner uploads videos unscanned.
+func NewFileService(en *encryptor.Encryptor, r storage.FileStore, sc scanner.Scanner, quarantineBucket string) FileService {
 	return &fileService{
-		en: en,
-		wr: r,
+		en:         en,
+		wr:         r,
+		sc:         sc,
+		quarantine: quarantineBucket,
 	}
 }
 
-func (u *fileService) UploadVideo(ctx context.Context, filesize int64, filename, bucket string, file io.Reader) (string, error) {
-	fileKey, err := u.en.Encrypt(filename)
+func (u *fileService) UploadVideo(ctx context.Context, filesize int64, filename, bucket string, file io.ReadSeeker) (string, string, error) {
+	encryptedName, err := u.en.Encrypt(filename)
+	if err != nil {
+		return "", "", fmt.Errorf("cannot encrypt filename: %w", err)
+	}
+
+	// the key is random, so it reveals nothing about the file and never collides across users
+	id, err := uuid.NewV7()
+	if err != nil {
+		return "", "", fmt.Errorf("cannot create file key: %w", err)
+	}
+	fileKey := id.String()
+
+	if err = u.scan(ctx, fileKey, filesize, file); err != nil {
+		return "", "", err
+	}
+
+	return fileKey, encryptedName, u.save(ctx, fmt.Sprintf("%s.mp4", fileKey), "video/mp4", bucket, filesize, file)
+}
+
+// scan checks the video for malware and rewinds it. Infected videos are copied to the
+// quarantine bucket, as plain bytes nothing will serve or convert.
+func (u *fileService) scan(ctx context.Context, fileKey string, filesize int64, file io.ReadSeeker) error {
+	if u.sc == nil {
+		return nil
+	}
+
+	result, err := u.sc.Scan(ctx, file)
 	if err != nil {
-		return "", fmt.Errorf("cannot encrypt image url: %w", err)
+		return fmt.Errorf("cannot scan file: %w", err)
 	}
 
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("cannot rewind file: %w", err)
+	}
+
+	if !result.Infected {
+		return nil
+	}
+
+	infected := &InfectedError{Signature: result.Signature}
+	if u.quarantine == "" {
+		return infected
+	}
+
+	// the video is rejected either way, losing the quarantined copy only loses evidence
+	key := fmt.Sprintf("%s.mp4", fileKey)
+	if err = u.save(ctx, key, "application/octet-stream", u.quarantine, filesize, file); err != nil {
+		slog.Error("Failed to quarantine infected file", "key", key, "signature", result.Signature, "error", err)
+		return infected
+	}
+
+	infected.QuarantineKey = key
+	return infected
+}
+
+func (u *fileService) save(ctx context.Context, key, contentType, bucket string, filesize int64, file io.Reader) error {
 	const threshold = 1 << 26 // 64MB
 	if filesize > threshold {
-		return fileKey, u.wr.SaveLarge(ctx, fmt.Sprintf("%s.mp4", fileKey), "video/mp4", bucket, file)
+		return u.wr.SaveLarge(ctx, key, contentType, bucket, file)
 	}
 
-	return fileKey, u.wr.Save(ctx, fmt.Sprintf("%s.mp4", fileKey), "video/mp4", bucket, file)
+	return u.wr.Save(ctx, key, contentType, bucket, file)
 }
 
+// DeleteVideo removes a video stored by UploadVideo, fileKey is the key it returned.
 func (u *fileService) DeleteVideo(ctx context.Context, bucket, fileKey string) error {
-	return u.wr.Delete(ctx, bucket, fileKey)
+	return u.wr.Delete(ctx, bucket, fmt.Sprintf("%s.mp4", fileKey))
+}
+
+func (u *fileService) OpenFile(ctx context.Context, bucket, fileKey string) (*storage.FileInfo, io.ReadCloser, error) {
+	info, err := u.wr.Stat(ctx, bucket, fileKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	file, err := u.wr.Read(ctx, bucket, fileKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return info, file, nil
 }
@@ -0,0 +1,111 @@
+package service
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ziliscite/video-to-mp3/events"
+)
+
+// progressTTL is how long the last progress of a job is kept for clients that start watching late.
+const progressTTL = 10 * time.Minute
+
+type ProgressHub interface {
+	// Publish sends the progress to the clients watching the job, and keeps it for the ones that come later.
+	Publish(progress *events.JobProgress)
+	// Watch returns the progress of the job, starting with the last one published if any,
+	// and a function to stop watching. Events a slow client can't keep up with are skipped,
+	// the last one of a job is always delivered.
+	Watch(jobId string) (<-chan *events.JobProgress, func())
+}
+
+type job struct {
+	last     *events.JobProgress
+	seen     time.Time
+	watchers map[chan *events.JobProgress]struct{}
+}
+
+type progressHub struct {
+	mu   sync.Mutex
+	jobs map[string]*job
+	now  func() time.Time
+}
+
+func NewProgressHub() ProgressHub {
+	return &progressHub{
+		jobs: make(map[string]*job),
+		now:  time.Now,
+	}
+}
+
+func (h *progressHub) Publish(progress *events.JobProgress) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.prune()
+
+	j := h.job(progress.JobId)
+	j.last, j.seen = progress, h.now()
+	for ch := range j.watchers {
+		send(ch, progress)
+	}
+}
+
+func (h *progressHub) Watch(jobId string) (<-chan *events.JobProgress, func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ch := make(chan *events.JobProgress, 8)
+	j := h.job(jobId)
+	j.watchers[ch] = struct{}{}
+	if j.last != nil {
+		ch <- j.last
+	}
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			h.mu.Lock()
+			defer h.mu.Unlock()
+
+			delete(j.watchers, ch)
+			if len(j.watchers) == 0 && j.last == nil {
+				delete(h.jobs, jobId)
+			}
+		})
+	}
+}
+
+func (h *progressHub) job(jobId string) *job {
+	j, ok := h.jobs[jobId]
+	if !ok {
+		j = &job{watchers: make(map[chan *events.JobProgress]struct{})}
+		h.jobs[jobId] = j
+	}
+	return j
+}
+
+// prune forgets the jobs nobody watches that haven't moved in a while.
+func (h *progressHub) prune() {
+	for id, j := range h.jobs {
+		if len(j.watchers) == 0 && h.now().Sub(j.seen) > progressTTL {
+			delete(h.jobs, id)
+		}
+	}
+}
+
+// send delivers the progress without blocking, making room by dropping the oldest one.
+// Only the hub sends, under its lock, so there is room once one is dropped.
+func send(ch chan *events.JobProgress, progress *events.JobProgress) {
+	select {
+	case ch <- progress:
+		return
+	default:
+	}
+
+	select {
+	case <-ch:
+	default:
+	}
+	ch <- progress
+}
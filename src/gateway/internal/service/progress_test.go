@@ -0,0 +1,65 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ziliscite/video-to-mp3/events"
+)
+
+func TestProgressHub(t *testing.T) {
+	progress := func(jobId string, percent int) *events.JobProgress {
+		return &events.JobProgress{JobId: jobId, UserId: 1, Status: events.JobProcessing, Percent: percent}
+	}
+
+	t.Run("late watchers get the last progress", func(t *testing.T) {
+		hub := NewProgressHub()
+		hub.Publish(progress("job-1", 5))
+		hub.Publish(progress("job-1", 10))
+		hub.Publish(progress("job-2", 50))
+
+		ch, stop := hub.Watch("job-1")
+		defer stop()
+
+		if p := <-ch; p.Percent != 10 {
+			t.Errorf("Expected 10, got %d", p.Percent)
+		}
+
+		hub.Publish(progress("job-1", 15))
+		if p := <-ch; p.Percent != 15 {
+			t.Errorf("Expected 15, got %d", p.Percent)
+		}
+	})
+
+	t.Run("slow watchers get the latest", func(t *testing.T) {
+		hub := NewProgressHub()
+		ch, stop := hub.Watch("job-1")
+		defer stop()
+
+		for percent := 1; percent <= 100; percent++ {
+			hub.Publish(progress("job-1", percent))
+		}
+
+		var last *events.JobProgress
+		for len(ch) > 0 {
+			last = <-ch
+		}
+
+		if last == nil || last.Percent != 100 {
+			t.Errorf("Expected the last progress to be delivered, got %+v", last)
+		}
+	})
+
+	t.Run("idle jobs are forgotten", func(t *testing.T) {
+		now := time.Now()
+		hub := &progressHub{jobs: make(map[string]*job), now: func() time.Time { return now }}
+		hub.Publish(progress("job-1", 5))
+
+		now = now.Add(progressTTL + time.Minute)
+		hub.Publish(progress("job-2", 5))
+
+		if _, ok := hub.jobs["job-1"]; ok || len(hub.jobs) != 1 {
+			t.Errorf("Expected only job-2 to be kept, got %d jobs", len(hub.jobs))
+		}
+	})
+}
@@ -2,62 +2,71 @@ package service
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	amqp "github.com/rabbitmq/amqp091-go"
-	"github.com/ziliscite/video-to-mp3/gateway/internal/domain"
+	"github.com/ziliscite/video-to-mp3/events"
+	"github.com/ziliscite/video-to-mp3/platform/mq"
 )
 
 type FilePublisher interface {
-	PublishVideo(ctx context.Context, video *domain.Video) error
+	// PublishVideo sends the uploaded video to the converter.
+	// The correlation id is carried by every event caused by this upload, an empty one starts a new chain.
+	PublishVideo(ctx context.Context, correlationId string, video *events.VideoUploaded) error
+	// PublishPin asks the converter to pin or unpin an audio against its retention policy.
+	PublishPin(ctx context.Context, correlationId string, pin *events.AudioPinned) error
+	// PublishTranscription asks the converter to transcribe an audio of the user.
+	PublishTranscription(ctx context.Context, correlationId string, transcription *events.TranscriptionRequested) error
+	// PublishReencode asks the converter to encode an audio of the user again.
+	PublishReencode(ctx context.Context, correlationId string, reencode *events.ReencodeRequested) error
+	// PublishRejection tells the user that their video was rejected by the malware scan.
+	PublishRejection(ctx context.Context, correlationId string, rejection *events.VideoRejected) error
 }
 
+var errNoNotificationQueue = errors.New("no notification queue configured")
+
 type publisher struct {
-	ac *amqp.Connection
-	vq amqp.Queue
+	mp *mq.Publisher
+	// np publishes to the mailer, it's nil without a notification queue
+	np *mq.Publisher
 }
 
-func NewPublisher(ac *amqp.Connection, queueName string) (FilePublisher, error) {
-	// create a new channel for the publisher
-	ch, err := ac.Channel()
+// NewPublisher creates a publisher of the video queue, and of the notification queue unless its name is empty.
+func NewPublisher(ac *amqp.Connection, queueName, notificationQueue string) (FilePublisher, error) {
+	mp, err := mq.NewPublisher(ac, queueName)
 	if err != nil {
 		return nil, err
 	}
-	defer ch.Close()
 
-	// declare the video queue once during publisher initialization
-	vq, err := ch.QueueDeclare(queueName, true, false, false, false, nil)
-	if err != nil {
-		return nil, err
+	p := &publisher{mp: mp}
+	if notificationQueue != "" {
+		if p.np, err = mq.NewPublisher(ac, notificationQueue); err != nil {
+			return nil, err
+		}
 	}
 
-	return &publisher{
-		ac: ac,
-		vq: vq,
-	}, nil
+	return p, nil
 }
 
-func (p *publisher) PublishVideo(ctx context.Context, video *domain.Video) error {
-	ch, err := p.ac.Channel()
-	if err != nil {
-		return err
-	}
-	defer ch.Close()
+func (p *publisher) PublishVideo(ctx context.Context, correlationId string, video *events.VideoUploaded) error {
+	return p.mp.Publish(ctx, events.TypeVideoUploaded, correlationId, video)
+}
 
-	// encode the video struct to json
-	msg, err := json.Marshal(video)
-	if err != nil {
-		return err
+func (p *publisher) PublishPin(ctx context.Context, correlationId string, pin *events.AudioPinned) error {
+	return p.mp.Publish(ctx, events.TypeAudioPinned, correlationId, pin)
+}
+
+func (p *publisher) PublishTranscription(ctx context.Context, correlationId string, transcription *events.TranscriptionRequested) error {
+	return p.mp.Publish(ctx, events.TypeTranscriptionRequested, correlationId, transcription)
+}
+
+func (p *publisher) PublishReencode(ctx context.Context, correlationId string, reencode *events.ReencodeRequested) error {
+	return p.mp.Publish(ctx, events.TypeReencodeRequested, correlationId, reencode)
+}
+
+func (p *publisher) PublishRejection(ctx context.Context, correlationId string, rejection *events.VideoRejected) error {
+	if p.np == nil {
+		return errNoNotificationQueue
 	}
 
-	return ch.PublishWithContext(ctx,
-		"",
-		p.vq.Name,
-		false,
-		false,
-		amqp.Publishing{
-			DeliveryMode: amqp.Persistent,
-			ContentType:  "application/json",
-			Body:         msg,
-		},
-	)
+	return p.np.Publish(ctx, events.TypeVideoRejected, correlationId, rejection)
 }
@@ -2,7 +2,7 @@ package main
 
 import (
 	"flag"
-	"fmt"
+	"github.com/ziliscite/video-to-mp3/platform/mq"
 	"log/slog"
 	"os"
 	"strconv"
@@ -18,7 +18,7 @@ type RabbitMQ struct {
 }
 
 func (r RabbitMQ) dsn() string {
-	return fmt.Sprintf("amqps://%s:%s@%s:%s", r.username, r.password, r.host, r.port)
+	return mq.DSN(r.username, r.password, r.host, r.port)
 }
 
 type Config struct {
@@ -3,6 +3,7 @@ package main
 import (
 	"errors"
 	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/ziliscite/video-to-mp3/events"
 	"github.com/ziliscite/video-to-mp3/mailer/internal"
 	"log/slog"
 )
@@ -23,13 +24,16 @@ func (s *listener) listen() error {
 	forever := make(chan bool)
 	go func() {
 		for m := range mails {
-			email, ok := m.Headers["email"].(string)
-			if !ok {
+			// messages published before envelopes and failure notifications carry
+			// neither a type nor an envelope, they can only be successes
+			env, err := events.Decode(m.Body, events.TypeConversionSucceeded, m.Headers)
+			if err != nil {
+				slog.Error("Failed to decode notification", "error", err)
 				m.Nack(false, false)
 				continue
 			}
 
-			if err = s.sendNotification(m.Body, email); err != nil {
+			if err = s.sendNotification(env); err != nil {
 				switch {
 				case errors.Is(err, internal.ErrConnection) || errors.Is(err, amqp.ErrClosed):
 					m.Nack(false, true)
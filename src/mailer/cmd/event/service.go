@@ -1,12 +1,16 @@
 package main
 
 import (
-	"encoding/json"
+	"errors"
+	"fmt"
 	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/ziliscite/video-to-mp3/events"
 	"github.com/ziliscite/video-to-mp3/mailer/internal"
-	"github.com/ziliscite/video-to-mp3/mailer/internal/domain"
+	"github.com/ziliscite/video-to-mp3/platform/mq"
 )
 
+var errUnknownEvent = errors.New("unknown event type")
+
 type listener struct {
 	amc *amqp.Connection
 	mq  amqp.Queue
@@ -14,20 +18,7 @@ type listener struct {
 }
 
 func newService(cfg Config, amc *amqp.Connection, mr *internal.Mailer) (*listener, error) {
-	ch, err := amc.Channel()
-	if err != nil {
-		return nil, err
-	}
-	defer ch.Close()
-
-	q, err := ch.QueueDeclare(
-		cfg.mq.queue,
-		true,
-		false,
-		false,
-		false,
-		nil,
-	)
+	q, err := mq.DeclareQueue(amc, cfg.mq.queue)
 	if err != nil {
 		return nil, err
 	}
@@ -39,16 +30,98 @@ func newService(cfg Config, amc *amqp.Connection, mr *internal.Mailer) (*listene
 	}, nil
 }
 
-func (s *listener) sendNotification(body []byte, email string) error {
-	var mail domain.Metadata
-	if err := json.Unmarshal(body, &mail); err != nil {
+// sendNotification picks the template by event type.
+func (s *listener) sendNotification(env *events.Envelope) error {
+	switch env.Type {
+	case events.TypeConversionSucceeded:
+		return s.sendSuccess(env)
+	case events.TypeConversionFailed:
+		return s.sendFailure(env)
+	case events.TypeVideoRejected:
+		return s.sendRejection(env)
+	default:
+		return fmt.Errorf("%w: %q", errUnknownEvent, env.Type)
+	}
+}
+
+func (s *listener) sendSuccess(env *events.Envelope) error {
+	var mail events.ConversionSucceeded
+	if err := env.Unmarshal(&mail); err != nil {
 		return err
 	}
 
-	return s.mr.Send(email, "mp4_audio_notification.tmpl", map[string]interface{}{
+	parts := make([]map[string]interface{}, 0, len(mail.Parts))
+	for i, part := range mail.Parts {
+		parts = append(parts, map[string]interface{}{
+			"number":   i + 1,
+			"title":    part.Title,
+			"start":    timestamp(part.Start),
+			"audioKey": part.AudioKey,
+			"audioURL": part.AudioURL,
+		})
+	}
+
+	return s.mr.Send(mail.UserEmail, "mp4_audio_notification.tmpl", map[string]interface{}{
 		"userID":   mail.UserId,
 		"filename": mail.FileName,
 		"videoKey": mail.VideoKey,
 		"audioKey": mail.AudioKey,
+		"audioURL": mail.AudioURL,
+		"parts":    parts,
+	})
+}
+
+// timestamp formats seconds from the start of the video the way players show them, as 1:02:03 or 2:03.
+func timestamp(seconds float64) string {
+	s := int(seconds)
+	if s >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", s/3600, s/60%60, s%60)
+	}
+	return fmt.Sprintf("%d:%02d", s/60, s%60)
+}
+
+func (s *listener) sendFailure(env *events.Envelope) error {
+	var failure events.ConversionFailed
+	if err := env.Unmarshal(&failure); err != nil {
+		return err
+	}
+
+	return s.mr.Send(failure.UserEmail, "mp4_audio_failure.tmpl", map[string]interface{}{
+		"userID":   failure.UserId,
+		"filename": failure.FileName,
+		"videoKey": failure.VideoKey,
+		"reason":   failure.Why(),
+		"message":  reasonMessage(failure.Why()),
+	})
+}
+
+func (s *listener) sendRejection(env *events.Envelope) error {
+	var rejection events.VideoRejected
+	if err := env.Unmarshal(&rejection); err != nil {
+		return err
+	}
+
+	return s.mr.Send(rejection.UserEmail, "mp4_rejected.tmpl", map[string]interface{}{
+		"userID":    rejection.UserId,
+		"filename":  rejection.FileName,
+		"signature": rejection.Signature,
 	})
 }
+
+// reasonMessage returns the explanation shown to the user for the failure reason.
+func reasonMessage(reason string) string {
+	switch reason {
+	case events.ReasonUnsupportedCodec:
+		return "The audio track of your video uses a format we can't convert yet."
+	case events.ReasonCorruptFile:
+		return "Your video file appears to be damaged or incomplete, so we couldn't read it."
+	case events.ReasonTooLong:
+		return "Your video is longer than the maximum length we can convert."
+	case events.ReasonPresetNotFound:
+		return "The preset your upload named no longer exists, upload it again with another preset."
+	case events.ReasonTimedOut:
+		return "Your video took longer to convert than we allow, try a shorter or smaller one."
+	default:
+		return "Something went wrong on our side while converting your video."
+	}
+}
@@ -2,10 +2,21 @@ module github.com/ziliscite/video-to-mp3/mailer
 
 go 1.24.0
 
-require github.com/go-mail/mail/v2 v2.3.0
+require (
+	github.com/go-mail/mail/v2 v2.3.0
+	github.com/rabbitmq/amqp091-go v1.10.0
+	github.com/ziliscite/video-to-mp3/events v0.0.0
+	github.com/ziliscite/video-to-mp3/platform v0.0.0
+)
 
 require (
-	github.com/rabbitmq/amqp091-go v1.10.0 // indirect
+	github.com/google/uuid v1.6.0 // indirect
+	github.com/santhosh-tekuri/jsonschema/v6 v6.0.2 // indirect
+	golang.org/x/text v0.21.0 // indirect
 	gopkg.in/alexcesaro/quotedprintable.v3 v3.0.0-20150716171945-2caba252f4dc // indirect
 	gopkg.in/mail.v2 v2.3.1 // indirect
 )
+
+replace github.com/ziliscite/video-to-mp3/events => ../events
+
+replace github.com/ziliscite/video-to-mp3/platform => ../platform
@@ -17,6 +17,6 @@ func Open(ctx context.Context, dsn string) (*pgxpool.Pool, error) {
 		return nil, err
 	}
 
-	slog.Info("Connected to authentication database")
+	slog.Info("Connected to database")
 	return db, nil
 }
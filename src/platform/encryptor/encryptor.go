@@ -0,0 +1,233 @@
+package encryptor
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrInvalidCiphertext = errors.New("invalid ciphertext")
+	ErrUnknownKey        = errors.New("unknown key")
+)
+
+// version starts every ciphertext that names its key. Ciphertexts without it were made with the legacy key.
+const version byte = 1
+
+const (
+	minSecretSize = 32
+	maxKeyIdLen   = 255
+)
+
+type key struct {
+	gcm     cipher.AEAD
+	hmacKey []byte
+}
+
+// Encryptor encrypts with the active key and decrypts with any key it knows.
+// Ciphertexts are deterministic, the same plaintext and key always give the same ciphertext.
+type Encryptor struct {
+	active string
+	keys   map[string]*key
+	legacy *key
+}
+
+// NewEncryptor creates an encryptor from a single 64-byte key, split into the encryption and nonce keys.
+// Its ciphertexts carry no key id, so it can't be rotated, use NewKeyring instead.
+func NewEncryptor(masterKey string) (*Encryptor, error) {
+	legacy, err := newLegacyKey(masterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Encryptor{legacy: legacy}, nil
+}
+
+// NewKeyring creates an encryptor that encrypts with the active key and decrypts with any of the keys.
+// Keys are secrets of at least 32 bytes, the encryption and nonce keys are derived from them with HKDF.
+// The legacy key of NewEncryptor, if not empty, decrypts ciphertexts written before the keyring.
+func NewKeyring(active string, secrets map[string][]byte, legacyKey string) (*Encryptor, error) {
+	if _, ok := secrets[active]; !ok {
+		return nil, fmt.Errorf("%w: active key %q", ErrUnknownKey, active)
+	}
+
+	en := &Encryptor{active: active, keys: make(map[string]*key, len(secrets))}
+	for id, secret := range secrets {
+		if id == "" || len(id) > maxKeyIdLen {
+			return nil, fmt.Errorf("invalid key id %q", id)
+		}
+
+		k, err := deriveKey(secret)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %q: %w", id, err)
+		}
+		en.keys[id] = k
+	}
+
+	if legacyKey != "" {
+		legacy, err := newLegacyKey(legacyKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid legacy key: %w", err)
+		}
+		en.legacy = legacy
+	}
+
+	return en, nil
+}
+
+// ParseKeys reads secrets written as comma separated id:hex pairs, e.g. "2024:ab12...,2025:cd34...".
+func ParseKeys(spec string) (map[string][]byte, error) {
+	secrets := make(map[string][]byte)
+	for _, pair := range strings.Split(spec, ",") {
+		id, encoded, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid key %q, expected id:hex", pair)
+		}
+
+		secret, err := hex.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %q: %w", id, err)
+		}
+		secrets[id] = secret
+	}
+
+	return secrets, nil
+}
+
+func newLegacyKey(masterKey string) (*key, error) {
+	if len(masterKey) != 64 {
+		return nil, errors.New("invalid key length")
+	}
+
+	return newKey([]byte(masterKey[:32]), []byte(masterKey[32:]))
+}
+
+func deriveKey(secret []byte) (*key, error) {
+	if len(secret) < minSecretSize {
+		return nil, fmt.Errorf("key must be at least %d bytes, got %d", minSecretSize, len(secret))
+	}
+
+	encKey, err := hkdf.Key(sha256.New, secret, nil, "video-to-mp3 encryptor aes-gcm", 32)
+	if err != nil {
+		return nil, err
+	}
+
+	hmacKey, err := hkdf.Key(sha256.New, secret, nil, "video-to-mp3 encryptor nonce", 32)
+	if err != nil {
+		return nil, err
+	}
+
+	return newKey(encKey, hmacKey)
+}
+
+func newKey(encKey, hmacKey []byte) (*key, error) {
+	block, err := aes.NewCipher(encKey)
+	if err != nil {
+		return nil, fmt.Errorf("cipher creation failed: %w", err)
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("GCM creation failed: %w", err)
+	}
+
+	return &key{gcm: gcm, hmacKey: hmacKey}, nil
+}
+
+// seal derives the nonce from the plaintext, so equal plaintexts give equal ciphertexts.
+func (k *key) seal(dst, plaintext, additional []byte) []byte {
+	mac := hmac.New(sha256.New, k.hmacKey)
+	mac.Write(plaintext)
+	nonce := mac.Sum(nil)[:k.gcm.NonceSize()]
+
+	return k.gcm.Seal(append(dst, nonce...), nonce, plaintext, additional)
+}
+
+func (k *key) open(ciphertext, additional []byte) ([]byte, error) {
+	nonceSize := k.gcm.NonceSize()
+	if len(ciphertext) < nonceSize {
+		return nil, fmt.Errorf("%w: ciphertext too short", ErrInvalidCiphertext)
+	}
+
+	plaintext, err := k.gcm.Open(nil, ciphertext[:nonceSize], ciphertext[nonceSize:], additional)
+	if err != nil {
+		return nil, ErrInvalidCiphertext
+	}
+
+	return plaintext, nil
+}
+
+// prefix names the key of a ciphertext, it is authenticated along with the plaintext.
+func prefix(keyId string) []byte {
+	return append([]byte{version, byte(len(keyId))}, keyId...)
+}
+
+func (en Encryptor) Encrypt(plaintext string) (string, error) {
+	var ciphertext []byte
+	if en.keys == nil {
+		ciphertext = en.legacy.seal(nil, []byte(plaintext), nil)
+	} else {
+		p := prefix(en.active)
+		ciphertext = en.keys[en.active].seal(p, []byte(plaintext), p)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(ciphertext), nil
+}
+
+func (en Encryptor) Decrypt(encrypted string) ([]byte, error) {
+	if encrypted == "" {
+		return nil, fmt.Errorf("%w: cannot decrypt empty string", ErrInvalidCiphertext)
+	}
+
+	ciphertext, err := base64.RawURLEncoding.DecodeString(encrypted)
+	if err != nil {
+		return nil, fmt.Errorf("%w: base64 decode failed: %w", ErrInvalidCiphertext, err)
+	}
+
+	if en.keys == nil {
+		return en.legacy.open(ciphertext, nil)
+	}
+
+	plaintext, err := en.open(ciphertext)
+	// a legacy ciphertext can start like a versioned one by chance, its random nonce decides
+	if err != nil && en.legacy != nil {
+		if legacy, lerr := en.legacy.open(ciphertext, nil); lerr == nil {
+			return legacy, nil
+		}
+	}
+
+	return plaintext, err
+}
+
+func (en Encryptor) open(ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) < 2 || ciphertext[0] != version || len(ciphertext) < 2+int(ciphertext[1]) {
+		return nil, ErrInvalidCiphertext
+	}
+
+	keyId := string(ciphertext[2 : 2+ciphertext[1]])
+	k, ok := en.keys[keyId]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKey, keyId)
+	}
+
+	p := prefix(keyId)
+	return k.open(ciphertext[len(p):], p)
+}
+
+// Rekey encrypts the plaintext of a ciphertext again with the active key.
+// A ciphertext already made with the active key comes back unchanged.
+func (en Encryptor) Rekey(encrypted string) (string, error) {
+	plaintext, err := en.Decrypt(encrypted)
+	if err != nil {
+		return "", err
+	}
+
+	return en.Encrypt(string(plaintext))
+}
@@ -3,6 +3,8 @@ package encryptor
 import (
 	"crypto/aes"
 	"encoding/base64"
+	"errors"
+	"strings"
 	"testing"
 )
 
@@ -192,3 +194,94 @@ func TestEncryptor(t *testing.T) {
 		}
 	})
 }
+
+func TestKeyring(t *testing.T) {
+	legacyKey := "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	secrets, err := ParseKeys("2024:" + strings.Repeat("ab", 32) + ", 2025:" + strings.Repeat("cd", 32))
+	if err != nil {
+		t.Fatalf("Failed to parse keys: %v", err)
+	}
+
+	old, err := NewKeyring("2024", map[string][]byte{"2024": secrets["2024"]}, legacyKey)
+	if err != nil {
+		t.Fatalf("Failed to create keyring: %v", err)
+	}
+
+	rotated, err := NewKeyring("2025", secrets, legacyKey)
+	if err != nil {
+		t.Fatalf("Failed to create keyring: %v", err)
+	}
+
+	legacy, err := NewEncryptor(legacyKey)
+	if err != nil {
+		t.Fatalf("Failed to create encryptor: %v", err)
+	}
+
+	decrypt := func(t *testing.T, en *Encryptor, encrypted, want string) {
+		t.Helper()
+
+		decrypted, err := en.Decrypt(encrypted)
+		if err != nil {
+			t.Fatalf("Decryption failed: %v", err)
+		}
+
+		if string(decrypted) != want {
+			t.Errorf("Expected %q, got %q", want, decrypted)
+		}
+	}
+
+	t.Run("rotated keyring decrypts older ciphertexts", func(t *testing.T) {
+		encrypted, _ := old.Encrypt("video.mp4")
+		decrypt(t, rotated, encrypted, "video.mp4")
+
+		unversioned, _ := legacy.Encrypt("video.mp4")
+		decrypt(t, rotated, unversioned, "video.mp4")
+	})
+
+	t.Run("new ciphertexts name the active key", func(t *testing.T) {
+		encrypted, _ := rotated.Encrypt("video.mp4")
+
+		if _, err := old.Decrypt(encrypted); !errors.Is(err, ErrUnknownKey) {
+			t.Errorf("Expected ErrUnknownKey, got %v", err)
+		}
+
+		// the key id is authenticated, naming another key fails
+		ciphertext, _ := base64.RawURLEncoding.DecodeString(encrypted)
+		copy(ciphertext[2:], "2024")
+		if _, err := rotated.Decrypt(base64.RawURLEncoding.EncodeToString(ciphertext)); !errors.Is(err, ErrInvalidCiphertext) {
+			t.Errorf("Expected ErrInvalidCiphertext, got %v", err)
+		}
+	})
+
+	t.Run("rekey", func(t *testing.T) {
+		unversioned, _ := legacy.Encrypt("video.mp4")
+		current, _ := rotated.Encrypt("video.mp4")
+
+		rekeyed, err := rotated.Rekey(unversioned)
+		if err != nil {
+			t.Fatalf("Rekey failed: %v", err)
+		}
+
+		if rekeyed != current {
+			t.Errorf("Expected %q, got %q", current, rekeyed)
+		}
+
+		if again, _ := rotated.Rekey(rekeyed); again != rekeyed {
+			t.Errorf("Expected a current ciphertext to stay %q, got %q", rekeyed, again)
+		}
+	})
+
+	t.Run("invalid keys", func(t *testing.T) {
+		if _, err := NewKeyring("2026", secrets, ""); !errors.Is(err, ErrUnknownKey) {
+			t.Errorf("Expected ErrUnknownKey for a missing active key, got %v", err)
+		}
+
+		if _, err := NewKeyring("short", map[string][]byte{"short": []byte("too short")}, ""); err == nil {
+			t.Error("Expected an error for a short key")
+		}
+
+		if _, err := ParseKeys("2025"); err == nil {
+			t.Error("Expected an error for a key without an id")
+		}
+	})
+}
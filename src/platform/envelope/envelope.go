@@ -0,0 +1,333 @@
+// Package envelope encrypts streams with per-stream data keys wrapped by a master key.
+//
+// An encrypted stream starts with a header naming the master key that wrapped its data key,
+// followed by the content sealed with AES-GCM in chunks, so neither side ever holds the whole
+// stream in memory. Rotating the master key only changes which key wraps new streams, older
+// streams stay readable as long as their master key is kept in the keyring.
+package envelope
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var (
+	ErrNotEncrypted = errors.New("stream is not envelope encrypted")
+	ErrUnknownKey   = errors.New("unknown master key")
+	ErrCorrupt      = errors.New("encrypted stream is corrupt or truncated")
+)
+
+// magic marks encrypted streams, so plaintext objects stored before encryption was enabled can be told apart.
+const magic = "VTMENV1\n"
+
+// HeaderSize is the number of bytes Encrypted needs to recognize a stream.
+const HeaderSize = len(magic)
+
+const (
+	chunkSize   = 64 << 10 // 64 KiB
+	keySize     = 32
+	prefixSize  = 7
+	overhead    = 16 // GCM tag
+	maxKeyIdLen = 255
+)
+
+// Keyring holds the master keys. New streams are wrapped with the active key,
+// existing streams are unwrapped with whichever key they name.
+type Keyring struct {
+	active string
+	keys   map[string]cipher.AEAD
+}
+
+// NewKeyring creates a keyring from 32-byte master keys by id.
+func NewKeyring(active string, keys map[string][]byte) (*Keyring, error) {
+	if _, ok := keys[active]; !ok {
+		return nil, fmt.Errorf("%w: active key %q", ErrUnknownKey, active)
+	}
+
+	kr := &Keyring{active: active, keys: make(map[string]cipher.AEAD, len(keys))}
+	for id, key := range keys {
+		if id == "" || len(id) > maxKeyIdLen {
+			return nil, fmt.Errorf("invalid key id %q", id)
+		}
+
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid master key %q: %w", id, err)
+		}
+		kr.keys[id] = aead
+	}
+
+	return kr, nil
+}
+
+// ParseKeyring reads master keys written as comma separated id:hex pairs, e.g. "2024:ab12...,2025:cd34...".
+func ParseKeyring(active, spec string) (*Keyring, error) {
+	keys := make(map[string][]byte)
+	for _, pair := range strings.Split(spec, ",") {
+		id, encoded, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid master key %q, expected id:hex", pair)
+		}
+
+		key, err := hex.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid master key %q: %w", id, err)
+		}
+		keys[id] = key
+	}
+
+	return NewKeyring(active, keys)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != keySize {
+		return nil, fmt.Errorf("key must be %d bytes, got %d", keySize, len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// wrap seals the data key with the active master key, the key id is authenticated with it.
+func (k *Keyring) wrap(dataKey []byte) ([]byte, error) {
+	master := k.keys[k.active]
+
+	nonce := make([]byte, master.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return master.Seal(nonce, nonce, dataKey, []byte(k.active)), nil
+}
+
+func (k *Keyring) unwrap(keyId string, wrapped []byte) ([]byte, error) {
+	master, ok := k.keys[keyId]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKey, keyId)
+	}
+
+	if len(wrapped) < master.NonceSize() {
+		return nil, ErrCorrupt
+	}
+
+	dataKey, err := master.Open(nil, wrapped[:master.NonceSize()], wrapped[master.NonceSize():], []byte(keyId))
+	if err != nil {
+		return nil, ErrCorrupt
+	}
+
+	return dataKey, nil
+}
+
+// Encrypted reports whether the start of a stream is an envelope header.
+func Encrypted(head []byte) bool {
+	return bytes.HasPrefix(head, []byte(magic))
+}
+
+// Encrypt returns a writer that encrypts everything written to it into w.
+// Close must be called to write the final chunk, it does not close w.
+func (k *Keyring) Encrypt(w io.Writer) (io.WriteCloser, error) {
+	dataKey := make([]byte, keySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+
+	wrapped, err := k.wrap(dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap data key: %w", err)
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := make([]byte, prefixSize)
+	if _, err = rand.Read(prefix); err != nil {
+		return nil, err
+	}
+
+	header := bytes.NewBuffer(nil)
+	header.WriteString(magic)
+	header.WriteByte(byte(len(k.active)))
+	header.WriteString(k.active)
+	_ = binary.Write(header, binary.BigEndian, uint16(len(wrapped)))
+	header.Write(wrapped)
+	header.Write(prefix)
+
+	if _, err = w.Write(header.Bytes()); err != nil {
+		return nil, err
+	}
+
+	return &writer{w: w, aead: aead, prefix: prefix, buf: make([]byte, 0, chunkSize+overhead)}, nil
+}
+
+// Decrypt returns a reader of the plaintext of an encrypted stream.
+func (k *Keyring) Decrypt(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReaderSize(r, chunkSize+overhead+1)
+
+	head := make([]byte, len(magic)+1)
+	if _, err := io.ReadFull(br, head); err != nil || !Encrypted(head) {
+		return nil, ErrNotEncrypted
+	}
+
+	keyId := make([]byte, head[len(magic)])
+	if _, err := io.ReadFull(br, keyId); err != nil {
+		return nil, ErrCorrupt
+	}
+
+	var wrappedLen uint16
+	if err := binary.Read(br, binary.BigEndian, &wrappedLen); err != nil {
+		return nil, ErrCorrupt
+	}
+
+	wrapped := make([]byte, wrappedLen)
+	if _, err := io.ReadFull(br, wrapped); err != nil {
+		return nil, ErrCorrupt
+	}
+
+	dataKey, err := k.unwrap(string(keyId), wrapped)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := make([]byte, prefixSize)
+	if _, err = io.ReadFull(br, prefix); err != nil {
+		return nil, ErrCorrupt
+	}
+
+	return &reader{r: br, aead: aead, prefix: prefix, chunk: make([]byte, chunkSize+overhead)}, nil
+}
+
+// nonce binds every chunk to its position and marks the last one, so chunks can't be
+// reordered, dropped or the stream cut short without failing authentication.
+func nonce(prefix []byte, counter uint32, last bool) []byte {
+	n := make([]byte, prefixSize+5)
+	copy(n, prefix)
+	binary.BigEndian.PutUint32(n[prefixSize:], counter)
+	if last {
+		n[prefixSize+4] = 1
+	}
+	return n
+}
+
+type writer struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	prefix  []byte
+	counter uint32
+	buf     []byte
+	closed  bool
+}
+
+// Write holds back a full chunk until more data arrives, only Close knows which chunk is last.
+func (e *writer) Write(p []byte) (int, error) {
+	if e.closed {
+		return 0, errors.New("write to closed envelope writer")
+	}
+
+	n := len(p)
+	for len(p) > 0 {
+		if len(e.buf) == chunkSize {
+			if err := e.seal(false); err != nil {
+				return 0, err
+			}
+		}
+
+		take := min(chunkSize-len(e.buf), len(p))
+		e.buf = append(e.buf, p[:take]...)
+		p = p[take:]
+	}
+
+	return n, nil
+}
+
+func (e *writer) Close() error {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+
+	return e.seal(true)
+}
+
+func (e *writer) seal(last bool) error {
+	sealed := e.aead.Seal(e.buf[:0], nonce(e.prefix, e.counter, last), e.buf, nil)
+	if _, err := e.w.Write(sealed); err != nil {
+		return err
+	}
+
+	e.counter++
+	e.buf = e.buf[:0]
+	return nil
+}
+
+type reader struct {
+	r       *bufio.Reader
+	aead    cipher.AEAD
+	prefix  []byte
+	counter uint32
+	chunk   []byte
+	plain   []byte
+	done    bool
+}
+
+func (d *reader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+
+		if err := d.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+func (d *reader) open() error {
+	n, err := io.ReadFull(d.r, d.chunk)
+	switch {
+	case errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, io.EOF):
+		// a short chunk can only be the last one
+		d.done = true
+	case err != nil:
+		return err
+	default:
+		// a full chunk is the last one when nothing follows it
+		if _, err = d.r.Peek(1); errors.Is(err, io.EOF) {
+			d.done = true
+		} else if err != nil {
+			return err
+		}
+	}
+
+	plain, err := d.aead.Open(d.chunk[:0], nonce(d.prefix, d.counter, d.done), d.chunk[:n], nil)
+	if err != nil {
+		return ErrCorrupt
+	}
+
+	d.counter++
+	d.plain = plain
+	return nil
+}
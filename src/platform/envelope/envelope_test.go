@@ -0,0 +1,134 @@
+package envelope
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+)
+
+func keyring(t *testing.T, active string, ids ...string) *Keyring {
+	t.Helper()
+
+	keys := make(map[string][]byte)
+	for _, id := range ids {
+		// derive the key from the id so keyrings built separately agree on it
+		keys[id] = bytes.Repeat([]byte(id[:1]), keySize)
+	}
+
+	kr, err := NewKeyring(active, keys)
+	if err != nil {
+		t.Fatalf("Failed to create keyring: %v", err)
+	}
+	return kr
+}
+
+func encrypt(t *testing.T, kr *Keyring, plaintext []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w, err := kr.Encrypt(&buf)
+	if err != nil {
+		t.Fatalf("Failed to start encryption: %v", err)
+	}
+
+	// uneven writes cross chunk boundaries
+	for len(plaintext) > 0 {
+		n := min(len(plaintext), 1000)
+		if _, err = w.Write(plaintext[:n]); err != nil {
+			t.Fatalf("Failed to write: %v", err)
+		}
+		plaintext = plaintext[n:]
+	}
+
+	if err = w.Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func decrypt(kr *Keyring, ciphertext []byte) ([]byte, error) {
+	r, err := kr.Decrypt(bytes.NewReader(ciphertext))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestEnvelope(t *testing.T) {
+	kr := keyring(t, "a", "a")
+
+	t.Run("round trip", func(t *testing.T) {
+		for _, size := range []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 3*chunkSize + 5} {
+			plaintext := make([]byte, size)
+			rand.Read(plaintext)
+
+			ciphertext := encrypt(t, kr, plaintext)
+			if !Encrypted(ciphertext[:HeaderSize]) {
+				t.Errorf("Expected a header for size %d", size)
+			}
+
+			got, err := decrypt(kr, ciphertext)
+			if err != nil {
+				t.Fatalf("Failed to decrypt size %d: %v", size, err)
+			}
+
+			if !bytes.Equal(got, plaintext) {
+				t.Errorf("Round trip of size %d does not match", size)
+			}
+		}
+	})
+
+	t.Run("rotated keyring reads older streams", func(t *testing.T) {
+		ciphertext := encrypt(t, kr, []byte("audio"))
+
+		rotated := keyring(t, "b", "a", "b")
+		if got, err := decrypt(rotated, ciphertext); err != nil || string(got) != "audio" {
+			t.Errorf("Expected %q, got %q, %v", "audio", got, err)
+		}
+
+		if _, err := decrypt(kr, encrypt(t, rotated, []byte("audio"))); !errors.Is(err, ErrUnknownKey) {
+			t.Errorf("Expected ErrUnknownKey, got %v", err)
+		}
+	})
+
+	t.Run("tampering is detected", func(t *testing.T) {
+		plaintext := make([]byte, 2*chunkSize+10)
+		ciphertext := encrypt(t, kr, plaintext)
+
+		flipped := bytes.Clone(ciphertext)
+		flipped[len(flipped)-20] ^= 1
+		if _, err := decrypt(kr, flipped); !errors.Is(err, ErrCorrupt) {
+			t.Errorf("Expected ErrCorrupt for a flipped bit, got %v", err)
+		}
+
+		// drop the last chunk, the stream now ends on a chunk that isn't marked last
+		truncated := ciphertext[:len(ciphertext)-(10+overhead)]
+		if _, err := decrypt(kr, truncated); !errors.Is(err, ErrCorrupt) {
+			t.Errorf("Expected ErrCorrupt for a truncated stream, got %v", err)
+		}
+	})
+
+	t.Run("plaintext is not encrypted", func(t *testing.T) {
+		if _, err := decrypt(kr, []byte("....ftypisom plain video")); !errors.Is(err, ErrNotEncrypted) {
+			t.Errorf("Expected ErrNotEncrypted, got %v", err)
+		}
+	})
+}
+
+func TestParseKeyring(t *testing.T) {
+	key := "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+	if _, err := ParseKeyring("2025", "2024:"+key+", 2025:"+key); err != nil {
+		t.Errorf("Expected a valid keyring, got %v", err)
+	}
+
+	if _, err := ParseKeyring("2026", "2025:"+key); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Expected ErrUnknownKey for a missing active key, got %v", err)
+	}
+
+	if _, err := ParseKeyring("2025", "2025:abcd"); err == nil {
+		t.Errorf("Expected an error for a short key")
+	}
+}
@@ -0,0 +1,151 @@
+// Package mq holds the RabbitMQ plumbing shared by the services: every work queue is durable
+// and every message is a persistent events.Envelope. Broadcasts go through fanout exchanges,
+// each subscriber reading them from a queue of its own.
+package mq
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/url"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/ziliscite/video-to-mp3/events"
+)
+
+// DSN returns the TLS connection url of a broker, the credentials are escaped.
+func DSN(username, password, host, port string) string {
+	u := url.URL{
+		Scheme: "amqps",
+		User:   url.UserPassword(username, password),
+		Host:   net.JoinHostPort(host, port),
+	}
+	return u.String()
+}
+
+// DeclareQueue declares a durable queue on a channel of its own.
+func DeclareQueue(ac *amqp.Connection, name string) (amqp.Queue, error) {
+	ch, err := ac.Channel()
+	if err != nil {
+		return amqp.Queue{}, err
+	}
+	defer ch.Close()
+
+	return ch.QueueDeclare(name, true, false, false, false, nil)
+}
+
+// DeclareExchange declares a durable fanout exchange on a channel of its own.
+func DeclareExchange(ac *amqp.Connection, name string) error {
+	ch, err := ac.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	return ch.ExchangeDeclare(name, amqp.ExchangeFanout, true, false, false, false, nil)
+}
+
+// Publisher publishes events to a queue, or to every queue bound to an exchange.
+type Publisher struct {
+	ac       *amqp.Connection
+	exchange string
+	key      string
+}
+
+// NewPublisher declares the queue once, when the publisher is created.
+func NewPublisher(ac *amqp.Connection, queueName string) (*Publisher, error) {
+	q, err := DeclareQueue(ac, queueName)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Publisher{
+		ac:  ac,
+		key: q.Name,
+	}, nil
+}
+
+// NewExchangePublisher declares the fanout exchange once, when the publisher is created.
+// Events published while nothing is subscribed are dropped.
+func NewExchangePublisher(ac *amqp.Connection, exchange string) (*Publisher, error) {
+	if err := DeclareExchange(ac, exchange); err != nil {
+		return nil, err
+	}
+
+	return &Publisher{
+		ac:       ac,
+		exchange: exchange,
+	}, nil
+}
+
+// Subscribe binds a queue of its own to the fanout exchange and consumes it until the channel
+// or connection closes, the queue is deleted along with them. Deliveries are acknowledged
+// as they are sent, a subscriber missing events must cope with it.
+func Subscribe(ac *amqp.Connection, exchange string) (*amqp.Channel, <-chan amqp.Delivery, error) {
+	if err := DeclareExchange(ac, exchange); err != nil {
+		return nil, nil, err
+	}
+
+	ch, err := ac.Channel()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	q, err := ch.QueueDeclare("", false, true, true, false, nil)
+	if err == nil {
+		err = ch.QueueBind(q.Name, "", exchange, false, nil)
+	}
+
+	var deliveries <-chan amqp.Delivery
+	if err == nil {
+		deliveries, err = ch.Consume(q.Name, "", true, true, false, false, nil)
+	}
+
+	if err != nil {
+		ch.Close()
+		return nil, nil, err
+	}
+
+	return ch, deliveries, nil
+}
+
+// Publish wraps the payload in an envelope of the event type and sends it.
+// The correlation id is carried by every event of a chain, an empty one starts a new chain.
+func (p *Publisher) Publish(ctx context.Context, eventType, correlationId string, payload any) error {
+	ch, err := p.ac.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	env, err := events.New(eventType, correlationId, payload)
+	if err != nil {
+		return err
+	}
+
+	msg, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+
+	return ch.PublishWithContext(ctx,
+		p.exchange,
+		p.key,
+		false,
+		false,
+		publishing(env, msg),
+	)
+}
+
+// publishing is the message of an encoded envelope, its headers mirror the envelope.
+func publishing(env *events.Envelope, body []byte) amqp.Publishing {
+	return amqp.Publishing{
+		DeliveryMode:  amqp.Persistent,
+		ContentType:   "application/json",
+		Body:          body,
+		MessageId:     env.ID,
+		CorrelationId: env.CorrelationID,
+		Type:          env.Type,
+		Timestamp:     env.OccurredAt,
+	}
+}
@@ -0,0 +1,136 @@
+package scanner
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// chunkSize is the size of the chunks a file is streamed in, well under clamd's StreamMaxLength.
+const chunkSize = 64 << 10
+
+// Clamd scans files with the INSTREAM command of a clamd daemon.
+type Clamd struct {
+	network string
+	address string
+	timeout time.Duration
+}
+
+// NewClamd creates a client of the clamd at the address, tcp://host:port or unix:///path/to/clamd.sock.
+// The timeout bounds a whole scan, clamd only answers once it has read the file.
+func NewClamd(address string, timeout time.Duration) (*Clamd, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid clamd address: %w", err)
+	}
+
+	c := &Clamd{network: u.Scheme, timeout: timeout}
+	switch u.Scheme {
+	case "tcp":
+		c.address = u.Host
+	case "unix":
+		c.address = u.Path
+	default:
+		return nil, fmt.Errorf("invalid clamd address %q: the scheme must be tcp or unix", address)
+	}
+
+	if c.address == "" {
+		return nil, fmt.Errorf("invalid clamd address %q: missing host or socket path", address)
+	}
+
+	return c, nil
+}
+
+func (c *Clamd) Scan(ctx context.Context, file io.Reader) (*Result, error) {
+	dialer := net.Dialer{Timeout: c.timeout}
+	conn, err := dialer.DialContext(ctx, c.network, c.address)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(c.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+
+	if err = conn.SetDeadline(deadline); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+
+	// canceling the context unblocks the reads and writes on the connection
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	readErr, writeErr := c.stream(conn, file)
+	if readErr != nil {
+		return nil, fmt.Errorf("failed to read file: %w", readErr)
+	}
+
+	// clamd replies before closing the connection when it refuses the stream,
+	// e.g. past its size limit, so the reply is read even if writing failed
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil {
+		if writeErr != nil {
+			err = writeErr
+		}
+		return nil, fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+
+	return parseReply(reply)
+}
+
+// stream sends the file as length-prefixed chunks, ended by a zero length chunk.
+// It returns the error reading the file apart from the error writing to clamd.
+func (c *Clamd) stream(conn net.Conn, file io.Reader) (error, error) {
+	w := bufio.NewWriterSize(conn, chunkSize+4)
+	if _, err := w.WriteString("zINSTREAM\x00"); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, chunkSize)
+	size := make([]byte, 4)
+	for {
+		n, err := io.ReadFull(file, buf)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size, uint32(n))
+			_, _ = w.Write(size)
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return nil, werr
+			}
+		}
+
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			return err, nil
+		}
+	}
+
+	binary.BigEndian.PutUint32(size, 0)
+	_, _ = w.Write(size)
+	return nil, w.Flush()
+}
+
+// parseReply reads a reply such as "stream: OK" or "stream: Eicar-Signature FOUND".
+func parseReply(reply string) (*Result, error) {
+	reply = strings.TrimSpace(strings.TrimRight(reply, "\x00"))
+
+	switch {
+	case reply == "stream: OK":
+		return &Result{}, nil
+	case strings.HasPrefix(reply, "stream: ") && strings.HasSuffix(reply, " FOUND"):
+		signature := strings.TrimSuffix(strings.TrimPrefix(reply, "stream: "), " FOUND")
+		return &Result{Infected: true, Signature: signature}, nil
+	case strings.Contains(reply, "size limit exceeded"):
+		return nil, ErrTooLarge
+	default:
+		return nil, fmt.Errorf("%w: unexpected reply %q", ErrUnavailable, reply)
+	}
+}
@@ -0,0 +1,158 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeClamd answers INSTREAM commands like clamd, files containing "EICAR" are infected.
+// Streams longer than limit are refused the way clamd enforces StreamMaxLength.
+func fakeClamd(t *testing.T, limit int) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveClamd(conn, limit)
+		}
+	}()
+
+	return "tcp://" + ln.Addr().String()
+}
+
+func serveClamd(conn net.Conn, limit int) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	if command, err := r.ReadString(0); err != nil || command != "zINSTREAM\x00" {
+		conn.Write([]byte("UNKNOWN COMMAND\x00"))
+		return
+	}
+
+	var body bytes.Buffer
+	size := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(r, size); err != nil {
+			return
+		}
+
+		n := binary.BigEndian.Uint32(size)
+		if n == 0 {
+			break
+		}
+
+		if body.Len()+int(n) > limit {
+			conn.Write([]byte("INSTREAM size limit exceeded. ERROR\x00"))
+			return
+		}
+
+		if _, err := io.CopyN(&body, r, int64(n)); err != nil {
+			return
+		}
+	}
+
+	if bytes.Contains(body.Bytes(), []byte("EICAR")) {
+		conn.Write([]byte("stream: Eicar-Signature FOUND\x00"))
+		return
+	}
+	conn.Write([]byte("stream: OK\x00"))
+}
+
+func TestClamd(t *testing.T) {
+	addr := fakeClamd(t, 1<<20)
+
+	clamd, err := NewClamd(addr, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	t.Run("clean", func(t *testing.T) {
+		// spans several chunks
+		result, err := clamd.Scan(context.Background(), strings.NewReader(strings.Repeat("video", chunkSize)))
+		if err != nil {
+			t.Fatalf("Scan failed: %v", err)
+		}
+
+		if result.Infected {
+			t.Errorf("Expected a clean file, got %+v", result)
+		}
+	})
+
+	t.Run("infected", func(t *testing.T) {
+		result, err := clamd.Scan(context.Background(), strings.NewReader("video EICAR video"))
+		if err != nil {
+			t.Fatalf("Scan failed: %v", err)
+		}
+
+		if !result.Infected || result.Signature != "Eicar-Signature" {
+			t.Errorf("Expected an infected file with its signature, got %+v", result)
+		}
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		if result, err := clamd.Scan(context.Background(), strings.NewReader("")); err != nil || result.Infected {
+			t.Errorf("Expected a clean empty file, got %+v, %v", result, err)
+		}
+	})
+
+	t.Run("too large", func(t *testing.T) {
+		_, err := clamd.Scan(context.Background(), bytes.NewReader(make([]byte, 4<<20)))
+		if !errors.Is(err, ErrTooLarge) {
+			t.Errorf("Expected ErrTooLarge, got %v", err)
+		}
+	})
+
+	t.Run("unavailable", func(t *testing.T) {
+		ln, _ := net.Listen("tcp", "127.0.0.1:0")
+		ln.Close()
+
+		down, _ := NewClamd("tcp://"+ln.Addr().String(), time.Second)
+		if _, err := down.Scan(context.Background(), strings.NewReader("video")); !errors.Is(err, ErrUnavailable) {
+			t.Errorf("Expected ErrUnavailable, got %v", err)
+		}
+	})
+}
+
+func TestNewClamd(t *testing.T) {
+	tests := []struct {
+		address string
+		network string
+		valid   bool
+	}{
+		{address: "tcp://clamav:3310", network: "tcp", valid: true},
+		{address: "unix:///run/clamav/clamd.sock", network: "unix", valid: true},
+		{address: "clamav:3310"},
+		{address: "http://clamav:3310"},
+		{address: "tcp://"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			c, err := NewClamd(tt.address, time.Second)
+			if tt.valid != (err == nil) {
+				t.Fatalf("Expected valid %v, got %v", tt.valid, err)
+			}
+
+			if tt.valid && c.network != tt.network {
+				t.Errorf("Expected network %s, got %s", tt.network, c.network)
+			}
+		})
+	}
+}
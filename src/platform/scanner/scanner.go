@@ -0,0 +1,28 @@
+// Package scanner checks uploaded files for malware before they are stored or converted.
+package scanner
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+var (
+	// ErrUnavailable is returned when the scanner can't be reached or gives no verdict.
+	// Files are never treated as clean when it happens.
+	ErrUnavailable = errors.New("scanner unavailable")
+	// ErrTooLarge is returned when the file exceeds the size the scanner accepts.
+	ErrTooLarge = errors.New("file too large to scan")
+)
+
+// Result is the verdict on a scanned file.
+type Result struct {
+	Infected bool
+	// Signature names the malware found, it's empty for clean files.
+	Signature string
+}
+
+// Scanner reads a file to its end and reports whether it is infected.
+type Scanner interface {
+	Scan(ctx context.Context, file io.Reader) (*Result, error)
+}
@@ -0,0 +1,291 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidKey       = fmt.Errorf("invalid file key")
+	ErrInvalidSignature = fmt.Errorf("invalid or expired signature")
+)
+
+// tmpDir holds uploads in progress. It lives under the root so renames stay on one filesystem,
+// and starts with a dot so it can never collide with a bucket.
+const tmpDir = ".tmp"
+
+type diskStore struct {
+	root   string
+	signer *URLSigner
+}
+
+// NewDiskStore creates a FileStore that keeps files on the local filesystem.
+// Every bucket is a directory under root. Presigned URLs are signed by signer,
+// which can be nil when nothing will be served.
+func NewDiskStore(root string, signer *URLSigner) (FileStore, error) {
+	if err := os.MkdirAll(filepath.Join(root, tmpDir), 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create storage directory %s: %w", root, err)
+	}
+
+	return &diskStore{
+		root:   root,
+		signer: signer,
+	}, nil
+}
+
+// path resolves a bucket and key to a file under the root, refusing anything that would escape it.
+func (s *diskStore) path(bucket, fileKey string) (string, error) {
+	if !validBucket(bucket) {
+		return "", fmt.Errorf("%w: bucket %q", ErrInvalidKey, bucket)
+	}
+
+	name := filepath.FromSlash(fileKey)
+	if fileKey == "" || !filepath.IsLocal(name) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidKey, fileKey)
+	}
+
+	return filepath.Join(s.root, bucket, name), nil
+}
+
+// Save writes to a temporary file first and renames it into place,
+// so readers never see a partially written file.
+func (s *diskStore) Save(ctx context.Context, fileKey, types, bucket string, file io.Reader) error {
+	dst, err := s.path(bucket, fileKey)
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(dst), 0o750); err != nil {
+		return fmt.Errorf("failed to create bucket %s: %w", bucket, err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Join(s.root, tmpDir), "upload-*")
+	if err != nil {
+		return fmt.Errorf("failed to upload file %s to bucket %s: %w", fileKey, bucket, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = io.Copy(tmp, file); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to upload file %s to bucket %s: %w", fileKey, bucket, err)
+	}
+
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("failed to upload file %s to bucket %s: %w", fileKey, bucket, err)
+	}
+
+	if err = os.Rename(tmp.Name(), dst); err != nil {
+		return fmt.Errorf("failed to upload file %s to bucket %s: %w", fileKey, bucket, err)
+	}
+
+	return nil
+}
+
+func (s *diskStore) SaveLarge(ctx context.Context, fileKey, types, bucket string, file io.Reader) error {
+	return s.Save(ctx, fileKey, types, bucket, file)
+}
+
+func (s *diskStore) Delete(ctx context.Context, bucket string, fileKey string) error {
+	p, err := s.path(bucket, fileKey)
+	if err != nil {
+		return err
+	}
+
+	if err = os.Remove(p); err != nil {
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			return ErrNotExist
+		default:
+			return fmt.Errorf("failed to delete object %s from bucket %s: %w", fileKey, bucket, err)
+		}
+	}
+
+	return nil
+}
+
+func (s *diskStore) Stat(ctx context.Context, bucket string, fileKey string) (*FileInfo, error) {
+	p, err := s.path(bucket, fileKey)
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := os.Stat(p)
+	if err != nil || fi.IsDir() {
+		switch {
+		case err == nil, errors.Is(err, fs.ErrNotExist):
+			return nil, ErrNotExist
+		default:
+			return nil, fmt.Errorf("failed to stat object %s in bucket %s: %w", fileKey, bucket, err)
+		}
+	}
+
+	return &FileInfo{
+		Key:          fileKey,
+		Size:         fi.Size(),
+		ContentType:  contentType(fileKey),
+		LastModified: fi.ModTime(),
+	}, nil
+}
+
+// List walks the bucket directory, keys are slash separated paths relative to it.
+func (s *diskStore) List(ctx context.Context, bucket string) ([]FileInfo, error) {
+	if !validBucket(bucket) {
+		return nil, fmt.Errorf("%w: bucket %q", ErrInvalidKey, bucket)
+	}
+
+	dir := filepath.Join(s.root, bucket)
+
+	var files []FileInfo
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			return nil
+		}
+
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+
+		key := filepath.ToSlash(rel)
+		files = append(files, FileInfo{
+			Key:          key,
+			Size:         fi.Size(),
+			ContentType:  contentType(key),
+			LastModified: fi.ModTime(),
+		})
+
+		return nil
+	})
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to list objects in bucket %s: %w", bucket, err)
+	}
+
+	return files, nil
+}
+
+func (s *diskStore) Read(ctx context.Context, bucket string, fileKey string) (io.ReadCloser, error) {
+	p, err := s.path(bucket, fileKey)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			return nil, ErrNotExist
+		default:
+			return nil, fmt.Errorf("failed to read object %s from bucket %s: %w", fileKey, bucket, err)
+		}
+	}
+
+	return f, nil
+}
+
+func (s *diskStore) ReadLarge(ctx context.Context, bucket string, fileKey string) (io.ReadCloser, error) {
+	return s.Read(ctx, bucket, fileKey)
+}
+
+// Presign returns a URL signed for the gateway's file route.
+func (s *diskStore) Presign(ctx context.Context, bucket string, fileKey string, expires time.Duration) (string, error) {
+	if s.signer == nil {
+		return "", fmt.Errorf("disk storage has no url signer configured")
+	}
+
+	if _, err := s.Stat(ctx, bucket, fileKey); err != nil {
+		return "", err
+	}
+
+	return s.signer.Sign(bucket, fileKey, time.Now().Add(expires)), nil
+}
+
+func validBucket(bucket string) bool {
+	return bucket != "" && !strings.HasPrefix(bucket, ".") && !strings.ContainsAny(bucket, `/\`)
+}
+
+// streamTypes are the types of HLS files, which the system's table lacks or gets wrong, .ts being TypeScript to some.
+var streamTypes = map[string]string{
+	".m3u8": "application/vnd.apple.mpegurl",
+	".ts":   "video/mp2t",
+}
+
+// contentType guesses the MIME type from the key's extension, disk files don't keep one.
+func contentType(fileKey string) string {
+	if t, ok := streamTypes[path.Ext(fileKey)]; ok {
+		return t
+	}
+
+	if t := mime.TypeByExtension(path.Ext(fileKey)); t != "" {
+		return t
+	}
+
+	return "application/octet-stream"
+}
+
+// URLSigner signs and verifies URLs for files served from local storage.
+type URLSigner struct {
+	base   string
+	secret []byte
+}
+
+// NewURLSigner creates a signer for URLs under base, e.g. http://localhost:8080/v1/files.
+func NewURLSigner(base string, secret []byte) *URLSigner {
+	return &URLSigner{
+		base:   strings.TrimSuffix(base, "/"),
+		secret: secret,
+	}
+}
+
+func (u *URLSigner) mac(bucket, fileKey string, expires int64) string {
+	h := hmac.New(sha256.New, u.secret)
+	h.Write([]byte(bucket + "/" + fileKey + "\n" + strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Sign returns a URL for the file that is valid until expires.
+func (u *URLSigner) Sign(bucket, fileKey string, expires time.Time) string {
+	exp := expires.Unix()
+
+	q := url.Values{}
+	q.Set("expires", strconv.FormatInt(exp, 10))
+	q.Set("signature", u.mac(bucket, fileKey, exp))
+
+	return u.base + "/" + url.PathEscape(bucket) + "/" + (&url.URL{Path: fileKey}).EscapedPath() + "?" + q.Encode()
+}
+
+// Verify checks the expires and signature query values of a signed URL.
+func (u *URLSigner) Verify(bucket, fileKey, expires, signature string) error {
+	exp, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > exp {
+		return ErrInvalidSignature
+	}
+
+	if !hmac.Equal([]byte(signature), []byte(u.mac(bucket, fileKey, exp))) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/ziliscite/video-to-mp3/platform/envelope"
+)
+
+var (
+	ErrEncrypted = fmt.Errorf("file is encrypted and can't be served directly")
+)
+
+type encryptedStore struct {
+	FileStore
+	kr      *envelope.Keyring
+	buckets map[string]bool
+}
+
+// NewEncryptedStore encrypts files written to the buckets with envelope encryption before they reach fs.
+// Reads from any bucket decrypt encrypted files and pass plaintext files through,
+// so files stored before encryption was enabled stay readable.
+func NewEncryptedStore(fs FileStore, kr *envelope.Keyring, buckets ...string) FileStore {
+	encrypted := make(map[string]bool, len(buckets))
+	for _, b := range buckets {
+		encrypted[b] = true
+	}
+
+	return &encryptedStore{
+		FileStore: fs,
+		kr:        kr,
+		buckets:   encrypted,
+	}
+}
+
+// Save stores the files of the other buckets as the wrapped store does. The ciphertext of the
+// encrypted ones is streamed and its length isn't known up front, so they go through SaveLarge.
+func (s *encryptedStore) Save(ctx context.Context, fileKey, types, bucket string, file io.Reader) error {
+	if !s.buckets[bucket] {
+		return s.FileStore.Save(ctx, fileKey, types, bucket, file)
+	}
+
+	return s.SaveLarge(ctx, fileKey, types, bucket, file)
+}
+
+// SaveLarge streams the ciphertext to the store, which uploads bodies of unknown length in parts.
+func (s *encryptedStore) SaveLarge(ctx context.Context, fileKey, types, bucket string, file io.Reader) error {
+	if !s.buckets[bucket] {
+		return s.FileStore.SaveLarge(ctx, fileKey, types, bucket, file)
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		w, err := s.kr.Encrypt(pw)
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+
+		if _, err = io.Copy(w, file); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+
+		pw.CloseWithError(w.Close())
+	}()
+
+	if err := s.FileStore.SaveLarge(ctx, fileKey, types, bucket, pr); err != nil {
+		// unblock the encrypting goroutine when the upload gives up early
+		pr.CloseWithError(err)
+		return err
+	}
+
+	return nil
+}
+
+func (s *encryptedStore) Read(ctx context.Context, bucket string, fileKey string) (io.ReadCloser, error) {
+	file, err := s.FileStore.Read(ctx, bucket, fileKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.decrypt(file, fileKey)
+}
+
+func (s *encryptedStore) ReadLarge(ctx context.Context, bucket string, fileKey string) (io.ReadCloser, error) {
+	file, err := s.FileStore.ReadLarge(ctx, bucket, fileKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.decrypt(file, fileKey)
+}
+
+// Presign refuses encrypted buckets, a direct link would hand out the ciphertext.
+func (s *encryptedStore) Presign(ctx context.Context, bucket string, fileKey string, expires time.Duration) (string, error) {
+	if s.buckets[bucket] {
+		return "", ErrEncrypted
+	}
+
+	return s.FileStore.Presign(ctx, bucket, fileKey, expires)
+}
+
+func (s *encryptedStore) decrypt(file io.ReadCloser, fileKey string) (io.ReadCloser, error) {
+	br := bufio.NewReader(file)
+
+	// files stored before encryption was enabled are plaintext
+	head, _ := br.Peek(envelope.HeaderSize)
+	if !envelope.Encrypted(head) {
+		return readCloser{Reader: br, Closer: file}, nil
+	}
+
+	plain, err := s.kr.Decrypt(br)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to decrypt %s: %w", fileKey, err)
+	}
+
+	return readCloser{Reader: plain, Closer: file}, nil
+}
+
+// readCloser reads from a wrapping reader and closes the underlying file.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
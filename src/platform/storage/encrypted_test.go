@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ziliscite/video-to-mp3/platform/envelope"
+)
+
+func TestEncryptedStore(t *testing.T) {
+	ctx := context.Background()
+
+	kr, err := envelope.NewKeyring("1", map[string][]byte{"1": bytes.Repeat([]byte{1}, 32)})
+	if err != nil {
+		t.Fatalf("Failed to create keyring: %v", err)
+	}
+
+	read := func(t *testing.T, fs FileStore, bucket, key string) string {
+		t.Helper()
+
+		r, err := fs.Read(ctx, bucket, key)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", key, err)
+		}
+		defer r.Close()
+
+		body, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", key, err)
+		}
+		return string(body)
+	}
+
+	for name, inner := range map[string]FileStore{"s3": NewStore(fakeS3(t, "mp3", "mp4"), SSE{}), "memory": NewMemoryStore()} {
+		t.Run(name, func(t *testing.T) {
+			fs := NewEncryptedStore(inner, kr, "mp3")
+
+			if err := fs.Save(ctx, "a.mp3", "audio/mpeg", "mp3", strings.NewReader("audio")); err != nil {
+				t.Fatalf("Failed to save: %v", err)
+			}
+
+			if got := read(t, inner, "mp3", "a.mp3"); !envelope.Encrypted([]byte(got)) || strings.Contains(got, "audio") {
+				t.Errorf("Expected the stored object to be ciphertext, got %q", got)
+			}
+
+			if got := read(t, fs, "mp3", "a.mp3"); got != "audio" {
+				t.Errorf("Expected %q, got %q", "audio", got)
+			}
+
+			// objects stored before encryption was enabled are plaintext
+			if err := inner.Save(ctx, "legacy.mp3", "audio/mpeg", "mp3", strings.NewReader("legacy")); err != nil {
+				t.Fatalf("Failed to save: %v", err)
+			}
+
+			if got := read(t, fs, "mp3", "legacy.mp3"); got != "legacy" {
+				t.Errorf("Expected %q, got %q", "legacy", got)
+			}
+
+			// other buckets are stored as they are
+			if err := fs.Save(ctx, "a.mp4", "video/mp4", "mp4", strings.NewReader("video")); err != nil {
+				t.Fatalf("Failed to save: %v", err)
+			}
+
+			if got := read(t, inner, "mp4", "a.mp4"); got != "video" {
+				t.Errorf("Expected an unencrypted video, got %q", got)
+			}
+
+			if _, err := fs.Presign(ctx, "mp3", "a.mp3", time.Minute); !errors.Is(err, ErrEncrypted) {
+				t.Errorf("Expected ErrEncrypted, got %v", err)
+			}
+		})
+	}
+}
+
+// savesStore records which of its saves were used.
+type savesStore struct {
+	FileStore
+	saves []string
+}
+
+func (s *savesStore) Save(ctx context.Context, fileKey, types, bucket string, file io.Reader) error {
+	s.saves = append(s.saves, "Save "+bucket)
+	return s.FileStore.Save(ctx, fileKey, types, bucket, file)
+}
+
+func (s *savesStore) SaveLarge(ctx context.Context, fileKey, types, bucket string, file io.Reader) error {
+	s.saves = append(s.saves, "SaveLarge "+bucket)
+	return s.FileStore.SaveLarge(ctx, fileKey, types, bucket, file)
+}
+
+func TestEncryptedStoreSave(t *testing.T) {
+	ctx := context.Background()
+
+	kr, err := envelope.NewKeyring("1", map[string][]byte{"1": bytes.Repeat([]byte{1}, 32)})
+	if err != nil {
+		t.Fatalf("Failed to create keyring: %v", err)
+	}
+
+	inner := &savesStore{FileStore: NewMemoryStore()}
+	fs := NewEncryptedStore(inner, kr, "mp3")
+
+	if err := fs.Save(ctx, "a.mp3", "audio/mpeg", "mp3", strings.NewReader("audio")); err != nil {
+		t.Fatalf("Failed to save: %v", err)
+	}
+
+	if err := fs.Save(ctx, "a.mp4", "video/mp4", "mp4", strings.NewReader("video")); err != nil {
+		t.Fatalf("Failed to save: %v", err)
+	}
+
+	// only the ciphertext, whose length isn't known, is uploaded in parts
+	if want := []string{"SaveLarge mp3", "Save mp4"}; strings.Join(inner.saves, ", ") != strings.Join(want, ", ") {
+		t.Errorf("Expected %v, got %v", want, inner.saves)
+	}
+}
@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+)
+
+var (
+	ErrNotExist = fmt.Errorf("file does not exist")
+)
+
+type FileWriter interface {
+	// Save saves the file to the storage.
+	// Filekey is the encrypted filename.
+	// Types is the MIME content type of the file.
+	// Bucket is the bucket name where the file will be saved.
+	Save(ctx context.Context, fileKey, types, bucket string, file io.Reader) error
+	// SaveLarge saves the file like Save, streaming it in parts where the backend supports it.
+	SaveLarge(ctx context.Context, fileKey, types, bucket string, file io.Reader) error
+}
+
+type FileReader interface {
+	// Read reads the file from the bucket.
+	Read(ctx context.Context, bucket string, fileKey string) (io.ReadCloser, error)
+	ReadLarge(ctx context.Context, bucket string, fileKey string) (io.ReadCloser, error)
+}
+
+type FileDeleter interface {
+	// Delete removes the file from the bucket.
+	Delete(ctx context.Context, bucket string, fileKey string) error
+}
+
+// FileInfo describes a stored object.
+type FileInfo struct {
+	Key          string
+	Size         int64
+	ContentType  string
+	LastModified time.Time
+}
+
+type FileStater interface {
+	// Stat returns the file's info without reading it.
+	Stat(ctx context.Context, bucket string, fileKey string) (*FileInfo, error)
+}
+
+type FileLister interface {
+	// List returns every object in the bucket.
+	List(ctx context.Context, bucket string) ([]FileInfo, error)
+}
+
+type FilePresigner interface {
+	// Presign returns a URL that grants read access to the file until it expires.
+	Presign(ctx context.Context, bucket string, fileKey string, expires time.Duration) (string, error)
+}
+
+type FileStore interface {
+	FileWriter
+	FileReader
+	FileDeleter
+	FileStater
+	FileLister
+	FilePresigner
+}
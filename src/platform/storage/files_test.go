@@ -0,0 +1,364 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+)
+
+// fakeS3 starts an in-memory S3-compatible server with the given buckets.
+func fakeS3(t *testing.T, buckets ...string) *s3.Client {
+	t.Helper()
+
+	backend := s3mem.New()
+	for _, b := range buckets {
+		if err := backend.CreateBucket(b); err != nil {
+			t.Fatalf("Failed to create bucket %s: %v", b, err)
+		}
+	}
+
+	srv := httptest.NewServer(gofakes3.New(backend).Server())
+	t.Cleanup(srv.Close)
+
+	return s3.New(s3.Options{
+		Region:       "us-east-1",
+		Credentials:  credentials.NewStaticCredentialsProvider("key", "secret", ""),
+		BaseEndpoint: aws.String(srv.URL),
+		UsePathStyle: true,
+	})
+}
+
+func TestNewS3Client(t *testing.T) {
+	backend := s3mem.New()
+	if err := backend.CreateBucket("mp3"); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+
+	// a MinIO-like server with a certificate from a private authority
+	srv := httptest.NewTLSServer(gofakes3.New(backend).Server())
+	t.Cleanup(srv.Close)
+
+	bundle := filepath.Join(t.TempDir(), "ca.pem")
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := os.WriteFile(bundle, cert, 0o600); err != nil {
+		t.Fatalf("Failed to write ca bundle: %v", err)
+	}
+
+	s3c, err := NewS3Client(context.Background(), S3Config{
+		Region:          "us-east-1",
+		Endpoint:        srv.URL,
+		PathStyle:       true,
+		CABundle:        bundle,
+		AccessKeyId:     "key",
+		SecretAccessKey: "secret",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	fs := NewStore(s3c, SSE{})
+	if err = fs.Save(context.Background(), "a.mp3", "audio/mpeg", "mp3", strings.NewReader("audio")); err != nil {
+		t.Fatalf("Expected the endpoint to be trusted, got %v", err)
+	}
+
+	if _, err = NewS3Client(context.Background(), S3Config{Region: "us-east-1", CABundle: filepath.Join(t.TempDir(), "missing.pem")}); err == nil {
+		t.Errorf("Expected an error for a missing ca bundle")
+	}
+}
+
+// backends returns a fresh instance of every FileStore with a "mp3" bucket.
+func backends(t *testing.T) map[string]FileStore {
+	t.Helper()
+
+	disk, err := NewDiskStore(t.TempDir(), NewURLSigner("http://localhost/v1/files", []byte("secret")))
+	if err != nil {
+		t.Fatalf("Failed to create disk store: %v", err)
+	}
+
+	return map[string]FileStore{
+		"s3":     NewStore(fakeS3(t, "mp3"), SSE{}),
+		"disk":   disk,
+		"memory": NewMemoryStore(),
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	ctx := context.Background()
+
+	for name, fs := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			t.Run("save, read and list", func(t *testing.T) {
+				if err := fs.Save(ctx, "a.mp3", "audio/mpeg", "mp3", bytes.NewReader([]byte("audio"))); err != nil {
+					t.Fatalf("Failed to save: %v", err)
+				}
+
+				r, err := fs.Read(ctx, "mp3", "a.mp3")
+				if err != nil {
+					t.Fatalf("Failed to read: %v", err)
+				}
+				defer r.Close()
+
+				body, _ := io.ReadAll(r)
+				if string(body) != "audio" {
+					t.Errorf("Expected %q, got %q", "audio", body)
+				}
+
+				files, err := fs.List(ctx, "mp3")
+				if err != nil {
+					t.Fatalf("Failed to list: %v", err)
+				}
+
+				if len(files) != 1 || files[0].Key != "a.mp3" || files[0].Size != 5 || files[0].LastModified.IsZero() {
+					t.Errorf("Unexpected listing: %+v", files)
+				}
+			})
+
+			t.Run("save large and read large", func(t *testing.T) {
+				if err := fs.SaveLarge(ctx, "b.mp3", "audio/mpeg", "mp3", bytes.NewReader([]byte("larger audio"))); err != nil {
+					t.Fatalf("Failed to save: %v", err)
+				}
+
+				r, err := fs.ReadLarge(ctx, "mp3", "b.mp3")
+				if err != nil {
+					t.Fatalf("Failed to read: %v", err)
+				}
+				defer r.Close()
+
+				body, _ := io.ReadAll(r)
+				if string(body) != "larger audio" {
+					t.Errorf("Expected %q, got %q", "larger audio", body)
+				}
+			})
+
+			t.Run("overwrite", func(t *testing.T) {
+				if err := fs.Save(ctx, "b.mp3", "audio/mpeg", "mp3", bytes.NewReader([]byte("new"))); err != nil {
+					t.Fatalf("Failed to save: %v", err)
+				}
+
+				info, err := fs.Stat(ctx, "mp3", "b.mp3")
+				if err != nil {
+					t.Fatalf("Failed to stat: %v", err)
+				}
+
+				if info.Size != 3 || info.ContentType != "audio/mpeg" {
+					t.Errorf("Unexpected info: %+v", info)
+				}
+			})
+
+			t.Run("stream files", func(t *testing.T) {
+				types := map[string]string{
+					"streams/a/master.m3u8":         "application/vnd.apple.mpegurl",
+					"streams/a/audio/segment000.ts": "video/mp2t",
+				}
+
+				for key, typ := range types {
+					if err := fs.Save(ctx, key, typ, "mp3", bytes.NewReader([]byte("stream"))); err != nil {
+						t.Fatalf("Failed to save %s: %v", key, err)
+					}
+
+					info, err := fs.Stat(ctx, "mp3", key)
+					if err != nil {
+						t.Fatalf("Failed to stat %s: %v", key, err)
+					}
+
+					if info.ContentType != typ {
+						t.Errorf("Expected %s to be %s, got %s", key, typ, info.ContentType)
+					}
+				}
+
+				for key := range types {
+					if err := fs.Delete(ctx, "mp3", key); err != nil {
+						t.Fatalf("Failed to delete %s: %v", key, err)
+					}
+				}
+			})
+
+			t.Run("presign", func(t *testing.T) {
+				u, err := fs.Presign(ctx, "mp3", "a.mp3", time.Minute)
+				if err != nil {
+					t.Fatalf("Failed to presign: %v", err)
+				}
+
+				if !strings.Contains(u, "a.mp3") {
+					t.Errorf("Expected the url to name the file, got %s", u)
+				}
+			})
+
+			t.Run("delete", func(t *testing.T) {
+				if err := fs.Delete(ctx, "mp3", "a.mp3"); err != nil {
+					t.Fatalf("Failed to delete: %v", err)
+				}
+
+				if _, err := fs.Read(ctx, "mp3", "a.mp3"); !errors.Is(err, ErrNotExist) {
+					t.Errorf("Expected ErrNotExist, got %v", err)
+				}
+
+				if _, err := fs.Stat(ctx, "mp3", "a.mp3"); !errors.Is(err, ErrNotExist) {
+					t.Errorf("Expected ErrNotExist, got %v", err)
+				}
+			})
+
+			t.Run("missing file", func(t *testing.T) {
+				if _, err := fs.ReadLarge(ctx, "mp3", "missing.mp3"); !errors.Is(err, ErrNotExist) {
+					t.Errorf("Expected ErrNotExist, got %v", err)
+				}
+			})
+		})
+	}
+}
+
+func TestDiskStore(t *testing.T) {
+	ctx := context.Background()
+
+	fs, err := NewDiskStore(t.TempDir(), nil)
+	if err != nil {
+		t.Fatalf("Failed to create disk store: %v", err)
+	}
+
+	t.Run("keys can't escape the root", func(t *testing.T) {
+		for _, key := range []string{"../escape.mp3", "/etc/passwd", "a/../../escape.mp3", ""} {
+			if err := fs.Save(ctx, key, "", "mp3", strings.NewReader("x")); !errors.Is(err, ErrInvalidKey) {
+				t.Errorf("Expected ErrInvalidKey for %q, got %v", key, err)
+			}
+		}
+
+		if err := fs.Save(ctx, "a.mp3", "", "..", strings.NewReader("x")); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Expected ErrInvalidKey for the bucket, got %v", err)
+		}
+	})
+
+	t.Run("no temporary files are listed", func(t *testing.T) {
+		if err := fs.Save(ctx, "a.mp3", "", "mp3", strings.NewReader("x")); err != nil {
+			t.Fatalf("Failed to save: %v", err)
+		}
+
+		files, err := fs.List(ctx, "mp3")
+		if err != nil || len(files) != 1 {
+			t.Errorf("Expected 1 file, got %+v, %v", files, err)
+		}
+	})
+
+	t.Run("presign needs a signer", func(t *testing.T) {
+		if _, err := fs.Presign(ctx, "mp3", "a.mp3", time.Minute); err == nil {
+			t.Errorf("Expected an error without a signer")
+		}
+	})
+}
+
+func TestURLSigner(t *testing.T) {
+	signer := NewURLSigner("http://localhost/v1/files/", []byte("secret"))
+
+	verify := func(raw string) error {
+		u, err := url.Parse(raw)
+		if err != nil {
+			t.Fatalf("Failed to parse %s: %v", raw, err)
+		}
+
+		bucket, key, _ := strings.Cut(strings.TrimPrefix(u.Path, "/v1/files/"), "/")
+		q := u.Query()
+		return signer.Verify(bucket, key, q.Get("expires"), q.Get("signature"))
+	}
+
+	if err := verify(signer.Sign("mp3", "a b.mp3", time.Now().Add(time.Minute))); err != nil {
+		t.Errorf("Expected a valid signature, got %v", err)
+	}
+
+	if err := verify(signer.Sign("mp3", "a.mp3", time.Now().Add(-time.Minute))); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Expected an expired signature to fail, got %v", err)
+	}
+
+	other := NewURLSigner("http://localhost/v1/files", []byte("other"))
+	u, _ := url.Parse(other.Sign("mp3", "a.mp3", time.Now().Add(time.Minute)))
+	if err := signer.Verify("mp3", "a.mp3", u.Query().Get("expires"), u.Query().Get("signature")); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Expected a foreign signature to fail, got %v", err)
+	}
+}
+
+func TestStoreSSE(t *testing.T) {
+	backend := s3mem.New()
+	if err := backend.CreateBucket("mp3"); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+
+	// record the encryption headers of every request that starts an upload
+	var headers []string
+	fake := gofakes3.New(backend).Server()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if (r.Method == http.MethodPut && r.URL.Query().Get("partNumber") == "") || (r.Method == http.MethodPost && r.URL.Query().Has("uploads")) {
+			headers = append(headers, r.Header.Get("X-Amz-Server-Side-Encryption")+" "+r.Header.Get("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id"))
+		}
+		fake.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	s3c := s3.New(s3.Options{
+		Region:       "us-east-1",
+		Credentials:  credentials.NewStaticCredentialsProvider("key", "secret", ""),
+		BaseEndpoint: aws.String(srv.URL),
+		UsePathStyle: true,
+	})
+
+	fs := NewStore(s3c, SSE{Algorithm: "aws:kms", KMSKeyId: "alias/media"})
+	ctx := context.Background()
+
+	if err := fs.Save(ctx, "a.mp3", "audio/mpeg", "mp3", strings.NewReader("audio")); err != nil {
+		t.Fatalf("Failed to save: %v", err)
+	}
+
+	if err := fs.SaveLarge(ctx, "b.mp3", "audio/mpeg", "mp3", strings.NewReader("audio")); err != nil {
+		t.Fatalf("Failed to save large: %v", err)
+	}
+
+	if len(headers) != 2 || headers[0] != "aws:kms alias/media" || headers[1] != "aws:kms alias/media" {
+		t.Errorf("Expected both uploads to request SSE-KMS, got %q", headers)
+	}
+
+	for _, sse := range []SSE{{}, {Algorithm: "AES256"}, {Algorithm: "aws:kms"}} {
+		if err := sse.Validate(); err != nil {
+			t.Errorf("Expected %+v to be valid, got %v", sse, err)
+		}
+	}
+
+	for _, sse := range []SSE{{Algorithm: "DES"}, {Algorithm: "AES256", KMSKeyId: "alias/media"}} {
+		if err := sse.Validate(); err == nil {
+			t.Errorf("Expected %+v to be invalid", sse)
+		}
+	}
+}
+
+func TestObjectURL(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  S3Config
+		want string
+	}{
+		{"aws", S3Config{Region: "ap-southeast-1"}, "https://vid.s3.ap-southeast-1.amazonaws.com/a%20b.mp4"},
+		{"aws path style", S3Config{Region: "ap-southeast-1", PathStyle: true}, "https://s3.ap-southeast-1.amazonaws.com/vid/a%20b.mp4"},
+		{"minio", S3Config{Endpoint: "https://minio.internal:9000", PathStyle: true}, "https://minio.internal:9000/vid/a%20b.mp4"},
+		{"virtual hosted endpoint", S3Config{Endpoint: "https://storage.example.com/"}, "https://vid.storage.example.com/a%20b.mp4"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.cfg.ObjectURL("vid", "a b.mp4"); got != tt.want {
+				t.Errorf("Expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
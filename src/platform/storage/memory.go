@@ -0,0 +1,132 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+type memoryFile struct {
+	data        []byte
+	contentType string
+	modified    time.Time
+}
+
+type memoryStore struct {
+	mu      sync.RWMutex
+	buckets map[string]map[string]*memoryFile
+}
+
+// NewMemoryStore creates a FileStore that keeps every file in memory.
+// It is meant for tests and local development, nothing survives a restart.
+func NewMemoryStore() FileStore {
+	return &memoryStore{
+		buckets: make(map[string]map[string]*memoryFile),
+	}
+}
+
+func (s *memoryStore) Save(ctx context.Context, fileKey, types, bucket string, file io.Reader) error {
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return fmt.Errorf("failed to upload file %s to bucket %s: %w", fileKey, bucket, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.buckets[bucket] == nil {
+		s.buckets[bucket] = make(map[string]*memoryFile)
+	}
+
+	s.buckets[bucket][fileKey] = &memoryFile{
+		data:        data,
+		contentType: types,
+		modified:    time.Now(),
+	}
+
+	return nil
+}
+
+func (s *memoryStore) SaveLarge(ctx context.Context, fileKey, types, bucket string, file io.Reader) error {
+	return s.Save(ctx, fileKey, types, bucket, file)
+}
+
+func (s *memoryStore) Delete(ctx context.Context, bucket string, fileKey string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.buckets[bucket][fileKey]; !ok {
+		return ErrNotExist
+	}
+
+	delete(s.buckets[bucket], fileKey)
+	return nil
+}
+
+func (s *memoryStore) Stat(ctx context.Context, bucket string, fileKey string) (*FileInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	f, ok := s.buckets[bucket][fileKey]
+	if !ok {
+		return nil, ErrNotExist
+	}
+
+	return &FileInfo{
+		Key:          fileKey,
+		Size:         int64(len(f.data)),
+		ContentType:  f.contentType,
+		LastModified: f.modified,
+	}, nil
+}
+
+func (s *memoryStore) List(ctx context.Context, bucket string) ([]FileInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var files []FileInfo
+	for key, f := range s.buckets[bucket] {
+		files = append(files, FileInfo{
+			Key:          key,
+			Size:         int64(len(f.data)),
+			ContentType:  f.contentType,
+			LastModified: f.modified,
+		})
+	}
+
+	// keep the order stable, like S3 listings
+	sort.Slice(files, func(i, j int) bool { return files[i].Key < files[j].Key })
+	return files, nil
+}
+
+func (s *memoryStore) Read(ctx context.Context, bucket string, fileKey string) (io.ReadCloser, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	f, ok := s.buckets[bucket][fileKey]
+	if !ok {
+		return nil, ErrNotExist
+	}
+
+	// stored slices are never mutated, a new upload replaces the whole file
+	return io.NopCloser(bytes.NewReader(f.data)), nil
+}
+
+func (s *memoryStore) ReadLarge(ctx context.Context, bucket string, fileKey string) (io.ReadCloser, error) {
+	return s.Read(ctx, bucket, fileKey)
+}
+
+// Presign returns a memory:// URL, files in memory can't be served to anyone outside the process.
+func (s *memoryStore) Presign(ctx context.Context, bucket string, fileKey string, expires time.Duration) (string, error) {
+	if _, err := s.Stat(ctx, bucket, fileKey); err != nil {
+		return "", err
+	}
+
+	u := url.URL{Scheme: "memory", Host: bucket, Path: "/" + fileKey}
+	return u.String(), nil
+}
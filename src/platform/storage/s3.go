@@ -0,0 +1,348 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+var partSize int64 = 10 << 20 // 10 MB
+
+// S3Config describes how to reach S3 or an S3-compatible server such as MinIO.
+type S3Config struct {
+	Region string
+	// Endpoint overrides the AWS endpoint, e.g. https://minio.internal:9000
+	Endpoint string
+	// PathStyle addresses buckets as endpoint/bucket instead of bucket.endpoint,
+	// which most S3-compatible servers need
+	PathStyle bool
+	// CABundle is a PEM file of extra certificate authorities to trust
+	CABundle string
+	// Profile selects a profile from the shared config and credentials files
+	Profile string
+	// AccessKeyId and SecretAccessKey are used when set, otherwise credentials come
+	// from the default chain: environment, shared profile, web identity (IRSA) and instance roles
+	AccessKeyId     string
+	SecretAccessKey string
+}
+
+// LoadAWSConfig resolves the region, credentials and CA bundle of the configuration.
+// Clients for other AWS services are created from it too.
+func LoadAWSConfig(ctx context.Context, cfg S3Config) (aws.Config, error) {
+	opts := []func(*config.LoadOptions) error{
+		config.WithRegion(cfg.Region),
+	}
+
+	if cfg.Profile != "" {
+		opts = append(opts, config.WithSharedConfigProfile(cfg.Profile))
+	}
+
+	if cfg.AccessKeyId != "" && cfg.SecretAccessKey != "" {
+		opts = append(opts, config.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(cfg.AccessKeyId, cfg.SecretAccessKey, ""),
+		))
+	}
+
+	if cfg.CABundle != "" {
+		bundle, err := os.ReadFile(cfg.CABundle)
+		if err != nil {
+			return aws.Config{}, fmt.Errorf("failed to read ca bundle %s: %w", cfg.CABundle, err)
+		}
+		opts = append(opts, config.WithCustomCABundle(bytes.NewReader(bundle)))
+	}
+
+	awsCfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return aws.Config{}, fmt.Errorf("failed to load aws config: %w", err)
+	}
+
+	return awsCfg, nil
+}
+
+// NewS3Client creates an S3 client from the configuration.
+func NewS3Client(ctx context.Context, cfg S3Config) (*s3.Client, error) {
+	awsCfg, err := LoadAWSConfig(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+		if cfg.Endpoint != "" {
+			o.BaseEndpoint = aws.String(cfg.Endpoint)
+		}
+		o.UsePathStyle = cfg.PathStyle
+	}), nil
+}
+
+// ObjectURL returns the unsigned URL of an object, honouring the endpoint and addressing style.
+func (c S3Config) ObjectURL(bucket, fileKey string) string {
+	key := (&url.URL{Path: fileKey}).EscapedPath()
+
+	if c.Endpoint == "" {
+		if c.PathStyle {
+			return fmt.Sprintf("https://s3.%s.amazonaws.com/%s/%s", c.Region, bucket, key)
+		}
+		return fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", bucket, c.Region, key)
+	}
+
+	u, err := url.Parse(c.Endpoint)
+	if err != nil {
+		return strings.TrimSuffix(c.Endpoint, "/") + "/" + bucket + "/" + key
+	}
+
+	if c.PathStyle {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + bucket + "/" + fileKey
+	} else {
+		u.Host = bucket + "." + u.Host
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + fileKey
+	}
+
+	return u.String()
+}
+
+// SSE selects the server-side encryption S3 applies to uploaded objects.
+type SSE struct {
+	// Algorithm is AES256 for S3 managed keys, aws:kms for KMS keys, or empty to use the bucket default
+	Algorithm string
+	// KMSKeyId is the KMS key for aws:kms, empty to use the account's S3 key
+	KMSKeyId string
+}
+
+// Validate checks the algorithm is one S3 accepts.
+func (e SSE) Validate() error {
+	switch types.ServerSideEncryption(e.Algorithm) {
+	case "", types.ServerSideEncryptionAes256:
+		if e.KMSKeyId != "" {
+			return fmt.Errorf("a kms key needs the %s algorithm", types.ServerSideEncryptionAwsKms)
+		}
+		return nil
+	case types.ServerSideEncryptionAwsKms:
+		return nil
+	default:
+		return fmt.Errorf("unsupported server-side encryption %q", e.Algorithm)
+	}
+}
+
+type store struct {
+	s3c *s3.Client
+	sse SSE
+}
+
+// NewStore creates a FileStore backed by S3, buckets are S3 buckets.
+// Uploads ask S3 to encrypt the objects with sse.
+func NewStore(s3c *s3.Client, sse SSE) FileStore {
+	return &store{
+		s3c: s3c,
+		sse: sse,
+	}
+}
+
+// encrypt adds the server-side encryption headers to an upload.
+// Reads need no headers, S3 decrypts SSE-S3 and SSE-KMS objects transparently.
+func (s *store) encrypt(input *s3.PutObjectInput) *s3.PutObjectInput {
+	if s.sse.Algorithm == "" {
+		return input
+	}
+
+	input.ServerSideEncryption = types.ServerSideEncryption(s.sse.Algorithm)
+	if s.sse.KMSKeyId != "" {
+		input.SSEKMSKeyId = aws.String(s.sse.KMSKeyId)
+	}
+	return input
+}
+
+// Save saves the file to an object in a bucket.
+func (s *store) Save(ctx context.Context, fileKey, types, bucket string, file io.Reader) error {
+	if _, err := s.s3c.PutObject(ctx, s.encrypt(&s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(fileKey),
+		Body:        file,
+		ContentType: aws.String(types),
+	})); err != nil {
+		return fmt.Errorf("failed to upload file %s to bucket %s: %w", fileKey, bucket, err)
+	}
+
+	if err := s3.NewObjectExistsWaiter(s.s3c).Wait(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(fileKey),
+	}, 2*time.Minute); err != nil {
+		return fmt.Errorf("failed to confirm existence of uploaded file %s in bucket %s: %w", fileKey, bucket, err)
+	}
+
+	return nil
+}
+
+// SaveLarge uses an upload manager to upload data to an object in a bucket.
+// The upload manager breaks large data into parts and uploads the parts concurrently.
+func (s *store) SaveLarge(ctx context.Context, fileKey, types, bucket string, file io.Reader) error {
+	uploader := manager.NewUploader(s.s3c, func(u *manager.Uploader) {
+		u.PartSize = partSize
+	})
+
+	if _, err := uploader.Upload(ctx, s.encrypt(&s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(fileKey),
+		Body:        file,
+		ContentType: aws.String(types),
+	})); err != nil {
+		return fmt.Errorf("failed to upload file %s to bucket %s: %w", fileKey, bucket, err)
+	}
+
+	if err := s3.NewObjectExistsWaiter(s.s3c).Wait(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(fileKey),
+	}, 2*time.Minute); err != nil {
+		return fmt.Errorf("failed to confirm existence of uploaded file %s in bucket %s: %w", fileKey, bucket, err)
+	}
+
+	return nil
+}
+
+func (s *store) Delete(ctx context.Context, bucket string, fileKey string) error {
+	if _, err := s.s3c.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(fileKey),
+	}); err != nil {
+		var noKey *types.NoSuchKey
+		errors.As(err, &noKey)
+		switch {
+		case errors.As(err, &noKey):
+			return ErrNotExist
+		default:
+			return fmt.Errorf("failed to delete object %s from bucket %s: %w", fileKey, bucket, err)
+		}
+	}
+
+	if err := s3.NewObjectNotExistsWaiter(s.s3c).Wait(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(fileKey),
+	}, time.Minute); err != nil {
+		return fmt.Errorf("failed attempt to wait for object %s in bucket %s to be deleted", fileKey, bucket)
+	}
+
+	return nil
+}
+
+func (s *store) Stat(ctx context.Context, bucket string, fileKey string) (*FileInfo, error) {
+	result, err := s.s3c.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(fileKey),
+	})
+	if err != nil {
+		// HEAD responses have no body, so a missing key comes back as NotFound rather than NoSuchKey
+		var apiErr smithy.APIError
+		switch {
+		case errors.As(err, &apiErr) && apiErr.ErrorCode() == "NotFound":
+			return nil, ErrNotExist
+		default:
+			return nil, fmt.Errorf("failed to stat object %s in bucket %s: %w", fileKey, bucket, err)
+		}
+	}
+
+	return &FileInfo{
+		Key:          fileKey,
+		Size:         aws.ToInt64(result.ContentLength),
+		ContentType:  aws.ToString(result.ContentType),
+		LastModified: aws.ToTime(result.LastModified),
+	}, nil
+}
+
+// Presign creates a presigned S3 GET request for the object.
+func (s *store) Presign(ctx context.Context, bucket string, fileKey string, expires time.Duration) (string, error) {
+	req, err := s3.NewPresignClient(s.s3c).PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(fileKey),
+	}, s3.WithPresignExpires(expires))
+	if err != nil {
+		return "", fmt.Errorf("failed to presign object %s in bucket %s: %w", fileKey, bucket, err)
+	}
+
+	return req.URL, nil
+}
+
+// List pages through every object in the bucket.
+func (s *store) List(ctx context.Context, bucket string) ([]FileInfo, error) {
+	var files []FileInfo
+
+	paginator := s3.NewListObjectsV2Paginator(s.s3c, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+	})
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list objects in bucket %s: %w", bucket, err)
+		}
+
+		for _, obj := range page.Contents {
+			files = append(files, FileInfo{
+				Key:          aws.ToString(obj.Key),
+				Size:         aws.ToInt64(obj.Size),
+				LastModified: aws.ToTime(obj.LastModified),
+			})
+		}
+	}
+
+	return files, nil
+}
+
+func (s *store) Read(ctx context.Context, bucket string, fileKey string) (io.ReadCloser, error) {
+	result, err := s.s3c.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(fileKey),
+	})
+
+	if err != nil {
+		var noKey *types.NoSuchKey
+		errors.As(err, &noKey)
+		switch {
+		case errors.As(err, &noKey):
+			return nil, ErrNotExist
+		default:
+			return nil, fmt.Errorf("failed to read object %s from bucket %s: %w", fileKey, bucket, err)
+		}
+	}
+
+	return result.Body, nil
+}
+
+// ReadLarge uses a download manager to download an object from a bucket.
+// The download manager gets the data in parts and writes them to a buffer until all of
+// the data has been downloaded.
+func (s *store) ReadLarge(ctx context.Context, bucket string, fileKey string) (io.ReadCloser, error) {
+	downloader := manager.NewDownloader(s.s3c, func(d *manager.Downloader) {
+		d.PartSize = partSize
+	})
+
+	buffer := manager.NewWriteAtBuffer([]byte{})
+
+	if _, err := downloader.Download(ctx, buffer, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(fileKey),
+	}); err != nil {
+		var noKey *types.NoSuchKey
+		errors.As(err, &noKey)
+		switch {
+		case errors.As(err, &noKey):
+			return nil, ErrNotExist
+		default:
+			return nil, fmt.Errorf("failed to download object %s from bucket %s: %w", fileKey, bucket, err)
+		}
+	}
+
+	return io.NopCloser(bytes.NewReader(buffer.Bytes())), nil
+}
@@ -0,0 +1,64 @@
+package transcriber
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// vttEscaper escapes the characters WebVTT cue text gives a meaning to.
+var vttEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// SRT formats the transcript as SubRip subtitles.
+func (t *Transcript) SRT() string {
+	var b strings.Builder
+	n := 0
+	for _, s := range t.Segments {
+		text := line(s.Text)
+		if text == "" {
+			continue
+		}
+
+		n++
+		fmt.Fprintf(&b, "%d\n%s --> %s\n%s\n\n", n, timestamp(s.Start, ","), timestamp(s.End, ","), text)
+	}
+	return b.String()
+}
+
+// VTT formats the transcript as WebVTT subtitles.
+func (t *Transcript) VTT() string {
+	var b strings.Builder
+	b.WriteString("WEBVTT\n\n")
+	for _, s := range t.Segments {
+		text := line(s.Text)
+		if text == "" {
+			continue
+		}
+
+		fmt.Fprintf(&b, "%s --> %s\n%s\n\n", timestamp(s.Start, "."), timestamp(s.End, "."), vttEscaper.Replace(text))
+	}
+	return b.String()
+}
+
+// Text formats the transcript as plain text, a segment per line.
+func (t *Transcript) Text() string {
+	var b strings.Builder
+	for _, s := range t.Segments {
+		if text := line(s.Text); text != "" {
+			b.WriteString(text)
+			b.WriteByte('\n')
+		}
+	}
+	return b.String()
+}
+
+// line puts the text of a segment on a single line, a blank line would end a subtitle early.
+func line(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// timestamp formats the time as subtitles do, 01:02:03,456 with the separator of the milliseconds.
+func timestamp(d time.Duration, sep string) string {
+	ms := max(d.Milliseconds(), 0)
+	return fmt.Sprintf("%02d:%02d:%02d%s%03d", ms/3600000, ms/60000%60, ms/1000%60, sep, ms%1000)
+}
@@ -0,0 +1,29 @@
+package transcriber
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFormats(t *testing.T) {
+	tr := &Transcript{Segments: []Segment{
+		{Start: 0, End: 1500 * time.Millisecond, Text: " Hello,\n\nworld "},
+		{Start: 2 * time.Second, End: 3 * time.Second, Text: "  "},
+		{Start: time.Hour + 2*time.Minute + 3*time.Second + 45*time.Millisecond, End: time.Hour + 2*time.Minute + 5*time.Second, Text: "x < y & z"},
+	}}
+
+	srt := "1\n00:00:00,000 --> 00:00:01,500\nHello, world\n\n2\n01:02:03,045 --> 01:02:05,000\nx < y & z\n\n"
+	if got := tr.SRT(); got != srt {
+		t.Errorf("Expected srt %q, got %q", srt, got)
+	}
+
+	vtt := "WEBVTT\n\n00:00:00.000 --> 00:00:01.500\nHello, world\n\n01:02:03.045 --> 01:02:05.000\nx &lt; y &amp; z\n\n"
+	if got := tr.VTT(); got != vtt {
+		t.Errorf("Expected vtt %q, got %q", vtt, got)
+	}
+
+	text := "Hello, world\nx < y & z\n"
+	if got := tr.Text(); got != text {
+		t.Errorf("Expected text %q, got %q", text, got)
+	}
+}
@@ -0,0 +1,124 @@
+package transcriber
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// HTTP sends audio to a server speaking the OpenAI audio transcription API,
+// as whisper.cpp's server and most hosted engines do.
+type HTTP struct {
+	url    string
+	token  string
+	model  string
+	client *http.Client
+}
+
+// NewHTTP creates an engine posting to the transcription endpoint at url, e.g. http://whisper:8080/v1/audio/transcriptions.
+// The token is sent as a bearer token and the model is named in the request, both are left out when empty.
+// The timeout bounds a whole transcription, servers only answer once they have transcribed the audio.
+func NewHTTP(url, token, model string, timeout time.Duration) *HTTP {
+	return &HTTP{url: url, token: token, model: model, client: &http.Client{Timeout: timeout}}
+}
+
+// verboseJSON is the response asked for, times are in seconds.
+type verboseJSON struct {
+	Language string `json:"language"`
+	Segments []struct {
+		Start float64 `json:"start"`
+		End   float64 `json:"end"`
+		Text  string  `json:"text"`
+	} `json:"segments"`
+}
+
+func (h *HTTP) Transcribe(ctx context.Context, wav, language string) (*Transcript, error) {
+	f, err := os.Open(wav)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audio: %w", err)
+	}
+	defer f.Close()
+
+	// the audio is streamed into the request body rather than held in memory
+	body, w := io.Pipe()
+	mw := multipart.NewWriter(w)
+	go func() {
+		w.CloseWithError(h.form(mw, f, filepath.Base(wav), language))
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, body)
+	if err != nil {
+		body.Close()
+		return nil, fmt.Errorf("invalid transcription request: %w", err)
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	if h.token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.token)
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("transcription was stopped: %w", ctx.Err())
+		}
+		return nil, fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, outputLimit))
+		err = fmt.Errorf("transcription server answered %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+		// the server may be back later, a refused audio stays refused
+		if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+			return nil, fmt.Errorf("%w: %w", ErrUnavailable, err)
+		}
+		return nil, err
+	}
+
+	var v verboseJSON
+	if err = json.NewDecoder(resp.Body).Decode(&v); err != nil {
+		return nil, fmt.Errorf("failed to decode transcription: %w", err)
+	}
+
+	t := &Transcript{Language: v.Language}
+	for _, s := range v.Segments {
+		t.Segments = append(t.Segments, Segment{
+			Start: time.Duration(s.Start * float64(time.Second)),
+			End:   time.Duration(s.End * float64(time.Second)),
+			Text:  strings.TrimSpace(s.Text),
+		})
+	}
+
+	return t, nil
+}
+
+// form writes the fields of the request and the audio.
+func (h *HTTP) form(mw *multipart.Writer, audio io.Reader, name, language string) error {
+	fields := map[string]string{"response_format": "verbose_json", "model": h.model, "language": language}
+	for k, v := range fields {
+		if v == "" {
+			continue
+		}
+		if err := mw.WriteField(k, v); err != nil {
+			return err
+		}
+	}
+
+	part, err := mw.CreateFormFile("file", name)
+	if err != nil {
+		return err
+	}
+
+	if _, err = io.Copy(part, audio); err != nil {
+		return err
+	}
+
+	return mw.Close()
+}
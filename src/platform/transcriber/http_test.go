@@ -0,0 +1,76 @@
+package transcriber
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHTTP(t *testing.T) {
+	wav := filepath.Join(t.TempDir(), "speech.wav")
+	if err := os.WriteFile(wav, []byte("RIFF"), 0600); err != nil {
+		t.Fatalf("Failed to write audio: %v", err)
+	}
+
+	t.Run("verbose json is read", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer secret" {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			file, _, err := r.FormFile("file")
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			audio, _ := io.ReadAll(file)
+
+			if string(audio) != "RIFF" || r.FormValue("language") != "fr" || r.FormValue("model") != "whisper-1" || r.FormValue("response_format") != "verbose_json" {
+				http.Error(w, "unexpected form", http.StatusBadRequest)
+				return
+			}
+
+			w.Write([]byte(`{"language":"french","segments":[{"start":0,"end":1.25,"text":" Bonjour."}]}`))
+		}))
+		defer srv.Close()
+
+		tr, err := NewHTTP(srv.URL, "secret", "whisper-1", time.Minute).Transcribe(context.Background(), wav, "fr")
+		if err != nil {
+			t.Fatalf("Transcribe failed: %v", err)
+		}
+
+		if tr.Language != "french" || len(tr.Segments) != 1 || tr.Segments[0].End != 1250*time.Millisecond || tr.Segments[0].Text != "Bonjour." {
+			t.Errorf("Unexpected transcript: %+v", tr)
+		}
+	})
+
+	t.Run("server errors are unavailable", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "overloaded", http.StatusServiceUnavailable)
+		}))
+		defer srv.Close()
+
+		if _, err := NewHTTP(srv.URL, "", "", time.Minute).Transcribe(context.Background(), wav, ""); !errors.Is(err, ErrUnavailable) {
+			t.Errorf("Expected ErrUnavailable, got %v", err)
+		}
+	})
+
+	t.Run("refused audio is not retried", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "invalid audio", http.StatusBadRequest)
+		}))
+		defer srv.Close()
+
+		_, err := NewHTTP(srv.URL, "", "", time.Minute).Transcribe(context.Background(), wav, "")
+		if err == nil || errors.Is(err, ErrUnavailable) {
+			t.Errorf("Expected a permanent error, got %v", err)
+		}
+	})
+}
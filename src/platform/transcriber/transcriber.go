@@ -0,0 +1,32 @@
+// Package transcriber turns the speech in audio files into text.
+package transcriber
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrUnavailable is returned when the engine can't be reached or fails on its side,
+// the same audio may be transcribed once it's back.
+var ErrUnavailable = errors.New("transcription engine unavailable")
+
+// Segment is a stretch of speech, timed from the start of the audio.
+type Segment struct {
+	Start time.Duration
+	End   time.Duration
+	Text  string
+}
+
+// Transcript is what an engine heard in an audio, in order.
+// Language is the language of the speech as the engine reports it.
+type Transcript struct {
+	Language string
+	Segments []Segment
+}
+
+// Transcriber transcribes a 16 kHz mono wav file, the input every engine takes.
+// The language is an ISO 639-1 code, the engine detects it when it's empty.
+type Transcriber interface {
+	Transcribe(ctx context.Context, wav, language string) (*Transcript, error)
+}
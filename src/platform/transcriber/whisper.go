@@ -0,0 +1,87 @@
+package transcriber
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// outputLimit is how much of the output of a failed run is kept in its error.
+const outputLimit = 2 << 10
+
+// Whisper runs a whisper.cpp style command line, which writes its transcript as json next to the audio.
+type Whisper struct {
+	path  string
+	model string
+}
+
+// NewWhisper creates an engine running the binary at path with the model file.
+func NewWhisper(path, model string) *Whisper {
+	return &Whisper{path: path, model: model}
+}
+
+// whisperOutput is the json written with -oj, offsets are in milliseconds.
+type whisperOutput struct {
+	Result struct {
+		Language string `json:"language"`
+	} `json:"result"`
+	Transcription []struct {
+		Offsets struct {
+			From int64 `json:"from"`
+			To   int64 `json:"to"`
+		} `json:"offsets"`
+		Text string `json:"text"`
+	} `json:"transcription"`
+}
+
+func (w *Whisper) Transcribe(ctx context.Context, wav, language string) (*Transcript, error) {
+	if language == "" {
+		language = "auto"
+	}
+
+	// the json is written to the output prefix with its extension added
+	prefix := strings.TrimSuffix(wav, filepath.Ext(wav))
+	cmd := exec.CommandContext(ctx, w.path, "-m", w.model, "-f", wav, "-l", language, "-oj", "-of", prefix, "-np")
+	cmd.Dir = filepath.Dir(wav)
+
+	out, err := cmd.CombinedOutput()
+	switch {
+	case ctx.Err() != nil:
+		return nil, fmt.Errorf("whisper was stopped: %w", ctx.Err())
+	case errors.Is(err, exec.ErrNotFound), errors.Is(err, os.ErrNotExist):
+		return nil, fmt.Errorf("%w: %w", ErrUnavailable, err)
+	case err != nil:
+		if len(out) > outputLimit {
+			out = out[len(out)-outputLimit:]
+		}
+		return nil, fmt.Errorf("whisper failed: %w: %s", err, out)
+	}
+
+	b, err := os.ReadFile(prefix + ".json")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read whisper output: %w", err)
+	}
+	defer os.Remove(prefix + ".json")
+
+	var output whisperOutput
+	if err = json.Unmarshal(b, &output); err != nil {
+		return nil, fmt.Errorf("failed to decode whisper output: %w", err)
+	}
+
+	t := &Transcript{Language: output.Result.Language}
+	for _, s := range output.Transcription {
+		t.Segments = append(t.Segments, Segment{
+			Start: time.Duration(s.Offsets.From) * time.Millisecond,
+			End:   time.Duration(s.Offsets.To) * time.Millisecond,
+			Text:  strings.TrimSpace(s.Text),
+		})
+	}
+
+	return t, nil
+}
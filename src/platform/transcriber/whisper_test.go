@@ -0,0 +1,69 @@
+package transcriber
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeWhisper writes a shell script standing in for whisper.cpp, it records its arguments and runs the script.
+func fakeWhisper(t *testing.T, script string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "whisper")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\necho \"$@\" > \"$(dirname \"$0\")/args\"\n"+script), 0700); err != nil {
+		t.Fatalf("Failed to write fake whisper: %v", err)
+	}
+	return path
+}
+
+func TestWhisper(t *testing.T) {
+	t.Run("transcript is read from the json output", func(t *testing.T) {
+		bin := fakeWhisper(t, `while [ $# -gt 0 ]; do [ "$1" = "-of" ] && out=$2; shift; done
+cat > "$out.json" <<'OUT'
+{"result":{"language":"en"},"transcription":[
+  {"offsets":{"from":0,"to":2500},"text":" Hello there."},
+  {"offsets":{"from":2500,"to":4000},"text":" General Kenobi."}
+]}
+OUT`)
+
+		wav := filepath.Join(t.TempDir(), "speech.wav")
+		tr, err := NewWhisper(bin, "ggml-base.bin").Transcribe(context.Background(), wav, "")
+		if err != nil {
+			t.Fatalf("Transcribe failed: %v", err)
+		}
+
+		if tr.Language != "en" || len(tr.Segments) != 2 || tr.Segments[1].Start != 2500*time.Millisecond || tr.Segments[1].Text != "General Kenobi." {
+			t.Errorf("Unexpected transcript: %+v", tr)
+		}
+
+		args, _ := os.ReadFile(filepath.Join(filepath.Dir(bin), "args"))
+		if !strings.Contains(string(args), "-m ggml-base.bin -f "+wav+" -l auto") {
+			t.Errorf("Expected the language to be detected, got %q", args)
+		}
+
+		if _, err = os.Stat(strings.TrimSuffix(wav, ".wav") + ".json"); !os.IsNotExist(err) {
+			t.Errorf("Expected the json output to be removed, got %v", err)
+		}
+	})
+
+	t.Run("failure keeps the output", func(t *testing.T) {
+		bin := fakeWhisper(t, `echo "failed to load model" >&2; exit 3`)
+
+		_, err := NewWhisper(bin, "missing.bin").Transcribe(context.Background(), filepath.Join(t.TempDir(), "speech.wav"), "de")
+		if err == nil || !strings.Contains(err.Error(), "failed to load model") || errors.Is(err, ErrUnavailable) {
+			t.Errorf("Expected the output of whisper in the error, got %v", err)
+		}
+	})
+
+	t.Run("missing binary is unavailable", func(t *testing.T) {
+		_, err := NewWhisper(filepath.Join(t.TempDir(), "none"), "model.bin").Transcribe(context.Background(), "speech.wav", "")
+		if !errors.Is(err, ErrUnavailable) {
+			t.Errorf("Expected ErrUnavailable, got %v", err)
+		}
+	})
+}